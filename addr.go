@@ -0,0 +1,110 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Addr is a network address, its host being an IPv4 or IPv6 address or a
+// hostname.
+type Addr struct {
+	Host string
+	Port int
+}
+
+// ParseAddr parses an address of the form "host:port", where IPv6 hosts are
+// enclosed in brackets, e.g. "[::1]:8080".
+func ParseAddr(s string) (Addr, error) {
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return Addr{}, errorf(ErrInvalidArgument, "invalid address %q", s)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > maxPort {
+		return Addr{}, errorf(ErrInvalidPort, "invalid port of address %q", s)
+	}
+	return Addr{Host: host, Port: port}, nil
+}
+
+// String returns the address in the form ParseAddr parses.
+func (a Addr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// IsIPv6 reports whether the host of the address is an IPv6 address.
+func (a Addr) IsIPv6() bool {
+	ip := net.ParseIP(a.Host)
+	return ip != nil && ip.To4() == nil
+}
+
+// startRecord is the content of the start file of an instance, which is
+// empty until the instance is claimed, holds the IP of the claimer until it
+// is started and the addresses of the instance afterwards.
+type startRecord struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port,omitempty"`
+	Host     string `json:"host,omitempty"`
+	TelePort int    `json:"telePort,omitempty"`
+}
+
+// started reports whether the record is the one of a started instance.
+func (r *startRecord) started() bool {
+	return r.Port != 0
+}
+
+// startCodec reads and writes start records. Started instances are stored as
+// JSON, records written as space separated fields before are still read.
+type startCodec struct{}
+
+// Encode satisfies the cp.Codec interface.
+func (startCodec) Encode(v interface{}) ([]byte, error) {
+	r := v.(*startRecord)
+	if !r.started() {
+		return []byte(r.IP), nil
+	}
+	return json.Marshal(r)
+}
+
+// Decode satisfies the cp.Codec interface.
+func (startCodec) Decode(b []byte) (interface{}, error) {
+	r := &startRecord{}
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '{' {
+		if err := json.Unmarshal(b, r); err != nil {
+			return nil, errorf(ErrInvalidFile, "invalid start record: %s", err)
+		}
+		return r, nil
+	}
+
+	//   <ip> <port> <host> <telePort>
+	fields := strings.Fields(string(b))
+	if len(fields) > 0 {
+		r.IP = fields[0]
+	}
+	if len(fields) > 1 {
+		port, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, errorf(ErrInvalidPort, "invalid port: "+fields[1])
+		}
+		r.Port = port
+	}
+	if len(fields) > 2 {
+		r.Host = fields[2]
+	}
+	if len(fields) > 3 {
+		telePort, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, errorf(ErrInvalidPort, "invalid teleport: "+fields[3])
+		}
+		r.TelePort = telePort
+	}
+	return r, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		addr Addr
+		ipv6 bool
+		err  bool
+	}{
+		{s: "10.0.0.1:8080", addr: Addr{"10.0.0.1", 8080}},
+		{s: "[2001:db8::1]:8080", addr: Addr{"2001:db8::1", 8080}, ipv6: true},
+		{s: "box1.example.com:80", addr: Addr{"box1.example.com", 80}},
+		{s: "2001:db8::1:8080", err: true},
+		{s: "10.0.0.1", err: true},
+		{s: "10.0.0.1:0", err: true},
+	} {
+		addr, err := ParseAddr(test.s)
+		if (err != nil) != test.err {
+			t.Errorf("%q: expected error %t, got %v", test.s, test.err, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if addr != test.addr {
+			t.Errorf("%q: expected %#v, got %#v", test.s, test.addr, addr)
+		}
+		if addr.String() != test.s {
+			t.Errorf("expected %q, got %q", test.s, addr.String())
+		}
+		if addr.IsIPv6() != test.ipv6 {
+			t.Errorf("%q: expected IPv6 %t", test.s, test.ipv6)
+		}
+	}
+}
+
+func TestStartCodec(t *testing.T) {
+	for _, test := range []struct {
+		body   string
+		record startRecord
+	}{
+		{"", startRecord{}},
+		{"10.0.0.1", startRecord{IP: "10.0.0.1"}},
+		{"10.0.0.1 24690 localhost 24691", startRecord{"10.0.0.1", 24690, "localhost", 24691}},
+		{`{"ip":"::1","port":24690,"host":"localhost","telePort":24691}`, startRecord{"::1", 24690, "localhost", 24691}},
+	} {
+		v, err := new(startCodec).Decode([]byte(test.body))
+		if err != nil {
+			t.Errorf("%q: %s", test.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(*v.(*startRecord), test.record) {
+			t.Errorf("%q: expected %#v, got %#v", test.body, test.record, v)
+		}
+	}
+
+	r := &startRecord{"::1", 24690, "localhost", 24691}
+	b, err := new(startCodec).Encode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := new(startCodec).Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, r) {
+		t.Errorf("expected %#v to round-trip, got %#v", r, v)
+	}
+}
+
+func TestInstanceStartedIPv6(t *testing.T) {
+	ip := "2001:db8::1"
+	ins := instanceSetupClaimed("ipv6-cat", ip)
+	ins, err := ins.Started(ip, "box1.ipv6-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusRunning {
+		t.Errorf("expected instance to be running, got %s", ins.Status)
+	}
+	if have, want := ins.Addr().String(), "[2001:db8::1]:9999"; have != want {
+		t.Errorf("expected address %s, got %s", want, have)
+	}
+}
@@ -6,8 +6,10 @@
 package visor
 
 import (
+	"context"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,20 +20,39 @@ import (
 const DeployLXC = "lxc"
 const appsPath = "apps"
 
+const (
+	envPath          = "env"
+	envOverridesPath = "env-overrides"
+	envVersionPath   = "env-version"
+	envVersionsPath  = "env-versions"
+	// Overrides as they were at each version, absent for versions recorded
+	// before overrides were versioned.
+	envOverrideVersionsPath = "env-override-versions"
+)
+
 // App is the representation of a repository of coherent changes.
 type App struct {
 	dir        *cp.Dir
+	closer     *closer
+	reads      *readLimit
+	guard      *guard
 	Name       string
 	RepoURL    string
 	Stack      string
 	Env        map[string]string
+	EnvVersion int
+	Labels     map[string]string
 	DeployType string
 	Registered time.Time
+	// Actor which registered the app, empty if unknown.
+	RegisteredBy string
+	// Actor which last stored the attrs, empty if unknown.
+	UpdatedBy string
 }
 
 // NewApp returns a new App given a name, repository url and stack.
 func (s *Store) NewApp(name string, repourl string, stack string) (app *App) {
-	app = &App{Name: name, RepoURL: repourl, Stack: stack, Env: map[string]string{}}
+	app = &App{Name: name, RepoURL: repourl, Stack: stack, Env: map[string]string{}, closer: s.closer, reads: s.reads, guard: s.guard}
 	app.dir = cp.NewDir(path.Join(appsPath, app.Name), s.GetSnapshot())
 
 	return
@@ -43,7 +64,16 @@ func (a *App) GetSnapshot() cp.Snapshot {
 }
 
 // Register adds the App to the global process state.
-func (a *App) Register() (*App, error) {
+func (a *App) Register() (_ *App, err error) {
+	defer a.annotate(&err, "register")
+	if err := guardOf(a).authorize("", AuditRegister, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	if ValidateNames {
+		if err := ValidateAppName(a.Name); err != nil {
+			return nil, err
+		}
+	}
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -61,10 +91,12 @@ func (a *App) Register() (*App, error) {
 		a.DeployType = DeployLXC
 	}
 
+	a.UpdatedBy = actorOf(a)
 	v := map[string]interface{}{
 		"repo-url":    a.RepoURL,
 		"stack":       a.Stack,
 		"deploy-type": a.DeployType,
+		"updated-by":  a.UpdatedBy,
 	}
 	attrs := cp.NewFile(a.dir.Prefix("attrs"), v, new(cp.JsonCodec), sp)
 
@@ -76,14 +108,20 @@ func (a *App) Register() (*App, error) {
 	a.dir = a.dir.Join(sp)
 
 	for k, v := range a.Env {
-		_, err = a.SetEnvironmentVar(k, v)
+		_, err = a.setEnvironmentVar(k, v)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if len(a.Env) > 0 {
+		if _, err = a.recordEnvVersion(); err != nil {
+			return nil, err
+		}
+	}
 
 	reg := time.Now()
-	d, err := a.dir.Set(registeredPath, formatTime(reg))
+	a.RegisteredBy = actorOf(a)
+	d, err := a.dir.Set(registeredPath, formatRegistered(reg, a.RegisteredBy))
 	if err != nil {
 		return nil, err
 	}
@@ -91,11 +129,17 @@ func (a *App) Register() (*App, error) {
 
 	a.dir = d
 
+	audit(a, "", AuditRegister, "app:"+a.Name)
+
 	return a, err
 }
 
 // Unregister removes the App form the global process state.
-func (a *App) Unregister() error {
+func (a *App) Unregister() (err error) {
+	defer a.annotate(&err, "unregister")
+	if err := guardOf(a).authorize("", AuditUnregister, "app:"+a.Name); err != nil {
+		return err
+	}
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -107,7 +151,11 @@ func (a *App) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `app "%s" not found`, a)
 	}
-	return a.dir.Join(sp).Del("/")
+	if err := a.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	audit(sp, actorOf(a), AuditUnregister, "app:"+a.Name)
+	return nil
 }
 
 // SetStack sets the application's stack
@@ -117,16 +165,22 @@ func (a *App) SetStack(stack string) (*App, error) {
 }
 
 // StoreAttrs saves the current App attrs.
-func (a *App) StoreAttrs() (*App, error) {
+func (a *App) StoreAttrs() (_ *App, err error) {
+	defer a.annotate(&err, "store-attrs")
+	if err := guardOf(a).authorize("", AuditAttrs, "app:"+a.Name); err != nil {
+		return nil, err
+	}
 	f, err := a.dir.GetFile("attrs", new(cp.JsonCodec))
 	if err != nil {
 		return nil, err
 	}
 
+	a.UpdatedBy = actorOf(a)
 	v := map[string]interface{}{
 		"repo-url":    a.RepoURL,
 		"stack":       a.Stack,
 		"deploy-type": a.DeployType,
+		"updated-by":  a.UpdatedBy,
 	}
 	f.Value = v
 	f, err = f.Save()
@@ -134,6 +188,8 @@ func (a *App) StoreAttrs() (*App, error) {
 		return nil, err
 	}
 
+	audit(f, "", AuditAttrs, "app:"+a.Name)
+
 	return a, nil
 }
 
@@ -202,8 +258,25 @@ func (a *App) GetEnvironmentVar(k string) (value string, err error) {
 	return
 }
 
-// SetEnvironmentVar stores the value for the given key.
-func (a *App) SetEnvironmentVar(k string, v string) (*App, error) {
+// SetEnvironmentVar stores the value for the given key and records a new
+// environment version.
+func (a *App) SetEnvironmentVar(k string, v string) (_ *App, err error) {
+	defer a.annotate(&err, "set-env")
+	if err := guardOf(a).authorize("", AuditEnv, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	a, err = a.setEnvironmentVar(k, v)
+	if err != nil {
+		return nil, err
+	}
+	if a, err = a.recordEnvVersion(); err != nil {
+		return nil, err
+	}
+	audit(a, "", AuditEnv, "app:"+a.Name)
+	return a, nil
+}
+
+func (a *App) setEnvironmentVar(k string, v string) (*App, error) {
 	d, err := a.dir.Set("env/"+strings.Replace(k, "_", "-", -1), v)
 	if err != nil {
 		return nil, err
@@ -215,8 +288,25 @@ func (a *App) SetEnvironmentVar(k string, v string) (*App, error) {
 	return a, nil
 }
 
-// DelEnvironmentVar removes the env variable for the given key.
-func (a *App) DelEnvironmentVar(k string) (*App, error) {
+// DelEnvironmentVar removes the env variable for the given key and records a
+// new environment version.
+func (a *App) DelEnvironmentVar(k string) (_ *App, err error) {
+	defer a.annotate(&err, "del-env")
+	if err := guardOf(a).authorize("", AuditEnv, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	a, err = a.delEnvironmentVar(k)
+	if err != nil {
+		return nil, err
+	}
+	if a, err = a.recordEnvVersion(); err != nil {
+		return nil, err
+	}
+	audit(a, "", AuditEnv, "app:"+a.Name)
+	return a, nil
+}
+
+func (a *App) delEnvironmentVar(k string) (*App, error) {
 	err := a.dir.Del("env/" + strings.Replace(k, "_", "-", -1))
 	if err != nil {
 		return nil, err
@@ -229,20 +319,307 @@ func (a *App) DelEnvironmentVar(k string) (*App, error) {
 	return a, nil
 }
 
+// SetEnvironmentVarForEnv stores the value for the given key which only
+// applies to instances of the given env, overriding the app-wide value, and
+// records a new environment version.
+func (a *App) SetEnvironmentVarForEnv(env, k, v string) (_ *App, err error) {
+	defer a.annotate(&err, "set-env")
+	if err := guardOf(a).authorize("", AuditEnv, "app:"+a.Name+"@"+env); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	if a, err = a.setEnvironmentVarForEnv(env, k, v); err != nil {
+		return nil, err
+	}
+	if a, err = a.recordEnvVersion(); err != nil {
+		return nil, err
+	}
+	audit(a, "", AuditEnv, "app:"+a.Name+"@"+env)
+	return a, nil
+}
+
+func (a *App) setEnvironmentVarForEnv(env, k, v string) (*App, error) {
+	d, err := a.dir.Set(path.Join(envOverridesPath, env, envKey(k)), v)
+	if err != nil {
+		return nil, err
+	}
+	a.dir = d
+	return a, nil
+}
+
+// DelEnvironmentVarForEnv removes the override of the given key for env and
+// records a new environment version.
+func (a *App) DelEnvironmentVarForEnv(env, k string) (_ *App, err error) {
+	defer a.annotate(&err, "del-env")
+	if err := guardOf(a).authorize("", AuditEnv, "app:"+a.Name+"@"+env); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	if a, err = a.delEnvironmentVarForEnv(env, k); err != nil {
+		return nil, err
+	}
+	if a, err = a.recordEnvVersion(); err != nil {
+		return nil, err
+	}
+	audit(a, "", AuditEnv, "app:"+a.Name+"@"+env)
+	return a, nil
+}
+
+func (a *App) delEnvironmentVarForEnv(env, k string) (*App, error) {
+	err := a.dir.Del(path.Join(envOverridesPath, env, envKey(k)))
+	if err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	a.dir = a.dir.Join(sp)
+	return a, nil
+}
+
+// EnvironmentVarsForEnv returns all variables overridden for the given env.
+func (a *App) EnvironmentVarsForEnv(env string) (map[string]string, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getEnvVars(a.dir.Prefix(envOverridesPath, env), sp)
+}
+
+// EnvironmentVersion returns the current version of the app's environment.
+// Every change to the environment increases the version by one, 0 means no
+// environment was ever set.
+func (a *App) EnvironmentVersion() (int, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return -1, err
+	}
+	return getEnvVersion(a, sp)
+}
+
+// GetEnvironmentAt returns the environment variables as they were set at the
+// given version.
+func (a *App) GetEnvironmentAt(version int) (map[string]string, error) {
+	vars := map[string]string{}
+	if version == 0 {
+		return vars, nil
+	}
+
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	_, err = sp.GetFile(a.dir.Prefix(envVersionsPath, strconv.Itoa(version)), &cp.JsonCodec{DecodedVal: &vars})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "environment version %d not found for %s", version, a.Name)
+		}
+		return nil, err
+	}
+	return vars, nil
+}
+
+// getEnvOverrides returns the variables overridden per env, by env.
+func getEnvOverrides(a *App, sp cp.Snapshot) (map[string]map[string]string, error) {
+	overrides := map[string]map[string]string{}
+	envs, err := sp.Getdir(a.dir.Prefix(envOverridesPath))
+	if cp.IsErrNoEnt(err) {
+		return overrides, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range envs {
+		vars, err := getEnvVars(a.dir.Prefix(envOverridesPath, env), sp)
+		if err != nil {
+			return nil, err
+		}
+		if len(vars) > 0 {
+			overrides[env] = vars
+		}
+	}
+	return overrides, nil
+}
+
+// getEnvOverridesAt returns the overrides as they were at the given version,
+// nil if they weren't recorded with it.
+func getEnvOverridesAt(a *App, version int, sp cp.Snapshot) (map[string]map[string]string, error) {
+	overrides := map[string]map[string]string{}
+	_, err := sp.GetFile(a.dir.Prefix(envOverrideVersionsPath, strconv.Itoa(version)), &cp.JsonCodec{DecodedVal: &overrides})
+	if cp.IsErrNoEnt(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// RollbackEnvironment restores the environment variables and the per-env
+// overrides of the given version. Overrides are left alone for versions
+// which didn't record them. The rollback itself is recorded as a new
+// version.
+func (a *App) RollbackEnvironment(version int) (_ *App, err error) {
+	defer a.annotate(&err, "rollback-env")
+	if err := guardOf(a).authorize("", AuditEnv, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	vars, err := a.GetEnvironmentAt(version)
+	if err != nil {
+		return nil, err
+	}
+	current, err := a.EnvironmentVars()
+	if err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := getEnvOverridesAt(a, version, sp)
+	if err != nil {
+		return nil, err
+	}
+	if overrides != nil {
+		if a, err = a.restoreEnvOverrides(overrides, sp); err != nil {
+			return nil, err
+		}
+	}
+
+	for k := range current {
+		if _, ok := vars[k]; ok {
+			continue
+		}
+		if a, err = a.delEnvironmentVar(k); err != nil {
+			return nil, err
+		}
+		delete(a.Env, k)
+	}
+	for k, v := range vars {
+		if cv, ok := current[k]; ok && cv == v {
+			continue
+		}
+		if a, err = a.setEnvironmentVar(k, v); err != nil {
+			return nil, err
+		}
+		a.Env[k] = v
+	}
+
+	if a, err = a.recordEnvVersion(); err != nil {
+		return nil, err
+	}
+	audit(a, "", AuditEnv, "app:"+a.Name)
+	return a, nil
+}
+
+// restoreEnvOverrides sets the per-env overrides to the given ones.
+func (a *App) restoreEnvOverrides(overrides map[string]map[string]string, sp cp.Snapshot) (*App, error) {
+	current, err := getEnvOverrides(a, sp)
+	if err != nil {
+		return nil, err
+	}
+	for env, vars := range current {
+		for k := range vars {
+			if _, ok := overrides[env][k]; ok {
+				continue
+			}
+			if a, err = a.delEnvironmentVarForEnv(env, k); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for env, vars := range overrides {
+		for k, v := range vars {
+			if cv, ok := current[env][k]; ok && cv == v {
+				continue
+			}
+			if a, err = a.setEnvironmentVarForEnv(env, k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a, nil
+}
+
+// recordEnvVersion stores the current environment and overrides under the
+// next version and increments the version of the app.
+func (a *App) recordEnvVersion() (*App, error) {
+	for {
+		sp, err := a.GetSnapshot().FastForward()
+		if err != nil {
+			return nil, err
+		}
+		a.dir = a.dir.Join(sp)
+
+		vars, err := a.EnvironmentVars()
+		if err != nil {
+			return nil, err
+		}
+
+		version := 0
+		f, err := sp.GetFile(a.dir.Prefix(envVersionPath), new(cp.IntCodec))
+		if cp.IsErrNoEnt(err) {
+			f = nil
+		} else if err != nil {
+			return nil, err
+		} else {
+			version = f.Value.(int)
+		}
+		version++
+
+		vf := cp.NewFile(a.dir.Prefix(envVersionsPath, strconv.Itoa(version)), vars, new(cp.JsonCodec), sp)
+		if _, err = vf.Save(); err != nil {
+			return nil, err
+		}
+		overrides, err := getEnvOverrides(a, sp)
+		if err != nil {
+			return nil, err
+		}
+		of := cp.NewFile(a.dir.Prefix(envOverrideVersionsPath, strconv.Itoa(version)), overrides, new(cp.JsonCodec), sp)
+		if _, err = of.Save(); err != nil {
+			return nil, err
+		}
+
+		// The version file is written last, as it triggers EvAppEnv.
+		if f == nil {
+			f, err = cp.NewFile(a.dir.Prefix(envVersionPath), version, new(cp.IntCodec), sp).Save()
+		} else {
+			f, err = f.Set(version)
+		}
+		if err == nil {
+			a.EnvVersion = version
+			a.dir = a.dir.Join(f)
+			return a, nil
+		}
+		if !cp.IsErrRevMismatch(err) {
+			return nil, err
+		}
+		time.Sleep(time.Second / 10)
+	}
+}
+
 // GetRevisions returns all registered Revisions for the App
 func (a *App) GetRevisions() ([]*Revision, error) {
 	sp, err := a.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
 	}
+	return getRevisions(a, sp)
+}
 
+func getRevisions(a *App, sp cp.Snapshot) ([]*Revision, error) {
 	revs, err := sp.Getdir(a.dir.Prefix("revs"))
 	if err != nil {
 		return nil, err
 	}
 
 	revisions := []*Revision{}
-	ch, errch := cp.GetSnapshotables(revs, func(name string) (cp.Snapshotable, error) {
+	ch, errch := getSnapshotables(a.reads, revs, func(name string) (cp.Snapshotable, error) {
 		return getRevision(a, name, sp)
 	})
 	for i := 0; i < len(revs); i++ {
@@ -262,6 +639,10 @@ func (a *App) GetProcs() (procs []*Proc, err error) {
 	if err != nil {
 		return
 	}
+	return getProcs(a, sp)
+}
+
+func getProcs(a *App, sp cp.Snapshot) (procs []*Proc, err error) {
 	names, err := sp.Getdir(a.dir.Prefix(procsPath))
 	if err != nil || len(names) == 0 {
 		if cp.IsErrNoEnt(err) {
@@ -269,7 +650,7 @@ func (a *App) GetProcs() (procs []*Proc, err error) {
 		}
 		return
 	}
-	ch, errch := cp.GetSnapshotables(names, func(name string) (cp.Snapshotable, error) {
+	ch, errch := getSnapshotables(a.reads, names, func(name string) (cp.Snapshotable, error) {
 		return getProc(a, name, sp)
 	})
 	for i := 0; i < len(names); i++ {
@@ -300,38 +681,100 @@ func (a *App) GetInstances() ([]*Instance, error) {
 	return result, nil
 }
 
-// WatchEvent watches for events related to the app
+// Watch starts a Watcher for the events of the app and its instances.
+// Optionally the events can be filtered by type. It stops once ctx is done or
+// the Watcher is stopped, which closes its channel.
+func (a *App) Watch(ctx context.Context, filter ...EventType) (*Watcher, error) {
+	return storeFromSnapshotable(a).Watch(ctx, WatchOptions{Filter: filter, app: a.Name})
+}
+
+// WatchEvent watches for events related to the app. The listener channel is
+// closed once the underlying watcher stops.
+//
+// DEPRECATED: WatchEvent can't be stopped, Watch should be used instead.
 func (a *App) WatchEvent(listener chan *Event) {
-	ch := make(chan *Event)
+	defer close(listener)
 
-	go storeFromSnapshotable(a).WatchEvent(ch)
+	w, err := a.Watch(context.Background())
+	if err != nil {
+		return
+	}
+	for e := range w.Events() {
+		listener <- e
+	}
+}
 
-	for e := range ch {
-		if e.Path.App != nil && *e.Path.App == a.Name {
-			listener <- e
-		}
-		if i, ok := e.Source.(*Instance); ok && i.AppName == a.Name {
-			listener <- e
-		}
+// SetActiveEnv points the proc at the env whose instances receive its
+// traffic, which lets proxies flip between two parallel envs like blue and
+// green at once. It emits EvProcActiveEnv.
+func (a *App) SetActiveEnv(proc, env string) (*App, error) {
+	object := "proc:" + a.Name + ":" + proc
+	if err := guardOf(a).authorize("", AuditAttrs, object); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
 	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	exists, _, err := sp.Exists(a.dir.Prefix(procsPath, proc, registeredPath))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errorf(ErrNotFound, "proc %s not found for app %s", proc, a.Name)
+	}
+	sp, err = sp.Set(a.dir.Prefix(procsPath, proc, procsActiveEnvPath), env)
+	if err != nil {
+		return nil, err
+	}
+	audit(sp, actorOf(a), AuditAttrs, object)
+	a.dir = a.dir.Join(sp)
+	return a, nil
+}
+
+// GetActiveEnv returns the env which receives the traffic of the proc, empty
+// if none was set.
+func (a *App) GetActiveEnv(proc string) (string, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return "", err
+	}
+	return getActiveEnv(a.Name, proc, sp)
+}
+
+func getActiveEnv(app, proc string, sp cp.Snapshot) (string, error) {
+	env, _, err := sp.Get(path.Join(appsPath, app, procsPath, proc, procsActiveEnvPath))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return "", err
+	}
+	return env, nil
 }
 
 func (a *App) String() string {
 	return fmt.Sprintf("App<%s>{stack: %s, type: %s}", a.Name, a.Stack, a.DeployType)
 }
 
+func (a *App) annotate(err *error, op string) {
+	annotate(err, op, "app:"+a.Name, a.dir.Name, a.dir.Snapshot.Rev)
+}
+
 // GetApp fetches an app with the given name.
 func (s *Store) GetApp(name string) (*App, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
-	return getApp(name, sp)
+	app, err := getApp(name, s.join(sp))
+	annotate(&err, "get", "app:"+name, path.Join(appsPath, name), sp.Rev)
+	return app, err
 }
 
 // GetApps returns the list of all registered Apps.
 func (s *Store) GetApps() ([]*App, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
@@ -345,8 +788,8 @@ func (s *Store) GetApps() ([]*App, error) {
 	}
 
 	apps := []*App{}
-	ch, errch := cp.GetSnapshotables(names, func(name string) (cp.Snapshotable, error) {
-		return getApp(name, sp)
+	ch, errch := getSnapshotables(s.reads, names, func(name string) (cp.Snapshotable, error) {
+		return getApp(name, s.join(sp))
 	})
 	for i := 0; i < len(names); i++ {
 		select {
@@ -376,6 +819,7 @@ func getApp(name string, s cp.Snapshotable) (*App, error) {
 	app.RepoURL = value["repo-url"].(string)
 	app.Stack = value["stack"].(string)
 	app.DeployType = value["deploy-type"].(string)
+	app.UpdatedBy, _ = value["updated-by"].(string)
 
 	f, err = app.dir.GetFile(registeredPath, new(cp.StringCodec))
 	if err != nil {
@@ -384,10 +828,31 @@ func getApp(name string, s cp.Snapshotable) (*App, error) {
 		}
 		return nil, err
 	}
-	app.Registered, err = parseTime(f.Value.(string))
+	app.Registered, app.RegisteredBy, err = parseRegistered(f.Value.(string))
+	if err != nil {
+		return nil, err
+	}
+
+	app.EnvVersion, err = getEnvVersion(app, sp)
+	if err != nil {
+		return nil, err
+	}
+
+	app.Labels, err = getLabels(app.dir)
 	if err != nil {
 		return nil, err
 	}
 
 	return app, nil
 }
+
+func getEnvVersion(a *App, sp cp.Snapshot) (int, error) {
+	f, err := sp.GetFile(a.dir.Prefix(envVersionPath), new(cp.IntCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return 0, nil
+		}
+		return -1, err
+	}
+	return f.Value.(int), nil
+}
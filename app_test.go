@@ -7,6 +7,7 @@ package visor
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -90,6 +91,22 @@ func TestAppRegistration(t *testing.T) {
 	}
 }
 
+func TestAppRegistrationBadName(t *testing.T) {
+	s, app := appSetup("Cat_Log")
+	if _, err := app.Register(); !IsErrBadName(err) {
+		t.Fatalf("expected bad name error, got %v", err)
+	}
+
+	ValidateNames = false
+	defer func() { ValidateNames = true }()
+	if _, err := app.Register(); err != nil {
+		t.Fatalf("expected legacy name to be accepted, got %v", err)
+	}
+	if _, err := s.NewRevision(app, "feature_1", "http://cat.log").Register(); err != nil {
+		t.Errorf("expected legacy ref to be accepted, got %v", err)
+	}
+}
+
 func TestEnvPersistenceOnRegister(t *testing.T) {
 	_, app := appSetup("envyapp")
 
@@ -297,6 +314,47 @@ func TestAppGetProcs(t *testing.T) {
 	}
 }
 
+func TestAppSetActiveEnv(t *testing.T) {
+	s, app := appSetup("blue-green-cat")
+	if _, err := s.NewProc(app, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := app.GetActiveEnv("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env != "" {
+		t.Errorf("expected no active env, got %q", env)
+	}
+
+	if _, err := app.SetActiveEnv("worker", "blue"); !IsErrNotFound(err) {
+		t.Errorf("expected unknown proc to not be found, got %v", err)
+	}
+	if _, err := app.SetActiveEnv("web", "blue/green"); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid env to be rejected, got %v", err)
+	}
+
+	l := make(chan *Event)
+	go storeFromSnapshotable(app).WatchEvent(l, EvProcActiveEnv)
+
+	for _, want := range []string{"blue", "green"} {
+		if app, err = app.SetActiveEnv("web", want); err != nil {
+			t.Fatal(err)
+		}
+		if env, err = app.GetActiveEnv("web"); err != nil {
+			t.Fatal(err)
+		}
+		if env != want {
+			t.Errorf("expected active env %s, got %q", want, env)
+		}
+		ev := expectEvent(EvProcActiveEnv, &Proc{}, l, t)
+		if ev.Path.Env == nil || *ev.Path.Env != want {
+			t.Errorf("expected event for env %s, got %s", want, ev.Path)
+		}
+	}
+}
+
 func TestAppGetInstances(t *testing.T) {
 	s, app := appSetup("likes")
 
@@ -347,3 +405,156 @@ func TestApps(t *testing.T) {
 		}
 	}
 }
+
+func TestAppsAt(t *testing.T) {
+	s, _ := appSetup("time-travel")
+
+	a, err := s.NewApp("past", "zebra", "joke").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := a.GetSnapshot().Rev
+
+	_, err = s.NewApp("future", "zebra", "joke").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := s.At(rev)
+
+	apps, err := pinned.GetApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].Name != "past" {
+		t.Errorf("expected only app past at rev %d, got %v", rev, apps)
+	}
+
+	_, err = pinned.RegisterInstance("past", "rev", "web", "default")
+	if !IsErrReadOnly(err) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	// Stores derived from the pinned one or its objects stay read-only.
+	if _, err = pinned.As("alice").RegisterInstance("past", "rev", "web", "default"); !IsErrReadOnly(err) {
+		t.Errorf("expected ErrReadOnly acting as alice, got %v", err)
+	}
+	if _, err = storeFromSnapshotable(apps[0]).RegisterInstance("past", "rev", "web", "default"); !IsErrReadOnly(err) {
+		t.Errorf("expected ErrReadOnly through an app at rev %d, got %v", rev, err)
+	}
+
+	s, err = pinned.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err = s.GetApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("expected 2 apps after fast-forward, got %d", len(apps))
+	}
+}
+
+func TestEnvironmentVersions(t *testing.T) {
+	_, app := appSetup("versioned")
+	app.Env["COW"] = "moo"
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.EnvVersion != 1 {
+		t.Fatalf("expected env version 1 after register, got %d", app.EnvVersion)
+	}
+
+	app, err = app.SetEnvironmentVar("CAT", "meow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err = app.DelEnvironmentVar("COW")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := app.EnvironmentVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 3 {
+		t.Fatalf("expected env version 3, got %d", v)
+	}
+
+	vars, err := app.GetEnvironmentAt(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"COW": "moo", "CAT": "meow"}; !reflect.DeepEqual(want, vars) {
+		t.Errorf("want %v, have %v", want, vars)
+	}
+
+	app, err = app.RollbackEnvironment(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.EnvVersion != 4 {
+		t.Errorf("expected rollback to record version 4, got %d", app.EnvVersion)
+	}
+	vars, err = app.EnvironmentVars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"COW": "moo"}; !reflect.DeepEqual(want, vars) {
+		t.Errorf("want %v, have %v", want, vars)
+	}
+
+	if _, err := app.GetEnvironmentAt(10); !IsErrNotFound(err) {
+		t.Errorf("expected ErrNotFound for unknown version, got %v", err)
+	}
+}
+
+func TestEnvironmentVersionsForEnv(t *testing.T) {
+	_, app := appSetup("versioned-envs")
+	app.Env["COW"] = "moo"
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"", "..", "../env"} {
+		if _, err := app.SetEnvironmentVarForEnv(env, "CAT", "meow"); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for env %q, got %v", env, err)
+		}
+		if _, err := app.DelEnvironmentVarForEnv(env, "CAT"); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for env %q, got %v", env, err)
+		}
+	}
+
+	if app, err = app.SetEnvironmentVarForEnv("prod", "CAT", "meow"); err != nil {
+		t.Fatal(err)
+	}
+	version := app.EnvVersion
+	if version != 2 {
+		t.Errorf("expected override to record version 2, got %d", version)
+	}
+	if app, err = app.SetEnvironmentVarForEnv("prod", "CAT", "purr"); err != nil {
+		t.Fatal(err)
+	}
+	if app, err = app.SetEnvironmentVarForEnv("staging", "DOG", "woof"); err != nil {
+		t.Fatal(err)
+	}
+
+	if app, err = app.RollbackEnvironment(version); err != nil {
+		t.Fatal(err)
+	}
+	for env, want := range map[string]map[string]string{
+		"prod":    {"CAT": "meow"},
+		"staging": {},
+	} {
+		vars, err := app.EnvironmentVarsForEnv(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, vars) {
+			t.Errorf("%s: want %v, have %v", env, want, vars)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Archive of done records, one file per day of termination.
+const procsArchivePath = "archive"
+
+// CompactTerminated moves the done records of instances which terminated
+// more than olderThan ago into one compressed archive file per day, which
+// keeps the done lookup small. Archived records are read with
+// GetArchivedInstances. Failed and lost records belong to instances which are
+// still registered and are left alone. It returns the number of records
+// archived.
+func (p *Proc) CompactTerminated(olderThan time.Duration) (int, error) {
+	if olderThan < 0 {
+		return 0, errorf(ErrInvalidArgument, "age must not be negative")
+	}
+	if err := guardOf(p).authorize("", ActionCompact, p.auditName()); err != nil {
+		return 0, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return 0, err
+	}
+	// Counters of procs without a counters file fall back to the number of
+	// done records, so keep what they count now.
+	c, f, err := getProcCounters(p.dir.Name, sp)
+	if err != nil {
+		return 0, err
+	}
+	if f == nil {
+		if _, err := cp.NewFile(p.dir.Prefix(procsCountersPath), c, new(cp.JsonCodec), sp).Save(); err != nil {
+			return 0, err
+		}
+	}
+
+	ids, err := getdirOrEmpty(sp, p.DoneInstancesPath())
+	if err != nil {
+		return 0, err
+	}
+	deadline := time.Now().Add(-olderThan)
+	days := map[string][]*Instance{}
+	for _, idstr := range ids {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return 0, err
+		}
+		ins, err := getSerialisedInstance(p.App.Name, p.Name, id, InsStatusDone, sp)
+		if err != nil {
+			return 0, err
+		}
+		if ins.Termination.Time.After(deadline) {
+			continue
+		}
+		day := formatDay(ins.Termination.Time)
+		days[day] = append(days[day], ins)
+	}
+
+	n := 0
+	for day, records := range days {
+		if err := p.archive(day, records); err != nil {
+			return n, err
+		}
+		// Records are only removed once archived, a failure in between
+		// leaves duplicates which are skipped when archiving again.
+		for _, ins := range records {
+			err := sp.Del(ins.procDonePath())
+			if err != nil && !cp.IsErrNoEnt(err) {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	audit(p, "", ActionCompact, p.auditName())
+	return n, nil
+}
+
+// GetArchivedInstances returns the archived records of instances which
+// terminated at or after t, ordered by id per day. Only the archive files
+// from the day of t on are read.
+func (p *Proc) GetArchivedInstances(t time.Time) ([]*Instance, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	days, err := getdirOrEmpty(sp, p.dir.Prefix(procsArchivePath))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(days)
+
+	since := formatDay(t)
+	instances := []*Instance{}
+	for _, day := range days {
+		if day < since {
+			continue
+		}
+		records, _, err := getArchive(p.dir.Prefix(procsArchivePath, day), sp)
+		if err != nil {
+			return nil, err
+		}
+		for _, ins := range records {
+			if !ins.Termination.Time.Before(t) {
+				instances = append(instances, ins)
+			}
+		}
+	}
+	return instances, nil
+}
+
+// archive adds the records to the archive file of the day, retrying when it
+// was changed concurrently. Records already archived are skipped.
+func (p *Proc) archive(day string, records []*Instance) error {
+	name := p.dir.Prefix(procsArchivePath, day)
+	for {
+		sp, err := p.GetSnapshot().FastForward()
+		if err != nil {
+			return err
+		}
+		archived, f, err := getArchive(name, sp)
+		if err != nil {
+			return err
+		}
+		seen := map[int64]bool{}
+		for _, ins := range archived {
+			seen[ins.ID] = true
+		}
+		for _, ins := range records {
+			if !seen[ins.ID] {
+				archived = append(archived, ins)
+			}
+		}
+		sort.Sort(instancesByID(archived))
+
+		if f == nil {
+			f = cp.NewFile(name, nil, archiveCodec(nil), sp)
+		}
+		if _, err := f.Set(archived); err == nil {
+			return nil
+		} else if !cp.IsErrRevMismatch(err) {
+			return err
+		}
+		time.Sleep(time.Second / 100)
+	}
+}
+
+// archiveCodec reads archive files into v and always writes them compressed.
+func archiveCodec(v interface{}) *fileCodec {
+	return &fileCodec{codec: GzipJSONCodec{}, DecodedVal: v}
+}
+
+// getArchive returns the records of the archive file at name and the file,
+// which is nil if there is no archive yet.
+func getArchive(name string, sp cp.Snapshot) ([]*Instance, *cp.File, error) {
+	records := []*Instance{}
+	f, err := sp.GetFile(name, archiveCodec(&records))
+	if cp.IsErrNoEnt(err) {
+		return []*Instance{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, ins := range records {
+		ins.dir = cp.NewDir(instancePath(ins.ID), sp)
+	}
+	return records, f, nil
+}
+
+// getArchivedRecord returns the archived record of the instance which
+// terminated on day, nil if it isn't archived.
+func getArchivedRecord(app, proc string, id int64, day string, sp cp.Snapshot) (*Instance, error) {
+	records, _, err := getArchive(path.Join(appsPath, app, procsPath, proc, procsArchivePath, day), sp)
+	if err != nil {
+		return nil, err
+	}
+	for _, ins := range records {
+		if ins.ID == id {
+			return ins, nil
+		}
+	}
+	return nil, nil
+}
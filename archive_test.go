@@ -0,0 +1,95 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcCompactTerminated(t *testing.T) {
+	var (
+		appid  = "compact-app"
+		s, app = procSetup(appid)
+	)
+
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	ids := map[int64]bool{}
+	for i := 0; i < 3; i++ {
+		ins, err := s.RegisterInstance(appid, "128af9", "web", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if ins, err = ins.Failed("10.0.2.12", errors.New("no reason")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ins.Unregister("archive-test", errors.New("done here")); err != nil {
+			t.Fatal(err)
+		}
+		ids[ins.ID] = true
+	}
+
+	n, err := proc.CompactTerminated(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected recent records to be kept, got %d archived", n)
+	}
+
+	n, err = proc.CompactTerminated(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(ids) {
+		t.Errorf("expected %d records to be archived, got %d", len(ids), n)
+	}
+
+	done, err := proc.GetDoneInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("expected done lookup to be empty, got %d records", len(done))
+	}
+
+	archived, err := proc.GetArchivedInstances(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != len(ids) {
+		t.Fatalf("expected %d archived records, got %d", len(ids), len(archived))
+	}
+	for _, ins := range archived {
+		if !ids[ins.ID] || ins.Termination.Reason != "done here" {
+			t.Errorf("unexpected archived record %#v", ins)
+		}
+	}
+
+	failed, err := proc.GetFailedInstancesSince(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 {
+		t.Errorf("expected archived failure to be found, got %d", len(failed))
+	}
+
+	c, err := proc.Counters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done != len(ids) {
+		t.Errorf("expected %d done instances to be counted, got %d", len(ids), c.Done)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	auditEnabledPath = "audit/enabled"
+	auditLogPath     = "audit/log"
+)
+
+// Audited operations.
+const (
+	AuditRegister      = "register"
+	AuditUnregister    = "unregister"
+	AuditAttrs         = "attrs"
+	AuditClaim         = "claim"
+	AuditEnv           = "env"
+	AuditLabels        = "labels"
+	AuditTxn           = "txn"
+	AuditSweepClaims   = "sweep-claims"
+	AuditRepairLookups = "repair-lookups"
+)
+
+// AuditRecord describes a single mutating operation performed on the tree.
+type AuditRecord struct {
+	Actor     string    `json:"actor"`
+	Operation string    `json:"operation"`
+	Object    string    `json:"object"`
+	Rev       int64     `json:"rev"`
+	Time      time.Time `json:"time"`
+}
+
+func (r *AuditRecord) String() string {
+	return fmt.Sprintf("AuditRecord{%s %s %s by %q at %d}", r.Time.Format(time.RFC3339), r.Operation, r.Object, r.Actor, r.Rev)
+}
+
+// SetAuditing enables or disables the audit log for the whole cluster. While
+// enabled every Register, Unregister, StoreAttrs and Claim, every change of
+// environments and labels, committed Txn, claim sweep and lookup repair
+// writes an AuditRecord under /audit.
+func (s *Store) SetAuditing(enabled bool) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		err = sp.Del(auditEnabledPath)
+		if cp.IsErrNoEnt(err) {
+			err = nil
+		}
+		return err
+	}
+	_, err = sp.Set(auditEnabledPath, timestamp())
+	return err
+}
+
+// IsAuditing reports whether the audit log is enabled.
+func (s *Store) IsAuditing() (bool, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return false, err
+	}
+	exists, _, err := sp.Exists(auditEnabledPath)
+	return exists, err
+}
+
+// GetAuditLog returns all audit records written since the given time ordered
+// from oldest to newest.
+func (s *Store) GetAuditLog(since time.Time) ([]*AuditRecord, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	names, err := getAuditNames(sp, since, true)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*AuditRecord{}
+	for _, name := range names {
+		r := &AuditRecord{}
+		_, err := sp.GetFile(path.Join(auditLogPath, name), &cp.JsonCodec{DecodedVal: r})
+		if err != nil {
+			if cp.IsErrNoEnt(err) {
+				// Pruned concurrently.
+				continue
+			}
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// PruneAuditLog removes all audit records written before the given time and
+// returns the number of removed records.
+func (s *Store) PruneAuditLog(before time.Time) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return 0, err
+	}
+	names, err := getAuditNames(sp, before, false)
+	if err != nil {
+		return 0, err
+	}
+	for i, name := range names {
+		err := sp.Del(path.Join(auditLogPath, name))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
+
+// getAuditNames returns the sorted record names written after (or before)
+// the given time.
+func getAuditNames(sp cp.Snapshot, t time.Time, after bool) ([]string, error) {
+	names, err := sp.Getdir(auditLogPath)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	sort.Strings(names)
+
+	result := []string{}
+	for _, name := range names {
+		nanos, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+		if err != nil {
+			return nil, errorf(ErrInvalidFile, "invalid audit record name %s", name)
+		}
+		if (nanos >= t.UnixNano()) == after {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// audit writes an AuditRecord for the given operation if auditing is enabled.
+// It's called once the operation succeeded, so failing to write the record is
+// logged instead of failing the operation, which callers would retry.
+func audit(s cp.Snapshotable, actor, op, object string) {
+	if err := writeAudit(s, actor, op, object); err != nil {
+		log.Printf("visor: auditing %s of %s failed: %s", op, object, err)
+	}
+}
+
+// writeAudit writes the AuditRecord of audit. The record carries the revision
+// of the given snapshot. An empty actor defaults to the one set with As.
+func writeAudit(s cp.Snapshotable, actor, op, object string) error {
+	if actor == "" {
+		actor = actorOf(s)
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	exists, _, err := sp.Exists(auditEnabledPath)
+	if err != nil || !exists {
+		return err
+	}
+
+	r := &AuditRecord{
+		Actor:     actor,
+		Operation: op,
+		Object:    object,
+		Rev:       s.GetSnapshot().Rev,
+		Time:      time.Now().UTC(),
+	}
+	// Names sort by time, the revision keeps them unique.
+	name := fmt.Sprintf("%019d-%d", r.Time.UnixNano(), r.Rev)
+
+	f := cp.NewFile(path.Join(auditLogPath, name), r, new(cp.JsonCodec), sp)
+	_, err = f.Save()
+	return err
+}
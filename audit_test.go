@@ -0,0 +1,108 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func auditSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.reset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuditDisabled(t *testing.T) {
+	s := auditSetup(t)
+	start := time.Now()
+
+	if _, err := s.NewApp("quiet", "git://quiet.git", "stack").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.GetAuditLog(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no audit records, got %v", records)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := auditSetup(t)
+	start := time.Now()
+
+	if err := s.SetAuditing(true); err != nil {
+		t.Fatal(err)
+	}
+	app, err := s.NewApp("audited", "git://audited.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, "rev", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.Claim("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ins.Unregister("operator", errors.New("done")); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.GetAuditLog(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ actor, op, object string }{
+		{"", AuditRegister, "app:audited"},
+		{"", AuditRegister, ins.auditName()},
+		{"10.0.0.1", AuditClaim, ins.auditName()},
+		{"operator", AuditUnregister, ins.auditName()},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	for i, e := range expected {
+		r := records[i]
+		if r.Actor != e.actor || r.Operation != e.op || r.Object != e.object {
+			t.Errorf("%d. expected %s %s by %q, got %s", i, e.op, e.object, e.actor, r)
+		}
+	}
+
+	n, err := s.PruneAuditLog(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(expected) {
+		t.Errorf("expected %d pruned records, got %d", len(expected), n)
+	}
+	records, err = s.GetAuditLog(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected empty audit log after pruning, got %v", records)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"sync"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Actions passed to the Authorizer in addition to the audited operations.
+const (
+	ActionUnclaim   = "unclaim"
+	ActionStart     = "start"
+	ActionStop      = "stop"
+	ActionRestart   = "restart"
+	ActionFail      = "fail"
+	ActionLose      = "lose"
+	ActionExit      = "exit"
+	ActionLock      = "lock"
+	ActionScale     = "scale"
+	ActionFlag      = "flag"
+	ActionDrain     = "drain"
+	ActionConfig    = "config"
+	ActionPort      = "port"
+	ActionEndpoints = "endpoints"
+	ActionHealth    = "health"
+	ActionReady     = "ready"
+	ActionRuntime   = "runtime"
+	ActionUsage     = "usage"
+	ActionProtect   = "protect"
+	ActionTrigger   = "trigger"
+	ActionHookRun   = "hook-run"
+	ActionPipeline  = "pipeline"
+	ActionHeartbeat = "heartbeat"
+	ActionDecline   = "decline"
+	ActionCompact   = "compact"
+)
+
+// Authorizer decides whether an actor may perform an action on an object.
+// Objects are named like in the audit log, e.g. "app:cat" or
+// "proc:cat:web". A non-nil error denies the operation.
+type Authorizer interface {
+	Authorize(actor, action, object string) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(actor, action, object string) error
+
+// Authorize calls f.
+func (f AuthorizerFunc) Authorize(actor, action, object string) error {
+	return f(actor, action, object)
+}
+
+// authz holds the Authorizer shared by all Stores derived from the same
+// connection.
+type authz struct {
+	mu sync.RWMutex
+	a  Authorizer
+}
+
+// guard is handed down from a Store to the objects fetched through it and
+// carries the Authorizer of the connection, the actor set with As and
+// whether the Store was pinned with At.
+type guard struct {
+	authz  *authz
+	actor  string
+	pinned bool
+}
+
+func newGuard() *guard {
+	return &guard{authz: &authz{}}
+}
+
+// authorize asks the Authorizer, if any, for permission. An empty actor
+// defaults to the one of the guard. Denials are returned as ErrUnauthorized.
+func (g *guard) authorize(actor, action, object string) error {
+	if g == nil {
+		return nil
+	}
+	if actor == "" {
+		actor = g.actor
+	}
+	g.authz.mu.RLock()
+	a := g.authz.a
+	g.authz.mu.RUnlock()
+	if a == nil {
+		return nil
+	}
+	if err := a.Authorize(actor, action, object); err != nil {
+		if IsErrUnauthorized(err) {
+			return err
+		}
+		return errorf(ErrUnauthorized, "%s %s denied for %q: %w", action, object, actor, err)
+	}
+	return nil
+}
+
+func guardOf(s cp.Snapshotable) *guard {
+	switch v := s.(type) {
+	case *Store:
+		return v.guard
+	case *App:
+		return appGuard(v)
+	case *Proc:
+		if v.guard != nil {
+			return v.guard
+		}
+		return appGuard(v.App)
+	case *Revision:
+		return appGuard(v.App)
+	case *Tag:
+		return appGuard(v.App)
+	case *Hook:
+		return appGuard(v.App)
+	case *Env:
+		return appGuard(v.App)
+	case *Instance:
+		return v.guard
+	case *Runner:
+		return v.guard
+	case *DeadLetter:
+		return v.guard
+	case *Offer:
+		return v.guard
+	}
+	return nil
+}
+
+// actorOf returns the actor set with As on the Store the object was obtained
+// through, empty if none.
+func actorOf(s cp.Snapshotable) string {
+	if g := guardOf(s); g != nil {
+		return g.actor
+	}
+	return ""
+}
+
+func appGuard(a *App) *guard {
+	if a == nil {
+		return nil
+	}
+	return a.guard
+}
+
+// SetAuthorizer installs an Authorizer which is asked before registering,
+// unregistering or storing the attrs of apps, procs, revisions, tags, hooks,
+// envs, runners, services and instances, before any state transition or
+// lock of instances, before claiming ports and configuring port pools,
+// before changing environments, labels, scales, flags, proxy states,
+// maintenance modes, deploy freezes or draining procs and before committing
+// a Txn, sweeping claims or repairing lookups. It applies to all Stores
+// derived from the same connection and the objects obtained through them. A
+// nil Authorizer permits everything.
+func (s *Store) SetAuthorizer(a Authorizer) {
+	s.guard.authz.mu.Lock()
+	defer s.guard.authz.mu.Unlock()
+	s.guard.authz.a = a
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func authzSetup() *Store {
+	s, err := DialURI(DefaultURI, "/authz-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestAuthorizer(t *testing.T) {
+	s := authzSetup()
+
+	app, err := s.NewApp("open-cat", "git://authz.git", "master").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []string{}
+	s.SetAuthorizer(AuthorizerFunc(func(actor, action, object string) error {
+		calls = append(calls, action+" "+object)
+		if strings.HasPrefix(object, "proc:open-cat:") || object == "app:locked-cat" {
+			return errors.New("owned by another team")
+		}
+		return nil
+	}))
+
+	if _, err := s.NewApp("locked-cat", "git://authz.git", "master").Register(); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	if _, err := s.GetApp("locked-cat"); !IsErrNotFound(err) {
+		t.Errorf("expected denied app not to be registered, got %v", err)
+	}
+
+	// Objects fetched before and after installing the Authorizer share it.
+	if _, err := s.NewProc(app, "web").Register(); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	if _, err := s.NewApp("other-cat", "git://authz.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.GetApp("other-cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewProc(other, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 4 {
+		t.Errorf("expected 4 authorizations, got %v", calls)
+	}
+
+	s.SetAuthorizer(nil)
+	if _, err := s.NewProc(app, "web").Register(); err != nil {
+		t.Errorf("expected nil authorizer to permit everything, got %v", err)
+	}
+}
+
+func TestAuthorizerInstanceAndStore(t *testing.T) {
+	s := authzSetup()
+	host := "10.0.0.1"
+
+	ins, err := s.RegisterInstance("authz-cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(host); err != nil {
+		t.Fatal(err)
+	}
+	app, err := s.NewApp("authz-dog", "git://authz.git", "master").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var call string
+	s.SetAuthorizer(AuthorizerFunc(func(actor, action, object string) error {
+		call = actor + " " + action + " " + object
+		return errors.New("read-only")
+	}))
+
+	object := ins.auditName()
+	for _, c := range []struct {
+		want string
+		op   func() error
+	}{
+		{host + " start " + object, func() error {
+			_, err := ins.Starting(host)
+			return err
+		}},
+		{host + " unclaim " + object, func() error {
+			_, err := ins.Unclaim(host)
+			return err
+		}},
+		{host + " fail " + object, func() error {
+			_, err := ins.Failed(host, errors.New("crashed"))
+			return err
+		}},
+		{"watchdog lose " + object, func() error {
+			_, err := ins.Lost("watchdog", errors.New("gone"))
+			return err
+		}},
+		{" restart " + object, func() error {
+			return ins.RequestRestart("env changed")
+		}},
+		{"ops lock " + object, func() error {
+			_, err := ins.Lock("ops", errors.New("debugging"))
+			return err
+		}},
+		{" port host:" + host, func() error {
+			_, err := s.ClaimHostPort(host)
+			return err
+		}},
+		{" port port-pool:worker", func() error {
+			_, err := s.SetPortPool("worker", 20000, 20010)
+			return err
+		}},
+		{" register service:logger:10.0.0.2:5000", func() error {
+			_, err := s.RegisterService(ServiceLogger, "10.0.0.2:5000", nil, 0)
+			return err
+		}},
+		{" env app:authz-dog", func() error {
+			_, err := app.SetEnvironmentVar("CAT", "meow")
+			return err
+		}},
+		{" env app:authz-dog@prod", func() error {
+			_, err := app.DelEnvironmentVarForEnv("prod", "CAT")
+			return err
+		}},
+		{" env app:authz-dog", func() error {
+			_, err := app.RollbackEnvironment(1)
+			return err
+		}},
+		{" env proc:authz-dog:web", func() error {
+			_, err := proc.SetEnvironmentVar("CAT", "meow")
+			return err
+		}},
+		{" labels " + object, func() error {
+			_, err := ins.SetLabels(map[string]string{"team": "cats"})
+			return err
+		}},
+		{" txn path:/authz/a", func() error {
+			_, err := s.Txn().Set("/authz/a", "1").Commit()
+			return err
+		}},
+		{" sweep-claims cluster", func() error {
+			_, err := s.SweepClaims(time.Minute)
+			return err
+		}},
+		{" repair-lookups cluster", func() error {
+			_, err := s.RepairInstanceLookups()
+			return err
+		}},
+	} {
+		call = ""
+		if err := c.op(); !IsErrUnauthorized(err) {
+			t.Errorf("expected %q to be unauthorized, got %v", c.want, err)
+		}
+		if call != c.want {
+			t.Errorf("expected authorization %q, got %q", c.want, call)
+		}
+	}
+
+	s.SetAuthorizer(nil)
+	if _, err := ins.Unclaim(host); err != nil {
+		t.Errorf("expected unclaim to be permitted, got %v", err)
+	}
+}
+
+func TestStoreAs(t *testing.T) {
+	s := authzSetup()
+	if err := s.SetAuditing(true); err != nil {
+		t.Fatal(err)
+	}
+	actors := []string{}
+	s.SetAuthorizer(AuthorizerFunc(func(actor, action, object string) error {
+		actors = append(actors, actor)
+		return nil
+	}))
+
+	bot := s.As("deploy-bot")
+	if bot.Actor() != "deploy-bot" || s.Actor() != "" {
+		t.Fatalf("expected actor only on the derived store, got %q and %q", bot.Actor(), s.Actor())
+	}
+	if _, err := bot.NewApp("actor-cat", "git://authz.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+	app, err := s.GetApp("actor-cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.RegisteredBy != "deploy-bot" || app.UpdatedBy != "deploy-bot" {
+		t.Errorf("expected app registered by deploy-bot, got %q/%q", app.RegisteredBy, app.UpdatedBy)
+	}
+
+	proc, err := bot.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc.RegisteredBy != "deploy-bot" {
+		t.Errorf("expected proc registered by deploy-bot, got %q", proc.RegisteredBy)
+	}
+	botApp, err := bot.GetApp("actor-cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err = botApp.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.MaxRestarts = 2
+	if _, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	if proc, err = app.GetProc("web"); err != nil {
+		t.Fatal(err)
+	}
+	if proc.Attrs.UpdatedBy != "deploy-bot" {
+		t.Errorf("expected attrs updated by deploy-bot, got %q", proc.Attrs.UpdatedBy)
+	}
+
+	if len(actors) != 3 || actors[0] != "deploy-bot" || actors[1] != "deploy-bot" || actors[2] != "deploy-bot" {
+		t.Errorf("expected actors passed to the authorizer, got %q", actors)
+	}
+	records, err := s.GetAuditLog(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || records[0].Actor != "deploy-bot" {
+		t.Errorf("expected audit records by deploy-bot, got %v", records)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const claimHistoryPath = "claim-history"
+
+// ClaimOutcome is how a claim of an instance ended.
+type ClaimOutcome string
+
+// ClaimOutcomes.
+const (
+	ClaimHeld      ClaimOutcome = "held"
+	ClaimUnclaimed ClaimOutcome = "unclaimed"
+	ClaimExited    ClaimOutcome = "exited"
+	ClaimFailed    ClaimOutcome = "failed"
+	ClaimLost      ClaimOutcome = "lost"
+)
+
+// StaleClaimPolicy defines what SweepClaims does with instances of a proc
+// which were claimed but not started in time.
+type StaleClaimPolicy string
+
+// StaleClaimPolicies.
+const (
+	// Release the claim, so another host can claim the instance.
+	StaleClaimUnclaim StaleClaimPolicy = "unclaim"
+	// Fail the instance, so it's replaced according to the proc's policies.
+	StaleClaimFail StaleClaimPolicy = "fail"
+)
+
+// ClaimSweep lists the instances changed by SweepClaims.
+type ClaimSweep struct {
+	Unclaimed []int64
+	Failed    []int64
+}
+
+func (c *ClaimSweep) String() string {
+	return fmt.Sprintf("ClaimSweep{unclaimed: %d, failed: %d}", len(c.Unclaimed), len(c.Failed))
+}
+
+// errStaleClaim is the reason recorded for instances failed by SweepClaims.
+var errStaleClaim = errors.New("claim went stale")
+
+// SweepClaims releases the claims of instances which were claimed longer
+// than maxClaimAge ago but neither started nor reported starting. Depending
+// on the StaleClaims policy of their proc the instances are unclaimed or
+// failed on behalf of the actor of the Store, which emits EvInsUnclaim or
+// EvInsFail. Instances which change concurrently are left alone.
+func (s *Store) SweepClaims(maxClaimAge time.Duration) (*ClaimSweep, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if err := s.guard.authorize("", AuditSweepClaims, "cluster"); err != nil {
+		return nil, err
+	}
+	if maxClaimAge <= 0 {
+		return nil, errorf(ErrInvalidArgument, "max claim age must be positive")
+	}
+	claimed, err := s.GetInstancesByStatus(InsStatusClaimed)
+	if err != nil {
+		return nil, err
+	}
+	sweep := &ClaimSweep{Unclaimed: []int64{}, Failed: []int64{}}
+	deadline := time.Now().Add(-maxClaimAge)
+
+	for _, ins := range claimed {
+		t, err := getClaimTime(ins.dir, ins.IP)
+		if err != nil {
+			return nil, err
+		}
+		if t.After(deadline) {
+			continue
+		}
+		attrs, err := getProcAttrsForEnv(ins.AppName, ins.ProcessName, ins.Env, ins.GetSnapshot())
+		if err != nil {
+			return nil, err
+		}
+		claimer, err := ins.getClaimer()
+		if cp.IsErrNoEnt(err) {
+			// Unregistered in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if claimer == nil || *claimer != ins.IP {
+			// Claimed by another host in the meantime.
+			continue
+		}
+		if attrs.StaleClaims == StaleClaimFail {
+			err = ins.guard.authorize("", ActionFail, ins.auditName())
+			if err == nil {
+				_, err = ins.fail(s.Actor(), errStaleClaim)
+			}
+		} else {
+			err = ins.guard.authorize("", ActionUnclaim, ins.auditName())
+			if err == nil {
+				_, err = ins.unclaim(ins.IP)
+			}
+		}
+		if cp.IsErrRevMismatch(err) {
+			// Transitioned in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.StaleClaims == StaleClaimFail {
+			sweep.Failed = append(sweep.Failed, ins.ID)
+		} else {
+			sweep.Unclaimed = append(sweep.Unclaimed, ins.ID)
+		}
+	}
+
+	audit(s, "", AuditSweepClaims, "cluster")
+	return sweep, nil
+}
+
+// getClaimTime returns when the instance was claimed by host, zero if the
+// claim wasn't recorded.
+func getClaimTime(d *cp.Dir, host string) (time.Time, error) {
+	val, _, err := d.Get(path.Join(claimsPath, host))
+	if cp.IsErrNoEnt(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(val)
+}
+
+// ClaimRecord is a single claim of an instance by a host.
+type ClaimRecord struct {
+	Host    string       `json:"host"`
+	Claimed time.Time    `json:"claimed"`
+	Outcome ClaimOutcome `json:"outcome"`
+	// Zero while the claim is held.
+	Released time.Time `json:"released"`
+}
+
+// ClaimHistory returns the claims of the instance, oldest first. Claims made
+// before the history was kept aren't included.
+func (i *Instance) ClaimHistory() ([]*ClaimRecord, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	records, _, err := getClaimHistory(i.dir.Join(sp))
+	return records, err
+}
+
+// recordClaim adds a held claim by host to the history.
+func (i *Instance) recordClaim(host string, claimed time.Time) error {
+	r := &ClaimRecord{Host: host, Claimed: claimed, Outcome: ClaimHeld}
+	p := i.dir.Prefix(claimHistoryPath, strconv.FormatInt(claimed.UnixNano(), 10))
+	_, err := cp.NewFile(p, r, new(cp.JsonCodec), i.GetSnapshot()).Save()
+	return err
+}
+
+// releaseClaim ends the latest claim of the history with outcome, if it's
+// still held.
+func (i *Instance) releaseClaim(outcome ClaimOutcome) error {
+	for {
+		sp, err := i.GetSnapshot().FastForward()
+		if err != nil {
+			return err
+		}
+		records, files, err := getClaimHistory(i.dir.Join(sp))
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		r, f := records[len(records)-1], files[len(files)-1]
+		if r.Outcome != ClaimHeld {
+			return nil
+		}
+		r.Outcome = outcome
+		r.Released = time.Now()
+		if _, err := f.Set(r); err == nil {
+			return nil
+		} else if !cp.IsErrRevMismatch(err) {
+			return err
+		}
+		time.Sleep(time.Second / 100)
+	}
+}
+
+// getClaimHistory returns the claim records below d and their files, oldest
+// first.
+func getClaimHistory(d *cp.Dir) ([]*ClaimRecord, []*cp.File, error) {
+	names, err := getdirOrEmpty(d.Snapshot, d.Prefix(claimHistoryPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(names)
+
+	records := make([]*ClaimRecord, 0, len(names))
+	files := make([]*cp.File, 0, len(names))
+	for _, name := range names {
+		r := &ClaimRecord{}
+		f, err := d.GetFile(path.Join(claimHistoryPath, name), &cp.JsonCodec{DecodedVal: r})
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, r)
+		files = append(files, f)
+	}
+	return records, files, nil
+}
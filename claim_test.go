@@ -0,0 +1,85 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweepClaims(t *testing.T) {
+	host := "10.0.0.1"
+	s := instanceSetup()
+
+	app, err := s.NewApp("sweep", "git://sweep.git", "sweepstack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+	worker := s.NewProc(app, "worker")
+	worker.Attrs.StaleClaims = StaleClaimFail
+	if _, err := worker.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(proc string) *Instance {
+		ins, err := s.RegisterInstance(app.Name, "128af9", proc, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, err = ins.Claim(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ins
+	}
+	web, work := claim("web"), claim("worker")
+	started, err := claim("web").Started(host, "box1.sweep.net", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sweep, err := s.SweepClaims(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sweep.Unclaimed)+len(sweep.Failed) != 0 {
+		t.Errorf("expected recent claims to be kept, got %s", sweep)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	sweep, err = s.SweepClaims(time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sweep.Unclaimed) != 1 || sweep.Unclaimed[0] != web.ID {
+		t.Errorf("expected instance %d to be unclaimed, got %v", web.ID, sweep.Unclaimed)
+	}
+	if len(sweep.Failed) != 1 || sweep.Failed[0] != work.ID {
+		t.Errorf("expected instance %d to be failed, got %v", work.ID, sweep.Failed)
+	}
+	testInstanceStatus(s, t, web.ID, InsStatusPending)
+	testInstanceStatus(s, t, work.ID, InsStatusFailed)
+	testInstanceStatus(s, t, started.ID, InsStatusRunning)
+
+	denied := claim("worker")
+	time.Sleep(10 * time.Millisecond)
+	s.SetAuthorizer(AuthorizerFunc(func(actor, action, object string) error {
+		if action == ActionFail {
+			return errorf(ErrUnauthorized, "no failing")
+		}
+		return nil
+	}))
+	if _, err := s.SweepClaims(time.Millisecond); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	s.SetAuthorizer(nil)
+	testInstanceStatus(s, t, denied.ID, InsStatusClaimed)
+
+	if _, err := s.SweepClaims(0); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"sync"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const codecsPath = "/codecs"
+
+// codecMagic starts files written with a Codec other than JSON, followed by
+// the name of the codec and a NUL byte. Files without it are JSON.
+const codecMagic = "\x00codec:"
+
+// CodecClass names a class of stored files whose encoding can be chosen
+// with SetCodec.
+type CodecClass string
+
+// CodecClasses.
+const (
+	// Proc attrs, proc-wide and per env.
+	CodecProcAttrs = CodecClass("proc-attrs")
+	// Serialised records of terminated instances.
+	CodecInstances = CodecClass("instances")
+)
+
+// Names of the built-in codecs.
+const (
+	CodecJSON     = "json"
+	CodecGzipJSON = "gzip-json"
+)
+
+// Codec encodes the values of stored files. Codecs like protobuf or msgpack
+// can be added with RegisterCodec. Files are always read with the codec
+// they were written with, so every client has to register the codecs in use.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: map[string]Codec{
+	CodecJSON:     JSONCodec{},
+	CodecGzipJSON: GzipJSONCodec{},
+}}
+
+// RegisterCodec makes the codec available for SetCodec and for reading files
+// written with it.
+func RegisterCodec(c Codec) error {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.m[c.Name()]; ok {
+		return errorf(ErrConflict, "codec %s already registered", c.Name())
+	}
+	codecs.m[c.Name()] = c
+	return nil
+}
+
+// Codecs returns the names of all registered codecs.
+func Codecs() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	names := []string{}
+	for name := range codecs.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, errorf(ErrInvalidArgument, "codec %s is not registered", name)
+	}
+	return c, nil
+}
+
+// SetCodec sets the codec files of the class are written with from now on.
+// Existing files are still read with the codec they were written with.
+func (s *Store) SetCodec(class CodecClass, name string) (*Store, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if _, err := lookupCodec(name); err != nil {
+		return nil, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	sp, err = sp.Set(path.Join(codecsPath, string(class)), name)
+	if err != nil {
+		return nil, err
+	}
+	return s.join(sp), nil
+}
+
+// GetCodec returns the name of the codec files of the class are written
+// with.
+func (s *Store) GetCodec(class CodecClass) (string, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return "", err
+	}
+	c, err := getCodec(sp, class)
+	if err != nil {
+		return "", err
+	}
+	return c.Name(), nil
+}
+
+func getCodec(s cp.Snapshotable, class CodecClass) (Codec, error) {
+	name, _, err := s.GetSnapshot().Get(path.Join(codecsPath, string(class)))
+	if cp.IsErrNoEnt(err) {
+		return JSONCodec{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lookupCodec(name)
+}
+
+// fileCodec is the cp.Codec of files of a CodecClass. It encodes with the
+// codec of the class and decodes with the codec named in the file, into
+// DecodedVal.
+type fileCodec struct {
+	codec      Codec
+	DecodedVal interface{}
+}
+
+// encodeCodec returns the cp.Codec to write files of the class with.
+func encodeCodec(s cp.Snapshotable, class CodecClass) (*fileCodec, error) {
+	c, err := getCodec(s, class)
+	if err != nil {
+		return nil, err
+	}
+	return &fileCodec{codec: c}, nil
+}
+
+// decodeCodec returns the cp.Codec to read a file of any class into v.
+func decodeCodec(v interface{}) *fileCodec {
+	return &fileCodec{DecodedVal: v}
+}
+
+// Encode satisfies the cp.Codec interface.
+func (c *fileCodec) Encode(v interface{}) ([]byte, error) {
+	codec := c.codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if codec.Name() == CodecJSON {
+		return b, nil
+	}
+	return append([]byte(codecMagic+codec.Name()+"\x00"), b...), nil
+}
+
+// Decode satisfies the cp.Codec interface.
+func (c *fileCodec) Decode(b []byte) (interface{}, error) {
+	var codec Codec = JSONCodec{}
+	if bytes.HasPrefix(b, []byte(codecMagic)) {
+		b = b[len(codecMagic):]
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return nil, errorf(ErrInvalidFile, "codec header not terminated")
+		}
+		var err error
+		if codec, err = lookupCodec(string(b[:i])); err != nil {
+			return nil, errorf(ErrInvalidFile, "can't decode file: %w", err)
+		}
+		b = b[i+1:]
+	}
+	if c.DecodedVal == nil {
+		var v interface{}
+		err := codec.Unmarshal(b, &v)
+		return v, err
+	}
+	err := codec.Unmarshal(b, c.DecodedVal)
+	return c.DecodedVal, err
+}
+
+// JSONCodec is the default Codec.
+type JSONCodec struct{}
+
+// Name satisfies the Codec interface.
+func (JSONCodec) Name() string { return CodecJSON }
+
+// Marshal satisfies the Codec interface.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal satisfies the Codec interface.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// GzipJSONCodec stores gzip compressed JSON.
+type GzipJSONCodec struct{}
+
+// Name satisfies the Codec interface.
+func (GzipJSONCodec) Name() string { return CodecGzipJSON }
+
+// Marshal satisfies the Codec interface.
+func (GzipJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal satisfies the Codec interface.
+func (GzipJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func codecSetup() (*Store, *Proc) {
+	s, err := DialURI(DefaultURI, "/codec-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp("codec-cat", "git://codec.git", "master").Register()
+	if err != nil {
+		panic(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, proc
+}
+
+func TestFileCodec(t *testing.T) {
+	attrs := ProcAttrs{DrainParallelism: 3}
+	legacy, err := json.Marshal(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, codec := range []Codec{JSONCodec{}, GzipJSONCodec{}} {
+		b, err := (&fileCodec{codec: codec}).Encode(attrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codec.Name() == CodecJSON && !bytes.Equal(b, legacy) {
+			t.Errorf("expected JSON files to be written as before, got %q", b)
+		}
+		decoded := ProcAttrs{}
+		if _, err := decodeCodec(&decoded).Decode(b); err != nil {
+			t.Fatalf("%s: %s", codec.Name(), err)
+		}
+		if !reflect.DeepEqual(decoded, attrs) {
+			t.Errorf("%s: expected %#v, got %#v", codec.Name(), attrs, decoded)
+		}
+	}
+
+	if _, err := decodeCodec(&ProcAttrs{}).Decode([]byte(codecMagic + "msgpack\x00abc")); !IsErrInvalidFile(err) {
+		t.Errorf("expected invalid file error for unregistered codec, got %v", err)
+	}
+	if err := RegisterCodec(JSONCodec{}); !IsErrConflict(err) {
+		t.Errorf("expected conflict registering json again, got %v", err)
+	}
+}
+
+func TestStoreSetCodec(t *testing.T) {
+	s, proc := codecSetup()
+
+	if _, err := s.SetCodec(CodecProcAttrs, "msgpack"); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	s, err := s.SetCodec(CodecProcAttrs, CodecGzipJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := s.GetCodec(CodecProcAttrs); err != nil || name != CodecGzipJSON {
+		t.Errorf("expected %s, got %s, %v", CodecGzipJSON, name, err)
+	}
+
+	proc.Attrs.DrainParallelism = 2
+	if proc, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	val, _, err := proc.GetSnapshot().Get(proc.dir.Prefix(procsAttrsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix([]byte(val), []byte(codecMagic+CodecGzipJSON)) {
+		t.Errorf("expected gzip encoded attrs, got %q", val)
+	}
+	proc, err = proc.App.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc.Attrs.DrainParallelism != 2 {
+		t.Errorf("expected attrs to be decoded, got %#v", proc.Attrs)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"path"
+	"regexp"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const configPath = "/config"
+
+var reConfigKey = regexp.MustCompile(`^[[:alnum:]][-.[:alnum:]]*$`)
+
+// Config is a cluster-wide setting shared by all components, like default
+// limits or the retention of loggers.
+type Config struct {
+	file  *cp.File
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// SetConfig stores the value of the config key.
+func (s *Store) SetConfig(key, value string) (*Store, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if !reConfigKey.MatchString(key) {
+		return nil, errorf(ErrInvalidKey, "invalid config key %q", key)
+	}
+	if err := s.guard.authorize("", ActionConfig, "config:"+key); err != nil {
+		return nil, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	sp, err = sp.Set(path.Join(configPath, key), value)
+	if err != nil {
+		return nil, err
+	}
+	return s.join(sp), nil
+}
+
+// DelConfig removes the config key.
+func (s *Store) DelConfig(key string) (*Store, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if err := s.guard.authorize("", ActionConfig, "config:"+key); err != nil {
+		return nil, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	err = sp.Del(path.Join(configPath, key))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "config %s not found", key)
+		}
+		return nil, err
+	}
+	if sp, err = sp.FastForward(); err != nil {
+		return nil, err
+	}
+	return s.join(sp), nil
+}
+
+// GetConfig returns the value of the config key.
+func (s *Store) GetConfig(key string) (string, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return "", err
+	}
+	c, err := getConfig(key, sp)
+	if err != nil {
+		return "", err
+	}
+	return c.Value, nil
+}
+
+// GetConfigs returns all config keys and their values.
+func (s *Store) GetConfigs() (map[string]string, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := getdirOrEmpty(sp, configPath)
+	if err != nil {
+		return nil, err
+	}
+	configs := map[string]string{}
+	for _, key := range keys {
+		c, err := getConfig(key, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		configs[key] = c.Value
+	}
+	return configs, nil
+}
+
+// WatchConfig starts a Watcher for changes of the config, which are
+// delivered as EvConfig and EvConfigDel events. Only the config is watched,
+// so the rest of the tree doesn't wake it up.
+func (s *Store) WatchConfig(ctx context.Context) (*Watcher, error) {
+	return s.Watch(ctx, WatchOptions{glob: path.Join(configPath, "*")})
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (c *Config) GetSnapshot() cp.Snapshot {
+	return c.file.Snapshot
+}
+
+func getConfig(key string, s cp.Snapshotable) (*Config, error) {
+	f, err := s.GetSnapshot().GetFile(path.Join(configPath, key), new(cp.StringCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "config %s not found", key)
+		}
+		return nil, err
+	}
+	return &Config{file: f, Key: key, Value: f.Value.(string)}, nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func configSetup() *Store {
+	s, err := DialURI(DefaultURI, "/config-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := s.reset(); err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestConfig(t *testing.T) {
+	s := configSetup()
+
+	if _, err := s.GetConfig("default-memory"); !IsErrNotFound(err) {
+		t.Errorf("expected missing config to not be found, got %v", err)
+	}
+	if _, err := s.SetConfig("not/a/key", "1"); !IsErrInvalidKey(err) {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+
+	s, err := s.SetConfig("default-memory", "512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.SetConfig("logger-retention", "7d"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.GetConfig("default-memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "512" {
+		t.Errorf("expected 512, got %q", v)
+	}
+
+	if s, err = s.DelConfig("logger-retention"); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := s.GetConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs["default-memory"] != "512" {
+		t.Errorf("expected only default-memory, got %v", configs)
+	}
+	if _, err := s.DelConfig("logger-retention"); !IsErrNotFound(err) {
+		t.Errorf("expected deleted config to not be found, got %v", err)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	s := configSetup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := s.WatchConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.NewApp("configcat", "git://configcat.git", "stack").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.SetConfig("default-memory", "512"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.DelConfig("default-memory"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []EventType{EvConfig, EvConfigDel} {
+		select {
+		case e := <-w.Events():
+			if e.Type != want || e.Path.Config == nil || *e.Path.Config != "default-memory" {
+				t.Fatalf("expected %s of default-memory, got %s %s", want, e.Type, e.Path)
+			}
+			if want == EvConfig {
+				if c, ok := e.Source.(*Config); !ok || c.Value != "512" {
+					t.Errorf("expected config value in event, got %#v", e.Source)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s event, got timeout", want)
+		}
+	}
+	if err := w.Stop(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Consul health check states.
+const (
+	ConsulPassing  = "passing"
+	ConsulWarning  = "warning"
+	ConsulCritical = "critical"
+)
+
+// ConsulHealth maps the status of an instance to the state of its Consul
+// health check.
+func ConsulHealth(status InsStatus) string {
+	switch status {
+	case InsStatusRunning:
+		return ConsulPassing
+	case InsStatusStopping:
+		return ConsulWarning
+	}
+	return ConsulCritical
+}
+
+// ConsulBridge mirrors the running instances into a Consul catalog. An
+// instance is registered once it started and deregistered once it exited,
+// failed or was unregistered. The node of an instance is its host.
+type ConsulBridge struct {
+	store *Store
+	// Address of the Consul agent, like "http://localhost:8500".
+	Addr string
+	// Datacenter to register in, the one of the agent if empty.
+	Datacenter string
+	// Client used for requests, one with DefaultWebhookTimeout if nil.
+	Client *http.Client
+
+	// Nodes of the registered instances.
+	nodes map[int64]string
+}
+
+// NewConsulBridge returns a ConsulBridge for the agent at addr. Call Run to
+// start it.
+func (s *Store) NewConsulBridge(addr string) *ConsulBridge {
+	return &ConsulBridge{store: s, Addr: addr, nodes: map[int64]string{}}
+}
+
+// Run registers all running instances and follows their changes until the
+// Store is closed or watching or a request fails. It syncs again if changes
+// were missed.
+func (b *ConsulBridge) Run() error {
+	w, err := b.store.Watch(context.Background(), WatchOptions{
+		Filter: []EventType{EvInsStart, EvInsStop, EvInsUnreg, EvInsFail, EvInsExit, EvInsLost},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	if err := b.Sync(); err != nil {
+		return err
+	}
+	for ev := range w.Events() {
+		if ev.Type == EvResyncNeeded {
+			err = b.Sync()
+		} else {
+			err = b.Apply(ev)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Stop()
+}
+
+// Sync registers all running instances and deregisters the instances it
+// registered before which aren't running anymore.
+func (b *ConsulBridge) Sync() error {
+	s, err := b.store.FastForward()
+	if err != nil {
+		return err
+	}
+	instances, err := s.GetInstancesByStatus(InsStatusRunning)
+	if err != nil && !IsErrNotFound(err) {
+		return err
+	}
+	running := map[int64]bool{}
+	for _, ins := range instances {
+		if err := b.Register(ins); err != nil {
+			return err
+		}
+		running[ins.ID] = true
+	}
+	for id := range b.nodes {
+		if running[id] {
+			continue
+		}
+		if err := b.Deregister(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply updates the catalog for an instance event.
+func (b *ConsulBridge) Apply(ev *Event) error {
+	ins, _ := ev.Source.(*Instance)
+	switch ev.Type {
+	case EvInsStart, EvInsStop, EvInsLost:
+		if ins == nil {
+			return nil
+		}
+		return b.Register(ins)
+	case EvInsExit, EvInsFail, EvInsUnreg:
+		if ev.Path.Instance == nil {
+			return nil
+		}
+		id, err := parseInstanceID(*ev.Path.Instance)
+		if err != nil {
+			return err
+		}
+		return b.Deregister(id)
+	}
+	return nil
+}
+
+// Register registers the instance as service with a health check reflecting
+// its status.
+func (b *ConsulBridge) Register(ins *Instance) error {
+	id := consulServiceID(ins.ID)
+	reg := consulRegistration{
+		Datacenter: b.Datacenter,
+		Node:       ins.Host,
+		Address:    ins.IP,
+		Service: &consulService{
+			ID:      id,
+			Service: ConsulServiceName(ins),
+			Tags:    []string{ins.Env, ins.RevisionName},
+			Address: ins.IP,
+			Port:    ins.Port,
+			Meta: map[string]string{
+				"visor-instance": strconv.FormatInt(ins.ID, 10),
+				"visor-app":      ins.AppName,
+				"visor-proc":     ins.ProcessName,
+			},
+		},
+		Check: &consulCheck{
+			Node:      ins.Host,
+			CheckID:   "service:" + id,
+			Name:      "visor instance status",
+			Status:    ConsulHealth(ins.Status),
+			Output:    string(ins.Status),
+			ServiceID: id,
+		},
+	}
+	if err := b.put("/v1/catalog/register", reg); err != nil {
+		return err
+	}
+	b.nodes[ins.ID] = ins.Host
+	return nil
+}
+
+// Deregister removes the service of the instance from the catalog, if it was
+// registered by the bridge.
+func (b *ConsulBridge) Deregister(id int64) error {
+	node, ok := b.nodes[id]
+	if !ok {
+		return nil
+	}
+	dereg := consulDeregistration{
+		Datacenter: b.Datacenter,
+		Node:       node,
+		ServiceID:  consulServiceID(id),
+	}
+	if err := b.put("/v1/catalog/deregister", dereg); err != nil {
+		return err
+	}
+	delete(b.nodes, id)
+	return nil
+}
+
+// ConsulServiceName returns the name of the Consul service of the instance,
+// "<app>-<proc>".
+func ConsulServiceName(ins *Instance) string {
+	return ins.AppName + "-" + ins.ProcessName
+}
+
+func consulServiceID(id int64) string {
+	return "visor-" + strconv.FormatInt(id, 10)
+}
+
+func (b *ConsulBridge) put(p string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", b.Addr+p, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := b.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+type consulRegistration struct {
+	Datacenter string         `json:",omitempty"`
+	Node       string         `json:"Node"`
+	Address    string         `json:"Address"`
+	Service    *consulService `json:"Service"`
+	Check      *consulCheck   `json:"Check"`
+}
+
+type consulService struct {
+	ID      string            `json:"ID"`
+	Service string            `json:"Service"`
+	Tags    []string          `json:"Tags"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Meta    map[string]string `json:"Meta"`
+}
+
+type consulCheck struct {
+	Node      string `json:"Node"`
+	CheckID   string `json:"CheckID"`
+	Name      string `json:"Name"`
+	Status    string `json:"Status"`
+	Output    string `json:"Output"`
+	ServiceID string `json:"ServiceID"`
+}
+
+type consulDeregistration struct {
+	Datacenter string `json:",omitempty"`
+	Node       string `json:"Node"`
+	ServiceID  string `json:"ServiceID"`
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsulHealth(t *testing.T) {
+	for status, want := range map[InsStatus]string{
+		InsStatusRunning:  ConsulPassing,
+		InsStatusStopping: ConsulWarning,
+		InsStatusLost:     ConsulCritical,
+		InsStatusFailed:   ConsulCritical,
+	} {
+		if got := ConsulHealth(status); got != want {
+			t.Errorf("expected %s for %s, got %s", want, status, got)
+		}
+	}
+}
+
+func TestConsulBridge(t *testing.T) {
+	type request struct {
+		path string
+		body map[string]interface{}
+	}
+	requests := []request{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, request{r.URL.Path, body})
+	}))
+	defer srv.Close()
+
+	b := (&Store{}).NewConsulBridge(srv.URL)
+	ins := &Instance{
+		ID:           42,
+		AppName:      "consul-cat",
+		RevisionName: "128af9",
+		ProcessName:  "web",
+		Env:          "prod",
+		IP:           "10.0.0.1",
+		Port:         9999,
+		Host:         "box1.consul.net",
+		Status:       InsStatusRunning,
+	}
+	id := "42"
+	if err := b.Apply(&Event{Type: EvInsStart, Path: EventData{Instance: &id}, Source: ins}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Apply(&Event{Type: EvInsExit, Path: EventData{Instance: &id}}); err != nil {
+		t.Fatal(err)
+	}
+	// Unknown instances are left alone.
+	other := "43"
+	if err := b.Apply(&Event{Type: EvInsUnreg, Path: EventData{Instance: &other}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %v", requests)
+	}
+	reg := requests[0]
+	if reg.path != "/v1/catalog/register" || reg.body["Node"] != "box1.consul.net" {
+		t.Errorf("expected registration on the instance host, got %v", reg)
+	}
+	svc, _ := reg.body["Service"].(map[string]interface{})
+	if svc["ID"] != "visor-42" || svc["Service"] != "consul-cat-web" || svc["Port"] != 9999.0 {
+		t.Errorf("unexpected service %v", svc)
+	}
+	check, _ := reg.body["Check"].(map[string]interface{})
+	if check["Status"] != ConsulPassing {
+		t.Errorf("expected passing check, got %v", check)
+	}
+	dereg := requests[1]
+	if dereg.path != "/v1/catalog/deregister" || dereg.body["ServiceID"] != "visor-42" || dereg.body["Node"] != "box1.consul.net" {
+		t.Errorf("unexpected deregistration %v", dereg)
+	}
+}
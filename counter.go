@@ -0,0 +1,117 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// addToCounter adds delta to the integer file at p, retrying when it was
+// changed concurrently. A missing file is left alone, so entries created
+// before the counter was introduced keep being counted the slow way.
+func addToCounter(s cp.Snapshotable, p string, delta int) error {
+	for {
+		sp, err := s.GetSnapshot().FastForward()
+		if err != nil {
+			return err
+		}
+		f, err := sp.GetFile(p, new(cp.IntCodec))
+		if cp.IsErrNoEnt(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := f.Set(f.Value.(int) + delta); err == nil {
+			return nil
+		} else if !cp.IsErrRevMismatch(err) {
+			return err
+		}
+		time.Sleep(time.Second / 100)
+	}
+}
+
+// ProcCounters are totals of the instances of a proc which terminated. Procs
+// registered before the totals were kept start from the number of entries in
+// their done, failed and lost lookups.
+type ProcCounters struct {
+	Done   int `json:"done"`
+	Failed int `json:"failed"`
+	Lost   int `json:"lost"`
+	// Zero if unknown.
+	LastFailure time.Time `json:"last-failure"`
+}
+
+// Counters returns the termination totals of the proc.
+func (p *Proc) Counters() (*ProcCounters, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	c, _, err := getProcCounters(p.dir.Name, sp)
+	return c, err
+}
+
+// getProcCounters returns the counters below the proc dir and their file,
+// which is nil if the proc has no counters yet.
+func getProcCounters(dir string, sp cp.Snapshot) (*ProcCounters, *cp.File, error) {
+	c := &ProcCounters{}
+	f, err := sp.GetFile(path.Join(dir, procsCountersPath), &cp.JsonCodec{DecodedVal: c})
+	if err == nil {
+		return c, f, nil
+	}
+	if !cp.IsErrNoEnt(err) {
+		return nil, nil, err
+	}
+	for p, n := range map[string]*int{donePath: &c.Done, failedPath: &c.Failed, lostPath: &c.Lost} {
+		names, err := getdirOrEmpty(sp, path.Join(dir, p))
+		if err != nil {
+			return nil, nil, err
+		}
+		*n = len(names)
+	}
+	return c, nil, nil
+}
+
+// countTermination adds an instance which went into the terminal status to
+// the counters of its proc.
+func countTermination(s cp.Snapshotable, app, proc string, status InsStatus, t time.Time) error {
+	dir := path.Join(appsPath, app, procsPath, proc)
+	for {
+		sp, err := s.GetSnapshot().FastForward()
+		if err != nil {
+			return err
+		}
+		c, f, err := getProcCounters(dir, sp)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case InsStatusDone:
+			c.Done++
+		case InsStatusFailed:
+			c.Failed++
+			c.LastFailure = t
+		case InsStatusLost:
+			c.Lost++
+		default:
+			return nil
+		}
+		if f == nil {
+			f = cp.NewFile(path.Join(dir, procsCountersPath), c, new(cp.JsonCodec), sp)
+			_, err = f.Save()
+		} else {
+			_, err = f.Set(c)
+		}
+		if err == nil || !cp.IsErrRevMismatch(err) {
+			return err
+		}
+		time.Sleep(time.Second / 100)
+	}
+}
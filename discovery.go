@@ -0,0 +1,221 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ServiceRecord is the address of a running instance, in the shape of a DNS
+// SRV record.
+type ServiceRecord struct {
+	// Service of the instance, see Instance.ServiceName.
+	Service  string
+	Instance int64
+	Env      string
+	Host     string
+	IP       string
+	Port     int
+}
+
+// DNSName returns the name of the service as DNS labels, "<proc>.<app>".
+func (r *ServiceRecord) DNSName() string {
+	app, proc, _ := splitServiceName(r.Service)
+	return proc + "." + app
+}
+
+// String returns the record in the zone file notation of SRV records.
+func (r *ServiceRecord) String() string {
+	return fmt.Sprintf("%s SRV 0 0 %d %s", r.DNSName(), r.Port, r.Host)
+}
+
+// RecordExporter publishes the records of services to an external
+// discovery system like CoreDNS or Consul.
+type RecordExporter interface {
+	// Export replaces all records of the service. An empty list removes the
+	// service.
+	Export(service string, records []*ServiceRecord) error
+}
+
+// ResolveService returns the records of the running instances of the
+// service, given as "<app>:<proc>", ordered by instance id. Instances of envs
+// with a readiness gate are left out until their lookup entry is ready.
+func (s *Store) ResolveService(name string) ([]*ServiceRecord, error) {
+	appName, procName, ok := splitServiceName(name)
+	if !ok {
+		return nil, errorf(ErrInvalidArgument, "invalid service name %q", name)
+	}
+	app, err := s.GetApp(appName)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := app.GetProc(procName)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := proc.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+	sort.Sort(instancesByID(instances))
+	lookups, err := proc.GetInstanceLookups()
+	if err != nil {
+		return nil, err
+	}
+	ready := map[int64]bool{}
+	for _, l := range lookups {
+		ready[l.ID] = l.IsReady()
+	}
+	gated := map[string]bool{}
+	records := []*ServiceRecord{}
+	for _, ins := range instances {
+		if ins.Status != InsStatusRunning {
+			continue
+		}
+		gate, ok := gated[ins.Env]
+		if !ok {
+			attrs, err := proc.GetAttrsForEnv(ins.Env)
+			if err != nil {
+				return nil, err
+			}
+			gate = attrs.ReadinessGate
+			gated[ins.Env] = gate
+		}
+		if !gate || ready[ins.ID] {
+			records = append(records, newServiceRecord(ins))
+		}
+	}
+	return records, nil
+}
+
+// ExportServices exports the records of all services, and again for every
+// service whenever one of its instances starts or goes away. All services
+// are exported again if changes were missed. It blocks until the Store is
+// closed or watching or exporting fails.
+func (s *Store) ExportServices(exp RecordExporter) error {
+	w, err := s.Watch(context.Background(), WatchOptions{
+		Filter: []EventType{EvInsStart, EvInsReady, EvInsStop, EvInsUnreg, EvInsFail, EvInsExit, EvInsLost},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	// Services of known instances, as unregistered ones can't be looked up.
+	services, err := s.exportServices(exp, map[int64]string{})
+	if err != nil {
+		return err
+	}
+
+	for ev := range w.Events() {
+		if ev.Type == EvResyncNeeded {
+			// Changes were missed, export all services again.
+			if services, err = s.exportServices(exp, services); err != nil {
+				return err
+			}
+			continue
+		}
+		var name string
+		if ins, ok := ev.Source.(*Instance); ok {
+			name = ins.ServiceName()
+			services[ins.ID] = name
+		} else if ev.Path.Instance != nil {
+			var id int64
+			if id, err = parseInstanceID(*ev.Path.Instance); err != nil {
+				continue
+			}
+			name = services[id]
+			delete(services, id)
+		}
+		if name == "" {
+			continue
+		}
+		sp, err := s.FastForward()
+		if err != nil {
+			return err
+		}
+		records, err := sp.ResolveService(name)
+		if IsErrNotFound(err) {
+			records, err = []*ServiceRecord{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := exp.Export(name, records); err != nil {
+			return err
+		}
+	}
+	return w.Stop()
+}
+
+// exportServices exports the records of all running services and no records
+// for the services of known which aren't running anymore. It returns the
+// services of all instances.
+func (s *Store) exportServices(exp RecordExporter, known map[int64]string) (map[int64]string, error) {
+	sp, err := s.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := sp.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+	services := map[int64]string{}
+	running := map[string]bool{}
+	for _, ins := range instances {
+		services[ins.ID] = ins.ServiceName()
+		if ins.Status == InsStatusRunning {
+			running[ins.ServiceName()] = true
+		}
+	}
+	gone := map[string]bool{}
+	for _, name := range known {
+		if !running[name] {
+			gone[name] = true
+		}
+	}
+	for name := range gone {
+		if err := exp.Export(name, []*ServiceRecord{}); err != nil {
+			return nil, err
+		}
+	}
+	for name := range running {
+		// Resolved so readiness gates apply.
+		records, err := sp.ResolveService(name)
+		if IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := exp.Export(name, records); err != nil {
+			return nil, err
+		}
+	}
+	return services, nil
+}
+
+func newServiceRecord(ins *Instance) *ServiceRecord {
+	return &ServiceRecord{
+		Service:  ins.ServiceName(),
+		Instance: ins.ID,
+		Env:      ins.Env,
+		Host:     ins.Host,
+		IP:       ins.IP,
+		Port:     ins.Port,
+	}
+}
+
+func splitServiceName(name string) (app, proc string, ok bool) {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
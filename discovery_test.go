@@ -0,0 +1,120 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func discoverySetup() *Store {
+	s, err := DialURI(DefaultURI, "/discovery-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp("dns-cat", "git://dns.git", "master").Register()
+	if err != nil {
+		panic(err)
+	}
+	if _, err = s.NewProc(app, "web").Register(); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+type exportRecorder chan []*ServiceRecord
+
+func (r exportRecorder) Export(service string, records []*ServiceRecord) error {
+	r <- records
+	return nil
+}
+
+func TestResolveService(t *testing.T) {
+	ip := "10.0.0.1"
+	s := discoverySetup()
+
+	if _, err := s.ResolveService("dns-cat"); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	ins, err := s.RegisterInstance("dns-cat", "128af9", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.RegisterInstance("dns-cat", "128af9", "web", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(ip, "box1.dns.net", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.ResolveService(ins.ServiceName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected only the running instance, got %v", records)
+	}
+	r := records[0]
+	if r.Instance != ins.ID || r.Host != "box1.dns.net" || r.Port != 9999 {
+		t.Errorf("expected record of instance %d, got %#v", ins.ID, r)
+	}
+	if r.String() != "web.dns-cat SRV 0 0 9999 box1.dns.net" {
+		t.Errorf("unexpected SRV notation %q", r.String())
+	}
+}
+
+func TestExportServices(t *testing.T) {
+	ip := "10.0.0.1"
+	s := discoverySetup()
+	exported := make(exportRecorder, 4)
+	go s.ExportServices(exported)
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	ins, err := s.RegisterInstance("dns-cat", "128af9", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(ip, "box1.dns.net", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+	expectExport(t, exported, 1)
+
+	if err = ins.Unregister("discovery-test", errors.New("gone")); err != nil {
+		t.Fatal(err)
+	}
+	expectExport(t, exported, 0)
+}
+
+func expectExport(t *testing.T, exported exportRecorder, n int) {
+	select {
+	case records := <-exported:
+		if len(records) != n {
+			t.Errorf("expected %d records, got %v", n, records)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected export of %d records", n)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	drainsPath        = "drains"
+	drainProgressPath = "progress"
+	drainDonePath     = "done"
+
+	// DefaultDrainParallelism is the number of instances stopped at once if
+	// the proc attrs don't set it.
+	DefaultDrainParallelism = 1
+)
+
+// DrainBatchTimeout is the time Drain waits for a batch of instances to
+// stop before giving up.
+var DrainBatchTimeout = 5 * time.Minute
+
+// ProcDrain records the progress of draining the instances of a proc in one
+// env. Once the drain is complete it's also stored as summary, which is
+// announced with an EvProcDrained event.
+type ProcDrain struct {
+	file        *cp.File
+	Env         string    `json:"env"`
+	Parallelism int       `json:"parallelism"`
+	Total       int       `json:"total"`
+	Stopped     int       `json:"stopped"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished,omitempty"`
+}
+
+// Drain stops all running instances of the proc in the given env, oldest
+// first, in batches of the drain parallelism set in the proc attrs for the
+// env. Each batch has to stop within DrainBatchTimeout before the next one is
+// stopped. The progress is recorded and can be followed with GetDrain.
+func (p *Proc) Drain(env string) (*ProcDrain, error) {
+	if err := guardOf(p).authorize("", ActionDrain, p.auditName()+"@"+env); err != nil {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := getProcAttrsForEnv(p.App.Name, p.Name, env, sp)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := p.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+	running := []*Instance{}
+	for _, ins := range instances {
+		if ins.Env == env && ins.Status == InsStatusRunning {
+			running = append(running, ins)
+		}
+	}
+	sort.Sort(instancesByID(running))
+
+	drain := &ProcDrain{
+		Env:         env,
+		Parallelism: attrs.DrainParallelism,
+		Total:       len(running),
+		Started:     time.Now().UTC(),
+	}
+	if drain.Parallelism < 1 {
+		drain.Parallelism = DefaultDrainParallelism
+	}
+	err = sp.Del(p.dir.Prefix(drainsPath, env, drainDonePath))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	if err := p.saveDrain(drain, drainProgressPath); err != nil {
+		return nil, err
+	}
+
+	for len(running) > 0 {
+		n := drain.Parallelism
+		if n > len(running) {
+			n = len(running)
+		}
+		batch := running[:n]
+		running = running[n:]
+
+		for _, ins := range batch {
+			if err := ins.Stop(); err != nil && !IsErrInvalidState(err) && !IsErrNotFound(err) {
+				return nil, err
+			}
+		}
+		for _, ins := range batch {
+			_, err := ins.WaitStatusIn(DrainBatchTimeout, InsStatusExited, InsStatusFailed, InsStatusLost)
+			if err != nil && !IsErrNotFound(err) {
+				return nil, err
+			}
+			drain.Stopped++
+		}
+		if err := p.saveDrain(drain, drainProgressPath); err != nil {
+			return nil, err
+		}
+	}
+
+	drain.Finished = time.Now().UTC()
+	if err := p.saveDrain(drain, drainProgressPath); err != nil {
+		return nil, err
+	}
+	if err := p.saveDrain(drain, drainDonePath); err != nil {
+		return nil, err
+	}
+	return drain, nil
+}
+
+// GetDrain returns the progress of the latest drain of the given env.
+func (p *Proc) GetDrain(env string) (*ProcDrain, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getDrain(p.App.Name, p.Name, env, drainProgressPath, sp)
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (d *ProcDrain) GetSnapshot() cp.Snapshot {
+	return d.file.Snapshot
+}
+
+// IsFinished reports whether all instances were stopped.
+func (d *ProcDrain) IsFinished() bool {
+	return !d.Finished.IsZero()
+}
+
+func (p *Proc) saveDrain(d *ProcDrain, name string) error {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	d.file, err = cp.NewFile(p.dir.Prefix(drainsPath, d.Env, name), d, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return err
+	}
+	p.dir = p.dir.Join(d.file)
+	return nil
+}
+
+func getDrain(app, proc, env, name string, s cp.Snapshotable) (*ProcDrain, error) {
+	d := &ProcDrain{}
+	p := path.Join(appsPath, app, procsPath, proc, drainsPath, env, name)
+	f, err := s.GetSnapshot().GetFile(p, &cp.JsonCodec{DecodedVal: d})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "no drain of %s:%s in %s", app, proc, env)
+		}
+		return nil, err
+	}
+	d.file = f
+	return d, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func drainSetup(appid string) (*Store, *Proc) {
+	s, err := DialURI(DefaultURI, "/drain-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp(appid, "git://drain.git", "master").Register()
+	if err != nil {
+		panic(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, proc
+}
+
+func TestProcDrain(t *testing.T) {
+	ip := "10.0.0.1"
+	s, proc := drainSetup("drain-cat")
+	proc.Attrs.DrainParallelism = 2
+	proc, err := proc.StoreAttrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := func(env string) *Instance {
+		ins, err := s.RegisterInstance(proc.App.Name, "128af9", proc.Name, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Claim(ip); err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Started(ip, "drain-cat.com", 9999, 10000); err != nil {
+			t.Fatal(err)
+		}
+		return ins
+	}
+	draining := []*Instance{start("prod"), start("prod"), start("prod")}
+	staging := start("staging")
+
+	// Play the runner, which exits instances once they are asked to stop.
+	go func() {
+		for _, ins := range draining {
+			ins, err := ins.WaitStatusIn(2*time.Second, InsStatusStopping)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := ins.Exited(ip); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	drain, err := proc.Drain("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drain.Total != 3 || drain.Stopped != 3 || !drain.IsFinished() {
+		t.Errorf("expected 3 of 3 instances to be drained, got %#v", drain)
+	}
+	if drain.Parallelism != 2 {
+		t.Errorf("expected parallelism 2, got %d", drain.Parallelism)
+	}
+
+	progress, err := proc.GetDrain("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !progress.IsFinished() {
+		t.Error("expected recorded drain to be finished")
+	}
+	ins, err := s.GetInstance(staging.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusRunning {
+		t.Errorf("expected instances of other envs to keep running, got %s", ins.Status)
+	}
+	if _, err := proc.GetDrain("staging"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"regexp"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const endpointsPath = "endpoints"
+
+var reEndpointName = regexp.MustCompile(`^[[:alnum:]][-_[:alnum:]]*$`)
+
+// Endpoint is a named port an instance serves on its host, e.g. "http",
+// "grpc" or "metrics".
+type Endpoint struct {
+	Port int `json:"port"`
+	// Protocol spoken on the port, e.g. "http", optional.
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// RegisterEndpoints stores the named endpoints of the instance next to its
+// start record, replacing any registered before. Port and TelePort stay as
+// they are for consumers which don't know about endpoints.
+func (i *Instance) RegisterEndpoints(endpoints map[string]Endpoint) (_ *Instance, err error) {
+	//
+	//   instances/
+	//       6868/
+	//           start     = {"ip":"10.0.0.1","port":24690,...}
+	// +         endpoints = {"http":{"port":24690},"metrics":{"port":24692}}
+	//
+	defer i.annotate(&err, "register-endpoints")
+	for name, e := range endpoints {
+		if !reEndpointName.MatchString(name) {
+			return nil, errorf(ErrInvalidArgument, "invalid endpoint name %q", name)
+		}
+		if e.Port < 1 || e.Port > maxPort {
+			return nil, errorf(ErrInvalidPort, "invalid port %d of endpoint %s", e.Port, name)
+		}
+	}
+	if err := i.guard.authorize("", ActionEndpoints, i.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	exists, _, err := sp.Exists(i.dir.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errorf(ErrNotFound, "instance %d not found", i.ID)
+	}
+	f, err := cp.NewFile(i.dir.Prefix(endpointsPath), endpoints, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	i.Endpoints = endpoints
+	i.dir = i.dir.Join(f)
+
+	audit(i, "", ActionEndpoints, i.auditName())
+	return i, nil
+}
+
+// getEndpoints returns nil if the instance has no endpoints.
+func getEndpoints(d *cp.Dir) (map[string]Endpoint, error) {
+	endpoints := map[string]Endpoint{}
+	_, err := d.GetFile(endpointsPath, &cp.JsonCodec{DecodedVal: &endpoints})
+	if cp.IsErrNoEnt(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstanceRegisterEndpoints(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("endpoint-cat", ip)
+	ins, err := ins.Started(ip, "box1.endpoint-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ins.RegisterEndpoints(map[string]Endpoint{"/http": {Port: 9999}}); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid endpoint name to be rejected, got %v", err)
+	}
+	if _, err := ins.RegisterEndpoints(map[string]Endpoint{"http": {Port: 0}}); !IsErrInvalidPort(err) {
+		t.Errorf("expected invalid port to be rejected, got %v", err)
+	}
+
+	endpoints := map[string]Endpoint{
+		"http":    {Port: 9999, Protocol: "http"},
+		"metrics": {Port: 10001},
+	}
+	ins, err = ins.RegisterEndpoints(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ins.Endpoints, endpoints) {
+		t.Errorf("expected endpoints %#v, got %#v", endpoints, ins.Endpoints)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	// Number of events a watch enriches concurrently.
+	enrichWorkers = 8
+	// How long apps and procs read for an event are reused for later ones.
+	enrichCacheTTL = time.Second
+)
+
+var (
+	reAppFile  = regexp.MustCompile("^/apps/(" + charPat + "+)/[^/]+$")
+	reProcFile = regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/[^/]+$")
+)
+
+// sourceCache keeps the apps and procs read to enrich the events of a watch.
+// An entry is used for an event if none of the files it was read from changed
+// up to the revision of the event, so only watches which see every change of
+// those files can use one.
+type sourceCache struct {
+	mu      sync.Mutex
+	changed map[string]int64 // Revision of the last change per key
+	entries map[string]*sourceCacheEntry
+}
+
+type sourceCacheEntry struct {
+	src  cp.Snapshotable
+	rev  int64
+	read time.Time
+}
+
+func newSourceCache() *sourceCache {
+	return &sourceCache{
+		changed: map[string]int64{},
+		entries: map[string]*sourceCacheEntry{},
+	}
+}
+
+// observe invalidates the entry read from the file of the change. It has to
+// be called for every change in order.
+func (c *sourceCache) observe(ev cp.Event) {
+	var key string
+	if m := reProcFile.FindStringSubmatch(ev.Path); m != nil {
+		key = "proc:" + m[1] + ":" + m[2]
+	} else if m := reAppFile.FindStringSubmatch(ev.Path); m != nil {
+		key = "app:" + m[1]
+	} else {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changed[key] = ev.Rev
+	delete(c.entries, key)
+}
+
+// reset drops all entries after changes may have been missed.
+func (c *sourceCache) reset(rev int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		c.changed[key] = rev
+	}
+	c.entries = map[string]*sourceCacheEntry{}
+}
+
+// get returns the source cached under key for an event at rev, or reads and
+// caches it.
+func (c *sourceCache) get(key string, rev int64, read func() (cp.Snapshotable, error)) (cp.Snapshotable, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	changed := c.changed[key]
+	c.mu.Unlock()
+	if ok && changed <= rev && changed <= e.rev && time.Since(e.read) < enrichCacheTTL {
+		return e.src, nil
+	}
+
+	src, err := read()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.changed[key] <= rev {
+		c.entries[key] = &sourceCacheEntry{src: src, rev: rev, read: time.Now()}
+	}
+	c.mu.Unlock()
+	return src, nil
+}
+
+// app returns a copy of the app at the revision of ev.
+func (c *sourceCache) app(name string, ev cp.Event) (*App, error) {
+	if c == nil {
+		return getApp(name, ev)
+	}
+	src, err := c.get("app:"+name, ev.Rev, func() (cp.Snapshotable, error) {
+		return getApp(name, ev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	app := *src.(*App)
+	return &app, nil
+}
+
+// proc returns a copy of the proc at the revision of ev.
+func (c *sourceCache) proc(app *App, name string, ev cp.Event) (*Proc, error) {
+	if c == nil {
+		return getProc(app, name, ev)
+	}
+	src, err := c.get("proc:"+app.Name+":"+name, ev.Rev, func() (cp.Snapshotable, error) {
+		return getProc(app, name, ev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	proc := *src.(*Proc)
+	return &proc, nil
+}
+
+// enrichPipeline enriches the events of a watch concurrently and delivers
+// them to the listener in the order they were added.
+type enrichPipeline struct {
+	ctx      context.Context
+	store    *Store
+	cache    *sourceCache
+	watcher  *Watcher
+	noEnrich bool
+	// Drops events after enrichment if set.
+	accept   func(*Event) bool
+	listener chan *Event
+	queue    chan *pendingEvent
+	workers  chan struct{}
+	done     chan struct{}
+}
+
+type pendingEvent struct {
+	event *Event
+	ready chan struct{}
+}
+
+func (s *Store) newEnrichPipeline(ctx context.Context, listener chan *Event, cache *sourceCache, w *Watcher) *enrichPipeline {
+	p := &enrichPipeline{
+		ctx:      ctx,
+		store:    s,
+		cache:    cache,
+		watcher:  w,
+		listener: listener,
+		queue:    make(chan *pendingEvent, enrichWorkers),
+		workers:  make(chan struct{}, enrichWorkers),
+		done:     make(chan struct{}),
+	}
+	go p.deliver()
+	return p
+}
+
+// add queues the event, enriching it first if it has no source yet, unless
+// enrichment is turned off. It returns false once events aren't delivered
+// anymore.
+func (p *enrichPipeline) add(event *Event) bool {
+	pe := &pendingEvent{event: event, ready: make(chan struct{})}
+	if p.noEnrich {
+		event.Source, event.Actor = nil, ""
+	}
+	if event.Source == nil && event.Type != EvResyncNeeded && !p.noEnrich {
+		select {
+		case p.workers <- struct{}{}:
+		case <-p.done:
+			return false
+		}
+		go func() {
+			defer func() {
+				<-p.workers
+				close(pe.ready)
+			}()
+			// Consumers decide what to do with events whose source is gone.
+			if err := event.enrichWith(p.cache); err != nil {
+				event.EnrichErr = err
+				if p.watcher != nil {
+					atomic.AddInt64(&p.watcher.unenriched, 1)
+				}
+			}
+		}()
+	} else {
+		close(pe.ready)
+	}
+	select {
+	case p.queue <- pe:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// close waits until the queued events are delivered.
+func (p *enrichPipeline) close() {
+	close(p.queue)
+	<-p.done
+}
+
+func (p *enrichPipeline) deliver() {
+	defer close(p.done)
+	for pe := range p.queue {
+		<-pe.ready
+		if p.accept != nil && !p.accept(pe.event) {
+			continue
+		}
+		select {
+		case p.listener <- pe.event:
+		case <-p.ctx.Done():
+			return
+		case <-p.store.closed():
+			return
+		}
+	}
+}
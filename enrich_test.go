@@ -0,0 +1,60 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+func TestSourceCache(t *testing.T) {
+	var (
+		c     = newSourceCache()
+		reads = 0
+		read  = func() (cp.Snapshotable, error) {
+			reads++
+			return &App{Name: "cat"}, nil
+		}
+	)
+	get := func(rev int64) {
+		if _, err := c.get("app:cat", rev, read); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get(10)
+	get(11)
+	if reads != 1 {
+		t.Errorf("expected app to be read once, got %d reads", reads)
+	}
+
+	// Changes of other apps, procs and deeper files don't matter.
+	c.observe(cp.Event{Rev: 12, Path: "/apps/dog/attrs"})
+	c.observe(cp.Event{Rev: 13, Path: "/apps/cat/procs/web/attrs"})
+	c.observe(cp.Event{Rev: 14, Path: "/apps/cat/flags/dark"})
+	get(14)
+	if reads != 1 {
+		t.Errorf("expected cached app to be used, got %d reads", reads)
+	}
+
+	c.observe(cp.Event{Rev: 15, Path: "/apps/cat/attrs"})
+	get(15)
+	if reads != 2 {
+		t.Errorf("expected app to be read again after a change, got %d reads", reads)
+	}
+	// Events from before the change can't use what was read after it.
+	get(14)
+	if reads != 3 {
+		t.Errorf("expected app to be read for an older event, got %d reads", reads)
+	}
+
+	c.reset(16)
+	get(16)
+	if reads != 4 {
+		t.Errorf("expected app to be read after a reset, got %d reads", reads)
+	}
+}
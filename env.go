@@ -6,6 +6,7 @@
 package visor
 
 import (
+	"path"
 	"strings"
 	"time"
 
@@ -24,6 +25,8 @@ type Env struct {
 	Ref        string
 	Vars       map[string]string
 	Registered time.Time
+	// Actor which registered the env, empty if unknown.
+	RegisteredBy string
 }
 
 // NewEnv returns a new Env given an App, the ref and the map of vars.
@@ -44,6 +47,9 @@ func (e *Env) GetSnapshot() cp.Snapshot {
 
 // Register adds the Env to the Apps envs.
 func (e *Env) Register() (*Env, error) {
+	if err := guardOf(e).authorize("", AuditRegister, e.auditName()); err != nil {
+		return nil, err
+	}
 	sp, err := e.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -73,7 +79,8 @@ func (e *Env) Register() (*Env, error) {
 	}
 
 	reg := time.Now()
-	d, err := e.dir.Set(registeredPath, formatTime(reg))
+	e.RegisteredBy = actorOf(e)
+	d, err := e.dir.Set(registeredPath, formatRegistered(reg, e.RegisteredBy))
 	if err != nil {
 		return nil, err
 	}
@@ -81,11 +88,16 @@ func (e *Env) Register() (*Env, error) {
 
 	e.dir = d
 
+	audit(e, "", AuditRegister, e.auditName())
+
 	return e, nil
 }
 
 // Unregister removes the Env from the Apps envs.
 func (e *Env) Unregister() error {
+	if err := guardOf(e).authorize("", AuditUnregister, e.auditName()); err != nil {
+		return err
+	}
 	sp, err := e.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -97,7 +109,15 @@ func (e *Env) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `env "%s" not found`, e.Ref)
 	}
-	return e.dir.Join(sp).Del("/")
+	if err := e.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	audit(sp, actorOf(e), AuditUnregister, e.auditName())
+	return nil
+}
+
+func (e *Env) auditName() string {
+	return "env:" + e.App.Name + "#" + e.Ref
 }
 
 // GetEnv retrieves the Env for the passed ref.
@@ -122,7 +142,7 @@ func (a *App) GetEnvs() ([]*Env, error) {
 	}
 
 	envs := []*Env{}
-	ch, errch := cp.GetSnapshotables(refs, func(ref string) (cp.Snapshotable, error) {
+	ch, errch := getSnapshotables(a.reads, refs, func(ref string) (cp.Snapshotable, error) {
 		return getEnv(a, ref, sp)
 	})
 	for i := 0; i < len(refs); i++ {
@@ -136,6 +156,89 @@ func (a *App) GetEnvs() ([]*Env, error) {
 	return envs, nil
 }
 
+// ResolveEnvironment returns the environment an instance of the given proc
+// and env runs with. Variables are merged in the following order, later ones
+// taking precedence:
+//
+//  1. app-wide variables (App.SetEnvironmentVar)
+//  2. variables of the registered Env with the given ref, if present
+//  3. env-qualified app overrides (App.SetEnvironmentVarForEnv)
+//  4. proc variables (Proc.SetEnvironmentVar)
+//
+// The proc can be nil, in which case only app and env variables are merged.
+func ResolveEnvironment(app *App, proc *Proc, env string) (map[string]string, error) {
+	sp, err := app.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := []map[string]string{}
+
+	vars, err := getEnvVars(app.dir.Prefix(envPath), sp)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, vars)
+
+	e, err := getEnv(app, env, sp)
+	if err == nil {
+		layers = append(layers, e.Vars)
+	} else if !IsErrNotFound(err) {
+		return nil, err
+	}
+
+	vars, err = getEnvVars(app.dir.Prefix(envOverridesPath, env), sp)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, vars)
+
+	if proc != nil {
+		vars, err = getEnvVars(proc.dir.Prefix(envPath), sp)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, vars)
+	}
+
+	resolved := map[string]string{}
+	for _, layer := range layers {
+		for k, v := range layer {
+			resolved[k] = v
+		}
+	}
+	return resolved, nil
+}
+
+// getEnvVars reads all variables stored as files in the given directory.
+func getEnvVars(dir string, sp cp.Snapshot) (map[string]string, error) {
+	vars := map[string]string{}
+
+	names, err := sp.Getdir(dir)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return vars, nil
+		}
+		return nil, err
+	}
+	for _, name := range names {
+		v, _, err := sp.Get(path.Join(dir, name))
+		if err != nil {
+			if cp.IsErrNoEnt(err) {
+				continue
+			}
+			return nil, err
+		}
+		vars[strings.Replace(name, "-", "_", -1)] = v
+	}
+	return vars, nil
+}
+
+// envKey returns the file name a variable is stored under.
+func envKey(k string) string {
+	return strings.Replace(k, "_", "-", -1)
+}
+
 func getEnv(app *App, ref string, s cp.Snapshotable) (*Env, error) {
 	e := &Env{
 		dir: cp.NewDir(app.dir.Prefix(envsPath, ref), s.GetSnapshot()),
@@ -165,7 +268,7 @@ func getEnv(app *App, ref string, s cp.Snapshotable) (*Env, error) {
 		}
 		return nil, err
 	}
-	e.Registered, err = parseTime(f.Value.(string))
+	e.Registered, e.RegisteredBy, err = parseRegistered(f.Value.(string))
 	if err != nil {
 		return nil, err
 	}
@@ -6,6 +6,7 @@
 package visor
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -161,3 +162,54 @@ func TestEnvList(t *testing.T) {
 		t.Error("GetEnvs didn't return the same amount of envs")
 	}
 }
+
+func TestResolveEnvironment(t *testing.T) {
+	app := envSetup(t)
+	app.Env = map[string]string{"LEVEL": "app", "APP_ONLY": "1"}
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := store.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.NewEnv("prod", map[string]string{"LEVEL": "env", "ENV_ONLY": "1"}).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err = app.SetEnvironmentVarForEnv("prod", "LEVEL", "override")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := ResolveEnvironment(app, proc, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"LEVEL": "override", "APP_ONLY": "1", "ENV_ONLY": "1"}
+	if !reflect.DeepEqual(want, vars) {
+		t.Errorf("want %v, have %v", want, vars)
+	}
+
+	proc, err = proc.SetEnvironmentVar("LEVEL", "proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err = ResolveEnvironment(app, proc, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "proc", vars["LEVEL"]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	vars, err = ResolveEnvironment(app, nil, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "app", vars["LEVEL"]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
@@ -14,35 +14,118 @@ import (
 
 // Errors.
 var (
-	ErrConflict        = errors.New("object already exists")
-	ErrInsClaimed      = errors.New("instance is already claimed")
-	ErrInvalidArgument = errors.New("invalid argument")
-	ErrInvalidFile     = errors.New("invalid file")
-	ErrInvalidKey      = errors.New("invalid key")
-	ErrInvalidPort     = errors.New("invalid port")
-	ErrInvalidShare    = errors.New("invalid share")
-	ErrInvalidState    = errors.New("invalid state")
-	ErrBadProcName     = errors.New("invalid proc type name: only alphanumeric chars allowed")
-	ErrUnauthorized    = errors.New("operation is not permitted")
-	ErrNotFound        = errors.New("object not found")
-	ErrTagShadowing    = errors.New("revision already exists with tag name")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrConflict         = errors.New("object already exists")
+	ErrDeployFrozen     = errors.New("deploys are frozen")
+	ErrInsClaimed       = errors.New("instance is already claimed")
+	ErrInvalidArgument  = errors.New("invalid argument")
+	ErrInvalidFile      = errors.New("invalid file")
+	ErrInvalidKey       = errors.New("invalid key")
+	ErrInvalidPort      = errors.New("invalid port")
+	ErrNoPorts          = errors.New("no ports left")
+	ErrInvalidShare     = errors.New("invalid share")
+	ErrInvalidState     = errors.New("invalid state")
+	ErrMaintenance      = errors.New("in maintenance mode")
+	ErrBadProcName      = errors.New("invalid proc type name: only alphanumeric chars allowed")
+	ErrBadName          = errors.New("invalid name")
+	ErrUnauthorized     = errors.New("operation is not permitted")
+	ErrNotFound         = errors.New("object not found")
+	ErrRevisionInUse    = errors.New("revision has instances")
+	ErrTagProtected     = errors.New("tag is protected")
+	ErrTagShadowing     = errors.New("revision already exists with tag name")
+	ErrReadOnly         = errors.New("store is read-only")
+	ErrRestartBudget    = errors.New("restart budget exceeded")
+	ErrSinkRejected     = errors.New("events rejected by sink")
+	ErrTimeout          = errors.New("timed out")
+	ErrTxnIncomplete    = errors.New("transaction partially applied")
 )
 
-// Error is the wrapper type to express custom errors.
+// Error is the wrapper type to express custom errors. Err is one of the
+// errors above, the optional cause is the error it was built from.
 type Error struct {
 	Err     error
 	Message string
+	// Context of the failed operation, set where known.
+	Op     string // Operation, like "claim"
+	Object string // Object operated on, like "instance:42"
+	Path   string // Coordinator path of the object
+	Rev    int64  // Revision the operation worked on
+	cause  error
 }
 
 // NewError wraps the given error with a custom message.
 func NewError(err error, msg string) *Error {
-	return &Error{err, msg}
+	return &Error{Err: err, Message: msg}
 }
 
 func (e *Error) Error() string {
 	return e.Message
 }
 
+// Is reports whether target is the wrapped error, so errors.Is finds it even
+// though Unwrap leads to the cause.
+func (e *Error) Is(target error) bool {
+	return target == e.Err
+}
+
+// Unwrap returns the cause, or the wrapped error if there is none.
+func (e *Error) Unwrap() error {
+	if e.cause == nil {
+		return e.Err
+	}
+	return e.cause
+}
+
+// Cause returns the error the Error was built from, nil if there is none.
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+// Fields returns the message and the known context of the error, for
+// structured logging.
+func (e *Error) Fields() map[string]interface{} {
+	fields := map[string]interface{}{"error": e.Message}
+	if e.Op != "" {
+		fields["op"] = e.Op
+	}
+	if e.Object != "" {
+		fields["object"] = e.Object
+	}
+	if e.Path != "" {
+		fields["path"] = e.Path
+		fields["rev"] = e.Rev
+	}
+	return fields
+}
+
+// AsError returns the first *Error in the chain of err, to get at the
+// context of a failed operation.
+func AsError(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// annotate adds the context of a failed operation on the object stored at
+// path to *err. Only visor errors are annotated, cotterpin and bare sentinel
+// errors are left alone so cp.IsErr* and comparisons with them keep working.
+// Context set by a nested operation is kept.
+func annotate(err *error, op, object, path string, rev int64) {
+	v, ok := (*err).(*Error)
+	if !ok {
+		return
+	}
+	c := *v
+	e := &c
+	if e.Op == "" {
+		e.Op, e.Object = op, object
+	}
+	if e.Path == "" {
+		e.Path, e.Rev = path, rev
+	}
+	*err = e
+}
+
 func unwrapErr(err error) error {
 	switch e := err.(type) {
 	case *cp.Error:
@@ -54,63 +137,155 @@ func unwrapErr(err error) error {
 	return err
 }
 
+// isErr reports whether err is target, wrapped directly by an *Error or a
+// cotterpin error, or anywhere in its chain.
+func isErr(err, target error) bool {
+	if err == nil {
+		return false
+	}
+	if unwrapErr(err) == target || errors.Is(err, target) {
+		return true
+	}
+	var cpErr *cp.Error
+	return errors.As(err, &cpErr) && cpErr.Err == target
+}
+
+// IsErrChecksumMismatch is a helper to test for ErrChecksumMismatch.
+func IsErrChecksumMismatch(err error) bool {
+	return isErr(err, ErrChecksumMismatch)
+}
+
 // IsErrConflict is a helper to test for ErrConflict.
 func IsErrConflict(err error) bool {
-	return unwrapErr(err) == ErrConflict
+	return isErr(err, ErrConflict)
+}
+
+// IsErrConflictDetailed is a helper to test for ErrConflict, which also
+// returns the message naming the conflicting object.
+func IsErrConflictDetailed(err error) (string, bool) {
+	if !IsErrConflict(err) {
+		return "", false
+	}
+	var e *Error
+	if errors.As(err, &e) && e.Err == ErrConflict {
+		return e.Message, true
+	}
+	return err.Error(), true
+}
+
+// IsErrBadProcName is a helper to test for ErrBadProcName.
+func IsErrBadProcName(err error) bool {
+	return isErr(err, ErrBadProcName)
+}
+
+// IsErrBadName is a helper to test for ErrBadName.
+func IsErrBadName(err error) bool {
+	return isErr(err, ErrBadName)
+}
+
+// IsErrDeployFrozen is a helper to test for ErrDeployFrozen.
+func IsErrDeployFrozen(err error) bool {
+	return isErr(err, ErrDeployFrozen)
 }
 
 // IsErrUnauthorized is a helper to test for ErrUnauthorized.
 func IsErrUnauthorized(err error) bool {
-	return unwrapErr(err) == ErrUnauthorized
+	return isErr(err, ErrUnauthorized)
 }
 
 // IsErrNotFound is a helper to test for ErrNotFound.
 func IsErrNotFound(err error) bool {
-	err = unwrapErr(err)
-
-	return err == cp.ErrNoEnt || err == ErrNotFound
+	return isErr(err, cp.ErrNoEnt) || isErr(err, ErrNotFound)
 }
 
 // IsErrInsClaimed is a helper to test for ErrInsClaimed.
 func IsErrInsClaimed(err error) bool {
-	return unwrapErr(err) == ErrInsClaimed
+	return isErr(err, ErrInsClaimed)
 }
 
 // IsErrInvalidArgument is a helper to test for ErrInvalidArgument.
 func IsErrInvalidArgument(err error) bool {
-	return unwrapErr(err) == ErrInvalidArgument
+	return isErr(err, ErrInvalidArgument)
 }
 
 // IsErrInvalidFile is a helper to test for ErrInvalidFile.
 func IsErrInvalidFile(err error) bool {
-	return unwrapErr(err) == ErrInvalidFile
+	return isErr(err, ErrInvalidFile)
 }
 
 // IsErrInvalidKey is a helper to test for ErrInvalidKey.
 func IsErrInvalidKey(err error) bool {
-	return unwrapErr(err) == ErrInvalidKey
+	return isErr(err, ErrInvalidKey)
 }
 
 // IsErrInvalidPort is a helper to test for ErrInvalidPort.
 func IsErrInvalidPort(err error) bool {
-	return unwrapErr(err) == ErrInvalidPort
+	return isErr(err, ErrInvalidPort)
+}
+
+// IsErrNoPorts is a helper to test for ErrNoPorts.
+func IsErrNoPorts(err error) bool {
+	return isErr(err, ErrNoPorts)
 }
 
 // IsErrInvalidShare is a helper to test for ErrInvalidShare.
 func IsErrInvalidShare(err error) bool {
-	return unwrapErr(err) == ErrInvalidShare
+	return isErr(err, ErrInvalidShare)
 }
 
 // IsErrInvalidState is a helper to test for ErrInvalidState.
 func IsErrInvalidState(err error) bool {
-	return unwrapErr(err) == ErrInvalidState
+	return isErr(err, ErrInvalidState)
+}
+
+// IsErrMaintenance is a helper to test for ErrMaintenance.
+func IsErrMaintenance(err error) bool {
+	return isErr(err, ErrMaintenance)
+}
+
+// IsErrTagProtected is a helper to test for ErrTagProtected.
+func IsErrTagProtected(err error) bool {
+	return isErr(err, ErrTagProtected)
 }
 
 // IsErrTagShadowing is a helper to test for ErrTagShadowing.
 func IsErrTagShadowing(err error) bool {
-	return unwrapErr(err) == ErrTagShadowing
+	return isErr(err, ErrTagShadowing)
+}
+
+// IsErrRevisionInUse is a helper to test for ErrRevisionInUse.
+func IsErrRevisionInUse(err error) bool {
+	return isErr(err, ErrRevisionInUse)
+}
+
+// IsErrReadOnly is a helper to test for ErrReadOnly.
+func IsErrReadOnly(err error) bool {
+	return isErr(err, ErrReadOnly)
+}
+
+// IsErrRestartBudget is a helper to test for ErrRestartBudget.
+func IsErrRestartBudget(err error) bool {
+	return isErr(err, ErrRestartBudget)
+}
+
+// IsErrSinkRejected is a helper to test for ErrSinkRejected.
+func IsErrSinkRejected(err error) bool {
+	return isErr(err, ErrSinkRejected)
+}
+
+// IsErrTimeout is a helper to test for ErrTimeout.
+func IsErrTimeout(err error) bool {
+	return isErr(err, ErrTimeout)
+}
+
+// IsErrTxnIncomplete is a helper to test for ErrTxnIncomplete.
+func IsErrTxnIncomplete(err error) bool {
+	return isErr(err, ErrTxnIncomplete)
 }
 
+// errorf returns an Error wrapping err with the formatted message. An error
+// formatted with %w is kept as cause.
 func errorf(err error, format string, args ...interface{}) *Error {
-	return NewError(err, fmt.Sprintf(format, args...))
+	msg := fmt.Errorf(format, args...)
+	return &Error{Err: err, Message: msg.Error(), cause: errors.Unwrap(msg)}
 }
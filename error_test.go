@@ -2,6 +2,7 @@ package visor
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	cp "github.com/soundcloud/cotterpin"
@@ -26,6 +27,36 @@ func TestIsErrConflict(t *testing.T) {
 		{errors.New("error"), false},
 		{cp.NewError(cp.ErrBadPath, "bad path"), false},
 		{NewError(ErrConflict, "conflict"), true},
+		{fmt.Errorf("register: %w", NewError(ErrConflict, "conflict")), true},
+	})
+}
+
+func TestIsErrConflictDetailed(t *testing.T) {
+	if _, ok := IsErrConflictDetailed(NewError(ErrNotFound, "not found")); ok {
+		t.Error("expected not found error not to be a conflict")
+	}
+	err := fmt.Errorf("register: %w", errorf(ErrConflict, `app "%s" already exists`, "cat"))
+	msg, ok := IsErrConflictDetailed(err)
+	if !ok || msg != `app "cat" already exists` {
+		t.Errorf("expected detailed conflict, got %q, %t", msg, ok)
+	}
+}
+
+func TestIsErrBadProcName(t *testing.T) {
+	testErrFn(t, IsErrBadProcName, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{ErrBadProcName, true},
+		{fmt.Errorf("register: %w", ErrBadProcName), true},
+	})
+}
+
+func TestIsErrDeployFrozen(t *testing.T) {
+	testErrFn(t, IsErrDeployFrozen, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrDeployFrozen, "frozen"), true},
 	})
 }
 
@@ -45,6 +76,7 @@ func TestIsErrNotFound(t *testing.T) {
 		{cp.NewError(cp.ErrBadPath, "bad path"), false},
 		{cp.NewError(cp.ErrNoEnt, "not found"), true},
 		{NewError(ErrNotFound, "not found"), true},
+		{errorf(ErrInvalidFile, "reading: %w", cp.NewError(cp.ErrNoEnt, "not found")), true},
 	})
 }
 
@@ -67,6 +99,15 @@ func TestIsErrInvalidState(t *testing.T) {
 	})
 }
 
+func TestIsErrMaintenance(t *testing.T) {
+	testErrFn(t, IsErrMaintenance, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrMaintenance, "in maintenance"), true},
+	})
+}
+
 func TestIsErrInvalidFile(t *testing.T) {
 	testErrFn(t, IsErrInvalidFile, []errorCase{
 		{nil, false},
@@ -102,3 +143,123 @@ func TestIsErrInvalidPort(t *testing.T) {
 		{NewError(ErrInvalidPort, "invalid port"), true},
 	})
 }
+
+func TestIsErrReadOnly(t *testing.T) {
+	testErrFn(t, IsErrReadOnly, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrReadOnly, "read-only"), true},
+	})
+}
+
+func TestIsErrChecksumMismatch(t *testing.T) {
+	testErrFn(t, IsErrChecksumMismatch, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrChecksumMismatch, "checksum mismatch"), true},
+	})
+}
+
+func TestIsErrRevisionInUse(t *testing.T) {
+	testErrFn(t, IsErrRevisionInUse, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrRevisionInUse, "in use"), true},
+	})
+}
+
+func TestIsErrTagProtected(t *testing.T) {
+	testErrFn(t, IsErrTagProtected, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrTagProtected, "protected"), true},
+	})
+}
+
+func TestIsErrRestartBudget(t *testing.T) {
+	testErrFn(t, IsErrRestartBudget, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrRestartBudget, "budget exceeded"), true},
+	})
+}
+
+func TestIsErrSinkRejected(t *testing.T) {
+	testErrFn(t, IsErrSinkRejected, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrSinkRejected, "bad request"), true},
+	})
+}
+
+func TestIsErrTimeout(t *testing.T) {
+	testErrFn(t, IsErrTimeout, []errorCase{
+		{nil, false},
+		{errors.New("error"), false},
+		{cp.NewError(cp.ErrBadPath, "bad path"), false},
+		{NewError(ErrTimeout, "timed out"), true},
+	})
+}
+
+func TestErrorUnwrap(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := errorf(ErrUnauthorized, "denied: %w", cause)
+	if err.Error() != "denied: connection refused" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Error("expected errors.Is to find the wrapped error")
+	}
+	if !errors.Is(err, cause) || err.Cause() != cause {
+		t.Error("expected errors.Is to find the cause")
+	}
+	var e *Error
+	if !errors.As(fmt.Errorf("op: %w", err), &e) || e.Err != ErrUnauthorized {
+		t.Errorf("expected errors.As to find the Error, got %v", e)
+	}
+	if errorf(ErrTimeout, "%s", cause).Cause() != nil {
+		t.Error("expected no cause without %w")
+	}
+}
+
+func TestErrorContext(t *testing.T) {
+	var err error = errorf(ErrNotFound, "instance 42 not found")
+	annotate(&err, "claim", "instance:42", "/instances/42", 7)
+	e, ok := AsError(fmt.Errorf("scheduling: %w", err))
+	if !ok {
+		t.Fatal("expected an Error in the chain")
+	}
+	if e.Op != "claim" || e.Object != "instance:42" || e.Path != "/instances/42" || e.Rev != 7 {
+		t.Errorf("unexpected context %#v", e)
+	}
+	if !IsErrNotFound(err) || err.Error() != "instance 42 not found" {
+		t.Errorf("expected annotated error to stay the same, got %v", err)
+	}
+	// Context of the innermost operation wins.
+	annotate(&err, "stop", "instance:42", "/instances/42", 8)
+	if e, _ := AsError(err); e.Op != "claim" || e.Rev != 7 {
+		t.Errorf("expected nested context to be kept, got %#v", e)
+	}
+	fields := e.Fields()
+	if fields["op"] != "claim" || fields["rev"] != int64(7) {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	cpErr := cp.NewError(cp.ErrNoEnt, "no entry")
+	err = cpErr
+	annotate(&err, "get", "app:cat", "/apps/cat", 3)
+	if err != error(cpErr) || !IsErrNotFound(err) {
+		t.Errorf("expected cotterpin error to be kept, got %#v", err)
+	}
+	err = ErrBadProcName
+	annotate(&err, "register", "proc:cat:web", "/apps/cat/procs/web", 3)
+	if err != ErrBadProcName {
+		t.Errorf("expected bare sentinel error to be kept, got %#v", err)
+	}
+}
@@ -6,11 +6,11 @@
 package visor
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 
 	cp "github.com/soundcloud/cotterpin"
@@ -22,7 +22,11 @@ type Event struct {
 	Path   EventData // Unique part of the event path
 	Rev    int64
 	Source cp.Snapshotable
+	Actor  string   // Actor which caused the event, if recorded
 	raw    cp.Event // Original event returned by cotterpin
+	// Why Source couldn't be read, e.g. because the object was already
+	// removed again. Source is nil then.
+	EnrichErr error
 }
 
 // EventData is used to represent information encoded in the file path.
@@ -31,6 +35,11 @@ type EventData struct {
 	Instance *string
 	Proc     *string
 	Revision *string
+	Hook     *string
+	Flag     *string
+	Env      *string
+	Tag      *string
+	Config   *string
 }
 
 func (d EventData) String() string {
@@ -53,35 +62,82 @@ type EventType string
 
 // EventTypes.
 const (
-	EvAppReg     = EventType("app-register")
-	EvAppUnreg   = EventType("app-unregister")
-	EvRevReg     = EventType("rev-register")
-	EvRevUnreg   = EventType("rev-unregister")
-	EvProcReg    = EventType("proc-register")
-	EvProcUnreg  = EventType("proc-unregister")
-	EvProcAttrs  = EventType("proc-attrs")
-	EvInsReg     = EventType("instance-register")
-	EvInsUnclaim = EventType("instance-unclaim")
-	EvInsUnreg   = EventType("instance-unregister")
-	EvInsStart   = EventType("instance-start")
-	EvInsStop    = EventType("instance-stop")
-	EvInsFail    = EventType("instance-fail")
-	EvInsExit    = EventType("instance-exit")
-	EvInsLost    = EventType("instance-lost")
-	EvUnknown    = EventType("UNKNOWN")
+	EvAppReg              = EventType("app-register")
+	EvAppUnreg            = EventType("app-unregister")
+	EvAppEnv              = EventType("app-env")
+	EvAppFlag             = EventType("app-flag")
+	EvAppFlagDel          = EventType("app-flag-delete")
+	EvRevReg              = EventType("rev-register")
+	EvRevUnreg            = EventType("rev-unregister")
+	EvRevTag              = EventType("rev-tag")
+	EvRevUntag            = EventType("rev-untag")
+	EvProcReg             = EventType("proc-register")
+	EvProcUnreg           = EventType("proc-unregister")
+	EvProcAttrs           = EventType("proc-attrs")
+	EvProcEnvAttrs        = EventType("proc-env-attrs")
+	EvProcDrained         = EventType("proc-drained")
+	EvProcScale           = EventType("proc-scale")
+	EvProcRampStep        = EventType("proc-ramp-step")
+	EvProcActiveEnv       = EventType("proc-active-env")
+	EvAppMaintenanceOn    = EventType("app-maintenance-on")
+	EvAppMaintenanceOff   = EventType("app-maintenance-off")
+	EvProcMaintenanceOn   = EventType("proc-maintenance-on")
+	EvProcMaintenanceOff  = EventType("proc-maintenance-off")
+	EvDeployFreeze        = EventType("deploy-freeze")
+	EvDeployUnfreeze      = EventType("deploy-unfreeze")
+	EvConfig              = EventType("config")
+	EvConfigDel           = EventType("config-delete")
+	EvHookReg             = EventType("hook-register")
+	EvHookUnreg           = EventType("hook-unregister")
+	EvInsReg              = EventType("instance-register")
+	EvInsClaim            = EventType("instance-claim")
+	EvInsUnclaim          = EventType("instance-unclaim")
+	EvInsUnreg            = EventType("instance-unregister")
+	EvInsStarting         = EventType("instance-starting")
+	EvInsStart            = EventType("instance-start")
+	EvInsStop             = EventType("instance-stop")
+	EvInsRestartRequested = EventType("instance-restart-requested")
+	EvInsCrashLoop        = EventType("instance-crash-loop")
+	EvInsHealth           = EventType("instance-health")
+	EvInsReady            = EventType("instance-ready")
+	EvInsRestart          = EventType("instance-restart")
+	EvInsFail             = EventType("instance-fail")
+	EvInsExit             = EventType("instance-exit")
+	EvInsLost             = EventType("instance-lost")
+	// Sent by Watchers which may have missed events.
+	EvResyncNeeded = EventType("resync-needed")
+	EvUnknown      = EventType("UNKNOWN")
 )
 
 type eventPath int
 
 const (
 	pathApp eventPath = iota
+	pathAppEnv
+	pathAppFlag
 	pathRev
+	pathRevTag
 	pathProc
 	pathProcAttrs
+	pathProcEnvAttrs
+	pathProcDrained
+	pathProcScale
+	pathProcRamp
+	pathProcActiveEnv
+	pathAppMaintenance
+	pathProcMaintenance
+	pathDeployFreeze
+	pathConfig
+	pathHook
 	pathInsRegistered
 	pathInsStatus
 	pathInsStart
 	pathInsStop
+	pathInsRestartReq
+	pathInsCrashLoop
+	pathInsHealth
+	pathInsReady
+	pathInsRestarts
 )
 
 const (
@@ -89,15 +145,36 @@ const (
 	globPlural = "**"
 )
 
+// Entries of the index of the tags referencing a revision.
+var reRevTag = regexp.MustCompile("^/apps/(" + charPat + "+)/revs/(" + charPat + "+)/tags/(" + charPat + "+)$")
+
 var eventPatterns = map[*regexp.Regexp]eventPath{
-	regexp.MustCompile("^/apps/(" + charPat + "+)/registered$"):                          pathApp,
-	regexp.MustCompile("^/apps/(" + charPat + "+)/revs/(" + charPat + "+)/registered$"):  pathRev,
-	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/registered$"): pathProc,
-	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/attrs$"):      pathProcAttrs,
-	regexp.MustCompile("^/instances/([-0-9]+)/registered$"):                              pathInsRegistered,
-	regexp.MustCompile("^/instances/([-0-9]+)/status$"):                                  pathInsStatus,
-	regexp.MustCompile("^/instances/([-0-9]+)/start$"):                                   pathInsStart,
-	regexp.MustCompile("^/instances/([-0-9]+)/stop$"):                                    pathInsStop,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/registered$"):                         pathApp,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/env-version$"):                        pathAppEnv,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/revs/(" + charPat + "+)/registered$"): pathRev,
+	reRevTag: pathRevTag,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/registered$"):                                  pathProc,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/attrs$"):                                       pathProcAttrs,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/flags/(" + charPat + "+)$"):                                             pathAppFlag,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/env-attrs/(" + charPat + "+)$"):                pathProcEnvAttrs,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/drains/(" + charPat + "+)/done$"):              pathProcDrained,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/scale/(" + charPat + "+)/(" + charPat + "+)$"): pathProcScale,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/ramp$"):                                        pathProcRamp,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/active-env$"):                                  pathProcActiveEnv,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/maintenance$"):                                                          pathAppMaintenance,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/maintenance$"):                                 pathProcMaintenance,
+	regexp.MustCompile("^/config/(" + charPat + "+)$"):                                                                    pathConfig,
+	regexp.MustCompile("^/deploy-freeze$"):                                                                                pathDeployFreeze,
+	regexp.MustCompile("^/apps/(" + charPat + "+)/hooks/(" + charPat + "+)$"):                                             pathHook,
+	regexp.MustCompile("^/instances/([-0-9]+)/registered$"):                                                               pathInsRegistered,
+	regexp.MustCompile("^/instances/([-0-9]+)/status$"):                                                                   pathInsStatus,
+	regexp.MustCompile("^/instances/([-0-9]+)/start$"):                                                                    pathInsStart,
+	regexp.MustCompile("^/instances/([-0-9]+)/stop$"):                                                                     pathInsStop,
+	regexp.MustCompile("^/instances/([-0-9]+)/restart-request$"):                                                          pathInsRestartReq,
+	regexp.MustCompile("^/instances/([-0-9]+)/crash-loop$"):                                                               pathInsCrashLoop,
+	regexp.MustCompile("^/instances/([-0-9]+)/health$"):                                                                   pathInsHealth,
+	regexp.MustCompile("^/instances/([-0-9]+)/ready$"):                                                                    pathInsReady,
+	regexp.MustCompile("^/instances/([-0-9]+)/restarts$"):                                                                 pathInsRestarts,
 }
 
 func (ev *Event) String() string {
@@ -109,27 +186,173 @@ func (ev *Event) String() string {
 // channel.
 // Optionally any number of EventTypes can be given in order to filter which
 // events will be sent over the given channel.
+// The listener channel is closed when WatchEvent returns. If the Store is
+// closed WatchEvent returns nil.
 func (s *Store) WatchEvent(listener chan *Event, filter ...EventType) error {
-	sp := s.GetSnapshot()
-	for {
-		ev, err := sp.Wait(globPlural)
-		if err != nil {
-			return err
-		}
-		sp = sp.Join(ev)
+	return s.WatchScoped(EventScope{}, listener, filter...)
+}
 
-		event, err := newEvent(ev)
-		if err != nil {
-			return err
+// EventJSONVersion is the version of the wire format produced by
+// Event.MarshalJSON.
+const EventJSONVersion = 1
+
+// Kinds of event sources in the wire format.
+const (
+	sourceApp          = "app"
+	sourceRevision     = "revision"
+	sourceTag          = "tag"
+	sourceProc         = "proc"
+	sourceInstance     = "instance"
+	sourceRestart      = "instance-restart"
+	sourceHook         = "hook"
+	sourceFlag         = "flag"
+	sourceDrain        = "drain"
+	sourceDeployFreeze = "deploy-freeze"
+	sourceConfig       = "config"
+)
+
+// eventJSON is the wire format of an Event:
+//
+//	{
+//	  "version": 1,
+//	  "type": "app-register",
+//	  "rev": 42,
+//	  "path": {"app": "cat", "proc": "web"},
+//	  "actor": "deploy-bot",
+//	  "source-type": "app",
+//	  "source": {...}
+//	}
+//
+// Empty path fields, actor and source are omitted. The source is encoded
+// like its type, source-type names which one it is. Events whose source
+// couldn't be read carry "enrich-error" instead.
+type eventJSON struct {
+	Version     int             `json:"version"`
+	Type        EventType       `json:"type"`
+	Rev         int64           `json:"rev"`
+	Path        eventPathJSON   `json:"path"`
+	Actor       string          `json:"actor,omitempty"`
+	SourceType  string          `json:"source-type,omitempty"`
+	Source      json.RawMessage `json:"source,omitempty"`
+	EnrichError string          `json:"enrich-error,omitempty"`
+}
+
+type eventPathJSON struct {
+	App      *string `json:"app,omitempty"`
+	Instance *string `json:"instance,omitempty"`
+	Proc     *string `json:"proc,omitempty"`
+	Revision *string `json:"revision,omitempty"`
+	Hook     *string `json:"hook,omitempty"`
+	Flag     *string `json:"flag,omitempty"`
+	Env      *string `json:"env,omitempty"`
+	Tag      *string `json:"tag,omitempty"`
+	Config   *string `json:"config,omitempty"`
+}
+
+// MarshalJSON encodes the event in the versioned wire format.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	v := eventJSON{
+		Version: EventJSONVersion,
+		Type:    e.Type,
+		Rev:     e.Rev,
+		Path:    eventPathJSON(e.Path),
+		Actor:   e.Actor,
+	}
+	if e.EnrichErr != nil {
+		v.EnrichError = e.EnrichErr.Error()
+	}
+	if e.Source != nil {
+		switch e.Source.(type) {
+		case *App:
+			v.SourceType = sourceApp
+		case *Revision:
+			v.SourceType = sourceRevision
+		case *Tag:
+			v.SourceType = sourceTag
+		case *Proc:
+			v.SourceType = sourceProc
+		case *Instance:
+			v.SourceType = sourceInstance
+		case *InstanceRestart:
+			v.SourceType = sourceRestart
+		case *Hook:
+			v.SourceType = sourceHook
+		case *Flag:
+			v.SourceType = sourceFlag
+		case *ProcDrain:
+			v.SourceType = sourceDrain
+		case *DeployFreeze:
+			v.SourceType = sourceDeployFreeze
+		case *Config:
+			v.SourceType = sourceConfig
+		default:
+			return nil, fmt.Errorf("can't encode event source %T", e.Source)
 		}
-		if !event.match(filter) {
-			continue
+		b, err := json.Marshal(e.Source)
+		if err != nil {
+			return nil, err
 		}
-		if err := event.enrich(); err != nil {
+		v.Source = b
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes an event in the wire format. The decoded Source
+// isn't attached to the coordinator, only its fields can be used.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	v := eventJSON{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Version != EventJSONVersion {
+		return errorf(ErrInvalidArgument, "unsupported event version %d", v.Version)
+	}
+
+	var src cp.Snapshotable
+	switch v.SourceType {
+	case "":
+	case sourceApp:
+		src = &App{}
+	case sourceRevision:
+		src = &Revision{}
+	case sourceTag:
+		src = &Tag{}
+	case sourceProc:
+		src = &Proc{}
+	case sourceInstance:
+		src = &Instance{}
+	case sourceRestart:
+		src = &InstanceRestart{}
+	case sourceHook:
+		src = &Hook{}
+	case sourceFlag:
+		src = &Flag{}
+	case sourceDrain:
+		src = &ProcDrain{}
+	case sourceDeployFreeze:
+		src = &DeployFreeze{}
+	case sourceConfig:
+		src = &Config{}
+	default:
+		return errorf(ErrInvalidArgument, "unknown event source type %q", v.SourceType)
+	}
+	if src != nil {
+		if err := json.Unmarshal(v.Source, src); err != nil {
 			return err
 		}
-		listener <- event
 	}
+
+	*e = Event{
+		Type:   v.Type,
+		Rev:    v.Rev,
+		Path:   EventData(v.Path),
+		Actor:  v.Actor,
+		Source: src,
+	}
+	if v.EnrichError != "" {
+		e.EnrichErr = errors.New(v.EnrichError)
+	}
+	return nil
 }
 
 func newEvent(src cp.Event) (*Event, error) {
@@ -149,6 +372,19 @@ func newEvent(src cp.Event) (*Event, error) {
 					event.Type = EvAppUnreg
 				}
 				event.Path = EventData{App: &match[1]}
+			case pathAppEnv:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvAppEnv
+				event.Path = EventData{App: &match[1]}
+			case pathAppFlag:
+				if src.IsSet() {
+					event.Type = EvAppFlag
+				} else if src.IsDel() {
+					event.Type = EvAppFlagDel
+				}
+				event.Path = EventData{App: &match[1], Flag: &match[2]}
 			case pathRev:
 				if src.IsSet() {
 					event.Type = EvRevReg
@@ -156,6 +392,15 @@ func newEvent(src cp.Event) (*Event, error) {
 					event.Type = EvRevUnreg
 				}
 				event.Path = EventData{App: &match[1], Revision: &match[2]}
+			case pathRevTag:
+				// The index of the revision is updated whenever a tag is
+				// registered, moved away or unregistered.
+				if src.IsSet() {
+					event.Type = EvRevTag
+				} else if src.IsDel() {
+					event.Type = EvRevUntag
+				}
+				event.Path = EventData{App: &match[1], Revision: &match[2], Tag: &match[3]}
 			case pathProc:
 				if src.IsSet() {
 					event.Type = EvProcReg
@@ -169,6 +414,73 @@ func newEvent(src cp.Event) (*Event, error) {
 				}
 				event.Type = EvProcAttrs
 				event.Path = EventData{App: &match[1], Proc: &match[2]}
+			case pathAppMaintenance:
+				if src.IsSet() {
+					event.Type = EvAppMaintenanceOn
+				} else if src.IsDel() {
+					event.Type = EvAppMaintenanceOff
+				}
+				event.Path = EventData{App: &match[1]}
+			case pathProcMaintenance:
+				if src.IsSet() {
+					event.Type = EvProcMaintenanceOn
+				} else if src.IsDel() {
+					event.Type = EvProcMaintenanceOff
+				}
+				event.Path = EventData{App: &match[1], Proc: &match[2]}
+			case pathDeployFreeze:
+				if src.IsSet() {
+					event.Type = EvDeployFreeze
+				} else if src.IsDel() {
+					event.Type = EvDeployUnfreeze
+				}
+			case pathConfig:
+				if src.IsSet() {
+					event.Type = EvConfig
+				} else if src.IsDel() {
+					event.Type = EvConfigDel
+				}
+				event.Path = EventData{Config: &match[1]}
+			case pathProcEnvAttrs:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvProcEnvAttrs
+				event.Path = EventData{App: &match[1], Proc: &match[2], Env: &match[3]}
+			case pathProcDrained:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvProcDrained
+				event.Path = EventData{App: &match[1], Proc: &match[2], Env: &match[3]}
+			case pathProcScale:
+				if !src.IsSet() && !src.IsDel() {
+					break
+				}
+				event.Type = EvProcScale
+				event.Path = EventData{App: &match[1], Proc: &match[2], Revision: &match[3], Env: &match[4]}
+			case pathProcRamp:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvProcRampStep
+				event.Path = EventData{App: &match[1], Proc: &match[2]}
+			case pathProcActiveEnv:
+				if !src.IsSet() {
+					break
+				}
+				// The env isn't part of the path, but is what consumers
+				// of the event need.
+				env := string(src.Body)
+				event.Type = EvProcActiveEnv
+				event.Path = EventData{App: &match[1], Proc: &match[2], Env: &env}
+			case pathHook:
+				if src.IsSet() {
+					event.Type = EvHookReg
+				} else if src.IsDel() {
+					event.Type = EvHookUnreg
+				}
+				event.Path = EventData{App: &match[1], Hook: &match[2]}
 			case pathInsRegistered:
 				if src.IsSet() {
 					event.Type = EvInsReg
@@ -183,10 +495,16 @@ func newEvent(src cp.Event) (*Event, error) {
 				// The start file can be in three different states:
 				// 1. "" - instance got registered or unclaimed
 				// 2. "<ip>" - instance got claimed
-				// 3. "<ip> <host> <port> <tport> - instance got started
-				if len(bytes.Fields(src.Body)) > 1 {
+				// 3. {"ip":<ip>,"port":<port>,...} - instance got started
+				v, err := new(startCodec).Decode(src.Body)
+				if err != nil {
+					return nil, err
+				}
+				if v.(*startRecord).started() {
 					event.Type = EvInsStart
-				} else if len(src.Body) == 0 {
+				} else if len(src.Body) > 0 {
+					event.Type = EvInsClaim
+				} else {
 					// The file is empty, so distinguish between registered and
 					// unclaimed by whether the file existed before already.
 					existed, err := pathExistedBefore(src)
@@ -204,11 +522,43 @@ func newEvent(src cp.Event) (*Event, error) {
 				}
 				event.Type = EvInsStop
 				event.Path = EventData{Instance: &match[1]}
+			case pathInsRestartReq:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsRestartRequested
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsCrashLoop:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsCrashLoop
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsHealth:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsHealth
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsReady:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsReady
+				event.Path = EventData{Instance: &match[1]}
+			case pathInsRestarts:
+				if !src.IsSet() {
+					break
+				}
+				event.Type = EvInsRestart
+				event.Path = EventData{Instance: &match[1]}
 			case pathInsStatus:
 				if !src.IsSet() {
 					break
 				}
 				switch InsStatus(src.Body) {
+				case InsStatusStarting:
+					event.Type = EvInsStarting
 				case InsStatusRunning:
 					event.Type = EvInsStart
 				case InsStatusExited:
@@ -243,6 +593,12 @@ func (e *Event) match(filter []EventType) bool {
 }
 
 func (e *Event) enrich() error {
+	return e.enrichWith(nil)
+}
+
+// enrichWith enriches the event, reading apps and procs through the cache if
+// one is given.
+func (e *Event) enrichWith(c *sourceCache) error {
 	var (
 		app *App
 		err error
@@ -253,32 +609,78 @@ func (e *Event) enrich() error {
 	}
 
 	if e.Path.App != nil {
-		app, err = getApp(*e.Path.App, e.raw)
+		app, err = c.app(*e.Path.App, e.raw)
 		if err != nil {
 			return err
 		}
 	}
 
 	switch e.Type {
-	case EvAppReg:
+	case EvAppReg, EvAppEnv, EvAppMaintenanceOn:
 		e.Source, err = app, nil
+	case EvAppFlag:
+		e.Source, err = getFlag(app, *e.Path.Flag, e.raw)
 	case EvRevReg:
 		e.Source, err = getRevision(app, *e.Path.Revision, e.raw)
-	case EvProcReg, EvProcAttrs:
-		e.Source, err = getProc(app, *e.Path.Proc, e.raw)
-	case EvInsReg, EvInsUnclaim, EvInsStart, EvInsStop, EvInsFail, EvInsExit, EvInsLost:
-		id, err := strconv.ParseInt(*e.Path.Instance, 10, 64)
-		if err != nil {
-			return err
+	case EvRevTag:
+		e.Source, err = getTag(app, *e.Path.Tag, e.raw)
+	case EvProcReg, EvProcAttrs, EvProcEnvAttrs, EvProcScale, EvProcRampStep, EvProcActiveEnv, EvProcMaintenanceOn:
+		e.Source, err = c.proc(app, *e.Path.Proc, e.raw)
+	case EvProcDrained:
+		e.Source, err = getDrain(app.Name, *e.Path.Proc, *e.Path.Env, drainDonePath, e.raw)
+	case EvHookReg:
+		e.Source, err = getHook(app, *e.Path.Hook, e.raw)
+	case EvDeployFreeze:
+		e.Source, err = getDeployFreeze(e.raw)
+	case EvConfig:
+		e.Source, err = getConfig(*e.Path.Config, e.raw)
+	case EvInsReg, EvInsClaim, EvInsUnclaim, EvInsStarting, EvInsStart, EvInsStop, EvInsRestartRequested, EvInsCrashLoop, EvInsHealth, EvInsReady, EvInsFail, EvInsExit, EvInsLost:
+		var id int64
+		if id, err = parseInstanceID(*e.Path.Instance); err == nil {
+			e.Source, err = getInstance(id, e.raw)
+		}
+	case EvInsRestart:
+		var id int64
+		if id, err = parseInstanceID(*e.Path.Instance); err == nil {
+			e.Source, err = getInstanceRestart(id, e.raw)
 		}
-		e.Source, err = getInstance(id, e.raw)
 	}
 	if err != nil {
-		return fmt.Errorf("error enriching event %+v: %s", e.raw, err)
+		e.Source = nil
+		return fmt.Errorf("error enriching event %+v: %w", e.raw, err)
 	}
+	e.Actor = e.sourceActor()
 	return nil
 }
 
+// sourceActor returns the actor recorded with the change of the source.
+func (e *Event) sourceActor() string {
+	switch src := e.Source.(type) {
+	case *App:
+		if e.Type == EvAppReg {
+			return src.RegisteredBy
+		}
+	case *Revision:
+		return src.RegisteredBy
+	case *Tag:
+		return src.RegisteredBy
+	case *Proc:
+		switch e.Type {
+		case EvProcReg:
+			return src.RegisteredBy
+		case EvProcAttrs:
+			return src.Attrs.UpdatedBy
+		}
+	case *Instance:
+		if e.Type == EvInsReg {
+			return src.RegisteredBy
+		}
+	case *DeployFreeze:
+		return src.Actor
+	}
+	return ""
+}
+
 func pathExistedBefore(e cp.Event) (bool, error) {
 	if e.Rev == 0 {
 		return false, nil
@@ -6,7 +6,9 @@
 package visor
 
 import (
+	"encoding/json"
 	"errors"
+	"path"
 	"reflect"
 	"strconv"
 	"testing"
@@ -209,6 +211,28 @@ func TestEventProcUnregistered(t *testing.T) {
 	}
 }
 
+func TestEventAppEnv(t *testing.T) {
+	s, l := eventSetup()
+	app := eventAppSetup(s, "envcat")
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(app).WatchEvent(l, EvAppEnv)
+
+	app, err = app.SetEnvironmentVar("MEOW", "purr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := expectEvent(EvAppEnv, app, l, t)
+	if want, have := app.EnvVersion, ev.Source.(*App).EnvVersion; want != have {
+		t.Errorf("want env version %d, have %d", want, have)
+	}
+}
+
 func TestEventProcAttrs(t *testing.T) {
 	var (
 		s, l    = eventSetup()
@@ -217,6 +241,10 @@ func TestEventProcAttrs(t *testing.T) {
 		control = &TrafficControl{
 			Share: 80,
 		}
+		routing = &LogRouting{
+			Level:       LogLevelWarn,
+			LoggerGroup: "bulk",
+		}
 	)
 
 	app, err := app.Register()
@@ -237,6 +265,7 @@ func TestEventProcAttrs(t *testing.T) {
 	}()
 
 	proc.Attrs.TrafficControl = control
+	proc.Attrs.LogRouting = routing
 
 	proc, err = proc.StoreAttrs()
 	if err != nil {
@@ -257,6 +286,184 @@ func TestEventProcAttrs(t *testing.T) {
 	if want, have := control, p.Attrs.TrafficControl; !reflect.DeepEqual(want, have) {
 		t.Errorf("want %#v, have %#v", want, have)
 	}
+	if want, have := routing, p.Attrs.LogRouting; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %#v, have %#v", want, have)
+	}
+}
+
+func TestEventHookRegistered(t *testing.T) {
+	s, l := eventSetup()
+	app := eventAppSetup(s, "reghook")
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := app.NewHook("scale", "v1").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(hook).WatchEvent(l, EvHookReg, EvHookUnreg)
+
+	hook, err = app.NewHook("scale", "v2").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvHookReg, hook, l, t)
+	if ev.Path.Hook == nil || *ev.Path.Hook != hook.Name {
+		t.Error("event.Path doesn't contain expected data")
+	}
+	if v := ev.Source.(*Hook).Version; v != 2 {
+		t.Errorf("expected hook version 2 in event, got %d", v)
+	}
+
+	if err := hook.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvHookUnreg, nil, l, t)
+}
+
+func TestEventMaintenance(t *testing.T) {
+	s, l := eventSetup()
+	app, err := eventAppSetup(s, "maintcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(proc).WatchEvent(l,
+		EvAppMaintenanceOn, EvAppMaintenanceOff, EvProcMaintenanceOn, EvProcMaintenanceOff)
+
+	if app, err = app.SetMaintenance(true, "incident"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvAppMaintenanceOn, app, l, t)
+	if _, err = app.SetMaintenance(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvAppMaintenanceOff, nil, l, t)
+
+	if proc, err = proc.SetMaintenance(true, "incident"); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvProcMaintenanceOn, proc, l, t)
+	if ev.Path.Proc == nil || *ev.Path.Proc != proc.Name {
+		t.Error("event.Path doesn't contain expected data")
+	}
+	if _, err = proc.SetMaintenance(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvProcMaintenanceOff, nil, l, t)
+}
+
+func TestEventProcEnvAttrs(t *testing.T) {
+	s, l := eventSetup()
+	app, err := eventAppSetup(s, "envattrscat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(proc).WatchEvent(l, EvProcEnvAttrs)
+
+	if proc, err = proc.StoreAttrsForEnv("staging", ProcAttrs{MaxRestarts: 3}); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvProcEnvAttrs, proc, l, t)
+	if ev.Path.Env == nil || *ev.Path.Env != "staging" {
+		t.Error("event.Path doesn't contain expected data")
+	}
+}
+
+func TestEventProcDrained(t *testing.T) {
+	s, l := eventSetup()
+	app, err := eventAppSetup(s, "draincat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(proc).WatchEvent(l, EvProcDrained)
+
+	if _, err := proc.Drain("staging"); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvProcDrained, &ProcDrain{}, l, t)
+	if ev.Path.Env == nil || *ev.Path.Env != "staging" {
+		t.Error("event.Path doesn't contain expected data")
+	}
+	if drain := ev.Source.(*ProcDrain); !drain.IsFinished() {
+		t.Errorf("expected finished drain in event, got %#v", drain)
+	}
+}
+
+func TestEventAppFlag(t *testing.T) {
+	s, l := eventSetup()
+	app, err := eventAppSetup(s, "flagcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(app).WatchEvent(l, EvAppFlag, EvAppFlagDel)
+
+	if app, err = app.SetFlag("dark-mode", json.RawMessage(`true`)); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvAppFlag, &Flag{}, l, t)
+	if ev.Path.Flag == nil || *ev.Path.Flag != "dark-mode" {
+		t.Error("event.Path doesn't contain expected data")
+	}
+	if v := string(ev.Source.(*Flag).Value); v != "true" {
+		t.Errorf("expected flag value true in event, got %s", v)
+	}
+	if _, err = app.DelFlag("dark-mode"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvAppFlagDel, nil, l, t)
+}
+
+func TestEventDeployFreeze(t *testing.T) {
+	s, l := eventSetup()
+
+	go s.WatchEvent(l, EvDeployFreeze, EvDeployUnfreeze)
+
+	s, err := s.SetDeployFreeze(true, "ops", "incident")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvDeployFreeze, &DeployFreeze{}, l, t)
+	if freeze := ev.Source.(*DeployFreeze); freeze.Reason != "incident" {
+		t.Errorf("expected freeze reason in event, got %#v", freeze)
+	}
+	if _, err := s.SetDeployFreeze(false, "ops", ""); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvDeployUnfreeze, nil, l, t)
+}
+
+func TestEventActor(t *testing.T) {
+	s, l := eventSetup()
+
+	go s.WatchEvent(l, EvAppReg)
+
+	app, err := eventAppSetup(s.As("deploy-bot"), "actorcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvAppReg, app, l, t)
+	if ev.Actor != "deploy-bot" {
+		t.Errorf("expected actor deploy-bot in event, got %q", ev.Actor)
+	}
 }
 
 func TestEventInstanceRegistered(t *testing.T) {
@@ -319,6 +526,10 @@ func TestEventInstanceStateChange(t *testing.T) {
 	if _, err = ins.Claim("0.0.0.0"); err != nil {
 		t.Fatal(err)
 	}
+	ev := expectEvent(EvInsClaim, ins, l, t)
+	if claimed := ev.Source.(*Instance); claimed.Status != InsStatusClaimed || claimed.IP != "0.0.0.0" {
+		t.Errorf("expected instance claimed by 0.0.0.0, got %s by %q", claimed.Status, claimed.IP)
+	}
 
 	ins, err = ins.Unclaim("0.0.0.0")
 	if err != nil {
@@ -329,12 +540,18 @@ func TestEventInstanceStateChange(t *testing.T) {
 	if _, err = ins.Claim(ip); err != nil {
 		t.Fatal(err)
 	}
+	expectEvent(EvInsClaim, ins, l, t)
+
+	if ins, err = ins.Starting(ip); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvInsStarting, ins, l, t)
 
 	ins, err = ins.Started(ip, host, port, tPort)
 	if err != nil {
 		t.Error(err)
 	}
-	ev := expectEvent(EvInsStart, ins, l, t)
+	ev = expectEvent(EvInsStart, ins, l, t)
 	if ev.Path.Instance == nil || (*ev.Path.Instance != strconv.FormatInt(ins.ID, 10)) {
 		t.Error("event.Path doesn't contain expected data")
 	}
@@ -343,6 +560,16 @@ func TestEventInstanceStateChange(t *testing.T) {
 		t.Fatal("instance fields don't match")
 	}
 
+	if err := ins.RequestRestart("env changed"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvInsRestartRequested, ins, l, t)
+
+	if ins, err = ins.SetHealth(HealthUnhealthy, "GET /health: 503"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(EvInsHealth, ins, l, t)
+
 	if err := ins.Stop(); err != nil {
 		t.Fatal(err)
 	}
@@ -361,6 +588,36 @@ func TestEventInstanceStateChange(t *testing.T) {
 	expectEvent(EvInsExit, ins, l, t)
 }
 
+func TestEventInstanceRestart(t *testing.T) {
+	ip := "10.0.0.1"
+	s, l := eventSetup()
+
+	ins, err := s.RegisterInstance("restartmouse", "stable", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(ip, "mouse.org", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Restarted(InsRestarts{Fail: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	go storeFromSnapshotable(ins).WatchEvent(l, EvInsRestart)
+
+	if _, err = ins.Restarted(InsRestarts{Fail: 2, OOM: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvInsRestart, &InstanceRestart{}, l, t)
+	r := ev.Source.(*InstanceRestart)
+	if r.Previous != (InsRestarts{Fail: 1}) || r.Restarts != (InsRestarts{Fail: 2, OOM: 1}) {
+		t.Errorf("expected restarts to go from 1 to 2 fails, got %#v to %#v", r.Previous, r.Restarts)
+	}
+}
+
 func TestEventInstanceEnrichment(t *testing.T) {
 	s, l := eventSetup()
 
@@ -382,6 +639,49 @@ func TestEventInstanceEnrichment(t *testing.T) {
 	expectEvent(EvInsUnreg, nil, l, t)
 }
 
+func TestEventEnrichmentFailure(t *testing.T) {
+	s, l := eventSetup()
+
+	// An instance without object file can't be read.
+	sp, err := s.GetSnapshot().Set(path.Join(instancePath(99999), registeredPath), timestamp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err := storeFromSnapshotable(sp).RegisterInstance("foo", "bar", "baz", "qux")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.WatchEvent(l, EvInsReg)
+
+	ev := expectEvent(EvInsReg, nil, l, t)
+	if ev.EnrichErr == nil || !IsErrNotFound(ev.EnrichErr) {
+		t.Errorf("expected not found enrichment error, got %v", ev.EnrichErr)
+	}
+	ev = expectEvent(EvInsReg, ins, l, t)
+	if ev.EnrichErr != nil {
+		t.Errorf("expected event to be enriched, got %v", ev.EnrichErr)
+	}
+}
+
+func TestEventRaw(t *testing.T) {
+	s, l := eventSetup()
+	app := eventAppSetup(s, "rawcat")
+
+	go s.WatchEventRaw(l, EvAppReg)
+
+	if _, err := app.Register(); err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvAppReg, nil, l, t)
+	if ev.Path.App == nil || *ev.Path.App != app.Name {
+		t.Errorf("expected path of %s, got %s", app.Name, ev.Path)
+	}
+	if ev.Source != nil || ev.Actor != "" {
+		t.Errorf("expected event without source, got %#v", ev)
+	}
+}
+
 func TestEventFilter(t *testing.T) {
 	s, l := eventSetup()
 
@@ -414,3 +714,76 @@ func TestEventFilter(t *testing.T) {
 	expectEvent(EvInsStart, ins, l, t)
 	expectEvent(EvInsUnreg, nil, l, t)
 }
+
+func TestEventWatchClose(t *testing.T) {
+	s, l := eventSetup()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.WatchEvent(l)
+	}()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WatchEvent to return after Close")
+	}
+
+	if _, ok := <-l; ok {
+		t.Error("expected listener to be closed")
+	}
+	if !s.IsClosed() {
+		t.Error("expected store to be closed")
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	app, proc := "jsoncat", "web"
+	ev := &Event{
+		Type:   EvProcReg,
+		Rev:    42,
+		Path:   EventData{App: &app, Proc: &proc},
+		Actor:  "deploy-bot",
+		Source: &Proc{Name: proc, Port: 8000},
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Event{}
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != EvProcReg || decoded.Rev != 42 || decoded.Actor != "deploy-bot" {
+		t.Errorf("expected event fields to round-trip, got %#v", decoded)
+	}
+	if decoded.Path.Proc == nil || *decoded.Path.Proc != proc || decoded.Path.Instance != nil {
+		t.Errorf("expected path to round-trip, got %s", decoded.Path)
+	}
+	if p, ok := decoded.Source.(*Proc); !ok || p.Port != 8000 {
+		t.Errorf("expected proc source, got %#v", decoded.Source)
+	}
+
+	ev = &Event{Type: EvInsReg, Rev: 43, EnrichErr: errors.New("instance '7' not found")}
+	if b, err = json.Marshal(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Source != nil || decoded.EnrichErr == nil || decoded.EnrichErr.Error() != ev.EnrichErr.Error() {
+		t.Errorf("expected enrichment error to round-trip, got %#v", decoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"version":2,"type":"app-register"}`), decoded); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error for unknown version, got %v", err)
+	}
+}
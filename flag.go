@@ -0,0 +1,147 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"regexp"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const flagsPath = "flags"
+
+var reFlagName = regexp.MustCompile(`^[[:alnum:]][-.[:alnum:]]*$`)
+
+// Flag is a runtime toggle of an app. Unlike environment variables flags
+// hold arbitrary JSON values and changing them doesn't imply restarting
+// instances.
+type Flag struct {
+	file  *cp.File
+	App   *App
+	Name  string
+	Value json.RawMessage
+}
+
+// SetFlag stores the JSON value of the named flag.
+func (a *App) SetFlag(name string, value json.RawMessage) (*App, error) {
+	return a.SetFlags(map[string]json.RawMessage{name: value})
+}
+
+// SetFlags stores the given flags, leaving all others as they are. Flags
+// with a nil value are removed.
+func (a *App) SetFlags(flags map[string]json.RawMessage) (*App, error) {
+	if err := guardOf(a).authorize("", ActionFlag, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	txn := storeFromSnapshotable(sp).Txn()
+	for name, value := range flags {
+		if !reFlagName.MatchString(name) {
+			return nil, errorf(ErrInvalidKey, "invalid flag name %q", name)
+		}
+		if value == nil {
+			txn.Del(a.dir.Prefix(flagsPath, name))
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, errorf(ErrInvalidArgument, "invalid value of flag %s: %w", name, err)
+		}
+		txn.Set(a.dir.Prefix(flagsPath, name), string(value))
+	}
+	sp, err = txn.commit()
+	if err != nil {
+		return nil, err
+	}
+	a.dir = a.dir.Join(sp)
+	return a, nil
+}
+
+// DelFlag removes the named flag.
+func (a *App) DelFlag(name string) (*App, error) {
+	if err := guardOf(a).authorize("", ActionFlag, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	err = sp.Del(a.dir.Prefix(flagsPath, name))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "flag %s not found", name)
+		}
+		return nil, err
+	}
+	if sp, err = sp.FastForward(); err != nil {
+		return nil, err
+	}
+	a.dir = a.dir.Join(sp)
+	return a, nil
+}
+
+// GetFlag returns the named flag.
+func (a *App) GetFlag(name string) (*Flag, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getFlag(a, name, sp)
+}
+
+// GetFlags returns the values of all flags of the app.
+func (a *App) GetFlags() (map[string]json.RawMessage, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	names, err := getdirOrEmpty(sp, a.dir.Prefix(flagsPath))
+	if err != nil {
+		return nil, err
+	}
+	flags := map[string]json.RawMessage{}
+	for _, name := range names {
+		flag, err := getFlag(a, name, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		flags[name] = flag.Value
+	}
+	return flags, nil
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (f *Flag) GetSnapshot() cp.Snapshot {
+	return f.file.Snapshot
+}
+
+// Decode decodes the value of the flag into v.
+func (f *Flag) Decode(v interface{}) error {
+	return json.Unmarshal(f.Value, v)
+}
+
+func getFlag(a *App, name string, s cp.Snapshotable) (*Flag, error) {
+	f, err := s.GetSnapshot().GetFile(a.dir.Prefix(flagsPath, name), new(cp.StringCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "flag %s not found for app %s", name, a.Name)
+		}
+		return nil, err
+	}
+	return &Flag{
+		file:  f,
+		App:   a,
+		Name:  name,
+		Value: json.RawMessage(f.Value.(string)),
+	}, nil
+}
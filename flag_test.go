@@ -0,0 +1,91 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func flagSetup(appid string) *App {
+	s, err := DialURI(DefaultURI, "/flag-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp(appid, "git://flag.git", "master").Register()
+	if err != nil {
+		panic(err)
+	}
+	return app
+}
+
+func TestAppFlags(t *testing.T) {
+	app := flagSetup("flag-cat")
+
+	app, err := app.SetFlag("new-checkout", json.RawMessage(`true`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err = app.SetFlags(map[string]json.RawMessage{
+		"sample-rate": json.RawMessage(`0.25`),
+		"regions":     json.RawMessage(`["eu","us"]`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err := app.GetFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 3 {
+		t.Fatalf("expected 3 flags, got %v", flags)
+	}
+	if string(flags["new-checkout"]) != "true" {
+		t.Errorf("expected new-checkout to be true, got %s", flags["new-checkout"])
+	}
+
+	flag, err := app.GetFlag("regions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regions := []string{}
+	if err := flag.Decode(&regions); err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) != 2 || regions[0] != "eu" {
+		t.Errorf("expected regions [eu us], got %v", regions)
+	}
+
+	app, err = app.SetFlags(map[string]json.RawMessage{"sample-rate": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.GetFlag("sample-rate"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if app, err = app.DelFlag("regions"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.DelFlag("regions"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if _, err := app.SetFlag("bad name", json.RawMessage(`1`)); !IsErrInvalidKey(err) {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+	if _, err := app.SetFlag("broken", json.RawMessage(`{`)); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+}
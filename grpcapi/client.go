@@ -0,0 +1,74 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package grpcapi
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TokenCredentials send a bearer token with every request, to be checked by
+// a TokenAuthenticator.
+type TokenCredentials struct {
+	Token string
+	// Allow sending the token without transport security.
+	Insecure bool
+}
+
+// GetRequestMetadata satisfies the credentials.PerRPCCredentials interface.
+func (c TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.Token}, nil
+}
+
+// RequireTransportSecurity satisfies the credentials.PerRPCCredentials
+// interface.
+func (c TokenCredentials) RequireTransportSecurity() bool {
+	return !c.Insecure
+}
+
+// Client is a VisorClient together with its connection.
+type Client struct {
+	VisorClient
+	conn *grpc.ClientConn
+}
+
+// Dial connects to the Visor service at addr over the given transport
+// credentials, sending the token with every request if it isn't empty. The
+// transport has to be secure, use DialInsecure for plain text connections.
+func Dial(addr, token string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*Client, error) {
+	if creds == nil || creds.Info().SecurityProtocol == "insecure" {
+		return nil, errors.New("grpcapi: Dial requires a secure transport, see DialInsecure")
+	}
+	return dial(addr, token, creds, false, opts)
+}
+
+// DialInsecure is Dial over a plain text connection, which sends the token
+// in the clear. It's meant for tests and connections that don't leave the
+// host.
+func DialInsecure(addr, token string, opts ...grpc.DialOption) (*Client, error) {
+	return dial(addr, token, insecure.NewCredentials(), true, opts)
+}
+
+func dial(addr, token string, creds credentials.TransportCredentials, plaintext bool, opts []grpc.DialOption) (*Client, error) {
+	dopts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if token != "" {
+		dopts = append(dopts, grpc.WithPerRPCCredentials(TokenCredentials{Token: token, Insecure: plaintext}))
+	}
+	conn, err := grpc.NewClient(addr, append(dopts, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{VisorClient: NewVisorClient(conn), conn: conn}, nil
+}
+
+// Close closes the connection of the client.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
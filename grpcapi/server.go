@@ -0,0 +1,428 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+// Package grpcapi exposes a visor Store over gRPC. The service is defined in
+// visor.proto, the generated types and stubs live in visor.pb.go and
+// visor_grpc.pb.go. Regenerate them with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative visor.proto
+//
+// Requests are authenticated by the Authenticator of the Server, the actor
+// it returns is recorded on every change, see visor.Store.As.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative visor.proto
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/soundcloud/visor"
+)
+
+// Authenticator returns the actor a request is performed for. A failing
+// Authenticator rejects the request as unauthenticated.
+type Authenticator func(ctx context.Context) (actor string, err error)
+
+// Server implements VisorServer for a Store.
+type Server struct {
+	UnimplementedVisorServer
+	store *visor.Store
+	// Authenticate is called for every request. All requests are accepted,
+	// without an actor, if nil.
+	Authenticate Authenticator
+}
+
+// NewServer returns a Server for the given Store. Register it with
+// RegisterVisorServer.
+func NewServer(s *visor.Store, auth Authenticator) *Server {
+	return &Server{store: s, Authenticate: auth}
+}
+
+// storeFor authenticates the request and returns the latest Store acting
+// for its actor.
+func (srv *Server) storeFor(ctx context.Context) (*visor.Store, error) {
+	actor := ""
+	if srv.Authenticate != nil {
+		var err error
+		if actor, err = srv.Authenticate(ctx); err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+	}
+	s, err := srv.store.FastForward()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if actor != "" {
+		s = s.As(actor)
+	}
+	return s, nil
+}
+
+// ListApps satisfies VisorServer.
+func (srv *Server) ListApps(ctx context.Context, req *ListAppsRequest) (*ListAppsResponse, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := s.GetApps()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := &ListAppsResponse{}
+	for _, a := range apps {
+		res.Apps = append(res.Apps, newApp(a))
+	}
+	return res, nil
+}
+
+// GetApp satisfies VisorServer.
+func (srv *Server) GetApp(ctx context.Context, req *GetAppRequest) (*App, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.GetApp(req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newApp(app), nil
+}
+
+// RegisterApp satisfies VisorServer.
+func (srv *Server) RegisterApp(ctx context.Context, req *RegisterAppRequest) (*App, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.App == nil {
+		return nil, status.Error(codes.InvalidArgument, "app missing")
+	}
+	app := s.NewApp(req.App.Name, req.App.RepoUrl, req.App.Stack)
+	app.DeployType = req.App.DeployType
+	if req.App.Env != nil {
+		app.Env = req.App.Env
+	}
+	if app, err = app.Register(); err != nil {
+		return nil, toStatus(err)
+	}
+	return newApp(app), nil
+}
+
+// UnregisterApp satisfies VisorServer.
+func (srv *Server) UnregisterApp(ctx context.Context, req *UnregisterAppRequest) (*Empty, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.GetApp(req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := app.Unregister(); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, nil
+}
+
+// ListProcs satisfies VisorServer.
+func (srv *Server) ListProcs(ctx context.Context, req *ListProcsRequest) (*ListProcsResponse, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.GetApp(req.App)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	procs, err := app.GetProcs()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	res := &ListProcsResponse{}
+	for _, p := range procs {
+		res.Procs = append(res.Procs, newProc(p))
+	}
+	return res, nil
+}
+
+// RegisterProc satisfies VisorServer.
+func (srv *Server) RegisterProc(ctx context.Context, req *RegisterProcRequest) (*Proc, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.GetApp(req.App)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	proc, err := s.NewProc(app, req.Name).Register()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newProc(proc), nil
+}
+
+// ListInstances satisfies VisorServer.
+func (srv *Server) ListInstances(ctx context.Context, req *ListInstancesRequest) (*ListInstancesResponse, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	app, err := s.GetApp(req.App)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	proc, err := app.GetProc(req.Proc)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	instances, err := proc.GetInstances()
+	if err != nil && !visor.IsErrNotFound(err) {
+		return nil, toStatus(err)
+	}
+	res := &ListInstancesResponse{}
+	for _, ins := range instances {
+		res.Instances = append(res.Instances, newInstance(ins))
+	}
+	return res, nil
+}
+
+// GetInstance satisfies VisorServer.
+func (srv *Server) GetInstance(ctx context.Context, req *GetInstanceRequest) (*Instance, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ins, err := s.GetInstance(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newInstance(ins), nil
+}
+
+// RegisterInstance satisfies VisorServer.
+func (srv *Server) RegisterInstance(ctx context.Context, req *RegisterInstanceRequest) (*Instance, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ins, err := s.RegisterInstance(req.App, req.Rev, req.Proc, req.Env)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newInstance(ins), nil
+}
+
+// StopInstance satisfies VisorServer.
+func (srv *Server) StopInstance(ctx context.Context, req *StopInstanceRequest) (*Empty, error) {
+	s, err := srv.storeFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ins, err := s.GetInstance(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := ins.Stop(); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, nil
+}
+
+// Events satisfies VisorServer. Nothing is sent before the first request of
+// the client, every later request replaces the filter. Events are sent until
+// the client cancels the stream, closing its send side only ends the filter
+// updates.
+func (srv *Server) Events(stream Visor_EventsServer) error {
+	s, err := srv.storeFor(stream.Context())
+	if err != nil {
+		return err
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	typesc := make(chan []string)
+	recvc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				recvc <- err
+				return
+			}
+			select {
+			case typesc <- req.Types:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	// Watchers stop with the stream.
+	w, err := watchEvents(stream.Context(), s, req.Types)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer func() { w.Stop() }()
+
+	for {
+		select {
+		case ev, ok := <-w.Events():
+			if !ok {
+				return toStatus(w.Stop())
+			}
+			msg, err := newEvent(ev)
+			if err != nil {
+				return toStatus(err)
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case types := <-typesc:
+			// The new filter applies from the latest revision on.
+			if err := w.Stop(); err != nil {
+				return toStatus(err)
+			}
+			if s, err = s.FastForward(); err != nil {
+				return toStatus(err)
+			}
+			if w, err = watchEvents(stream.Context(), s, types); err != nil {
+				return toStatus(err)
+			}
+		case err := <-recvc:
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// watchEvents starts a Watcher for the events of the given types, all if
+// none.
+func watchEvents(ctx context.Context, s *visor.Store, types []string) (*visor.Watcher, error) {
+	filter := make([]visor.EventType, len(types))
+	for i, t := range types {
+		filter[i] = visor.EventType(t)
+	}
+	return s.Watch(ctx, visor.WatchOptions{Filter: filter})
+}
+
+func newApp(a *visor.App) *App {
+	return &App{
+		Name:         a.Name,
+		RepoUrl:      a.RepoURL,
+		Stack:        a.Stack,
+		DeployType:   a.DeployType,
+		Env:          a.Env,
+		Registered:   a.Registered.Unix(),
+		RegisteredBy: a.RegisteredBy,
+	}
+}
+
+func newProc(p *visor.Proc) *Proc {
+	return &Proc{
+		App:          p.App.Name,
+		Name:         p.Name,
+		Port:         int32(p.Port),
+		ControlPort:  int32(p.ControlPort),
+		Registered:   p.Registered.Unix(),
+		RegisteredBy: p.RegisteredBy,
+	}
+}
+
+func newInstance(i *visor.Instance) *Instance {
+	return &Instance{
+		Id:           i.ID,
+		App:          i.AppName,
+		Rev:          i.RevisionName,
+		Proc:         i.ProcessName,
+		Env:          i.Env,
+		Ip:           i.IP,
+		Port:         int32(i.Port),
+		TelePort:     int32(i.TelePort),
+		Host:         i.Host,
+		Status:       string(i.Status),
+		Registered:   i.Registered.Unix(),
+		RegisteredBy: i.RegisteredBy,
+		Labels:       i.Labels,
+	}
+}
+
+func newEvent(e *visor.Event) (*Event, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	path := map[string]string{}
+	for k, v := range map[string]*string{
+		"app":      e.Path.App,
+		"rev":      e.Path.Revision,
+		"proc":     e.Path.Proc,
+		"instance": e.Path.Instance,
+	} {
+		if v != nil {
+			path[k] = *v
+		}
+	}
+	return &Event{
+		Type:  string(e.Type),
+		Rev:   e.Rev,
+		Path:  path,
+		Actor: e.Actor,
+		Json:  b,
+	}, nil
+}
+
+// toStatus maps visor errors to gRPC status errors.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	var code codes.Code
+	switch {
+	case visor.IsErrNotFound(err):
+		code = codes.NotFound
+	case visor.IsErrConflict(err):
+		code = codes.AlreadyExists
+	case visor.IsErrInvalidState(err), visor.IsErrDeployFrozen(err), visor.IsErrMaintenance(err):
+		code = codes.FailedPrecondition
+	case visor.IsErrInvalidArgument(err), visor.IsErrInvalidKey(err), visor.IsErrBadProcName(err), visor.IsErrBadName(err):
+		code = codes.InvalidArgument
+	case visor.IsErrUnauthorized(err), visor.IsErrReadOnly(err):
+		code = codes.PermissionDenied
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+// TokenAuthenticator authenticates requests by the bearer token in their
+// authorization metadata, mapping tokens to actors.
+func TokenAuthenticator(tokens map[string]string) Authenticator {
+	return func(ctx context.Context) (string, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if token := strings.TrimPrefix(v, "Bearer "); token != v {
+				if actor, ok := tokens[token]; ok {
+					return actor, nil
+				}
+			}
+		}
+		return "", visor.NewError(visor.ErrUnauthorized, "invalid or missing token")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/soundcloud/visor"
+)
+
+var testTokens = map[string]string{"s3cr3t": "grpcapi-test"}
+
+func serverSetup() (*visor.Store, *Client, func()) {
+	// A fresh root per run, as the tree can't be reset from here.
+	s, err := visor.DialURI(visor.DefaultURI, fmt.Sprintf("/grpcapi-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := grpc.NewServer()
+	RegisterVisorServer(srv, NewServer(s, TokenAuthenticator(testTokens)))
+	go srv.Serve(l)
+
+	c, err := DialInsecure(l.Addr().String(), "s3cr3t")
+	if err != nil {
+		panic(err)
+	}
+	return s, c, func() {
+		c.Close()
+		srv.Stop()
+		s.Close()
+	}
+}
+
+func TestServerApps(t *testing.T) {
+	_, c, done := serverSetup()
+	defer done()
+	ctx := context.Background()
+
+	app, err := c.RegisterApp(ctx, &RegisterAppRequest{App: &App{Name: "grpc-cat", RepoUrl: "git://grpc.git", Stack: "master"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.RegisteredBy != "grpcapi-test" {
+		t.Errorf("expected app registered by the token actor, got %q", app.RegisteredBy)
+	}
+	_, err = c.RegisterApp(ctx, &RegisterAppRequest{App: &App{Name: "grpc-cat", RepoUrl: "git://grpc.git", Stack: "master"}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected already exists, got %v", err)
+	}
+	apps, err := c.ListApps(ctx, &ListAppsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps.Apps) != 1 || apps.Apps[0].Name != "grpc-cat" {
+		t.Errorf("expected grpc-cat, got %v", apps.Apps)
+	}
+	if _, err := c.GetApp(ctx, &GetAppRequest{Name: "no-cat"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	s, c, done := serverSetup()
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := c.Events(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&EventsRequest{Types: []string{string(visor.EvAppReg)}}); err != nil {
+		t.Fatal(err)
+	}
+	// Give the server a moment to start watching.
+	time.Sleep(100 * time.Millisecond)
+	if _, err := s.NewApp("stream-cat", "git://stream.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != string(visor.EvAppReg) || ev.Path["app"] != "stream-cat" {
+		t.Errorf("expected registration of stream-cat, got %v", ev)
+	}
+	e := &visor.Event{}
+	if err := e.UnmarshalJSON(ev.Json); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != visor.EvAppReg {
+		t.Errorf("expected JSON of %s event, got %s", visor.EvAppReg, e.Type)
+	}
+
+	if err := stream.Send(&EventsRequest{Types: []string{string(visor.EvAppUnreg)}}); err != nil {
+		t.Fatal(err)
+	}
+	// Closing the send side keeps the events coming.
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	app, err := s.NewApp("stream-dog", "git://stream.git", "master").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if ev, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != string(visor.EvAppUnreg) || ev.Path["app"] != "stream-dog" {
+		t.Errorf("expected unregistration of stream-dog after changing the filter, got %v", ev)
+	}
+}
+
+func TestDialRequiresSecureTransport(t *testing.T) {
+	for _, creds := range []credentials.TransportCredentials{nil, insecure.NewCredentials()} {
+		if _, err := Dial("127.0.0.1:0", "s3cr3t", creds); err == nil {
+			t.Errorf("expected Dial over %v to fail", creds)
+		}
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	auth := TokenAuthenticator(testTokens)
+	for i, tt := range []struct {
+		md    metadata.MD
+		actor string
+		ok    bool
+	}{
+		{metadata.Pairs("authorization", "Bearer s3cr3t"), "grpcapi-test", true},
+		{metadata.Pairs("authorization", "Bearer wrong"), "", false},
+		{metadata.Pairs("authorization", "s3cr3t"), "", false},
+		{metadata.MD{}, "", false},
+	} {
+		actor, err := auth(metadata.NewIncomingContext(context.Background(), tt.md))
+		if (err == nil) != tt.ok || actor != tt.actor {
+			t.Errorf("%d. expected %q, %v, got %q, %v", i, tt.actor, tt.ok, actor, err)
+		}
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	for i, tt := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{nil, codes.OK},
+		{visor.NewError(visor.ErrNotFound, "x"), codes.NotFound},
+		{visor.NewError(visor.ErrConflict, "x"), codes.AlreadyExists},
+		{visor.NewError(visor.ErrInvalidArgument, "x"), codes.InvalidArgument},
+		{visor.NewError(visor.ErrUnauthorized, "x"), codes.PermissionDenied},
+		{fmt.Errorf("x"), codes.Internal},
+	} {
+		if code := status.Code(toStatus(tt.err)); code != tt.code {
+			t.Errorf("%d. expected %s, got %s", i, tt.code, code)
+		}
+	}
+}
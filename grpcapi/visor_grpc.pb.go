@@ -0,0 +1,496 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v25.1.0
+// source: visor.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Visor_ListApps_FullMethodName         = "/visor.Visor/ListApps"
+	Visor_GetApp_FullMethodName           = "/visor.Visor/GetApp"
+	Visor_RegisterApp_FullMethodName      = "/visor.Visor/RegisterApp"
+	Visor_UnregisterApp_FullMethodName    = "/visor.Visor/UnregisterApp"
+	Visor_ListProcs_FullMethodName        = "/visor.Visor/ListProcs"
+	Visor_RegisterProc_FullMethodName     = "/visor.Visor/RegisterProc"
+	Visor_ListInstances_FullMethodName    = "/visor.Visor/ListInstances"
+	Visor_GetInstance_FullMethodName      = "/visor.Visor/GetInstance"
+	Visor_RegisterInstance_FullMethodName = "/visor.Visor/RegisterInstance"
+	Visor_StopInstance_FullMethodName     = "/visor.Visor/StopInstance"
+	Visor_Events_FullMethodName           = "/visor.Visor/Events"
+)
+
+// VisorClient is the client API for Visor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VisorClient interface {
+	ListApps(ctx context.Context, in *ListAppsRequest, opts ...grpc.CallOption) (*ListAppsResponse, error)
+	GetApp(ctx context.Context, in *GetAppRequest, opts ...grpc.CallOption) (*App, error)
+	RegisterApp(ctx context.Context, in *RegisterAppRequest, opts ...grpc.CallOption) (*App, error)
+	UnregisterApp(ctx context.Context, in *UnregisterAppRequest, opts ...grpc.CallOption) (*Empty, error)
+	ListProcs(ctx context.Context, in *ListProcsRequest, opts ...grpc.CallOption) (*ListProcsResponse, error)
+	RegisterProc(ctx context.Context, in *RegisterProcRequest, opts ...grpc.CallOption) (*Proc, error)
+	ListInstances(ctx context.Context, in *ListInstancesRequest, opts ...grpc.CallOption) (*ListInstancesResponse, error)
+	GetInstance(ctx context.Context, in *GetInstanceRequest, opts ...grpc.CallOption) (*Instance, error)
+	RegisterInstance(ctx context.Context, in *RegisterInstanceRequest, opts ...grpc.CallOption) (*Instance, error)
+	StopInstance(ctx context.Context, in *StopInstanceRequest, opts ...grpc.CallOption) (*Empty, error)
+	Events(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EventsRequest, Event], error)
+}
+
+type visorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVisorClient(cc grpc.ClientConnInterface) VisorClient {
+	return &visorClient{cc}
+}
+
+func (c *visorClient) ListApps(ctx context.Context, in *ListAppsRequest, opts ...grpc.CallOption) (*ListAppsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAppsResponse)
+	err := c.cc.Invoke(ctx, Visor_ListApps_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) GetApp(ctx context.Context, in *GetAppRequest, opts ...grpc.CallOption) (*App, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(App)
+	err := c.cc.Invoke(ctx, Visor_GetApp_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) RegisterApp(ctx context.Context, in *RegisterAppRequest, opts ...grpc.CallOption) (*App, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(App)
+	err := c.cc.Invoke(ctx, Visor_RegisterApp_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) UnregisterApp(ctx context.Context, in *UnregisterAppRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Visor_UnregisterApp_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) ListProcs(ctx context.Context, in *ListProcsRequest, opts ...grpc.CallOption) (*ListProcsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListProcsResponse)
+	err := c.cc.Invoke(ctx, Visor_ListProcs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) RegisterProc(ctx context.Context, in *RegisterProcRequest, opts ...grpc.CallOption) (*Proc, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Proc)
+	err := c.cc.Invoke(ctx, Visor_RegisterProc_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) ListInstances(ctx context.Context, in *ListInstancesRequest, opts ...grpc.CallOption) (*ListInstancesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListInstancesResponse)
+	err := c.cc.Invoke(ctx, Visor_ListInstances_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) GetInstance(ctx context.Context, in *GetInstanceRequest, opts ...grpc.CallOption) (*Instance, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Instance)
+	err := c.cc.Invoke(ctx, Visor_GetInstance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) RegisterInstance(ctx context.Context, in *RegisterInstanceRequest, opts ...grpc.CallOption) (*Instance, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Instance)
+	err := c.cc.Invoke(ctx, Visor_RegisterInstance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) StopInstance(ctx context.Context, in *StopInstanceRequest, opts ...grpc.CallOption) (*Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, Visor_StopInstance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *visorClient) Events(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EventsRequest, Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Visor_ServiceDesc.Streams[0], Visor_Events_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[EventsRequest, Event]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Visor_EventsClient = grpc.BidiStreamingClient[EventsRequest, Event]
+
+// VisorServer is the server API for Visor service.
+// All implementations must embed UnimplementedVisorServer
+// for forward compatibility.
+type VisorServer interface {
+	ListApps(context.Context, *ListAppsRequest) (*ListAppsResponse, error)
+	GetApp(context.Context, *GetAppRequest) (*App, error)
+	RegisterApp(context.Context, *RegisterAppRequest) (*App, error)
+	UnregisterApp(context.Context, *UnregisterAppRequest) (*Empty, error)
+	ListProcs(context.Context, *ListProcsRequest) (*ListProcsResponse, error)
+	RegisterProc(context.Context, *RegisterProcRequest) (*Proc, error)
+	ListInstances(context.Context, *ListInstancesRequest) (*ListInstancesResponse, error)
+	GetInstance(context.Context, *GetInstanceRequest) (*Instance, error)
+	RegisterInstance(context.Context, *RegisterInstanceRequest) (*Instance, error)
+	StopInstance(context.Context, *StopInstanceRequest) (*Empty, error)
+	Events(grpc.BidiStreamingServer[EventsRequest, Event]) error
+	mustEmbedUnimplementedVisorServer()
+}
+
+// UnimplementedVisorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVisorServer struct{}
+
+func (UnimplementedVisorServer) ListApps(context.Context, *ListAppsRequest) (*ListAppsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListApps not implemented")
+}
+func (UnimplementedVisorServer) GetApp(context.Context, *GetAppRequest) (*App, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetApp not implemented")
+}
+func (UnimplementedVisorServer) RegisterApp(context.Context, *RegisterAppRequest) (*App, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterApp not implemented")
+}
+func (UnimplementedVisorServer) UnregisterApp(context.Context, *UnregisterAppRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnregisterApp not implemented")
+}
+func (UnimplementedVisorServer) ListProcs(context.Context, *ListProcsRequest) (*ListProcsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListProcs not implemented")
+}
+func (UnimplementedVisorServer) RegisterProc(context.Context, *RegisterProcRequest) (*Proc, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterProc not implemented")
+}
+func (UnimplementedVisorServer) ListInstances(context.Context, *ListInstancesRequest) (*ListInstancesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListInstances not implemented")
+}
+func (UnimplementedVisorServer) GetInstance(context.Context, *GetInstanceRequest) (*Instance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInstance not implemented")
+}
+func (UnimplementedVisorServer) RegisterInstance(context.Context, *RegisterInstanceRequest) (*Instance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterInstance not implemented")
+}
+func (UnimplementedVisorServer) StopInstance(context.Context, *StopInstanceRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopInstance not implemented")
+}
+func (UnimplementedVisorServer) Events(grpc.BidiStreamingServer[EventsRequest, Event]) error {
+	return status.Errorf(codes.Unimplemented, "method Events not implemented")
+}
+func (UnimplementedVisorServer) mustEmbedUnimplementedVisorServer() {}
+func (UnimplementedVisorServer) testEmbeddedByValue()               {}
+
+// UnsafeVisorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VisorServer will
+// result in compilation errors.
+type UnsafeVisorServer interface {
+	mustEmbedUnimplementedVisorServer()
+}
+
+func RegisterVisorServer(s grpc.ServiceRegistrar, srv VisorServer) {
+	// If the following call pancis, it indicates UnimplementedVisorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Visor_ServiceDesc, srv)
+}
+
+func _Visor_ListApps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAppsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).ListApps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_ListApps_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).ListApps(ctx, req.(*ListAppsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_GetApp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAppRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).GetApp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_GetApp_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).GetApp(ctx, req.(*GetAppRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_RegisterApp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAppRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).RegisterApp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_RegisterApp_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).RegisterApp(ctx, req.(*RegisterAppRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_UnregisterApp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnregisterAppRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).UnregisterApp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_UnregisterApp_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).UnregisterApp(ctx, req.(*UnregisterAppRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_ListProcs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListProcsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).ListProcs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_ListProcs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).ListProcs(ctx, req.(*ListProcsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_RegisterProc_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterProcRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).RegisterProc(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_RegisterProc_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).RegisterProc(ctx, req.(*RegisterProcRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_ListInstances_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListInstancesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).ListInstances(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_ListInstances_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).ListInstances(ctx, req.(*ListInstancesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_GetInstance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInstanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).GetInstance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_GetInstance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).GetInstance(ctx, req.(*GetInstanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_RegisterInstance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterInstanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).RegisterInstance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_RegisterInstance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).RegisterInstance(ctx, req.(*RegisterInstanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_StopInstance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopInstanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VisorServer).StopInstance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Visor_StopInstance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VisorServer).StopInstance(ctx, req.(*StopInstanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Visor_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(VisorServer).Events(&grpc.GenericServerStream[EventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Visor_EventsServer = grpc.BidiStreamingServer[EventsRequest, Event]
+
+// Visor_ServiceDesc is the grpc.ServiceDesc for Visor service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Visor_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "visor.Visor",
+	HandlerType: (*VisorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListApps",
+			Handler:    _Visor_ListApps_Handler,
+		},
+		{
+			MethodName: "GetApp",
+			Handler:    _Visor_GetApp_Handler,
+		},
+		{
+			MethodName: "RegisterApp",
+			Handler:    _Visor_RegisterApp_Handler,
+		},
+		{
+			MethodName: "UnregisterApp",
+			Handler:    _Visor_UnregisterApp_Handler,
+		},
+		{
+			MethodName: "ListProcs",
+			Handler:    _Visor_ListProcs_Handler,
+		},
+		{
+			MethodName: "RegisterProc",
+			Handler:    _Visor_RegisterProc_Handler,
+		},
+		{
+			MethodName: "ListInstances",
+			Handler:    _Visor_ListInstances_Handler,
+		},
+		{
+			MethodName: "GetInstance",
+			Handler:    _Visor_GetInstance_Handler,
+		},
+		{
+			MethodName: "RegisterInstance",
+			Handler:    _Visor_RegisterInstance_Handler,
+		},
+		{
+			MethodName: "StopInstance",
+			Handler:    _Visor_StopInstance_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Events",
+			Handler:       _Visor_Events_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "visor.proto",
+}
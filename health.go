@@ -0,0 +1,127 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HealthCheckInterval is the interval in which WatchHealth checks the
+// coordinator.
+var HealthCheckInterval = 5 * time.Second
+
+// HealthEvent describes the connection to the coordinator at one check.
+type HealthEvent struct {
+	// Whether the coordinator could be reached.
+	Connected bool
+	// Error which made the check fail, if not Connected.
+	Err error
+	// Revision up to which changes have been followed locally.
+	Rev int64
+	// Latest revision of the coordinator, as of the last successful check.
+	HeadRev int64
+	// Number of revisions the local view is behind the coordinator.
+	Lag  int64
+	Time time.Time
+}
+
+func (e HealthEvent) String() string {
+	if !e.Connected {
+		return fmt.Sprintf("<HealthEvent disconnected: %s>", e.Err)
+	}
+	return fmt.Sprintf("<HealthEvent rev=%d head=%d lag=%d>", e.Rev, e.HeadRev, e.Lag)
+}
+
+// WatchHealth follows the changes of the coordinator from the revision of
+// the Store and checks every HealthCheckInterval whether the coordinator can
+// be reached and how far the followed revision lags behind its head. The
+// result of every check is sent to the given channel, so daemons can stop
+// acting on a stale view. Failing checks don't end the watch, the next
+// successful one reports the connection as restored.
+// The channel is closed when WatchHealth returns, which is once the Store
+// is closed.
+func (s *Store) WatchHealth(ch chan HealthEvent) error {
+	defer close(ch)
+
+	f := &revFollower{rev: s.GetSnapshot().Rev}
+	go f.follow(s)
+
+	var head int64
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		ev := HealthEvent{Time: time.Now().UTC()}
+		sp, err := s.GetSnapshot().FastForward()
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			ev.Err = err
+		} else {
+			ev.Connected = true
+			head = sp.Rev
+		}
+		ev.Rev = f.get()
+		ev.HeadRev = head
+		if ev.Lag = head - ev.Rev; ev.Lag < 0 {
+			ev.Lag = 0
+		}
+
+		select {
+		case ch <- ev:
+		case <-s.closed():
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-s.closed():
+			return nil
+		}
+	}
+}
+
+// revFollower records the revision of the latest change seen.
+type revFollower struct {
+	mu  sync.Mutex
+	rev int64
+}
+
+func (f *revFollower) get() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rev
+}
+
+func (f *revFollower) set(rev int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rev > f.rev {
+		f.rev = rev
+	}
+}
+
+// follow waits for changes until the Store is closed. After a failing wait
+// it resumes from the revision reached once the coordinator is back.
+func (f *revFollower) follow(s *Store) {
+	sp := s.GetSnapshot()
+	for {
+		ev, err := sp.Wait(globPlural)
+		if err == nil {
+			sp = sp.Join(ev)
+			f.set(ev.Rev)
+			continue
+		}
+		select {
+		case <-time.After(HealthCheckInterval):
+		case <-s.closed():
+			return
+		}
+		sp = s.GetSnapshot()
+		sp.Rev = f.get()
+	}
+}
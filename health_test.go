@@ -0,0 +1,65 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func healthSetup() *Store {
+	s, err := DialURI(DefaultURI, "/health-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestWatchHealth(t *testing.T) {
+	defer func(d time.Duration) { HealthCheckInterval = d }(HealthCheckInterval)
+	HealthCheckInterval = 50 * time.Millisecond
+
+	s := healthSetup()
+	if _, err := s.NewApp("health-cat", "git://health.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan HealthEvent)
+	errc := make(chan error, 1)
+	go func() { errc <- s.WatchHealth(ch) }()
+
+	timeout := time.After(time.Second)
+	for caughtUp := false; !caughtUp; {
+		select {
+		case ev := <-ch:
+			if !ev.Connected {
+				t.Fatalf("expected to be connected, got %s", ev)
+			}
+			caughtUp = ev.Lag == 0 && ev.Rev > s.GetSnapshot().Rev
+		case <-timeout:
+			t.Fatal("expected the watcher to catch up with the registration")
+		}
+	}
+
+	s.Close()
+	for range ch {
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("expected nil after close, got %v", err)
+	}
+}
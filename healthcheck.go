@@ -0,0 +1,81 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import "strings"
+
+// Types of Healthcheck.
+const (
+	HealthcheckHTTP   = "http"
+	HealthcheckTCP    = "tcp"
+	HealthcheckScript = "script"
+)
+
+// Healthcheck defines how runners and pm services decide whether an instance
+// of a proc is healthy: an instance is unhealthy once FailureThreshold checks
+// in a row failed or timed out.
+type Healthcheck struct {
+	Type string `json:"type"`
+	// Path requested by http checks, or script run by script checks.
+	Path string `json:"path,omitempty"`
+	// Named endpoint checked, the port of the instance if empty.
+	Endpoint         string `json:"endpoint,omitempty"`
+	IntervalSec      int    `json:"interval-sec"`
+	TimeoutSec       int    `json:"timeout-sec"`
+	FailureThreshold int    `json:"failure-threshold"`
+	// Bumped by StoreAttrs whenever the check changes.
+	Version int `json:"version,omitempty"`
+}
+
+// Validate checks that the healthcheck can be run.
+func (h *Healthcheck) Validate() error {
+	switch h.Type {
+	case HealthcheckHTTP:
+		if !strings.HasPrefix(h.Path, "/") {
+			return errorf(ErrInvalidArgument, "http healthcheck path must start with /")
+		}
+	case HealthcheckTCP:
+		if h.Path != "" {
+			return errorf(ErrInvalidArgument, "tcp healthcheck takes no path")
+		}
+	case HealthcheckScript:
+		if h.Path == "" {
+			return errorf(ErrInvalidArgument, "script healthcheck needs a path")
+		}
+	default:
+		return errorf(ErrInvalidArgument, "invalid healthcheck type %q", h.Type)
+	}
+	if h.Endpoint != "" && !reEndpointName.MatchString(h.Endpoint) {
+		return errorf(ErrInvalidArgument, "invalid healthcheck endpoint %q", h.Endpoint)
+	}
+	if h.IntervalSec < 1 || h.TimeoutSec < 1 {
+		return errorf(ErrInvalidArgument, "healthcheck interval and timeout must be positive")
+	}
+	if h.TimeoutSec > h.IntervalSec {
+		return errorf(ErrInvalidArgument, "healthcheck timeout must not exceed its interval")
+	}
+	if h.FailureThreshold < 1 {
+		return errorf(ErrInvalidArgument, "healthcheck failure threshold must be positive")
+	}
+	return nil
+}
+
+// versionHealthcheck sets the version of the healthcheck of the attrs to the
+// one of prev, bumped if the check differs.
+func (a *ProcAttrs) versionHealthcheck(prev ProcAttrs) {
+	if a.Healthcheck == nil {
+		return
+	}
+	h := *a.Healthcheck
+	h.Version = 1
+	if prev.Healthcheck != nil {
+		h.Version = prev.Healthcheck.Version
+		if h != *prev.Healthcheck {
+			h.Version++
+		}
+	}
+	a.Healthcheck = &h
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import "testing"
+
+func TestHealthcheckValidate(t *testing.T) {
+	for _, test := range []struct {
+		check Healthcheck
+		valid bool
+	}{
+		{Healthcheck{Type: HealthcheckHTTP, Path: "/health", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, true},
+		{Healthcheck{Type: HealthcheckTCP, Endpoint: "grpc", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, true},
+		{Healthcheck{Type: HealthcheckScript, Path: "bin/check", IntervalSec: 10, TimeoutSec: 10, FailureThreshold: 1}, true},
+		{Healthcheck{Type: "udp", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, false},
+		{Healthcheck{Type: HealthcheckHTTP, Path: "health", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, false},
+		{Healthcheck{Type: HealthcheckTCP, Path: "/health", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, false},
+		{Healthcheck{Type: HealthcheckScript, IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, false},
+		{Healthcheck{Type: HealthcheckTCP, IntervalSec: 2, TimeoutSec: 10, FailureThreshold: 3}, false},
+		{Healthcheck{Type: HealthcheckTCP, IntervalSec: 10, TimeoutSec: 2}, false},
+		{Healthcheck{Type: HealthcheckTCP, Endpoint: "/grpc", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}, false},
+	} {
+		if err := test.check.Validate(); (err == nil) != test.valid {
+			t.Errorf("want valid %t for %#v, have %v", test.valid, test.check, err)
+		}
+	}
+}
+
+func TestProcHealthcheckVersion(t *testing.T) {
+	s, app := procSetup("healthcheck-app")
+	proc := s.NewProc(app, "web")
+	proc, err := proc.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := Healthcheck{Type: HealthcheckHTTP, Path: "/health", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}
+	for _, test := range []struct {
+		timeout int
+		version int
+	}{
+		{2, 1},
+		{2, 1},
+		{5, 2},
+	} {
+		c := check
+		c.TimeoutSec = test.timeout
+		proc.Attrs.Healthcheck = &c
+		if proc, err = proc.StoreAttrs(); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := proc.GetAttrsForEnv("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Healthcheck.Version != test.version {
+			t.Errorf("want version %d, have %d", test.version, attrs.Healthcheck.Version)
+		}
+	}
+
+	proc.Attrs.Healthcheck = &Healthcheck{Type: HealthcheckHTTP, Path: "health", IntervalSec: 10, TimeoutSec: 2, FailureThreshold: 3}
+	if _, err := proc.StoreAttrs(); !IsErrInvalidArgument(err) {
+		t.Errorf("want invalid healthcheck to be rejected, have %v", err)
+	}
+}
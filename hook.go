@@ -2,14 +2,27 @@ package visor
 
 import (
 	"errors"
+	"fmt"
+	"path"
 	"regexp"
+	"sort"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
 )
 
 const (
-	hooksPath = "hooks"
+	hooksPath        = "hooks"
+	hookRunsPath     = "hook-runs"
+	pipelinesPath    = "hook-pipelines"
+	hookVersionsPath = "hook-versions"
+
+	// Number of runs kept per hook, older ones are pruned by RecordRun.
+	hookRunRetention = 50
+	// Number of previous scripts kept per hook.
+	hookVersionRetention = 10
+	// Output of runs is truncated to this many bytes.
+	maxHookRunOutput = 64 * 1024
 )
 
 var (
@@ -19,10 +32,44 @@ var (
 // Hook represents a named executable script.
 type Hook struct {
 	file       *cp.File
-	App        *App      `json:"-"`
-	Name       string    `json:"name"`
-	Script     string    `json:"script"`
-	Registered time.Time `json:"registered"`
+	App        *App        `json:"-"`
+	Name       string      `json:"name"`
+	Script     string      `json:"script"`
+	Triggers   []EventType `json:"triggers,omitempty"`
+	Version    int         `json:"version"`
+	Registered time.Time   `json:"registered"`
+}
+
+// HookPhase names a point in the lifecycle of an app at which a pipeline of
+// hooks is run.
+type HookPhase string
+
+// HookPhases.
+const (
+	HookPhasePreDeploy  = HookPhase("pre-deploy")
+	HookPhasePostDeploy = HookPhase("post-deploy")
+	HookPhasePreStop    = HookPhase("pre-stop")
+)
+
+var hookPhases = []HookPhase{HookPhasePreDeploy, HookPhasePostDeploy, HookPhasePreStop}
+
+// HookRun is the result of one execution of a Hook.
+type HookRun struct {
+	Host     string    `json:"host"`
+	ExitCode int       `json:"exit-code"`
+	Output   string    `json:"output"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// Succeeded reports whether the run exited with status 0.
+func (r *HookRun) Succeeded() bool {
+	return r.ExitCode == 0
+}
+
+// Duration returns how long the run took.
+func (r *HookRun) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
 }
 
 // NewHook returns a new Hook given an App, a name and the script.
@@ -40,9 +87,36 @@ func (h *Hook) GetSnapshot() cp.Snapshot {
 	return h.file.Snapshot
 }
 
-// Register stores the Hook with the App.
+// Register stores the Hook with the App. If a hook with the same name and a
+// different script is registered already, its script is kept as previous
+// version and the version of the Hook is incremented.
 func (h *Hook) Register() (*Hook, error) {
-	var err error
+	if err := guardOf(h).authorize("", AuditRegister, h.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := h.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	current, err := getHook(h.App, h.Name, sp)
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+
+	h.Version = 1
+	if current != nil {
+		h.file = current.file
+		h.Version = current.Version
+		if h.Triggers == nil {
+			h.Triggers = current.Triggers
+		}
+		if current.Script != h.Script {
+			if err := current.archive(); err != nil {
+				return nil, err
+			}
+			h.Version++
+		}
+	}
 
 	h.Registered = time.Now()
 
@@ -51,11 +125,16 @@ func (h *Hook) Register() (*Hook, error) {
 		return nil, err
 	}
 
+	audit(h, "", AuditRegister, h.auditName())
+
 	return h, nil
 }
 
 // Unregister removes the stored Hook from the App.
 func (h *Hook) Unregister() error {
+	if err := guardOf(h).authorize("", AuditUnregister, h.auditName()); err != nil {
+		return err
+	}
 	sp, err := h.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -67,7 +146,227 @@ func (h *Hook) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `hook "%s" not found`, h.Name)
 	}
-	return h.file.Del()
+	if err := h.file.Del(); err != nil {
+		return err
+	}
+	for _, dir := range []string{hookRunsPath, hookVersionsPath} {
+		err = sp.Del(h.App.dir.Prefix(dir, h.Name))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return err
+		}
+	}
+	audit(sp, actorOf(h), AuditUnregister, h.auditName())
+	return nil
+}
+
+// GetVersion returns the Hook as it was registered with version n. Only the
+// latest ten previous versions are kept.
+func (h *Hook) GetVersion(n int) (*Hook, error) {
+	sp, err := h.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	current, err := getHook(h.App, h.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	if current.Version == n {
+		return current, nil
+	}
+	return getHookVersion(h.App, h.Name, n, sp)
+}
+
+// Rollback registers the script of the version preceding the current one
+// again. The restored script gets a new version, so executors which pinned
+// the current version can tell the difference.
+func (h *Hook) Rollback() (*Hook, error) {
+	sp, err := h.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	current, err := getHook(h.App, h.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := getHookVersion(h.App, h.Name, current.Version-1, sp)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := h.App.NewHook(h.Name, prev.Script)
+	restored.Triggers = current.Triggers
+	return restored.Register()
+}
+
+// archive stores the Hook as a previous version and prunes old versions.
+func (h *Hook) archive() error {
+	dir := h.App.dir.Prefix(hookVersionsPath, h.Name)
+	f := cp.NewFile(path.Join(dir, fmt.Sprintf("%010d", h.Version)), h, new(cp.JsonCodec), h.GetSnapshot())
+	f, err := f.Save()
+	if err != nil {
+		return err
+	}
+
+	names, err := getdirOrEmpty(f.GetSnapshot(), dir)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > hookVersionRetention {
+		err := f.GetSnapshot().Del(path.Join(dir, names[0]))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// BindTrigger stores that the Hook is to be run on events of the given type.
+func (h *Hook) BindTrigger(t EventType) (*Hook, error) {
+	if t == "" || t == EvUnknown {
+		return nil, errorf(ErrInvalidArgument, "invalid trigger %q", t)
+	}
+	return h.updateTriggers(func(triggers []EventType) []EventType {
+		for _, tr := range triggers {
+			if tr == t {
+				return triggers
+			}
+		}
+		return append(triggers, t)
+	})
+}
+
+// UnbindTrigger removes the given event type from the triggers of the Hook.
+func (h *Hook) UnbindTrigger(t EventType) (*Hook, error) {
+	return h.updateTriggers(func(triggers []EventType) []EventType {
+		kept := []EventType{}
+		for _, tr := range triggers {
+			if tr != t {
+				kept = append(kept, tr)
+			}
+		}
+		return kept
+	})
+}
+
+// IsTriggeredBy reports whether the Hook is bound to the given event type.
+func (h *Hook) IsTriggeredBy(t EventType) bool {
+	for _, tr := range h.Triggers {
+		if tr == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hook) updateTriggers(fn func([]EventType) []EventType) (*Hook, error) {
+	if err := guardOf(h).authorize("", ActionTrigger, h.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := h.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	cur, err := getHook(h.App, h.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	cur.Triggers = fn(cur.Triggers)
+
+	cur.file, err = cur.file.Set(cur)
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+// RecordRun stores the result of running the Hook on host. Only the latest
+// runs are kept and output exceeding 64KiB is truncated.
+func (h *Hook) RecordRun(host string, exitCode int, output string, started, finished time.Time) (*HookRun, error) {
+	if host == "" {
+		return nil, errorf(ErrInvalidArgument, "host must not be empty")
+	}
+	if finished.Before(started) {
+		return nil, errorf(ErrInvalidArgument, "run of hook %q finished before it started", h.Name)
+	}
+	if err := guardOf(h).authorize(host, ActionHookRun, h.auditName()); err != nil {
+		return nil, err
+	}
+	if len(output) > maxHookRunOutput {
+		output = output[len(output)-maxHookRunOutput:]
+	}
+	sp, err := h.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	exists, _, err := sp.Exists(h.file.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errorf(ErrNotFound, `hook "%s" not found`, h.Name)
+	}
+
+	run := &HookRun{
+		Host:     host,
+		ExitCode: exitCode,
+		Output:   output,
+		Started:  started.UTC(),
+		Finished: finished.UTC(),
+	}
+	dir := h.App.dir.Prefix(hookRunsPath, h.Name)
+	f := cp.NewFile(path.Join(dir, fmt.Sprintf("%019d", run.Started.UnixNano())), run, new(cp.JsonCodec), sp)
+	if f, err = f.Save(); err != nil {
+		return nil, err
+	}
+
+	names, err := getdirOrEmpty(f.GetSnapshot(), dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for len(names) > hookRunRetention {
+		err := f.GetSnapshot().Del(path.Join(dir, names[0]))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		names = names[1:]
+	}
+	return run, nil
+}
+
+// GetRuns returns the recorded runs of the Hook ordered from oldest to
+// newest.
+func (h *Hook) GetRuns() ([]*HookRun, error) {
+	sp, err := h.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	dir := h.App.dir.Prefix(hookRunsPath, h.Name)
+	names, err := getdirOrEmpty(sp, dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	runs := []*HookRun{}
+	for _, n := range names {
+		r := &HookRun{}
+		if _, err := sp.GetFile(path.Join(dir, n), &cp.JsonCodec{DecodedVal: r}); err != nil {
+			if cp.IsErrNoEnt(err) {
+				// Pruned concurrently.
+				continue
+			}
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+func (h *Hook) auditName() string {
+	return "hook:" + h.App.Name + "/" + h.Name
 }
 
 // GetHook retrieves the Hook for the passed name.
@@ -92,7 +391,7 @@ func (a *App) GetHooks() ([]*Hook, error) {
 	}
 
 	hooks := []*Hook{}
-	ch, errch := cp.GetSnapshotables(names, func(name string) (cp.Snapshotable, error) {
+	ch, errch := getSnapshotables(a.reads, names, func(name string) (cp.Snapshotable, error) {
 		return getHook(a, name, sp)
 	})
 	for i := 0; i < len(names); i++ {
@@ -106,6 +405,117 @@ func (a *App) GetHooks() ([]*Hook, error) {
 	return hooks, nil
 }
 
+// GetHooksForEvent returns the Hooks bound to the given event type.
+func (a *App) GetHooksForEvent(t EventType) ([]*Hook, error) {
+	hooks, err := a.GetHooks()
+	if err != nil {
+		return nil, err
+	}
+	bound := []*Hook{}
+	for _, h := range hooks {
+		if h.IsTriggeredBy(t) {
+			bound = append(bound, h)
+		}
+	}
+	return bound, nil
+}
+
+// SetHookPipeline stores the names of the hooks to run in the given phase in
+// the order they are to be run. All hooks have to be registered and may only
+// appear once. An empty list removes the pipeline.
+func (a *App) SetHookPipeline(phase HookPhase, names []string) error {
+	if !isHookPhase(phase) {
+		return errorf(ErrInvalidArgument, "invalid hook phase %q", phase)
+	}
+	if err := guardOf(a).authorize("", ActionPipeline, "app:"+a.Name); err != nil {
+		return err
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	p := a.dir.Prefix(pipelinesPath, string(phase))
+
+	if len(names) == 0 {
+		err := sp.Del(p)
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return err
+		}
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			return errorf(ErrInvalidArgument, "hook %q appears twice in %s pipeline", name, phase)
+		}
+		seen[name] = true
+
+		exists, _, err := sp.Exists(a.dir.Prefix(hooksPath, name))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errorf(ErrNotFound, `hook "%s" not found`, name)
+		}
+	}
+	_, err = cp.NewFile(p, names, new(cp.ListCodec), sp).Save()
+	return err
+}
+
+// GetHookPipeline returns the hooks of the pipeline for the given phase in
+// the order they are to be run. If a hook of the pipeline was unregistered
+// since it was set an error of kind ErrNotFound is returned.
+func (a *App) GetHookPipeline(phase HookPhase) ([]*Hook, error) {
+	if !isHookPhase(phase) {
+		return nil, errorf(ErrInvalidArgument, "invalid hook phase %q", phase)
+	}
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	f, err := sp.GetFile(a.dir.Prefix(pipelinesPath, string(phase)), new(cp.ListCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*Hook{}, nil
+		}
+		return nil, err
+	}
+
+	hooks := []*Hook{}
+	for _, name := range f.Value.([]string) {
+		h, err := getHook(a, name, sp)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+func isHookPhase(phase HookPhase) bool {
+	for _, p := range hookPhases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func getHookVersion(app *App, name string, n int, sp cp.Snapshot) (*Hook, error) {
+	h := &Hook{}
+	p := app.dir.Prefix(hookVersionsPath, name, fmt.Sprintf("%010d", n))
+	if _, err := sp.GetFile(p, &cp.JsonCodec{DecodedVal: h}); err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, `version %d of hook "%s" not found`, n, name)
+		}
+		return nil, err
+	}
+	h.file = cp.NewFile(app.dir.Prefix(hooksPath, name), h, new(cp.JsonCodec), sp)
+	h.App = app
+	return h, nil
+}
+
 func getHook(app *App, name string, s cp.Snapshotable) (*Hook, error) {
 	c := new(cp.JsonCodec)
 	c.DecodedVal = &Hook{}
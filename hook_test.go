@@ -1,7 +1,9 @@
 package visor
 
 import (
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestHookRegister(t *testing.T) {
@@ -126,3 +128,219 @@ func hookSetup(t *testing.T) *App {
 
 	return hookStore.NewApp("hook-test", "git://hook.git", "hooks")
 }
+
+func TestHookBindTrigger(t *testing.T) {
+	app := hookSetup(t)
+	script := `#!/bin/sh\necho "trigger"`
+
+	fail, err := app.NewHook("fail", script).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.NewHook("other", script).Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	fail, err = fail.BindTrigger(EvInsFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail, err = fail.BindTrigger(EvInsFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fail.Triggers) != 1 {
+		t.Errorf("expected one trigger, got %v", fail.Triggers)
+	}
+	if _, err := fail.BindTrigger(EvUnknown); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+
+	hooks, err := app.GetHooksForEvent(EvInsFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].Name != "fail" {
+		t.Errorf("expected hook fail for %s, got %v", EvInsFail, hooks)
+	}
+
+	if _, err := fail.UnbindTrigger(EvInsFail); err != nil {
+		t.Fatal(err)
+	}
+	hooks, err = app.GetHooksForEvent(EvInsFail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 0 {
+		t.Errorf("expected no hooks for %s, got %v", EvInsFail, hooks)
+	}
+}
+
+func TestHookRecordRun(t *testing.T) {
+	app := hookSetup(t)
+
+	hook, err := app.NewHook("deploy", `#!/bin/sh\nexit 1`).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < hookRunRetention+2; i++ {
+		started := start.Add(time.Duration(i) * time.Second)
+		_, err := hook.RecordRun("10.0.0.1", i%2, "output", started, started.Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := hook.RecordRun("10.0.0.1", 0, "", start, start.Add(-time.Second)); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+
+	runs, err := hook.GetRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != hookRunRetention {
+		t.Fatalf("expected %d runs, got %d", hookRunRetention, len(runs))
+	}
+	if !runs[0].Started.Equal(start.Add(2 * time.Second).UTC()) {
+		t.Errorf("expected oldest runs to be pruned, first run started %s", runs[0].Started)
+	}
+	last := runs[len(runs)-1]
+	if last.Host != "10.0.0.1" || last.Output != "output" || last.Duration() != time.Second {
+		t.Errorf("recorded run differs: %#v", last)
+	}
+	if last.Succeeded() {
+		t.Errorf("expected last run to have failed")
+	}
+
+	if err := hook.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = hook.GetRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("expected runs to be removed with the hook, got %d", len(runs))
+	}
+}
+
+func TestHookPipeline(t *testing.T) {
+	app := hookSetup(t)
+	script := `#!/bin/sh\necho "pipeline"`
+
+	for _, name := range []string{"migrate", "warmup", "notify"} {
+		if _, err := app.NewHook(name, script).Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := app.SetHookPipeline(HookPhasePreDeploy, []string{"warmup", "migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.SetHookPipeline(HookPhasePreDeploy, []string{"migrate", "missing"})
+	if !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	err = app.SetHookPipeline(HookPhasePreDeploy, []string{"migrate", "migrate"})
+	if !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error for duplicate, got %v", err)
+	}
+	err = app.SetHookPipeline(HookPhase("post-scale"), []string{"migrate"})
+	if !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error for phase, got %v", err)
+	}
+
+	hooks, err := app.GetHookPipeline(HookPhasePreDeploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 || hooks[0].Name != "warmup" || hooks[1].Name != "migrate" {
+		t.Errorf("pipeline differs: %v", hooks)
+	}
+
+	hooks, err = app.GetHookPipeline(HookPhasePreStop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 0 {
+		t.Errorf("expected empty pipeline, got %v", hooks)
+	}
+
+	if err := app.SetHookPipeline(HookPhasePreDeploy, nil); err != nil {
+		t.Fatal(err)
+	}
+	hooks, err = app.GetHookPipeline(HookPhasePreDeploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 0 {
+		t.Errorf("expected pipeline to be removed, got %v", hooks)
+	}
+}
+
+func TestHookVersions(t *testing.T) {
+	app := hookSetup(t)
+
+	hook, err := app.NewHook("scale", "v1").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Version != 1 {
+		t.Errorf("expected version 1, got %d", hook.Version)
+	}
+	if _, err := hook.BindTrigger(EvInsStart); err != nil {
+		t.Fatal(err)
+	}
+
+	hook, err = app.NewHook("scale", "v2").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Version != 2 {
+		t.Errorf("expected version 2, got %d", hook.Version)
+	}
+	if !hook.IsTriggeredBy(EvInsStart) {
+		t.Error("expected triggers to be kept across versions")
+	}
+	hook, err = app.NewHook("scale", "v2").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Version != 2 {
+		t.Errorf("expected unchanged script to keep version 2, got %d", hook.Version)
+	}
+
+	v1, err := hook.GetVersion(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Script != "v1" {
+		t.Errorf("expected script of version 1, got %q", v1.Script)
+	}
+	if _, err := hook.GetVersion(5); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	hook, err = hook.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook.Version != 3 || hook.Script != "v1" {
+		t.Errorf("expected version 3 with script v1, got %d %q", hook.Version, hook.Script)
+	}
+
+	for i := 0; i < hookVersionRetention+1; i++ {
+		if hook, err = app.NewHook("scale", strconv.Itoa(i)).Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := hook.GetVersion(1); !IsErrNotFound(err) {
+		t.Errorf("expected version 1 to be pruned, got %v", err)
+	}
+	if _, err := hook.GetVersion(hook.Version - hookVersionRetention); err != nil {
+		t.Errorf("expected version %d to be kept: %s", hook.Version-hookVersionRetention, err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	hostsPath       = "hosts"
+	hostPortMin     = 10000
+	hostPortMax     = maxPort
+	hostPortPoolPfx = "host:"
+)
+
+// Hostnames, IPv4 and IPv6 addresses.
+var reHostName = regexp.MustCompile(`^[:[:alnum:]][-.:[:alnum:]]*$`)
+
+// GetInstancesByHost returns all instances claimed by the given host. The
+// lookup is backed by an index which is maintained by Claim, Started, Unclaim
+// and Unregister.
+func (s *Store) GetInstancesByHost(host string) ([]*Instance, error) {
+	if err := validateHost(host); err != nil {
+		return nil, err
+	}
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := sp.Getdir(path.Join(hostsPath, host, instancesPath))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*Instance{}, nil
+		}
+		return nil, err
+	}
+
+	ch, errch := getSnapshotables(s.reads, ids, func(idstr string) (cp.Snapshotable, error) {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, err
+		}
+		return getInstance(id, s.join(sp))
+	})
+	instances := []*Instance{}
+	for i := 0; i < len(ids); i++ {
+		select {
+		case r := <-ch:
+			instances = append(instances, r.(*Instance))
+		case err := <-errch:
+			// The index can briefly outlive an unregistered instance.
+			if !IsErrNotFound(err) {
+				return nil, err
+			}
+		}
+	}
+	return instances, nil
+}
+
+// ClaimHostPort allocates a port which is unique on the given host only, for
+// runners which don't need cluster wide unique ports. Ports are handed out
+// from 10000 on, released ones are reused first.
+func (s *Store) ClaimHostPort(host string) (int, error) {
+	if err := s.writable(); err != nil {
+		return -1, err
+	}
+	if err := validateHost(host); err != nil {
+		return -1, err
+	}
+	if err := s.guard.authorize("", ActionPort, "host:"+host); err != nil {
+		return -1, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return -1, err
+	}
+	return hostPortPool(host, sp).claim()
+}
+
+// ReleaseHostPort makes a port claimed with ClaimHostPort available again.
+func (s *Store) ReleaseHostPort(host string, port int) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := validateHost(host); err != nil {
+		return err
+	}
+	if port < hostPortMin || port > hostPortMax {
+		return errorf(ErrInvalidPort, "port %d is not a host port", port)
+	}
+	if err := s.guard.authorize("", ActionPort, "host:"+host); err != nil {
+		return err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	return hostPortPool(host, sp).release(port)
+}
+
+// validateHost returns ErrInvalidArgument unless host is a hostname or an
+// IP address, which can be used as a path element.
+func validateHost(host string) error {
+	if !reHostName.MatchString(host) {
+		return errorf(ErrInvalidArgument, "invalid host %q", host)
+	}
+	return nil
+}
+
+func hostPortPool(host string, sp cp.Snapshot) *PortPool {
+	return &PortPool{
+		Name: hostPortPoolPfx + host,
+		Min:  hostPortMin,
+		Max:  hostPortMax,
+		dir:  cp.NewDir(path.Join(hostsPath, host, portsPath), sp),
+	}
+}
+
+func hostInstancePath(host string, id int64) string {
+	return path.Join(hostsPath, host, instancesPath, strconv.FormatInt(id, 10))
+}
+
+// indexHost adds the instance to the index of the given host.
+func (i *Instance) indexHost(host string) error {
+	sp, err := i.GetSnapshot().Set(hostInstancePath(host, i.ID), timestamp())
+	if err != nil {
+		return err
+	}
+	i.dir = i.dir.Join(sp)
+	return nil
+}
+
+// unindexHost removes the instance from the index of the given host.
+func (i *Instance) unindexHost(host string) error {
+	if host == "" {
+		return nil
+	}
+	err := i.GetSnapshot().Del(hostInstancePath(host, i.ID))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	return nil
+}
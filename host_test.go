@@ -0,0 +1,301 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"path"
+	"testing"
+)
+
+func hostSetup() *Store {
+	s, err := DialURI(DefaultURI, "/host-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestGetInstancesByHost(t *testing.T) {
+	var (
+		s     = hostSetup()
+		hostA = "10.0.0.1"
+		hostB = "10.0.0.2"
+	)
+
+	ins1, err := s.RegisterInstance("ant", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins2, err := s.RegisterInstance("ant", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins1, err = ins1.Claim(hostA); err != nil {
+		t.Fatal(err)
+	}
+	if ins1, err = ins1.Started(hostA, "box1.friday.net", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"", "..", "a/../../apps/x"} {
+		if _, err := ins2.Claim(host); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error claiming for %q, got %v", host, err)
+		}
+		if _, err := ins1.Started(host, "box1.friday.net", 9999, 10000); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error starting on %q, got %v", host, err)
+		}
+		if _, err := s.GetInstancesByHost(host); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error listing %q, got %v", host, err)
+		}
+	}
+	if ins2, err = ins2.Claim(hostB); err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := s.GetInstancesByHost(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != ins1.ID {
+		t.Errorf("expected instance %d on %s, got %v", ins1.ID, hostA, instances)
+	}
+
+	if _, err = ins2.Unclaim(hostB); err != nil {
+		t.Fatal(err)
+	}
+	instances, err = s.GetInstancesByHost(hostB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 0 {
+		t.Errorf("expected no instances on %s after unclaim, got %v", hostB, instances)
+	}
+
+	if err = ins1.Unregister(hostA, errors.New("scaled down")); err != nil {
+		t.Fatal(err)
+	}
+	instances, err = s.GetInstancesByHost(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 0 {
+		t.Errorf("expected no instances on %s after unregister, got %v", hostA, instances)
+	}
+}
+
+func TestGetInstancesByStatus(t *testing.T) {
+	var (
+		s    = hostSetup()
+		host = "10.0.0.3"
+	)
+
+	pending, err := s.RegisterInstance("bee", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost, err := s.RegisterInstance("bee", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lost, err = lost.Claim(host); err != nil {
+		t.Fatal(err)
+	}
+	if lost, err = lost.Started(host, "box3.friday.net", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	running, err := s.GetInstancesByStatus(InsStatusRunning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].ID != lost.ID {
+		t.Errorf("expected running instance %d, got %v", lost.ID, running)
+	}
+
+	if _, err = lost.Lost("watchdog", errors.New("gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	for status, want := range map[InsStatus]int64{
+		InsStatusPending: pending.ID,
+		InsStatusLost:    lost.ID,
+	} {
+		instances, err := s.GetInstancesByStatus(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(instances) != 1 || instances[0].ID != want {
+			t.Errorf("expected %s instance %d, got %v", status, want, instances)
+		}
+	}
+
+	running, err = s.GetInstancesByStatus(InsStatusRunning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 0 {
+		t.Errorf("expected no running instances, got %v", running)
+	}
+
+	if _, err := s.GetInstancesByStatus(InsStatusDone); !IsErrInvalidArgument(err) {
+		t.Errorf("expected ErrInvalidArgument for done, got %v", err)
+	}
+}
+
+func TestClaimHostPort(t *testing.T) {
+	var (
+		s     = hostSetup()
+		hostA = "10.0.0.1"
+		hostB = "10.0.0.2"
+	)
+
+	a1, err := s.ClaimHostPort(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := s.ClaimHostPort(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1 == a2 {
+		t.Errorf("expected distinct ports on %s, got %d twice", hostA, a1)
+	}
+	b1, err := s.ClaimHostPort(hostB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b1 != a1 {
+		t.Errorf("expected hosts to allocate independently, got %d and %d", a1, b1)
+	}
+
+	if err := s.ReleaseHostPort(hostA, a1); err != nil {
+		t.Fatal(err)
+	}
+	a3, err := s.ClaimHostPort(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a3 != a1 {
+		t.Errorf("expected released port %d to be reused, got %d", a1, a3)
+	}
+
+	if err := s.ReleaseHostPort(hostA, 80); !IsErrInvalidPort(err) {
+		t.Errorf("expected invalid port error, got %v", err)
+	}
+	for _, host := range []string{"", "..", "a/../../apps/x"} {
+		if _, err := s.ClaimHostPort(host); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid host %q to be rejected, got %v", host, err)
+		}
+		if err := s.ReleaseHostPort(host, a1); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid host %q to be rejected, got %v", host, err)
+		}
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	for host, valid := range map[string]bool{
+		"10.0.0.1":         true,
+		"2001:db8::1":      true,
+		"::1":              true,
+		"box1.example.com": true,
+		"":                 false,
+		".":                false,
+		"..":               false,
+		"a/../../apps/x":   false,
+	} {
+		if err := validateHost(host); (err == nil) != valid {
+			t.Errorf("expected valid %t for %q, got %v", valid, host, err)
+		}
+	}
+}
+
+func TestGetInstancesByStatusStale(t *testing.T) {
+	var (
+		s    = hostSetup()
+		host = "10.0.0.4"
+	)
+
+	ins, err := s.RegisterInstance("bee", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim(host); err != nil {
+		t.Fatal(err)
+	}
+	// ins still believes to be pending.
+	if err := ins.Unregister("cli", errors.New("gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []InsStatus{InsStatusPending, InsStatusClaimed} {
+		sp, err := s.GetSnapshot().FastForward()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, err := getdirOrEmpty(sp, path.Join(statusIdxPath, string(status)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 0 {
+			t.Errorf("expected no %s index entries, got %v", status, ids)
+		}
+	}
+}
+
+func TestInstanceStatusIndexBackfill(t *testing.T) {
+	var (
+		s    = hostSetup()
+		host = "10.0.0.5"
+	)
+
+	if _, err := s.RegisterInstance("bee", "128af9", "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := s.RegisterInstance("bee", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err = claimed.Claim(host); err != nil {
+		t.Fatal(err)
+	}
+	if err := claimed.GetSnapshot().Del(statusIdxPath); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(when string) {
+		instances, err := s.GetInstancesByStatus(InsStatusClaimed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(instances) != 1 || instances[0].ID != claimed.ID {
+			t.Errorf("%s: expected claimed instance %d, got %v", when, claimed.ID, instances)
+		}
+	}
+	check("without index")
+
+	if s, err = s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	exists, _, err := s.GetSnapshot().Exists(statusIndexPath(InsStatusClaimed, claimed.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Errorf("expected Init to index claimed instance %d", claimed.ID)
+	}
+	check("after backfill")
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+// Package httpapi exposes a visor Store over HTTP, so tooling which isn't
+// written in Go can use visor without talking to the coordinator.
+//
+//	GET    /apps                                  list apps
+//	POST   /apps                                  register an app
+//	GET    /apps/<app>                            get an app
+//	DELETE /apps/<app>                            unregister an app
+//	GET    /apps/<app>/procs                      list procs
+//	POST   /apps/<app>/procs                      register a proc
+//	GET    /apps/<app>/revisions                  list revisions
+//	POST   /apps/<app>/revisions                  register a revision
+//	GET    /apps/<app>/procs/<proc>/instances     list instances of a proc
+//	POST   /instances                             register an instance
+//	GET    /instances/<id>                        get an instance
+//	GET    /events?type=<type>                    stream events
+//
+// Bodies are JSON. Events are streamed as server-sent events carrying the
+// JSON encoding of visor.Event. Requests are authenticated by the
+// Authenticator of the Server, the actor it returns is recorded on every
+// change, see visor.Store.As.
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soundcloud/visor"
+)
+
+// Authenticator returns the actor a request is performed for. A failing
+// Authenticator rejects the request as unauthenticated.
+type Authenticator func(r *http.Request) (actor string, err error)
+
+// Server is an http.Handler serving the REST API of a Store.
+type Server struct {
+	store *visor.Store
+	// Authenticate is called for every request. All requests are accepted,
+	// without an actor, if nil.
+	Authenticate Authenticator
+}
+
+// NewServer returns a Server for the given Store.
+func NewServer(s *visor.Store, auth Authenticator) *Server {
+	return &Server{store: s, Authenticate: auth}
+}
+
+// ServeHTTP satisfies the http.Handler interface.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	actor := ""
+	if srv.Authenticate != nil {
+		var err error
+		if actor, err = srv.Authenticate(r); err != nil {
+			writeJSON(w, http.StatusUnauthorized, Error{err.Error()})
+			return
+		}
+	}
+	s, err := srv.store.FastForward()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if actor != "" {
+		s = s.As(actor)
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case match(parts, "apps"):
+		switch r.Method {
+		case "GET":
+			srv.listApps(w, s)
+		case "POST":
+			srv.registerApp(w, r, s)
+		default:
+			methodNotAllowed(w)
+		}
+	case match(parts, "apps", "*"):
+		switch r.Method {
+		case "GET":
+			srv.getApp(w, s, parts[1])
+		case "DELETE":
+			srv.unregisterApp(w, s, parts[1])
+		default:
+			methodNotAllowed(w)
+		}
+	case match(parts, "apps", "*", "procs"):
+		switch r.Method {
+		case "GET":
+			srv.listProcs(w, s, parts[1])
+		case "POST":
+			srv.registerProc(w, r, s, parts[1])
+		default:
+			methodNotAllowed(w)
+		}
+	case match(parts, "apps", "*", "revisions"):
+		switch r.Method {
+		case "GET":
+			srv.listRevisions(w, s, parts[1])
+		case "POST":
+			srv.registerRevision(w, r, s, parts[1])
+		default:
+			methodNotAllowed(w)
+		}
+	case match(parts, "apps", "*", "procs", "*", "instances"):
+		if r.Method != "GET" {
+			methodNotAllowed(w)
+			return
+		}
+		srv.listInstances(w, s, parts[1], parts[3])
+	case match(parts, "instances"):
+		if r.Method != "POST" {
+			methodNotAllowed(w)
+			return
+		}
+		srv.registerInstance(w, r, s)
+	case match(parts, "instances", "*"):
+		if r.Method != "GET" {
+			methodNotAllowed(w)
+			return
+		}
+		srv.getInstance(w, s, parts[1])
+	case match(parts, "events"):
+		if r.Method != "GET" {
+			methodNotAllowed(w)
+			return
+		}
+		srv.streamEvents(w, r, s)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// match reports whether the path parts equal the pattern, where * matches
+// any single part.
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if parts[i] == "" || (p != "*" && p != parts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// App is the JSON form of a visor.App.
+type App struct {
+	Name         string            `json:"name"`
+	RepoURL      string            `json:"repo-url"`
+	Stack        string            `json:"stack"`
+	DeployType   string            `json:"deploy-type,omitempty"`
+	Env          map[string]string `json:"env,omitempty"`
+	Registered   time.Time         `json:"registered"`
+	RegisteredBy string            `json:"registered-by,omitempty"`
+}
+
+// Proc is the JSON form of a visor.Proc.
+type Proc struct {
+	App          string          `json:"app"`
+	Name         string          `json:"name"`
+	Port         int             `json:"port"`
+	ControlPort  int             `json:"control-port"`
+	Attrs        visor.ProcAttrs `json:"attrs"`
+	Registered   time.Time       `json:"registered"`
+	RegisteredBy string          `json:"registered-by,omitempty"`
+}
+
+// Revision is the JSON form of a visor.Revision.
+type Revision struct {
+	App          string    `json:"app"`
+	Ref          string    `json:"ref"`
+	ArchiveURL   string    `json:"archive-url"`
+	Registered   time.Time `json:"registered"`
+	RegisteredBy string    `json:"registered-by,omitempty"`
+}
+
+// InstanceRequest is the body of an instance registration.
+type InstanceRequest struct {
+	App  string `json:"app"`
+	Rev  string `json:"rev"`
+	Proc string `json:"proc"`
+	Env  string `json:"env"`
+}
+
+func newApp(a *visor.App) App {
+	return App{
+		Name:         a.Name,
+		RepoURL:      a.RepoURL,
+		Stack:        a.Stack,
+		DeployType:   a.DeployType,
+		Env:          a.Env,
+		Registered:   a.Registered,
+		RegisteredBy: a.RegisteredBy,
+	}
+}
+
+func newProc(p *visor.Proc) Proc {
+	return Proc{
+		App:          p.App.Name,
+		Name:         p.Name,
+		Port:         p.Port,
+		ControlPort:  p.ControlPort,
+		Attrs:        p.Attrs,
+		Registered:   p.Registered,
+		RegisteredBy: p.RegisteredBy,
+	}
+}
+
+func newRevision(r *visor.Revision) Revision {
+	return Revision{
+		App:          r.App.Name,
+		Ref:          r.Ref,
+		ArchiveURL:   r.ArchiveURL,
+		Registered:   r.Registered,
+		RegisteredBy: r.RegisteredBy,
+	}
+}
+
+func (srv *Server) listApps(w http.ResponseWriter, s *visor.Store) {
+	apps, err := s.GetApps()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := []App{}
+	for _, a := range apps {
+		res = append(res, newApp(a))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (srv *Server) registerApp(w http.ResponseWriter, r *http.Request, s *visor.Store) {
+	req := App{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	app := s.NewApp(req.Name, req.RepoURL, req.Stack)
+	app.DeployType = req.DeployType
+	if req.Env != nil {
+		app.Env = req.Env
+	}
+	app, err := app.Register()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newApp(app))
+}
+
+func (srv *Server) getApp(w http.ResponseWriter, s *visor.Store, name string) {
+	app, err := s.GetApp(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newApp(app))
+}
+
+func (srv *Server) unregisterApp(w http.ResponseWriter, s *visor.Store, name string) {
+	app, err := s.GetApp(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := app.Unregister(); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) listProcs(w http.ResponseWriter, s *visor.Store, appName string) {
+	app, err := s.GetApp(appName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	procs, err := app.GetProcs()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := []Proc{}
+	for _, p := range procs {
+		res = append(res, newProc(p))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (srv *Server) registerProc(w http.ResponseWriter, r *http.Request, s *visor.Store, appName string) {
+	req := Proc{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	app, err := s.GetApp(appName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	proc := s.NewProc(app, req.Name)
+	proc.Attrs = req.Attrs
+	if proc, err = proc.Register(); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newProc(proc))
+}
+
+func (srv *Server) listRevisions(w http.ResponseWriter, s *visor.Store, appName string) {
+	app, err := s.GetApp(appName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	revs, err := app.GetRevisions()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := []Revision{}
+	for _, rev := range revs {
+		res = append(res, newRevision(rev))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (srv *Server) registerRevision(w http.ResponseWriter, r *http.Request, s *visor.Store, appName string) {
+	req := Revision{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	app, err := s.GetApp(appName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rev, err := s.NewRevision(app, req.Ref, req.ArchiveURL).Register()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newRevision(rev))
+}
+
+func (srv *Server) listInstances(w http.ResponseWriter, s *visor.Store, appName, procName string) {
+	app, err := s.GetApp(appName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	proc, err := app.GetProc(procName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	instances, err := proc.GetInstances()
+	if err != nil && !visor.IsErrNotFound(err) {
+		writeError(w, err)
+		return
+	}
+	if instances == nil {
+		instances = []*visor.Instance{}
+	}
+	writeJSON(w, http.StatusOK, instances)
+}
+
+func (srv *Server) registerInstance(w http.ResponseWriter, r *http.Request, s *visor.Store) {
+	req := InstanceRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	ins, err := s.RegisterInstance(req.App, req.Rev, req.Proc, req.Env)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ins)
+}
+
+func (srv *Server) getInstance(w http.ResponseWriter, s *visor.Store, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, visor.NewError(visor.ErrInvalidArgument, fmt.Sprintf("invalid instance id %q", idStr)))
+		return
+	}
+	ins, err := s.GetInstance(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ins)
+}
+
+// streamEvents sends events as server-sent events until the client goes
+// away. The type query parameter, which can be repeated, filters them.
+func (srv *Server) streamEvents(w http.ResponseWriter, r *http.Request, s *visor.Store) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	filter := []visor.EventType{}
+	for _, t := range r.URL.Query()["type"] {
+		filter = append(filter, visor.EventType(t))
+	}
+
+	// The Watcher stops with the request.
+	watcher, err := s.Watch(r.Context(), visor.WatchOptions{Filter: filter})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer watcher.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for ev := range watcher.Events() {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Rev, ev.Type, b)
+		flusher.Flush()
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, visor.NewError(visor.ErrInvalidArgument, "invalid body: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Error is the body of failed requests.
+type Error struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), Error{err.Error()})
+}
+
+func errorStatus(err error) int {
+	switch {
+	case visor.IsErrNotFound(err):
+		return http.StatusNotFound
+	case visor.IsErrConflict(err), visor.IsErrInvalidState(err), visor.IsErrDeployFrozen(err), visor.IsErrMaintenance(err):
+		return http.StatusConflict
+	case visor.IsErrInvalidArgument(err), visor.IsErrInvalidKey(err), visor.IsErrBadProcName(err), visor.IsErrBadName(err):
+		return http.StatusBadRequest
+	case visor.IsErrUnauthorized(err), visor.IsErrReadOnly(err):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, Error{"method not allowed"})
+}
+
+// TokenAuthenticator authenticates requests by the bearer token in their
+// Authorization header, mapping tokens to actors.
+func TokenAuthenticator(tokens map[string]string) Authenticator {
+	return func(r *http.Request) (string, error) {
+		v := r.Header.Get("Authorization")
+		if token := strings.TrimPrefix(v, "Bearer "); token != v {
+			if actor, ok := tokens[token]; ok {
+				return actor, nil
+			}
+		}
+		return "", visor.NewError(visor.ErrUnauthorized, "invalid or missing token")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soundcloud/visor"
+)
+
+var testTokens = map[string]string{"s3cr3t": "httpapi-test"}
+
+func serverSetup() (*visor.Store, *httptest.Server) {
+	// A fresh root per run, as the tree can't be reset from here.
+	s, err := visor.DialURI(visor.DefaultURI, fmt.Sprintf("/httpapi-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s, httptest.NewServer(NewServer(s, TokenAuthenticator(testTokens)))
+}
+
+func do(t *testing.T, method, url string, body interface{}, v interface{}) int {
+	buf := &bytes.Buffer{}
+	if body != nil {
+		json.NewEncoder(buf).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+func TestServerApps(t *testing.T) {
+	s, srv := serverSetup()
+	defer srv.Close()
+	defer s.Close()
+
+	app := App{}
+	if code := do(t, "POST", srv.URL+"/apps", App{Name: "rest-cat", RepoURL: "git://rest.git", Stack: "master"}, &app); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if app.RegisteredBy != "httpapi-test" {
+		t.Errorf("expected app registered by the token actor, got %q", app.RegisteredBy)
+	}
+	if code := do(t, "POST", srv.URL+"/apps", App{Name: "rest-cat", RepoURL: "git://rest.git", Stack: "master"}, nil); code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", code)
+	}
+
+	apps := []App{}
+	if code := do(t, "GET", srv.URL+"/apps", nil, &apps); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(apps) != 1 || apps[0].Name != "rest-cat" {
+		t.Errorf("expected rest-cat, got %v", apps)
+	}
+
+	proc := Proc{}
+	if code := do(t, "POST", srv.URL+"/apps/rest-cat/procs", Proc{Name: "web"}, &proc); code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", code)
+	}
+	if code := do(t, "GET", srv.URL+"/apps/rest-dog", nil, nil); code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", code)
+	}
+	if code := do(t, "GET", srv.URL+"/instances/nan", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", code)
+	}
+	if code := do(t, "PUT", srv.URL+"/apps", nil, nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", code)
+	}
+	if code := do(t, "DELETE", srv.URL+"/apps/rest-cat", nil, nil); code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", code)
+	}
+}
+
+func TestServerUnauthenticated(t *testing.T) {
+	s, srv := serverSetup()
+	defer srv.Close()
+	defer s.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/apps", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Actors can't be claimed without a token.
+	req.Header.Set("X-Visor-Actor", "httpapi-test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerEventsStop(t *testing.T) {
+	s, srv := serverSetup()
+	defer srv.Close()
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("GET", srv.URL+"/events?type=app-register", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	if _, err := s.NewApp("sse-cat", "git://rest.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "event: ") {
+			if ev := strings.TrimSpace(line[len("event: "):]); ev != string(visor.EvAppReg) {
+				t.Errorf("expected %s event, got %s", visor.EvAppReg, ev)
+			}
+			break
+		}
+	}
+
+	// Closing the server waits for the handler, which only returns once
+	// its Watcher stopped.
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("expected event stream to end with the request")
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	auth := TokenAuthenticator(testTokens)
+	for i, tt := range []struct {
+		header string
+		actor  string
+		ok     bool
+	}{
+		{"Bearer s3cr3t", "httpapi-test", true},
+		{"Bearer wrong", "", false},
+		{"s3cr3t", "", false},
+		{"", "", false},
+	} {
+		r := httptest.NewRequest("GET", "/apps", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		actor, err := auth(r)
+		if (err == nil) != tt.ok || actor != tt.actor {
+			t.Errorf("%d. expected %q, %v, got %q, %v", i, tt.actor, tt.ok, actor, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for i, tt := range []struct {
+		parts   []string
+		pattern []string
+		match   bool
+	}{
+		{[]string{"apps"}, []string{"apps"}, true},
+		{[]string{"apps", "cat"}, []string{"apps", "*"}, true},
+		{[]string{"apps", ""}, []string{"apps", "*"}, false},
+		{[]string{"apps", "cat", "procs"}, []string{"apps", "*"}, false},
+		{[]string{"instances", "1"}, []string{"apps", "*"}, false},
+	} {
+		if got := match(tt.parts, tt.pattern...); got != tt.match {
+			t.Errorf("%d. expected %t, got %t", i, tt.match, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"sync"
+	"time"
+)
+
+// IDAllocator allocates the ids of new instances. Ids have to be positive
+// and unique across all clients of a coordinator.
+type IDAllocator interface {
+	NextID(s *Store) (int64, error)
+}
+
+// CoordinatorIDs allocates ids from the coordinator, which is the default.
+// All registrations contend for the same counter.
+type CoordinatorIDs struct{}
+
+// NextID satisfies the IDAllocator interface.
+func (CoordinatorIDs) NextID(s *Store) (int64, error) {
+	return s.GetSnapshot().Getuid()
+}
+
+// Bit layout of SnowflakeIDs, below the sign bit.
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeTimeBits = 63 - snowflakeNodeBits - snowflakeSeqBits
+
+	// MaxSnowflakeNode is the highest node number of SnowflakeIDs.
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+)
+
+// DefaultSnowflakeEpoch is the epoch of SnowflakeIDs if they don't set one.
+var DefaultSnowflakeEpoch = time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeIDs allocates time ordered ids without asking the coordinator.
+// An id is made of the milliseconds since Epoch, the Node and a sequence
+// number for ids of the same millisecond. Every client registering
+// instances has to use a different Node.
+type SnowflakeIDs struct {
+	Node int64
+	// DefaultSnowflakeEpoch if zero.
+	Epoch time.Time
+
+	mu   sync.Mutex
+	last int64
+	seq  int64
+	now  func() time.Time
+}
+
+// NewSnowflakeIDs returns SnowflakeIDs for the given node.
+func NewSnowflakeIDs(node int64) (*SnowflakeIDs, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, errorf(ErrInvalidArgument, "snowflake node must be within 0 and %d", MaxSnowflakeNode)
+	}
+	return &SnowflakeIDs{Node: node}, nil
+}
+
+// NextID satisfies the IDAllocator interface. If all ids of a millisecond
+// are used up it waits for the next one.
+func (a *SnowflakeIDs) NextID(s *Store) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	epoch := a.Epoch
+	if epoch.IsZero() {
+		epoch = DefaultSnowflakeEpoch
+	}
+	now := a.now
+	if now == nil {
+		now = time.Now
+	}
+	ms := int64(now().Sub(epoch) / time.Millisecond)
+	if ms < 0 || ms >= 1<<snowflakeTimeBits {
+		return 0, errorf(ErrInvalidState, "clock is outside of the snowflake epoch %s", epoch)
+	}
+	if ms < a.last {
+		// The clock went backwards, keep counting from the last id.
+		ms = a.last
+	}
+	if ms == a.last {
+		a.seq++
+		if a.seq == 1<<snowflakeSeqBits {
+			for ms <= a.last {
+				time.Sleep(time.Millisecond)
+				ms = int64(now().Sub(epoch) / time.Millisecond)
+			}
+			a.seq = 0
+		}
+	} else {
+		a.seq = 0
+	}
+	a.last = ms
+	return ms<<(snowflakeNodeBits+snowflakeSeqBits) | a.Node<<snowflakeSeqBits | a.seq, nil
+}
+
+// SetIDAllocator sets how the ids of instances registered and transactions
+// committed through the Store and all Stores derived from it are allocated.
+// CoordinatorIDs if nil.
+func (s *Store) SetIDAllocator(a IDAllocator) {
+	s.closer.mu.Lock()
+	defer s.closer.mu.Unlock()
+	s.closer.ids = a
+}
+
+func (s *Store) nextID() (int64, error) {
+	s.closer.mu.Lock()
+	a := s.closer.ids
+	s.closer.mu.Unlock()
+	if a == nil {
+		a = CoordinatorIDs{}
+	}
+	id, err := a.NextID(s)
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, errorf(ErrInvalidState, "allocated invalid id %d", id)
+	}
+	return id, nil
+}
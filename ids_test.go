@@ -0,0 +1,75 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnowflakeIDs(t *testing.T) {
+	if _, err := NewSnowflakeIDs(MaxSnowflakeNode + 1); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	a, err := NewSnowflakeIDs(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := DefaultSnowflakeEpoch.Add(time.Hour)
+	a.now = func() time.Time { return now }
+
+	seen := map[int64]bool{}
+	last := int64(0)
+	for i := 0; i < 100; i++ {
+		if i == 50 {
+			// Clocks going backwards don't produce smaller ids.
+			now = now.Add(-time.Second)
+		}
+		id, err := a.NextID(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last || seen[id] {
+			t.Fatalf("expected increasing unique ids, got %d after %d", id, last)
+		}
+		if node := id >> snowflakeSeqBits & MaxSnowflakeNode; node != 7 {
+			t.Errorf("expected node 7 in id %d, got %d", id, node)
+		}
+		seen[id] = true
+		last = id
+	}
+
+	b, _ := NewSnowflakeIDs(8)
+	b.now = a.now
+	id, err := b.NextID(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen[id] {
+		t.Errorf("expected ids of different nodes to differ, got %d", id)
+	}
+}
+
+func TestStoreSetIDAllocator(t *testing.T) {
+	s := instanceSetup()
+	a, err := NewSnowflakeIDs(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetIDAllocator(a)
+	defer s.SetIDAllocator(nil)
+
+	ins, err := s.RegisterInstance("snowflake-cat", "128af9", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.ID>>snowflakeSeqBits&MaxSnowflakeNode != 1 {
+		t.Errorf("expected snowflake id, got %d", ins.ID)
+	}
+	if _, err := s.GetInstance(ins.ID); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"sync"
+)
+
+// AppLister lists apps from the local cache of an Informer.
+type AppLister interface {
+	List() []*App
+	Get(name string) (*App, bool)
+}
+
+// ProcLister lists procs from the local cache of an Informer.
+type ProcLister interface {
+	List() []*Proc
+	Get(app, name string) (*Proc, bool)
+	ListByApp(app string) []*Proc
+}
+
+// InstanceLister lists instances from the local cache of an Informer.
+type InstanceLister interface {
+	List() []*Instance
+	Get(id int64) (*Instance, bool)
+	ListByProc(app, proc string) []*Instance
+	ListByStatus(status InsStatus) []*Instance
+	ListByHost(host string) []*Instance
+}
+
+// Informer maintains an in-memory mirror of all apps, procs and instances.
+// It is populated from an initial listing and kept up to date by watching
+// the event stream from the revision of that listing on. Objects handed out
+// by its listers are shared and must not be modified.
+type Informer struct {
+	store *Store
+
+	mu        sync.RWMutex
+	apps      map[string]*App
+	procs     map[string]*Proc
+	instances map[int64]*Instance
+	rev       int64
+
+	// Indexes of the cached objects.
+	procsByApp        map[string]map[string]*Proc
+	instancesByProc   map[string]map[int64]*Instance
+	instancesByStatus map[InsStatus]map[int64]*Instance
+	instancesByHost   map[string]map[int64]*Instance
+
+	handlers []func(*Event)
+	synced   chan struct{}
+}
+
+// NewInformer returns an Informer for the Store. Call Run to populate it.
+func (s *Store) NewInformer() *Informer {
+	inf := &Informer{
+		store:  s,
+		synced: make(chan struct{}),
+	}
+	inf.reset()
+	return inf
+}
+
+// AddHandler registers a function which is called with every event after it
+// has been applied to the cache. Handlers must be added before Run.
+func (inf *Informer) AddHandler(h func(*Event)) {
+	inf.handlers = append(inf.handlers, h)
+}
+
+// Run lists the current state and applies events until the Store is closed
+// or watching fails. The state is listed again whenever events may have been
+// missed, see EvResyncNeeded. It blocks and is meant to be run in its own
+// goroutine.
+func (inf *Informer) Run() error {
+	store, err := inf.store.FastForward()
+	if err != nil {
+		return err
+	}
+	if err := inf.list(store); err != nil {
+		return err
+	}
+	close(inf.synced)
+
+	w, err := store.Watch(context.Background(), WatchOptions{})
+	if err != nil {
+		return err
+	}
+	for e := range w.Events() {
+		if err := inf.apply(e); err != nil {
+			w.Stop()
+			return err
+		}
+		for _, h := range inf.handlers {
+			h(e)
+		}
+	}
+	return w.Stop()
+}
+
+// HasSynced reports whether the initial listing has been loaded.
+func (inf *Informer) HasSynced() bool {
+	select {
+	case <-inf.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForSync blocks until the initial listing has been loaded.
+func (inf *Informer) WaitForSync() {
+	<-inf.synced
+}
+
+// Rev returns the coordinator revision the cache reflects.
+func (inf *Informer) Rev() int64 {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+	return inf.rev
+}
+
+// Apps returns a lister for the cached apps.
+func (inf *Informer) Apps() AppLister {
+	return appLister{inf}
+}
+
+// Procs returns a lister for the cached procs.
+func (inf *Informer) Procs() ProcLister {
+	return procLister{inf}
+}
+
+// Instances returns a lister for the cached instances.
+func (inf *Informer) Instances() InstanceLister {
+	return instanceLister{inf}
+}
+
+func (inf *Informer) list(s *Store) error {
+	apps, err := s.GetApps()
+	if err != nil {
+		return err
+	}
+
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+
+	inf.reset()
+	for _, app := range apps {
+		procs, err := getProcs(app, s.GetSnapshot())
+		if err != nil {
+			return err
+		}
+		inf.setApp(app)
+		for _, p := range procs {
+			inf.setProc(p)
+		}
+	}
+
+	it, err := s.InstancesIter()
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		inf.setInstance(it.Instance())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	inf.rev = s.GetSnapshot().Rev
+
+	return nil
+}
+
+// reset empties the cache.
+func (inf *Informer) reset() {
+	inf.apps = map[string]*App{}
+	inf.procs = map[string]*Proc{}
+	inf.instances = map[int64]*Instance{}
+	inf.procsByApp = map[string]map[string]*Proc{}
+	inf.instancesByProc = map[string]map[int64]*Instance{}
+	inf.instancesByStatus = map[InsStatus]map[int64]*Instance{}
+	inf.instancesByHost = map[string]map[int64]*Instance{}
+}
+
+func (inf *Informer) apply(e *Event) error {
+	if e.Type == EvResyncNeeded {
+		// Changes may have been missed, start over from the revision the
+		// watch continues at.
+		return inf.list(inf.store.At(e.Rev))
+	}
+
+	var (
+		id  int64
+		ins *Instance
+		err error
+	)
+
+	// The instance is refetched on any of its events, as they only tell
+	// which transition happened.
+	if e.Path.Instance != nil {
+		id, err = parseInstanceID(*e.Path.Instance)
+		if err != nil {
+			return err
+		}
+		if e.Type != EvInsUnreg {
+			ins, err = getInstance(id, e.raw)
+			if err != nil && !IsErrNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+
+	inf.rev = e.Rev
+
+	switch {
+	case e.Type == EvAppReg || e.Type == EvAppEnv:
+		if app, ok := e.Source.(*App); ok {
+			inf.setApp(app)
+		}
+	case e.Type == EvAppUnreg:
+		inf.delApp(*e.Path.App)
+	case e.Type == EvProcReg || e.Type == EvProcAttrs:
+		if p, ok := e.Source.(*Proc); ok {
+			inf.setProc(p)
+		}
+	case e.Type == EvProcUnreg:
+		inf.delProc(*e.Path.App, *e.Path.Proc)
+	case e.Path.Instance != nil:
+		if ins != nil {
+			inf.setInstance(ins)
+		} else {
+			inf.delInstance(id)
+		}
+	}
+	return nil
+}
+
+func (inf *Informer) setApp(app *App) {
+	inf.apps[app.Name] = app
+}
+
+func (inf *Informer) delApp(name string) {
+	delete(inf.apps, name)
+	for proc := range inf.procsByApp[name] {
+		inf.delProc(name, proc)
+	}
+}
+
+func (inf *Informer) setProc(p *Proc) {
+	key := procKey(p.App.Name, p.Name)
+	inf.procs[key] = p
+	if inf.procsByApp[p.App.Name] == nil {
+		inf.procsByApp[p.App.Name] = map[string]*Proc{}
+	}
+	inf.procsByApp[p.App.Name][p.Name] = p
+}
+
+func (inf *Informer) delProc(app, name string) {
+	delete(inf.procs, procKey(app, name))
+	delete(inf.procsByApp[app], name)
+	if len(inf.procsByApp[app]) == 0 {
+		delete(inf.procsByApp, app)
+	}
+}
+
+func (inf *Informer) setInstance(ins *Instance) {
+	inf.delInstance(ins.ID)
+	inf.instances[ins.ID] = ins
+	addToIndex(inf.instancesByProc, procKey(ins.AppName, ins.ProcessName), ins)
+	if inf.instancesByStatus[ins.Status] == nil {
+		inf.instancesByStatus[ins.Status] = map[int64]*Instance{}
+	}
+	inf.instancesByStatus[ins.Status][ins.ID] = ins
+	if ins.IP != "" {
+		addToIndex(inf.instancesByHost, ins.IP, ins)
+	}
+}
+
+func (inf *Informer) delInstance(id int64) {
+	ins, ok := inf.instances[id]
+	if !ok {
+		return
+	}
+	delete(inf.instances, id)
+	delFromIndex(inf.instancesByProc, procKey(ins.AppName, ins.ProcessName), id)
+	delete(inf.instancesByStatus[ins.Status], id)
+	if len(inf.instancesByStatus[ins.Status]) == 0 {
+		delete(inf.instancesByStatus, ins.Status)
+	}
+	delFromIndex(inf.instancesByHost, ins.IP, id)
+}
+
+func addToIndex(idx map[string]map[int64]*Instance, key string, ins *Instance) {
+	if idx[key] == nil {
+		idx[key] = map[int64]*Instance{}
+	}
+	idx[key][ins.ID] = ins
+}
+
+func delFromIndex(idx map[string]map[int64]*Instance, key string, id int64) {
+	delete(idx[key], id)
+	if len(idx[key]) == 0 {
+		delete(idx, key)
+	}
+}
+
+func procKey(app, proc string) string {
+	return app + ":" + proc
+}
+
+func instanceList(m map[int64]*Instance) []*Instance {
+	list := make([]*Instance, 0, len(m))
+	for _, ins := range m {
+		list = append(list, ins)
+	}
+	return list
+}
+
+type appLister struct{ inf *Informer }
+
+func (l appLister) List() []*App {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+
+	list := make([]*App, 0, len(l.inf.apps))
+	for _, app := range l.inf.apps {
+		list = append(list, app)
+	}
+	return list
+}
+
+func (l appLister) Get(name string) (*App, bool) {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+
+	app, ok := l.inf.apps[name]
+	return app, ok
+}
+
+type procLister struct{ inf *Informer }
+
+func (l procLister) List() []*Proc {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+
+	list := make([]*Proc, 0, len(l.inf.procs))
+	for _, p := range l.inf.procs {
+		list = append(list, p)
+	}
+	return list
+}
+
+func (l procLister) Get(app, name string) (*Proc, bool) {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+
+	p, ok := l.inf.procs[procKey(app, name)]
+	return p, ok
+}
+
+func (l procLister) ListByApp(app string) []*Proc {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+
+	list := []*Proc{}
+	for _, p := range l.inf.procsByApp[app] {
+		list = append(list, p)
+	}
+	return list
+}
+
+type instanceLister struct{ inf *Informer }
+
+func (l instanceLister) List() []*Instance {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+	return instanceList(l.inf.instances)
+}
+
+func (l instanceLister) Get(id int64) (*Instance, bool) {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+
+	ins, ok := l.inf.instances[id]
+	return ins, ok
+}
+
+func (l instanceLister) ListByProc(app, proc string) []*Instance {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+	return instanceList(l.inf.instancesByProc[procKey(app, proc)])
+}
+
+func (l instanceLister) ListByStatus(status InsStatus) []*Instance {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+	return instanceList(l.inf.instancesByStatus[status])
+}
+
+func (l instanceLister) ListByHost(host string) []*Instance {
+	l.inf.mu.RLock()
+	defer l.inf.mu.RUnlock()
+	return instanceList(l.inf.instancesByHost[host])
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func informerSetup(t *testing.T) (*Store, *Proc) {
+	s, err := DialURI(DefaultURI, "/informer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	app, err := s.NewApp("informer", "git://informer.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, proc
+}
+
+func TestInformer(t *testing.T) {
+	s, proc := informerSetup(t)
+	host := "10.0.0.1"
+
+	ins1, err := s.RegisterInstance("informer", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inf := s.NewInformer()
+	events := make(chan *Event, 64)
+	inf.AddHandler(func(e *Event) { events <- e })
+
+	done := make(chan error)
+	go func() {
+		done <- inf.Run()
+	}()
+	inf.WaitForSync()
+
+	if _, ok := inf.Apps().Get("informer"); !ok {
+		t.Error("expected app to be cached")
+	}
+	if procs := inf.Procs().ListByApp("informer"); len(procs) != 1 || procs[0].Name != proc.Name {
+		t.Errorf("expected proc %s to be cached, got %v", proc.Name, procs)
+	}
+	if _, ok := inf.Instances().Get(ins1.ID); !ok {
+		t.Errorf("expected instance %d to be cached", ins1.ID)
+	}
+
+	if _, err = ins1.Claim(host); err != nil {
+		t.Fatal(err)
+	}
+	waitInformer(t, events, func() bool {
+		return len(inf.Instances().ListByHost(host)) == 1
+	})
+	if pending := inf.Instances().ListByStatus(InsStatusPending); len(pending) != 0 {
+		t.Errorf("expected no pending instances, got %v", pending)
+	}
+	if claimed := inf.Instances().ListByStatus(InsStatusClaimed); len(claimed) != 1 || claimed[0].ID != ins1.ID {
+		t.Errorf("expected instance %d to be listed as claimed, got %v", ins1.ID, claimed)
+	}
+
+	ins2, err := s.RegisterInstance("informer", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitInformer(t, events, func() bool {
+		_, ok := inf.Instances().Get(ins2.ID)
+		return ok
+	})
+	if l := inf.Instances().ListByProc("informer", "web"); len(l) != 2 {
+		t.Errorf("expected 2 instances for proc, got %v", l)
+	}
+
+	if err = proc.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	waitInformer(t, events, func() bool {
+		_, ok := inf.Procs().Get("informer", "web")
+		return !ok
+	})
+
+	s.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected informer to stop after store was closed")
+	}
+}
+
+func TestInformerResync(t *testing.T) {
+	s, _ := informerSetup(t)
+
+	ins1, err := s.RegisterInstance("informer", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	inf := s.NewInformer()
+	if err := inf.list(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes the watch missed.
+	if err := ins1.Unregister("informer-test", errors.New("gone")); err != nil {
+		t.Fatal(err)
+	}
+	ins2, err := s.RegisterInstance("informer", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := ins2.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := inf.apply(&Event{Type: EvResyncNeeded, Rev: sp.Rev}); err != nil {
+		t.Fatal(err)
+	}
+	if inf.Rev() != sp.Rev {
+		t.Errorf("expected rev %d after resync, got %d", sp.Rev, inf.Rev())
+	}
+	if _, ok := inf.Instances().Get(ins1.ID); ok {
+		t.Errorf("expected unregistered instance %d to be dropped", ins1.ID)
+	}
+	if l := inf.Instances().ListByProc("informer", "web"); len(l) != 1 || l[0].ID != ins2.ID {
+		t.Errorf("expected instance %d to be listed, got %v", ins2.ID, l)
+	}
+}
+
+func waitInformer(t *testing.T, events chan *Event, cond func() bool) {
+	timeout := time.After(time.Second)
+	for !cond() {
+		select {
+		case <-events:
+		case <-timeout:
+			t.Fatal("informer did not catch up")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const healthPath = "health"
+
+// HealthState is the outcome of the healthchecks of an instance, which is
+// independent of its InsStatus: a running instance can be unhealthy.
+type HealthState string
+
+// Health states of an instance.
+const (
+	HealthUnknown   HealthState = "unknown"
+	HealthHealthy   HealthState = "healthy"
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+// InsHealth is the health an instance was last reported with.
+type InsHealth struct {
+	State  HealthState `json:"state"`
+	Detail string      `json:"detail,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+// SetHealth reports the health of the running instance, e.g. after its
+// healthcheck failed, so load balancers can take an unhealthy instance out
+// of rotation without it being stopped. A changed state or detail emits an
+// EvInsHealth event, reporting the same health again is a no-op.
+func (i *Instance) SetHealth(h HealthState, detail string) (_ *Instance, err error) {
+	defer i.annotate(&err, "set-health")
+	//
+	//   instances/
+	//       6868/
+	//           start  = {"ip":"10.0.0.1","port":24690,...}
+	// +         health = {"state":"unhealthy","detail":"GET /health: 503",...}
+	//
+	switch h {
+	case HealthUnknown, HealthHealthy, HealthUnhealthy:
+	default:
+		return nil, errorf(ErrInvalidArgument, "invalid health state %q", h)
+	}
+	if err := i.guard.authorize("", ActionHealth, i.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	i, err = i.refresh(sp)
+	if err != nil {
+		return nil, err
+	}
+	if i.Status != InsStatusRunning {
+		return nil, errorf(ErrInvalidState, "%s is not running", i)
+	}
+	if i.Health != nil && i.Health.State == h && i.Health.Detail == detail {
+		return i, nil
+	}
+
+	health := &InsHealth{State: h, Detail: detail, Time: time.Now()}
+	f, err := cp.NewFile(i.dir.Prefix(healthPath), health, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	i.Health = health
+	i.dir = i.dir.Join(f)
+	return i, nil
+}
+
+// IsHealthy reports whether the instance can receive traffic as far as its
+// health is concerned, which is the case unless it was reported unhealthy.
+func (i *Instance) IsHealthy() bool {
+	return i.Health == nil || i.Health.State != HealthUnhealthy
+}
+
+// getHealth returns nil if no health was reported for the instance.
+func getHealth(d *cp.Dir) (*InsHealth, error) {
+	health := &InsHealth{}
+	_, err := d.GetFile(healthPath, &cp.JsonCodec{DecodedVal: health})
+	if cp.IsErrNoEnt(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return health, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import "testing"
+
+func TestInstanceSetHealth(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("health-cat", ip)
+
+	if _, err := ins.SetHealth(HealthUnhealthy, ""); !IsErrInvalidState(err) {
+		t.Errorf("expected health of claimed instance to be rejected, got %v", err)
+	}
+	ins, err := ins.Started(ip, "box1.health-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.SetHealth("sick", ""); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid health state to be rejected, got %v", err)
+	}
+	if !ins.IsHealthy() {
+		t.Error("expected instance without health to be healthy")
+	}
+
+	ins, err = ins.SetHealth(HealthUnhealthy, "GET /health: 503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := ins.GetSnapshot().Rev
+	ins, err = ins.SetHealth(HealthUnhealthy, "GET /health: 503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.GetSnapshot().Rev != rev {
+		t.Error("expected unchanged health not to be written")
+	}
+
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusRunning {
+		t.Errorf("expected instance to stay running, got %s", ins.Status)
+	}
+	if ins.IsHealthy() || ins.Health.Detail != "GET /health: 503" {
+		t.Errorf("expected instance to be unhealthy, got %#v", ins.Health)
+	}
+}
@@ -6,33 +6,48 @@
 package visor
 
 import (
+	"encoding/json"
 	"fmt"
 	"path"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
 )
 
 const (
-	claimsPath    = "claims"
-	instancesPath = "instances"
-	donePath      = "done"
-	failedPath    = "failed"
-	lostPath      = "lost"
-	lockPath      = "lock"
-	objectPath    = "object"
-	startPath     = "start"
-	statusPath    = "status"
-	stopPath      = "stop"
-	restartsPath  = "restarts"
+	claimsPath       = "claims"
+	instancesPath    = "instances"
+	donePath         = "done"
+	failedPath       = "failed"
+	failedDaysPath   = "failed-days"
+	lostPath         = "lost"
+	lockPath         = "lock"
+	objectPath       = "object"
+	startPath        = "start"
+	statusPath       = "status"
+	stopPath         = "stop"
+	restartsPath     = "restarts"
+	restartReqPath   = "restart-request"
+	exitPath         = "exit"
+	zonePath         = "zone"
+	restartTimesPath = "restart-times"
+	crashLoopPath    = "crash-loop"
+	replacesPath     = "replaces"
+	replacedByPath   = "replaced-by"
+	statusIdxPath    = "index/status"
+
+	// Number of restart timestamps kept per instance.
+	maxRestartTimes = 100
 
 	restartFailField = 0
 	restartOOMField  = 1
 
 	InsStatusPending  InsStatus = "pending"
 	InsStatusClaimed  InsStatus = "claimed"
+	InsStatusStarting InsStatus = "starting"
 	InsStatusRunning  InsStatus = "running"
 	InsStatusStopping InsStatus = "stopping"
 	InsStatusFailed   InsStatus = "failed"
@@ -54,6 +69,29 @@ func (r InsRestarts) Fields() []int {
 	return []int{r.Fail, r.OOM}
 }
 
+// InstanceRestart is the source of EvInsRestart events, the instance with
+// its restart counts before the change.
+type InstanceRestart struct {
+	*Instance
+	Previous InsRestarts `json:"previousRestarts"`
+}
+
+// getInstanceRestart returns the instance at the revision its restarts
+// changed with the counts of the revision before.
+func getInstanceRestart(id int64, s cp.Snapshotable) (*InstanceRestart, error) {
+	ins, err := getInstance(id, s)
+	if err != nil {
+		return nil, err
+	}
+	sp := s.GetSnapshot()
+	sp.Rev--
+	prev, _, err := (&Instance{dir: cp.NewDir(instancePath(id), sp)}).getRestarts()
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceRestart{Instance: ins, Previous: prev}, nil
+}
+
 // Int64Slice is a sortable list of int64s.
 type Int64Slice []int64
 
@@ -61,6 +99,25 @@ func (p Int64Slice) Len() int           { return len(p) }
 func (p Int64Slice) Less(i, j int) bool { return p[i] < p[j] }
 func (p Int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+type instancesByID []*Instance
+
+func (p instancesByID) Len() int           { return len(p) }
+func (p instancesByID) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p instancesByID) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// instancesByPriority orders instances by descending priority, instances of
+// the same priority by ID and so in order of registration.
+type instancesByPriority []*Instance
+
+func (p instancesByPriority) Len() int      { return len(p) }
+func (p instancesByPriority) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p instancesByPriority) Less(i, j int) bool {
+	if p[i].Priority != p[j].Priority {
+		return p[i].Priority > p[j].Priority
+	}
+	return p[i].ID < p[j].ID
+}
+
 // Termination represents extra information for an Instance termination.
 type Termination struct {
 	Client string    `json:"client"`
@@ -68,23 +125,87 @@ type Termination struct {
 	Time   time.Time `json:"time"`
 }
 
+// InstanceOpts are the parameters of RegisterInstanceWithOpts.
+type InstanceOpts struct {
+	App    string
+	Rev    string
+	Proc   string
+	Env    string
+	Labels map[string]string
+	// Host the instance has to be claimed by, any if empty.
+	HostConstraint string
+	Priority       int
+	// ID of the instance replaced by the new one.
+	Replaces int64
+}
+
+// insObjectVersion is the version of the object file written. Readers
+// reject newer versions, fields added within a version have to be optional.
+const insObjectVersion = 1
+
+// insObject is the object file of an instance, a versioned JSON document.
+// Instances registered by older clients have a list of the positional
+// fields "<app> <rev> <proc> [<env>]" instead, or JSON without version.
+type insObject struct {
+	Version        int    `json:"version"`
+	App            string `json:"app"`
+	Rev            string `json:"rev"`
+	Proc           string `json:"proc"`
+	Env            string `json:"env"`
+	HostConstraint string `json:"hostConstraint,omitempty"`
+	Priority       int    `json:"priority,omitempty"`
+}
+
+// InsExit describes how the process of an exited instance terminated.
+type InsExit struct {
+	Code int  `json:"code"`
+	OOM  bool `json:"oom"`
+}
+
+// InsRestartRequest is the intent that an instance should be restarted in
+// place, see Instance.RequestRestart.
+type InsRestartRequest struct {
+	Reason string
+	Time   time.Time
+}
+
 // Instance represents service instances.
 type Instance struct {
 	dir          *cp.Dir
-	ID           int64       `json:"id"`
-	AppName      string      `json:"app"`
-	RevisionName string      `json:"rev"`
-	ProcessName  string      `json:"proc"`
-	Env          string      `json:"env"`
-	IP           string      `json:"ip"`
-	Port         int         `json:"port"`
-	TelePort     int         `json:"telePort"`
-	Host         string      `json:"host"`
-	Status       InsStatus   `json:"status"`
-	Restarts     InsRestarts `json:"restarts"`
-	Registered   time.Time   `json:"registered"`
-	Claimed      time.Time   `json:"claimed"`
-	Termination  Termination `json:"termination,omitempty"`
+	guard        *guard
+	closer       *closer
+	reads        *readLimit
+	ID           int64             `json:"id"`
+	AppName      string            `json:"app"`
+	RevisionName string            `json:"rev"`
+	ProcessName  string            `json:"proc"`
+	Env          string            `json:"env"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	IP           string            `json:"ip"`
+	Port         int               `json:"port"`
+	TelePort     int               `json:"telePort"`
+	Host         string            `json:"host"`
+	Status       InsStatus         `json:"status"`
+	Restarts     InsRestarts       `json:"restarts"`
+	Registered   time.Time         `json:"registered"`
+	RegisteredBy string            `json:"registeredBy,omitempty"`
+	Claimed      time.Time         `json:"claimed"`
+	Termination  Termination       `json:"termination,omitempty"`
+	Exit         *InsExit          `json:"exit,omitempty"`
+	Runtime      *RuntimeInfo      `json:"runtime,omitempty"`
+	Replaces     int64             `json:"replaces,omitempty"`
+	ReplacedBy   int64             `json:"replacedBy,omitempty"`
+	// Zone the instance was started in, empty if unknown.
+	Zone string `json:"zone,omitempty"`
+	// Named ports, see RegisterEndpoints.
+	Endpoints map[string]Endpoint `json:"endpoints,omitempty"`
+	// Last reported health, see SetHealth.
+	Health *InsHealth `json:"health,omitempty"`
+	// When the instance got ready, zero if it isn't, see Ready.
+	ReadyAt time.Time `json:"readyAt,omitempty"`
+	// Host the instance has to be claimed by, any if empty.
+	HostConstraint string `json:"hostConstraint,omitempty"`
+	Priority       int    `json:"priority,omitempty"`
 }
 
 // GetSnapshot satisfies the cp.Snapshotable interface.
@@ -94,11 +215,13 @@ func (i *Instance) GetSnapshot() cp.Snapshot {
 
 // GetInstance returns an Instance from the given id
 func (s *Store) GetInstance(id int64) (ins *Instance, err error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return
 	}
-	return getInstance(id, sp)
+	ins, err = getInstance(id, s.join(sp))
+	annotate(&err, "get", fmt.Sprintf("instance:%d", id), instancePath(id), sp.Rev)
+	return
 }
 
 // GetSerialisedInstance returns an instance for the given id and status.
@@ -107,7 +230,7 @@ func (s *Store) GetSerialisedInstance(
 	id int64,
 	status InsStatus,
 ) (*Instance, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
@@ -127,14 +250,12 @@ func getSerialisedInstance(
 			ProcessName: proc,
 			dir:         cp.NewDir(instancePath(id), sp),
 		}
-		c = &cp.JsonCodec{
-			DecodedVal: i,
-		}
+		c = decodeCodec(i)
 	)
 
 	_, err := sp.GetFile(i.procStatusPath(status), c)
 	if err != nil {
-		return nil, errorf(err, "fetching instance %d: %s", id, err)
+		return nil, errorf(err, "fetching instance %d: %w", id, err)
 	}
 
 	return i, nil
@@ -142,70 +263,155 @@ func getSerialisedInstance(
 
 // RegisterInstance stores the Instance.
 func (s *Store) RegisterInstance(app, rev, proc, env string) (ins *Instance, err error) {
+	return s.RegisterInstanceWithOpts(InstanceOpts{App: app, Rev: rev, Proc: proc, Env: env})
+}
+
+// RegisterInstanceReplacing stores an Instance which replaces the instance
+// with the given id, e.g. when it is rescheduled. The link is kept in both
+// instances as Replaces and ReplacedBy, including their serialised records.
+func (s *Store) RegisterInstanceReplacing(oldID int64, app, rev, proc, env string) (*Instance, error) {
+	if oldID <= 0 {
+		return nil, errorf(ErrInvalidArgument, "invalid instance id %d", oldID)
+	}
+	ins, err := s.RegisterInstanceWithOpts(InstanceOpts{
+		App:      app,
+		Rev:      rev,
+		Proc:     proc,
+		Env:      env,
+		Replaces: oldID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ins.markReplaced(oldID); err != nil {
+		return nil, err
+	}
+	return ins, nil
+}
+
+// RegisterInstanceWithOpts stores the Instance described by opts.
+func (s *Store) RegisterInstanceWithOpts(opts InstanceOpts) (ins *Instance, err error) {
+	defer annotate(&err, "register", fmt.Sprintf("proc:%s:%s", opts.App, opts.Proc), instancesPath, 0)
 	//
 	//   instances/
 	//       6868/
-	// +         object = <app> <rev> <proc>
+	// +         object = {"version":1,"app":<app>,"rev":<rev>,"proc":<proc>,"env":<env>}
 	// +         start  =
 	//
 	//   apps/<app>/procs/<proc>/instances/<rev>
 	// +     6868 = 2012-07-19 16:41 UTC
 	//
-	id, err := s.GetSnapshot().Getuid()
-	if err != nil {
-		return
+	if err = s.writable(); err != nil {
+		return nil, err
 	}
-	ins = &Instance{
-		ID:           id,
-		AppName:      app,
-		RevisionName: rev,
-		ProcessName:  proc,
-		Env:          env,
-		Registered:   time.Now(),
-		Status:       InsStatusPending,
-		dir:          cp.NewDir(instancePath(id), s.GetSnapshot()),
+	if err = validateLabels(opts.Labels); err != nil {
+		return nil, err
 	}
-
-	object := cp.NewFile(ins.dir.Prefix(objectPath), ins.objectArray(), new(cp.ListCodec), s.GetSnapshot())
-	object, err = object.Save()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
-
-	start := cp.NewFile(ins.dir.Prefix(startPath), "", new(cp.StringCodec), s.GetSnapshot())
-	start, err = start.Save()
+	if err = checkDeployFreeze(sp); err != nil {
+		return nil, err
+	}
+	if err = checkMaintenance(opts.App, opts.Proc, sp); err != nil {
+		return nil, err
+	}
+	err = s.guard.authorize("", AuditRegister, fmt.Sprintf("proc:%s:%s", opts.App, opts.Proc))
 	if err != nil {
 		return nil, err
 	}
-
-	// Create the file used for lookups of existing instances per proc.
-	_, err = ins.GetSnapshot().Set(ins.procStatusPath(InsStatusRunning), formatTime(ins.Registered))
+	id, err := s.nextID()
+	if err != nil {
+		return
+	}
+	ins = &Instance{
+		ID:             id,
+		AppName:        opts.App,
+		RevisionName:   opts.Rev,
+		ProcessName:    opts.Proc,
+		Env:            opts.Env,
+		Labels:         opts.Labels,
+		HostConstraint: opts.HostConstraint,
+		Priority:       opts.Priority,
+		Registered:     time.Now(),
+		Status:         InsStatusPending,
+		Replaces:       opts.Replaces,
+		RegisteredBy:   s.Actor(),
+		dir:            cp.NewDir(instancePath(id), s.GetSnapshot()),
+		guard:          s.guard,
+		closer:         s.closer,
+		reads:          s.reads,
+	}
+
+	// All files are written in one transaction so a failure can't leave a
+	// start file without lookup entry behind. The registered file should be
+	// the last path set in order for the event system to work properly.
+	txn := s.Txn()
+	txn.SetFile(ins.dir.Prefix(objectPath), ins.object(), new(cp.JsonCodec)).
+		SetFile(ins.dir.Prefix(startPath), "", new(cp.StringCodec)).
+		// Create the file used for lookups of existing instances per proc.
+		Set(ins.procStatusPath(InsStatusRunning), formatTime(ins.Registered)).
+		Set(statusIndexPath(InsStatusPending, id), timestamp())
+	if len(opts.Labels) > 0 {
+		txn.SetFile(ins.dir.Prefix(labelsPath), opts.Labels, new(cp.JsonCodec))
+	}
+	if opts.Replaces != 0 {
+		txn.Set(ins.dir.Prefix(replacesPath), strconv.FormatInt(opts.Replaces, 10))
+	}
+	sp, err = txn.
+		Set(ins.dir.Prefix(registeredPath), formatRegistered(ins.Registered, ins.RegisteredBy)).
+		commit()
 	if err != nil {
 		return nil, err
 	}
 
-	// This should be the last path set in order for the event system to work properly.
-	registered, err := ins.dir.Set(registeredPath, formatTime(ins.Registered))
+	ins.dir = ins.dir.Join(sp)
+
+	err = addToCounter(sp, procInstanceCountPath(ins.AppName, ins.ProcessName), 1)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	ins.dir = ins.dir.Join(registered)
+	audit(ins, "", AuditRegister, ins.auditName())
 
-	return
+	return ins, nil
 }
 
 // Unregister removes the instance tree representation.
-func (i *Instance) Unregister(client string, reason error) error {
-	i, err := i.updateLookup(i.Status, InsStatusDone, client, reason)
+func (i *Instance) Unregister(client string, reason error) (err error) {
+	defer i.annotate(&err, "unregister")
+	if err := i.guard.authorize(client, AuditUnregister, i.auditName()); err != nil {
+		return err
+	}
+	status, err := i.storedStatus()
+	if err != nil {
+		return err
+	}
+	i, err = i.updateLookup(status, InsStatusDone, client, reason)
 	if err != nil {
 		return err
 	}
-	return i.dir.Del("/")
+	if err := i.dir.Del("/"); err != nil {
+		return err
+	}
+	if err := i.unindexHost(i.IP); err != nil {
+		return err
+	}
+	if err := i.indexStatus(status, ""); err != nil {
+		return err
+	}
+	audit(i, client, AuditUnregister, i.auditName())
+	return nil
 }
 
 // Claim locks the instance to the specified host.
-func (i *Instance) Claim(host string) (*Instance, error) {
+func (i *Instance) Claim(host string) (_ *Instance, err error) {
+	defer i.annotate(&err, "claim")
+	// The host is joined into the path of the host index.
+	if err := validateHost(host); err != nil {
+		return nil, err
+	}
 	done, err := i.IsDone()
 	if err != nil {
 		return nil, err
@@ -213,22 +419,30 @@ func (i *Instance) Claim(host string) (*Instance, error) {
 	if done {
 		return nil, errorf(ErrUnauthorized, "%s is done", i)
 	}
+	if i.HostConstraint != "" && i.HostConstraint != host {
+		return nil, errorf(ErrUnauthorized, "%s is constrained to host %s", i, i.HostConstraint)
+	}
+	if err := i.checkOffer(host); err != nil {
+		return nil, err
+	}
+	if err := i.guard.authorize(host, AuditClaim, i.auditName()); err != nil {
+		return nil, err
+	}
 
 	//
 	//   instances/
 	//       6868/
 	//           claims/
 	// +             10.0.0.1 = 2012-07-19 16:22 UTC
-	//           object = <app> <rev> <proc>
+	//           object = {"version":1,"app":<app>,...}
 	// -         start  =
 	// +         start  = 10.0.0.1
 	//
-	f, err := i.dir.GetFile(startPath, new(cp.ListCodec))
+	f, err := i.dir.GetFile(startPath, new(startCodec))
 	if err != nil {
 		return nil, err
 	}
-	fields := f.Value.([]string)
-	if len(fields) > 0 {
+	if f.Value.(*startRecord).IP != "" {
 		return nil, errorf(ErrInsClaimed, "%s already claimed", i)
 	}
 	d := i.dir.Join(f)
@@ -248,7 +462,22 @@ func (i *Instance) Claim(host string) (*Instance, error) {
 	}
 	i.Claimed = claimed
 	i.dir = i.dir.Join(d)
-	return i, err
+
+	if err := i.recordClaim(host, claimed); err != nil {
+		return nil, err
+	}
+
+	if err := i.indexHost(host); err != nil {
+		return nil, err
+	}
+	if err := i.indexStatus(InsStatusPending, InsStatusClaimed); err != nil {
+		return nil, err
+	}
+	i.claimed(host)
+
+	audit(i, host, AuditClaim, i.auditName())
+
+	return i, nil
 }
 
 // Claims returns the list of claimers.
@@ -266,14 +495,27 @@ func (i *Instance) Claims() (claims []string, err error) {
 }
 
 // Unclaim removes the lock applied by Claim of the Ticket.
-func (i *Instance) Unclaim(host string) (*Instance, error) {
+func (i *Instance) Unclaim(host string) (_ *Instance, err error) {
+	defer i.annotate(&err, "unclaim")
 	//
 	//   instances/
 	//       6868/
 	// -         start = 10.0.0.1
 	// +         start =
 	//
-	err := i.verifyClaimer(host)
+	err = i.verifyClaimer(host)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.guard.authorize(host, ActionUnclaim, i.auditName()); err != nil {
+		return nil, err
+	}
+	return i.unclaim(host)
+}
+
+// unclaim releases the claim of host without checking who releases it.
+func (i *Instance) unclaim(host string) (*Instance, error) {
+	from, err := i.storedStatus()
 	if err != nil {
 		return nil, err
 	}
@@ -284,59 +526,158 @@ func (i *Instance) Unclaim(host string) (*Instance, error) {
 	}
 	i.dir = d
 
+	if err := i.clearReadiness(); err != nil {
+		return nil, err
+	}
+
+	if err := i.clearStarting(); err != nil {
+		return nil, err
+	}
+
+	if err := i.unindexHost(host); err != nil {
+		return nil, err
+	}
+	if err := i.releaseClaim(ClaimUnclaimed); err != nil {
+		return nil, err
+	}
+	if err := i.indexStatus(from, InsStatusPending); err != nil {
+		return nil, err
+	}
+	i.Status = InsStatusPending
+
 	return i, nil
 }
 
 // Started puts the Instance into start state.
 func (i *Instance) Started(host, hostname string, port, telePort int) (*Instance, error) {
+	return i.StartedInZone(host, hostname, port, telePort, "")
+}
+
+// StartedInZone is Started for an instance running in the given zone, which
+// proxies weigh traffic by, see TrafficControl.ZoneShares.
+func (i *Instance) StartedInZone(host, hostname string, port, telePort int, zone string) (_ *Instance, err error) {
+	defer i.annotate(&err, "started")
 	//
 	//   instances/
 	//       6868/
-	//           object = <app> <rev> <proc>
+	//           object = {"version":1,"app":<app>,...}
 	// -         start  = 10.0.0.1
-	// +         start  = 10.0.0.1 24690 localhost 24691
+	// +         start  = {"ip":"10.0.0.1","port":24690,"host":"localhost","telePort":24691}
 	//
+	if err := validateHost(host); err != nil {
+		return nil, err
+	}
 	if i.Status == InsStatusRunning {
 		return i, nil
 	}
-	err := i.verifyClaimer(host)
+	err = i.verifyClaimer(host)
 	if err != nil {
 		return nil, err
 	}
+	if err := i.guard.authorize(host, ActionStart, i.auditName()); err != nil {
+		return nil, err
+	}
+	from, err := i.storedStatus()
+	if err != nil {
+		return nil, err
+	}
+	// Readiness and health of a process which ran before don't carry over.
+	if err := i.clearReadiness(); err != nil {
+		return nil, err
+	}
+	if zone != "" {
+		if !reZoneName.MatchString(zone) {
+			return nil, errorf(ErrInvalidArgument, "invalid zone name %q", zone)
+		}
+		// Written before the start file, so the zone is known once the
+		// instance is seen running.
+		if i.dir, err = i.dir.Set(zonePath, zone); err != nil {
+			return nil, err
+		}
+		i.Zone = zone
+	}
 	i.started(host, hostname, port, telePort)
 
-	start := cp.NewFile(i.dir.Prefix(startPath), i.startArray(), new(cp.ListCodec), i.GetSnapshot())
+	start := cp.NewFile(i.dir.Prefix(startPath), i.startRecord(), new(startCodec), i.GetSnapshot())
 	start, err = start.Save()
 	if err != nil {
 		return nil, err
 	}
 	i.dir = i.dir.Join(start)
 
+	if err := i.clearStarting(); err != nil {
+		return nil, err
+	}
+
+	if err := i.indexHost(host); err != nil {
+		return nil, err
+	}
+	if err := i.indexStatus(from, InsStatusRunning); err != nil {
+		return nil, err
+	}
+
 	return i, nil
 }
 
-// Restarted tells the coordinator that the instance has been restarted.
-func (i *Instance) Restarted(restarts InsRestarts) (*Instance, error) {
+// Starting tells the coordinator that the claimed instance is being started
+// by host. It's optional between Claim and Started and lets slow starting
+// instances be told apart from ones nobody works on.
+func (i *Instance) Starting(host string) (_ *Instance, err error) {
+	defer i.annotate(&err, "starting")
+	//
+	//   instances/
+	//       6868/
+	//           start  = 10.0.0.1
+	// +         status = starting
+	//
+	if err := i.verifyClaimer(host); err != nil {
+		return nil, err
+	}
+	if err := i.guard.authorize(host, ActionStart, i.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	current, err := getInstance(i.ID, sp)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != InsStatusClaimed {
+		return nil, errorf(ErrInvalidState, "%s is %s, not claimed", i, current.Status)
+	}
+	return current.updateStatus(InsStatusStarting)
+}
+
+// Restarted tells the coordinator that the instance has been restarted. Once
+// the restarts exceed the MaxRestarts of the proc the instance is failed and
+// returned with InsStatusFailed.
+func (i *Instance) Restarted(restarts InsRestarts) (_ *Instance, err error) {
+	defer i.annotate(&err, "restarted")
 	//
 	//   instances/
 	//       6868/
-	//           object   = <app> <rev> <proc>
-	//           start    = 10.0.0.1 24690 localhost
+	//           object   = {"version":1,"app":<app>,...}
+	//           start    = {"ip":"10.0.0.1","port":24690,...}
 	// -         restarts = 1 4
 	// +         restarts = 2 4
 	//
 	//   instances/
 	//       6869/
-	//           object   = <app> <rev> <proc>
-	//           start    = 10.0.0.1 24691 localhost
+	//           object   = {"version":1,"app":<app>,...}
+	//           start    = {"ip":"10.0.0.1","port":24691,...}
 	// +         restarts = 1 0
 	//
+	if err := i.guard.authorize("", ActionRestart, i.auditName()); err != nil {
+		return nil, err
+	}
 	sp, err := i.GetSnapshot().FastForward()
 	if err != nil {
 		return i, err
 	}
 
-	i, err = getInstance(i.ID, sp)
+	i, err = i.refresh(sp)
 	if err != nil {
 		return nil, err
 	}
@@ -355,23 +696,187 @@ func (i *Instance) Restarted(restarts InsRestarts) (*Instance, error) {
 	i.Restarts = restarts
 	i.dir = i.dir.Join(f)
 
+	// The restart fulfilled a pending restart request.
+	err = i.dir.Snapshot.Del(i.dir.Prefix(restartReqPath))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	// The restarted process has to get ready again.
+	if err := i.clearReadiness(); err != nil {
+		return nil, err
+	}
+
+	if err := i.recordRestart(time.Now()); err != nil {
+		return nil, err
+	}
+
+	attrs, err := getProcAttrsForEnv(i.AppName, i.ProcessName, i.Env, i.GetSnapshot())
+	if err != nil {
+		return nil, err
+	}
+	if max := attrs.MaxRestarts; max > 0 && restarts.Fail+restarts.OOM > max {
+		reason := errorf(ErrRestartBudget, "%d restarts exceed the budget of %d", restarts.Fail+restarts.OOM, max)
+		return i.Failed(i.IP, reason)
+	}
+
 	return i, nil
 }
 
+// IsCrashLooping reports whether the Instance restarted often enough within
+// the window of the crash loop policy of its proc. Instances of procs without
+// policy never crash loop.
+func (i *Instance) IsCrashLooping() (bool, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return false, err
+	}
+	return i.isCrashLooping(sp, time.Now())
+}
+
+// GetRestartTimes returns the times of the latest restarts of the Instance,
+// oldest first.
+func (i *Instance) GetRestartTimes() ([]time.Time, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getRestartTimes(i.dir.Join(sp))
+}
+
+// recordRestart adds a restart at the given time and marks the instance as
+// crash looping once its proc's policy is met, which emits EvInsCrashLoop.
+func (i *Instance) recordRestart(at time.Time) error {
+	//
+	//   instances/
+	//       6868/
+	//           ...
+	// -         restart-times = 1374074400
+	// +         restart-times = 1374074400 1374074460
+	// +         crash-loop    = 2013-07-17 15:21 UTC
+	//
+	times, err := getRestartTimes(i.dir)
+	if err != nil {
+		return err
+	}
+	times = append(times, at)
+	if len(times) > maxRestartTimes {
+		times = times[len(times)-maxRestartTimes:]
+	}
+	fields := make([]string, len(times))
+	for n, t := range times {
+		fields[n] = strconv.FormatInt(t.Unix(), 10)
+	}
+	i.dir, err = i.dir.Set(restartTimesPath, strings.Join(fields, " "))
+	if err != nil {
+		return err
+	}
+
+	looping, err := i.isCrashLooping(i.GetSnapshot(), at)
+	if err != nil {
+		return err
+	}
+	exists, _, err := i.GetSnapshot().Exists(i.dir.Prefix(crashLoopPath))
+	if err != nil {
+		return err
+	}
+	switch {
+	case looping && !exists:
+		i.dir, err = i.dir.Set(crashLoopPath, formatTime(at))
+	case !looping && exists:
+		err = i.dir.Del(crashLoopPath)
+	}
+	return err
+}
+
+func (i *Instance) isCrashLooping(sp cp.Snapshot, now time.Time) (bool, error) {
+	attrs, err := getProcAttrsForEnv(i.AppName, i.ProcessName, i.Env, sp)
+	if err != nil {
+		return false, err
+	}
+	policy := attrs.CrashLoop
+	if policy == nil || policy.Restarts < 1 {
+		return false, nil
+	}
+	times, err := getRestartTimes(i.dir.Join(sp))
+	if err != nil {
+		return false, err
+	}
+	since := now.Add(-time.Duration(policy.WindowSec) * time.Second)
+	n := 0
+	for _, t := range times {
+		if !t.Before(since) {
+			n++
+		}
+	}
+	return n >= policy.Restarts, nil
+}
+
+// RequestRestart communicates the intent that the Instance should be
+// restarted in place, e.g. to pick up a changed environment. The runner is
+// expected to restart the process and call Restarted, which clears the
+// request.
+func (i *Instance) RequestRestart(reason string) error {
+	//
+	//   instances/
+	//       6868/
+	//           ...
+	// +         restart-request = 2012-07-19 16:41 UTC <reason>
+	//
+	if err := i.guard.authorize("", ActionRestart, i.auditName()); err != nil {
+		return err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+
+	i, err = i.refresh(sp)
+	if err != nil {
+		return err
+	}
+
+	if i.Status != InsStatusRunning {
+		return errorf(ErrInvalidState, "%s is not running", i)
+	}
+	i.dir, err = i.dir.Set(restartReqPath, timestamp()+" "+reason)
+	return err
+}
+
+// GetRestartRequest returns the pending restart request of the Instance or
+// nil if there is none.
+func (i *Instance) GetRestartRequest() (*InsRestartRequest, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	val, _, err := sp.Get(i.dir.Prefix(restartReqPath))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseRestartRequest(val)
+}
+
 // Stop communicates the intend that the Instance should be stopped.
-func (i *Instance) Stop() error {
+func (i *Instance) Stop() (err error) {
+	defer i.annotate(&err, "stop")
 	//
 	//   instances/
 	//       6868/
 	//           ...
 	// +         stop =
 	//
+	if err := i.guard.authorize("", ActionStop, i.auditName()); err != nil {
+		return err
+	}
 	sp, err := i.GetSnapshot().FastForward()
 	if err != nil {
 		return err
 	}
 
-	i, err = getInstance(i.ID, sp)
+	i, err = i.refresh(sp)
 	if err != nil {
 		return err
 	}
@@ -379,12 +884,12 @@ func (i *Instance) Stop() error {
 	if i.Status != InsStatusRunning {
 		return ErrInvalidState
 	}
-	_, err = i.dir.Set(stopPath, "")
+	i.dir, err = i.dir.Set(stopPath, "")
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return i.indexStatus(InsStatusRunning, InsStatusStopping)
 }
 
 // Failed transitions the instance to failed.
@@ -392,7 +897,8 @@ func (i *Instance) Stop() error {
 // claimed by host.
 // It returns a revision mismatch error if the status is pending, but another
 // caller has already failed this instance.
-func (i *Instance) Failed(host string, reason error) (*Instance, error) {
+func (i *Instance) Failed(host string, reason error) (_ *Instance, err error) {
+	defer i.annotate(&err, "failed")
 	status := i.Status
 
 	if status != InsStatusPending {
@@ -400,19 +906,33 @@ func (i *Instance) Failed(host string, reason error) (*Instance, error) {
 			return nil, err
 		}
 	}
+	if err := i.guard.authorize(host, ActionFail, i.auditName()); err != nil {
+		return nil, err
+	}
+	return i.fail(host, reason)
+}
+
+// fail transitions the instance into failed state on behalf of client
+// without checking the claim.
+func (i *Instance) fail(client string, reason error) (*Instance, error) {
+	status := i.Status
 
 	if _, err := i.updateStatus(InsStatusFailed); err != nil {
 		return nil, err
 	}
-	return i.updateLookup(status, InsStatusFailed, host, reason)
+	return i.updateLookup(status, InsStatusFailed, client, reason)
 }
 
 // Lost transitions the instance into lost state and updates the
 // coordinator with client and reason.
-func (i *Instance) Lost(client string, reason error) (*Instance, error) {
+func (i *Instance) Lost(client string, reason error) (_ *Instance, err error) {
+	defer i.annotate(&err, "lost")
+	if err := i.guard.authorize(client, ActionLose, i.auditName()); err != nil {
+		return nil, err
+	}
 	current := i.Status
 
-	_, err := i.updateStatus(InsStatusLost)
+	_, err = i.updateStatus(InsStatusLost)
 	if err != nil {
 		return nil, err
 	}
@@ -421,14 +941,52 @@ func (i *Instance) Lost(client string, reason error) (*Instance, error) {
 
 // Exited tells the coordinator that the instance has exited.
 func (i *Instance) Exited(host string) (i1 *Instance, err error) {
-	if err = i.verifyClaimer(host); err != nil {
-		return
-	}
-	i1, err = i.updateStatus(InsStatusExited)
-	if err != nil {
+	defer i.annotate(&err, "exited")
+	return i.exited(host, nil)
+}
+
+// ExitedWith tells the coordinator that the instance has exited with the
+// given exit code and whether it was killed for running out of memory. Both
+// are kept in the done record once the instance is unregistered.
+func (i *Instance) ExitedWith(host string, code int, oom bool) (*Instance, error) {
+	return i.exited(host, &InsExit{Code: code, OOM: oom})
+}
+
+func (i *Instance) exited(host string, exit *InsExit) (i1 *Instance, err error) {
+	//
+	//   instances/
+	//       6868/
+	//           ...
+	// +         exit   = 137 true
+	// -         status = running
+	// +         status = exited
+	//
+	if err = i.verifyClaimer(host); err != nil {
+		return
+	}
+	if err = i.guard.authorize(host, ActionExit, i.auditName()); err != nil {
+		return
+	}
+	if exit != nil {
+		i.dir, err = i.dir.Set(exitPath, fmt.Sprintf("%d %t", exit.Code, exit.OOM))
+		if err != nil {
+			return nil, err
+		}
+		i.Exit = exit
+	}
+	i1, err = i.updateStatus(InsStatusExited)
+	if err != nil {
 		return nil, err
 	}
 	err = i.dir.Snapshot.Del(i.procStatusPath(InsStatusExited))
+	if err != nil {
+		return nil, err
+	}
+	err = addToCounter(i.dir.Snapshot, procInstanceCountPath(i.AppName, i.ProcessName), -1)
+	if err != nil {
+		return nil, err
+	}
+	err = i.releaseClaim(ClaimExited)
 
 	return
 }
@@ -443,6 +1001,15 @@ func (i *Instance) WaitStatus() (*Instance, error) {
 		return nil, err
 	}
 	i.Status = InsStatus(string(ev.Body))
+	if ev.IsDel() {
+		// The starting status is removed once the instance started or got
+		// unclaimed.
+		ins, err := getInstance(i.ID, ev)
+		if err != nil {
+			return nil, err
+		}
+		i.Status = ins.Status
+	}
 	i.dir = i.dir.Join(ev)
 
 	return i, nil
@@ -474,16 +1041,7 @@ func (i *Instance) WaitStop() (*Instance, error) {
 
 // WaitExited blocks until the instance exited.
 func (i *Instance) WaitExited() (*Instance, error) {
-	for {
-		i, err := i.WaitStatus()
-		if err != nil {
-			return nil, err
-		}
-		if i.Status == InsStatusExited {
-			break
-		}
-	}
-	return i, nil
+	return i.WaitStatusIn(0, InsStatusExited)
 }
 
 // WaitFailed blocks until the instance failed.
@@ -495,7 +1053,7 @@ func (i *Instance) WaitFailed() (*Instance, error) {
 	}
 
 	ins := &Instance{}
-	if _, err := (&cp.JsonCodec{DecodedVal: ins}).Decode(ev.Body); err != nil {
+	if _, err := decodeCodec(ins).Decode(ev.Body); err != nil {
 		return nil, err
 	}
 	i.Status = ins.Status
@@ -507,16 +1065,66 @@ func (i *Instance) WaitFailed() (*Instance, error) {
 
 // WaitLost blocks until the instance is lost.
 func (i *Instance) WaitLost() (*Instance, error) {
+	return i.WaitStatusIn(0, InsStatusLost)
+}
+
+// WaitStatusIn blocks until the Instance is in any of the given statuses and
+// returns it, starting from the snapshot of the Instance. The instance is
+// only read when one of its files changed. A zero timeout waits forever,
+// otherwise an error of kind ErrTimeout is returned once it passed.
+func (i *Instance) WaitStatusIn(timeout time.Duration, statuses ...InsStatus) (*Instance, error) {
+	type result struct {
+		ins *Instance
+		err error
+	}
+	var (
+		resc     = make(chan result, 1)
+		stop     = make(chan struct{})
+		timeoutc <-chan time.Time
+	)
+	go func() {
+		ins, err := i.waitStatusIn(stop, statuses)
+		resc <- result{ins, err}
+	}()
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutc = timer.C
+	}
+
+	select {
+	case r := <-resc:
+		return r.ins, r.err
+	case <-timeoutc:
+		// The waiter exits with the next change of the instance.
+		close(stop)
+		return nil, errorf(ErrTimeout, "%s not in %v after %s", i, statuses, timeout)
+	}
+}
+
+func (i *Instance) waitStatusIn(stop chan struct{}, statuses []InsStatus) (*Instance, error) {
+	sp := i.GetSnapshot()
 	for {
-		i, err := i.WaitStatus()
+		ins, err := i.refresh(sp)
 		if err != nil {
 			return nil, err
 		}
-		if i.Status == InsStatusLost {
-			break
+		for _, s := range statuses {
+			if ins.Status == s {
+				return ins, nil
+			}
 		}
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+		ev, err := sp.Wait(path.Join(instancePath(i.ID), "*"))
+		if err != nil {
+			return nil, err
+		}
+		sp = sp.Join(ev)
 	}
-	return i, nil
 }
 
 // WaitUnregister blocks until the instance is unregistered.
@@ -545,6 +1153,9 @@ func (i *Instance) GetStatusInfo() (string, error) {
 
 // Lock sets the lock path to the given client and reason.
 func (i *Instance) Lock(client string, reason error) (*Instance, error) {
+	if err := i.guard.authorize(client, ActionLock, i.auditName()); err != nil {
+		return nil, err
+	}
 	locked, err := i.IsLocked()
 	if err != nil {
 		return nil, err
@@ -563,6 +1174,9 @@ func (i *Instance) Lock(client string, reason error) (*Instance, error) {
 
 // Unlock removes the instance lock path.
 func (i *Instance) Unlock() (*Instance, error) {
+	if err := i.guard.authorize("", ActionLock, i.auditName()); err != nil {
+		return nil, err
+	}
 	err := i.dir.Del(lockPath)
 	if err != nil {
 		return nil, err
@@ -613,7 +1227,7 @@ func (i *Instance) RevString() string {
 
 // RefString returns the cannonical string representation of an instance.
 func (i *Instance) RefString() string {
-	return fmt.Sprintf("%s:%s@%s#%s", i.AppName, i.ProcessName, i.RevisionName, i.Env)
+	return i.Ref().String()
 }
 
 // ServiceName returns the cannonical string representation of an instance
@@ -636,7 +1250,7 @@ func (i *Instance) Fields() string {
 
 // String returns the Go-syntax representation of Instance.
 func (i *Instance) String() string {
-	return fmt.Sprintf("Instance{id=%d, app=%s, rev=%s, proc=%s, env=%s, addr=%s:%d}", i.ID, i.AppName, i.RevisionName, i.ProcessName, i.Env, i.IP, i.Port)
+	return fmt.Sprintf("Instance{id=%d, app=%s, rev=%s, proc=%s, env=%s, addr=%s}", i.ID, i.AppName, i.RevisionName, i.ProcessName, i.Env, i.Addr())
 }
 
 // IDString returns a string of the format "INSTANCE[id]"
@@ -652,24 +1266,52 @@ func (i *Instance) claimDir() *cp.Dir {
 	return cp.NewDir(i.dir.Prefix(claimsPath), i.GetSnapshot())
 }
 
+// refresh fetches the instance again from the given snapshot.
+func (i *Instance) refresh(sp cp.Snapshot) (*Instance, error) {
+	ins, err := getInstance(i.ID, sp)
+	if err != nil {
+		return nil, err
+	}
+	ins.guard, ins.closer, ins.reads = i.guard, i.closer, i.reads
+	return ins, nil
+}
+
+func (i *Instance) auditName() string {
+	return "instance:" + i.idString()
+}
+
+func (i *Instance) annotate(err *error, op string) {
+	annotate(err, op, i.auditName(), i.dir.Name, i.dir.Snapshot.Rev)
+}
+
 func (i *Instance) idString() string {
 	return fmt.Sprintf("%d", i.ID)
 }
 
-func (i *Instance) objectArray() []string {
-	return []string{i.AppName, i.RevisionName, i.ProcessName, i.Env}
+func (i *Instance) object() *insObject {
+	return &insObject{
+		Version:        insObjectVersion,
+		App:            i.AppName,
+		Rev:            i.RevisionName,
+		Proc:           i.ProcessName,
+		Env:            i.Env,
+		HostConstraint: i.HostConstraint,
+		Priority:       i.Priority,
+	}
 }
 
-func (i *Instance) startArray() []string {
-	return []string{i.IP, i.portString(), i.Host, i.telePortString()}
+func (i *Instance) startRecord() *startRecord {
+	return &startRecord{IP: i.IP, Port: i.Port, Host: i.Host, TelePort: i.TelePort}
 }
 
-func (i *Instance) portString() string {
-	return fmt.Sprintf("%d", i.Port)
+// Addr returns the address the instance serves on.
+func (i *Instance) Addr() Addr {
+	return Addr{Host: i.IP, Port: i.Port}
 }
 
-func (i *Instance) telePortString() string {
-	return fmt.Sprintf("%d", i.TelePort)
+// TeleAddr returns the address the instance serves its telemetry on.
+func (i *Instance) TeleAddr() Addr {
+	return Addr{Host: i.IP, Port: i.TelePort}
 }
 
 func (i *Instance) procDonePath() string {
@@ -718,6 +1360,10 @@ func (i *Instance) started(ip, host string, port, telePort int) {
 }
 
 func (i *Instance) updateStatus(s InsStatus) (*Instance, error) {
+	from, err := i.storedStatus()
+	if err != nil {
+		return nil, err
+	}
 	d, err := i.dir.Set("status", string(s))
 	if err != nil {
 		return nil, err
@@ -725,25 +1371,87 @@ func (i *Instance) updateStatus(s InsStatus) (*Instance, error) {
 	i.Status = s
 	i.dir = d
 
+	if err := i.indexStatus(from, s); err != nil {
+		return nil, err
+	}
+
 	return i, nil
 }
 
+// storedStatus reads the status of the instance from the coordinator, which
+// the status the instance was loaded with may lag behind.
+func (i *Instance) storedStatus() (InsStatus, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return "", err
+	}
+	current, err := getInstance(i.ID, sp)
+	if err != nil {
+		return "", err
+	}
+	return current.Status, nil
+}
+
+// indexStatus moves the instance in the status index from one status to
+// another. An empty status skips the respective step.
+func (i *Instance) indexStatus(from, to InsStatus) error {
+	if from != "" && from != to {
+		err := i.GetSnapshot().Del(statusIndexPath(from, i.ID))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return err
+		}
+	}
+	if to == "" {
+		return nil
+	}
+	sp, err := i.GetSnapshot().Set(statusIndexPath(to, i.ID), timestamp())
+	if err != nil {
+		return err
+	}
+	i.dir = i.dir.Join(sp)
+	return nil
+}
+
+// clearStarting removes the starting status, which is superseded by the
+// start file.
+func (i *Instance) clearStarting() error {
+	status, _, err := i.GetSnapshot().Get(i.dir.Prefix(statusPath))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil
+		}
+		return err
+	}
+	if InsStatus(status) != InsStatusStarting {
+		return nil
+	}
+	if err := i.dir.Del(statusPath); err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	i.dir = i.dir.Join(sp)
+	return nil
+}
+
 func (i *Instance) getClaimer() (*string, error) {
 	sp, err := i.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
 	}
 	i.dir = i.dir.Join(sp)
-	f, err := sp.GetFile(i.dir.Prefix(startPath), new(cp.ListCodec))
+	f, err := sp.GetFile(i.dir.Prefix(startPath), new(startCodec))
 	if err != nil {
 		return nil, err
 	}
-	fields := f.Value.([]string)
+	r := f.Value.(*startRecord)
 
-	if len(fields) == 0 {
+	if r.IP == "" {
 		return nil, nil
 	}
-	return &fields[0], nil
+	return &r.IP, nil
 }
 
 func (i *Instance) setClaimer(claimer string) (*cp.Dir, error) {
@@ -799,6 +1507,25 @@ func (i *Instance) updateLookup(
 		return nil, err
 	}
 
+	if i.Exit == nil {
+		i.Exit, err = getExit(i.dir.Join(sp))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if i.Runtime == nil {
+		i.Runtime, err = getRuntimeInfo(i.dir.Join(sp))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if i.Endpoints == nil {
+		i.Endpoints, err = getEndpoints(i.dir.Join(sp))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if from == InsStatusFailed || from == InsStatusLost {
 		ins, err := getSerialisedInstance(i.AppName, i.ProcessName, i.ID, from, sp)
 		if err != nil {
@@ -808,7 +1535,11 @@ func (i *Instance) updateLookup(
 		i.Termination = ins.Termination
 	}
 
-	f := cp.NewFile(sp.Prefix(i.procStatusPath(to)), i, new(cp.JsonCodec), sp)
+	codec, err := encodeCodec(sp, CodecInstances)
+	if err != nil {
+		return nil, err
+	}
+	f := cp.NewFile(sp.Prefix(i.procStatusPath(to)), i, codec, sp)
 	f, err = f.Save()
 	if err != nil {
 		return nil, err
@@ -821,6 +1552,37 @@ func (i *Instance) updateLookup(
 		return nil, err
 	}
 
+	// Exited instances were already uncounted when their lookup was removed.
+	if from != InsStatusExited && i.procStatusPath(from) == i.procInstancesPath() &&
+		i.procStatusPath(to) != i.procInstancesPath() {
+		err = addToCounter(i.dir.Snapshot, procInstanceCountPath(i.AppName, i.ProcessName), -1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = countTermination(i.dir.Snapshot, i.AppName, i.ProcessName, to, i.Termination.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == InsStatusFailed {
+		p := failedDayPath(i.AppName, i.ProcessName, i.Termination.Time, i.ID)
+		if _, err = i.dir.Snapshot.Set(p, formatTime(i.Termination.Time)); err != nil {
+			return nil, err
+		}
+	}
+
+	switch to {
+	case InsStatusFailed:
+		err = i.releaseClaim(ClaimFailed)
+	case InsStatusLost:
+		err = i.releaseClaim(ClaimLost)
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	return i, nil
 }
 
@@ -832,26 +1594,15 @@ func (i *Instance) waitStartPath() (*Instance, error) {
 		return nil, err
 	}
 	i.dir = i.dir.Join(ev)
-	parts, err := new(cp.ListCodec).Decode(ev.Body)
+	v, err := new(startCodec).Decode(ev.Body)
 	if err != nil {
 		return nil, err
 	}
-	fields := parts.([]string)
-	if len(fields) >= 3 {
-		ip, host := fields[0], fields[2]
-		port, err := strconv.Atoi(fields[1])
-		if err != nil {
-			return nil, err
-		}
-
-		telePort, err := strconv.Atoi(fields[3])
-		if err != nil {
-			return nil, err
-		}
-
-		i.started(ip, host, port, telePort)
-	} else if len(fields) > 0 {
-		i.claimed(fields[0])
+	r := v.(*startRecord)
+	if r.started() {
+		i.started(r.IP, r.Host, r.Port, r.TelePort)
+	} else if r.IP != "" {
+		i.claimed(r.IP)
 	} else {
 		// TODO
 	}
@@ -873,7 +1624,7 @@ func (i *Instance) waitStartPathStatus(s InsStatus) (i1 *Instance, err error) {
 
 // GetInstances returns all existing instances.
 func (s *Store) GetInstances() ([]*Instance, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
@@ -883,12 +1634,12 @@ func (s *Store) GetInstances() ([]*Instance, error) {
 	}
 
 	instances := []*Instance{}
-	ch, errch := cp.GetSnapshotables(ids, func(idstr string) (cp.Snapshotable, error) {
+	ch, errch := getSnapshotables(s.reads, ids, func(idstr string) (cp.Snapshotable, error) {
 		id, err := parseInstanceID(idstr)
 		if err != nil {
 			return nil, err
 		}
-		return getInstance(id, sp)
+		return getInstance(id, s.join(sp))
 	})
 	errStr := ""
 	for i := 0; i < len(ids); i++ {
@@ -908,19 +1659,158 @@ func (s *Store) GetInstances() ([]*Instance, error) {
 
 // GetLostInstances returns all existing instances in lost state.
 func (s *Store) GetLostInstances() ([]*Instance, error) {
-	is, err := s.GetInstances()
+	return s.GetInstancesByStatus(InsStatusLost)
+}
+
+// GetPendingInstances returns all pending instances ordered by priority,
+// highest first. Instances of the same priority are in order of
+// registration.
+func (s *Store) GetPendingInstances() ([]*Instance, error) {
+	instances, err := s.GetInstancesByStatus(InsStatusPending)
 	if err != nil {
 		return nil, err
 	}
+	sort.Sort(instancesByPriority(instances))
+	return instances, nil
+}
 
-	ls := []*Instance{}
-	for _, i := range is {
-		if i.Status == InsStatusLost {
-			ls = append(ls, i)
+// ClaimNext claims the pending instance with the highest priority the host
+// is allowed to claim. Instances claimed by another host, offered to another
+// host or done in the meantime are skipped, denials of the Authorizer are
+// returned. It fails with ErrNotFound if there is none left.
+func (s *Store) ClaimNext(host string) (*Instance, error) {
+	instances, err := s.GetPendingInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, ins := range instances {
+		if ins.HostConstraint != "" && ins.HostConstraint != host {
+			continue
+		}
+		done, err := ins.IsDone()
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			continue
 		}
+		if err := ins.checkOffer(host); err != nil {
+			if IsErrUnauthorized(err) {
+				continue
+			}
+			return nil, err
+		}
+		claimed, err := ins.Claim(host)
+		if err != nil {
+			if IsErrInsClaimed(err) {
+				continue
+			}
+			return nil, err
+		}
+		return claimed, nil
+	}
+	return nil, errorf(ErrNotFound, "no pending instance to claim for %s", host)
+}
+
+// GetInstancesByStatus returns all existing instances in the given status.
+// Only the IDs in the status index are read, which is maintained by the
+// instance state transitions. Trees without an index, which Init backfills,
+// are scanned. As done instances are removed from the tree they can't be
+// listed, use Proc.GetDoneInstances instead.
+func (s *Store) GetInstancesByStatus(status InsStatus) ([]*Instance, error) {
+	if status == InsStatusDone {
+		return nil, errorf(ErrInvalidArgument, "done instances are not indexed")
 	}
 
-	return ls, nil
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	indexed, _, err := sp.Exists(statusIdxPath)
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		return s.join(sp).scanInstancesByStatus(status)
+	}
+	ids, err := sp.Getdir(path.Join(statusIdxPath, string(status)))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*Instance{}, nil
+		}
+		return nil, err
+	}
+
+	ch, errch := getSnapshotables(s.reads, ids, func(idstr string) (cp.Snapshotable, error) {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, err
+		}
+		return getInstance(id, s.join(sp))
+	})
+	instances := []*Instance{}
+	for i := 0; i < len(ids); i++ {
+		select {
+		case r := <-ch:
+			// Guard against index entries lagging behind a transition.
+			if ins := r.(*Instance); ins.Status == status {
+				instances = append(instances, ins)
+			}
+		case err := <-errch:
+			if !IsErrNotFound(err) {
+				return nil, err
+			}
+		}
+	}
+	return instances, nil
+}
+
+func (s *Store) scanInstancesByStatus(status InsStatus) ([]*Instance, error) {
+	all, err := s.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+	instances := []*Instance{}
+	for _, ins := range all {
+		if ins.Status == status {
+			instances = append(instances, ins)
+		}
+	}
+	return instances, nil
+}
+
+// indexInstanceStatuses backfills the status index of trees written before
+// it was maintained.
+func indexInstanceStatuses(sp cp.Snapshot) (cp.Snapshot, error) {
+	indexed, _, err := sp.Exists(statusIdxPath)
+	if err != nil || indexed {
+		return sp, err
+	}
+	ids, err := getdirOrEmpty(sp, instancesPath)
+	if err != nil {
+		return sp, err
+	}
+	for _, idstr := range ids {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return sp, err
+		}
+		ins, err := getInstance(id, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return sp, err
+		}
+		if ins.Status == InsStatusDone {
+			continue
+		}
+		sp, err = sp.Set(statusIndexPath(ins.Status, id), timestamp())
+		if err != nil {
+			return sp, err
+		}
+	}
+	return sp, nil
 }
 
 // WatchInstanceStart sends Instance over the given listener channel which
@@ -930,23 +1820,231 @@ func (s *Store) GetLostInstances() ([]*Instance, error) {
 func (s *Store) WatchInstanceStart(listener chan *Instance, errors chan error) {
 	eventc := make(chan *Event)
 	go func() {
-		for {
-			listener <- (<-eventc).Source.(*Instance)
+		defer close(listener)
+		for e := range eventc {
+			ins, ok := e.Source.(*Instance)
+			if !ok {
+				continue
+			}
+			select {
+			case listener <- ins:
+			case <-s.closed():
+				return
+			}
 		}
 	}()
 	if err := s.WatchEvent(eventc, EvInsReg, EvInsUnclaim); err != nil {
-		errors <- err
+		s.sendErr(errors, err)
 	}
 }
 
+// markReplaced links the instance with the given id to its replacement i.
+// Instances which are already unregistered are left alone.
+func (i *Instance) markReplaced(id int64) error {
+	//
+	//   instances/
+	//       6868/
+	// +         replaced-by = 6869
+	//
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	old, err := getInstance(id, sp)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if old.dir, err = old.dir.Set(replacedByPath, i.idString()); err != nil {
+		return err
+	}
+	old.ReplacedBy = i.ID
+
+	// Terminated instances are also kept serialised in their lookup.
+	if old.Status != InsStatusFailed && old.Status != InsStatusLost {
+		return nil
+	}
+	sp, err = old.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	exists, _, err := sp.Exists(old.procStatusPath(old.Status))
+	if err != nil || !exists {
+		return err
+	}
+	record, err := getSerialisedInstance(old.AppName, old.ProcessName, old.ID, old.Status, sp)
+	if err != nil {
+		return err
+	}
+	record.ReplacedBy = i.ID
+	codec, err := encodeCodec(sp, CodecInstances)
+	if err != nil {
+		return err
+	}
+	_, err = cp.NewFile(old.procStatusPath(old.Status), record, codec, sp).Save()
+	return err
+}
+
+func getLineage(d *cp.Dir, name string) (int64, error) {
+	val, _, err := d.Get(name)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	id, err := parseInstanceID(val)
+	if err != nil {
+		return 0, errorf(ErrInvalidFile, "invalid instance id %q in %s", val, name)
+	}
+	return id, nil
+}
+
+func getRestartTimes(d *cp.Dir) ([]time.Time, error) {
+	f, err := d.GetFile(restartTimesPath, new(cp.ListCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []time.Time{}, nil
+		}
+		return nil, err
+	}
+	times := []time.Time{}
+	for _, field := range f.Value.([]string) {
+		sec, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errorf(ErrInvalidFile, "invalid restart time %q", field)
+		}
+		times = append(times, time.Unix(sec, 0).UTC())
+	}
+	return times, nil
+}
+
+func getProcAttrs(app, proc string, sp cp.Snapshot) (ProcAttrs, error) {
+	var attrs ProcAttrs
+	p := path.Join(appsPath, app, procsPath, proc, procsAttrsPath)
+	_, err := sp.GetFile(p, decodeCodec(&attrs))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return attrs, err
+	}
+	return attrs, nil
+}
+
+// getProcAttrsForEnv reads the attrs of the proc for the given env, falling
+// back to the proc-wide attrs.
+func getProcAttrsForEnv(app, proc, env string, sp cp.Snapshot) (ProcAttrs, error) {
+	if env != "" {
+		var attrs ProcAttrs
+		p := path.Join(appsPath, app, procsPath, proc, procsEnvAttrsPath, env)
+		_, err := sp.GetFile(p, decodeCodec(&attrs))
+		if err == nil {
+			return attrs, nil
+		}
+		if !cp.IsErrNoEnt(err) {
+			return attrs, err
+		}
+	}
+	return getProcAttrs(app, proc, sp)
+}
+
+// getObject reads the object file of the instance with the given id.
+func getObject(id int64, sp cp.Snapshot) (*insObject, error) {
+	val, _, err := sp.Get(path.Join(instancePath(id), objectPath))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil, errorf(ErrNotFound, "object file not found for instance %d", id)
+		}
+		return nil, err
+	}
+	return parseObject(id, val)
+}
+
+func parseObject(id int64, val string) (*insObject, error) {
+	obj := &insObject{}
+	if strings.HasPrefix(val, "{") {
+		if err := json.Unmarshal([]byte(val), obj); err != nil {
+			return nil, errorf(ErrInvalidFile, "object file for %d is invalid: %w", id, err)
+		}
+		if obj.Version > insObjectVersion {
+			return nil, errorf(ErrInvalidFile, "object file for %d has unsupported version %d", id, obj.Version)
+		}
+		return obj, nil
+	}
+
+	// Legacy list of positional fields.
+	fields := strings.Fields(val)
+	if len(fields) < 3 {
+		return nil, errorf(ErrInvalidFile, "object file for %d has %d instead %d fields", id, len(fields), 3)
+	}
+	obj.App, obj.Rev, obj.Proc = fields[0], fields[1], fields[2]
+	if len(fields) > 3 {
+		obj.Env = fields[3]
+	}
+	return obj, nil
+}
+
+func getExit(d *cp.Dir) (*InsExit, error) {
+	f, err := d.GetFile(exitPath, new(cp.ListCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	fields := f.Value.([]string)
+	if len(fields) != 2 {
+		return nil, errorf(ErrInvalidFile, "exit file has %d instead of 2 fields", len(fields))
+	}
+	exit := &InsExit{}
+	if exit.Code, err = strconv.Atoi(fields[0]); err != nil {
+		return nil, errorf(ErrInvalidFile, "invalid exit code %q", fields[0])
+	}
+	if exit.OOM, err = strconv.ParseBool(fields[1]); err != nil {
+		return nil, errorf(ErrInvalidFile, "invalid oom flag %q", fields[1])
+	}
+	return exit, nil
+}
+
+func parseRestartRequest(val string) (*InsRestartRequest, error) {
+	parts := strings.SplitN(val, " ", 2)
+	t, err := parseTime(parts[0])
+	if err != nil {
+		return nil, errorf(ErrInvalidFile, "invalid restart request %q", val)
+	}
+	req := &InsRestartRequest{Time: t}
+	if len(parts) > 1 {
+		req.Reason = parts[1]
+	}
+	return req, nil
+}
+
 func instancePath(id int64) string {
 	return path.Join(instancesPath, strconv.FormatInt(id, 10))
 }
 
+func statusIndexPath(status InsStatus, id int64) string {
+	return path.Join(statusIdxPath, string(status), strconv.FormatInt(id, 10))
+}
+
 func procInstancesPath(app, rev, proc string) string {
 	return path.Join(appsPath, app, procsPath, proc, instancesPath, rev)
 }
 
+// failedDayPath is the entry of a failed instance in the index of failures
+// bucketed by UTC day.
+func failedDayPath(app, proc string, t time.Time, id int64) string {
+	return path.Join(appsPath, app, procsPath, proc, failedDaysPath, formatDay(t), strconv.FormatInt(id, 10))
+}
+
+func formatDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func procInstanceCountPath(app, proc string) string {
+	return path.Join(appsPath, app, procsPath, proc, procsInstanceCountPath)
+}
+
 func parseInstanceID(idstr string) (int64, error) {
 	return strconv.ParseInt(idstr, 10, 64)
 }
@@ -956,6 +2054,9 @@ func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 		ID:     id,
 		Status: InsStatusPending,
 		dir:    cp.NewDir(instancePath(id), s.GetSnapshot()),
+		guard:  guardOf(s),
+		closer: closerOf(s),
+		reads:  readLimitOf(s),
 	}
 
 	exists, _, err := s.GetSnapshot().Exists(i.dir.Name)
@@ -966,33 +2067,17 @@ func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 		return nil, errorf(ErrNotFound, `instance '%d' not found`, id)
 	}
 
-	f, err := i.dir.GetFile(startPath, new(cp.ListCodec))
+	f, err := i.dir.GetFile(startPath, new(startCodec))
 	if cp.IsErrNoEnt(err) {
 		// Ignore
 	} else if err != nil {
 		return nil, err
 	} else {
-		fields := f.Value.([]string)
-
-		if len(fields) > 0 { // IP
-			i.Status = InsStatusClaimed
-			i.IP = fields[0]
-		}
-		if len(fields) > 1 { // Port
-			i.Status = InsStatusRunning
-			i.Port, err = strconv.Atoi(fields[1])
-			if err != nil {
-				return nil, errorf(ErrInvalidPort, "invalid port: " + fields[1])
-			}
-		}
-		if len(fields) > 2 { // Hostname
-			i.Host = fields[2]
-		}
-		if len(fields) > 3 { // TelePort
-			i.TelePort, err = strconv.Atoi(fields[3])
-			if err != nil {
-				return nil, errorf(ErrInvalidPort, "invalid teleport: " + fields[3])
-			}
+		r := f.Value.(*startRecord)
+		if r.started() {
+			i.started(r.IP, r.Host, r.Port, r.TelePort)
+		} else if r.IP != "" {
+			i.claimed(r.IP)
 		}
 	}
 
@@ -1000,7 +2085,10 @@ func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 	if cp.IsErrNoEnt(err) {
 		err = nil
 	} else if err == nil {
-		i.Status = InsStatus(statusStr)
+		// A started instance may not have cleared its starting status yet.
+		if status := InsStatus(statusStr); status != InsStatusStarting || i.Status != InsStatusRunning {
+			i.Status = status
+		}
 	} else {
 		return nil, err
 	}
@@ -1014,31 +2102,71 @@ func getInstance(id int64, s cp.Snapshotable) (*Instance, error) {
 		}
 	}
 
-	f, err = i.dir.GetFile(objectPath, new(cp.ListCodec))
+	obj, err := getObject(id, i.GetSnapshot())
 	if err != nil {
-		return nil, errorf(ErrNotFound, "object file not found for instance %d", id)
+		return nil, err
 	}
+	i.AppName = obj.App
+	i.RevisionName = obj.Rev
+	i.ProcessName = obj.Proc
+	i.Env = obj.Env
+	i.HostConstraint = obj.HostConstraint
+	i.Priority = obj.Priority
 
-	fields := f.Value.([]string)
-	if len(fields) < 3 {
-		return nil, errorf(ErrInvalidFile, "object file for %d has %d instead %d fields", id, len(fields), 3)
+	i.Restarts, _, err = i.getRestarts()
+	if err != nil {
+		return nil, err
+	}
+
+	i.Labels, err = getLabels(i.dir)
+	if err != nil {
+		return nil, err
 	}
 
-	i.AppName = fields[0]
-	i.RevisionName = fields[1]
-	i.ProcessName = fields[2]
-	i.Env = fields[3]
+	i.Exit, err = getExit(i.dir)
+	if err != nil {
+		return nil, err
+	}
 
-	i.Restarts, _, err = i.getRestarts()
+	i.Runtime, err = getRuntimeInfo(i.dir)
 	if err != nil {
 		return nil, err
 	}
 
+	i.Endpoints, err = getEndpoints(i.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	i.Health, err = getHealth(i.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	i.ReadyAt, err = getReadyAt(i.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	i.Zone, _, err = i.dir.Get(zonePath)
+	if cp.IsErrNoEnt(err) {
+		err = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if i.Replaces, err = getLineage(i.dir, replacesPath); err != nil {
+		return nil, err
+	}
+	if i.ReplacedBy, err = getLineage(i.dir, replacedByPath); err != nil {
+		return nil, err
+	}
+
 	f, err = i.dir.GetFile(registeredPath, new(cp.StringCodec))
 	if err != nil {
 		return nil, err
 	}
-	i.Registered, err = parseTime(f.Value.(string))
+	i.Registered, i.RegisteredBy, err = parseRegistered(f.Value.(string))
 	if err != nil {
 		return nil, err
 	}
@@ -209,6 +209,60 @@ func TestInstanceClaiming(t *testing.T) {
 	}
 }
 
+func TestInstanceClaimHistory(t *testing.T) {
+	hostA := "10.0.0.1"
+	hostB := "10.0.0.2"
+	s := instanceSetup()
+
+	ins, err := s.RegisterInstance("bat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.Claim(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.Unclaim(hostA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.Claim(hostB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := ins.ClaimHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 claims, got %d", len(history))
+	}
+	if history[1].Host != hostB || history[1].Outcome != ClaimHeld || !history[1].Released.IsZero() {
+		t.Errorf("expected held claim by %s, got %#v", hostB, history[1])
+	}
+
+	if _, err := ins.Failed(hostB, errors.New("no space left")); err != nil {
+		t.Fatal(err)
+	}
+	history, err = ins.ClaimHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range []struct {
+		host    string
+		outcome ClaimOutcome
+	}{{hostA, ClaimUnclaimed}, {hostB, ClaimFailed}} {
+		r := history[n]
+		if r.Host != want.host || r.Outcome != want.outcome {
+			t.Errorf("expected claim %d by %s to be %s, got %#v", n, want.host, want.outcome, r)
+		}
+		if r.Released.Before(r.Claimed) {
+			t.Errorf("expected claim %d to be released after it was claimed, got %#v", n, r)
+		}
+	}
+}
+
 func TestInstanceStarted(t *testing.T) {
 	app := "fat"
 	rev := "128af9"
@@ -267,6 +321,26 @@ func TestInstanceStarted(t *testing.T) {
 	}
 }
 
+func TestInstanceStartedInZone(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("zone-cat", ip)
+
+	if _, err := ins.StartedInZone(ip, "box1.zone-cat.com", 9999, 10000, "eu/1"); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid zone to be rejected, got %v", err)
+	}
+	ins, err := ins.StartedInZone(ip, "box1.zone-cat.com", 9999, 10000, "eu-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusRunning || ins.Zone != "eu-1" {
+		t.Errorf("expected instance running in eu-1, got %s in %q", ins.Status, ins.Zone)
+	}
+}
+
 func TestInstanceStop(t *testing.T) {
 	ip := "10.0.0.1"
 	s := instanceSetup()
@@ -337,6 +411,263 @@ func TestInstanceExited(t *testing.T) {
 	testInstanceStatus(s, t, ins.ID, InsStatusExited)
 }
 
+func TestInstanceExitedWith(t *testing.T) {
+	s := instanceSetup()
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("exit-cat", ip)
+
+	ins, err := ins.Started(ip, "exit-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins, err = ins.ExitedWith(ip, 137, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins1, err := s.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins1.Exit == nil || ins1.Exit.Code != 137 || !ins1.Exit.OOM {
+		t.Errorf("expected exit code 137 with oom, got %#v", ins1.Exit)
+	}
+
+	if err := ins1.Unregister("test-bot", fmt.Errorf("exited")); err != nil {
+		t.Fatal(err)
+	}
+	done, err := s.GetSerialisedInstance(ins.AppName, ins.ProcessName, ins.ID, InsStatusDone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Exit == nil || done.Exit.Code != 137 || !done.Exit.OOM {
+		t.Errorf("expected exit in done record, got %#v", done.Exit)
+	}
+}
+
+func TestInstanceStarting(t *testing.T) {
+	s := instanceSetup()
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("slow-cat", ip)
+
+	if _, err := ins.Starting("10.0.0.2"); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	ins, err := ins.Starting(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testInstanceStatus(s, t, ins.ID, InsStatusStarting)
+
+	starting, err := s.GetInstancesByStatus(InsStatusStarting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starting) != 1 || starting[0].ID != ins.ID {
+		t.Errorf("expected instance in starting index, got %v", starting)
+	}
+
+	ins, err = ins.Started(ip, "slow-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testInstanceStatus(s, t, ins.ID, InsStatusRunning)
+
+	starting, err = s.GetInstancesByStatus(InsStatusStarting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starting) != 0 {
+		t.Errorf("expected starting index to be empty, got %v", starting)
+	}
+}
+
+func TestInstanceWaitStatusIn(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("wait-cat", ip)
+
+	if _, err := ins.WaitStatusIn(100*time.Millisecond, InsStatusRunning); !IsErrTimeout(err) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+
+	ins1, err := ins.WaitStatusIn(0, InsStatusClaimed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins1.Status != InsStatusClaimed {
+		t.Errorf("expected claimed instance, got %s", ins1.Status)
+	}
+
+	go func() {
+		started, err := ins.Started(ip, "wait-cat.com", 9999, 10000)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := started.Exited(ip); err != nil {
+			panic(err)
+		}
+	}()
+
+	ins1, err = ins1.WaitStatusIn(time.Second, InsStatusExited, InsStatusFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins1.Status != InsStatusExited {
+		t.Errorf("expected exited instance, got %s", ins1.Status)
+	}
+}
+
+func TestRegisterInstanceReplacing(t *testing.T) {
+	s := instanceSetup()
+	ip := "10.0.0.1"
+	old := instanceSetupClaimed("lineage-cat", ip)
+
+	old, err := old.Failed(ip, errors.New("host died"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RegisterInstanceReplacing(0, "lineage-cat", "128af9", "web", "default"); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	ins, err := s.RegisterInstanceReplacing(old.ID, "lineage-cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Replaces != old.ID {
+		t.Errorf("expected %d to replace %d, got %d", ins.ID, old.ID, ins.Replaces)
+	}
+
+	ins1, err := s.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins1.Replaces != old.ID {
+		t.Errorf("expected stored replaces %d, got %d", old.ID, ins1.Replaces)
+	}
+	old1, err := s.GetInstance(old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old1.ReplacedBy != ins.ID {
+		t.Errorf("expected %d to be replaced by %d, got %d", old.ID, ins.ID, old1.ReplacedBy)
+	}
+	record, err := s.GetSerialisedInstance(old.AppName, old.ProcessName, old.ID, InsStatusFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ReplacedBy != ins.ID {
+		t.Errorf("expected serialised record to be replaced by %d, got %d", ins.ID, record.ReplacedBy)
+	}
+}
+
+func TestRegisterInstanceWithOpts(t *testing.T) {
+	s := instanceSetup()
+	ins, err := s.RegisterInstanceWithOpts(InstanceOpts{
+		App:            "opts-cat",
+		Rev:            "128af9",
+		Proc:           "web",
+		Env:            "default",
+		Labels:         map[string]string{"team": "cats"},
+		HostConstraint: "10.0.0.2",
+		Priority:       5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins1, err := s.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins1.AppName != "opts-cat" || ins1.Env != "default" {
+		t.Errorf("expected object fields to be stored, got %#v", ins1)
+	}
+	if ins1.HostConstraint != "10.0.0.2" {
+		t.Errorf("expected host constraint 10.0.0.2, got %q", ins1.HostConstraint)
+	}
+	if ins1.Priority != 5 {
+		t.Errorf("expected priority 5, got %d", ins1.Priority)
+	}
+	if ins1.Labels["team"] != "cats" {
+		t.Errorf("expected label team=cats, got %v", ins1.Labels)
+	}
+
+	if _, err := ins1.Claim("10.0.0.1"); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	if _, err := ins1.Claim("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RegisterInstanceWithOpts(InstanceOpts{
+		App:    "opts-cat",
+		Rev:    "128af9",
+		Proc:   "web",
+		Env:    "default",
+		Labels: map[string]string{"": "x"},
+	})
+	if !IsErrInvalidKey(err) {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+}
+
+func TestPendingInstancesByPriority(t *testing.T) {
+	s := instanceSetup()
+	register := func(priority int, host string) *Instance {
+		ins, err := s.RegisterInstanceWithOpts(InstanceOpts{
+			App:            "queue-cat",
+			Rev:            "128af9",
+			Proc:           "web",
+			Env:            "default",
+			Priority:       priority,
+			HostConstraint: host,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ins
+	}
+	low := register(0, "")
+	high := register(10, "")
+	pinned := register(20, "10.0.0.2")
+	mid := register(5, "")
+
+	pending, err := s.GetPendingInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int64{pinned.ID, high.ID, mid.ID, low.ID}
+	if len(pending) != len(expected) {
+		t.Fatalf("expected %d pending instances, got %d", len(expected), len(pending))
+	}
+	for i, id := range expected {
+		if pending[i].ID != id {
+			t.Errorf("expected instance %d at %d, got %d", id, i, pending[i].ID)
+		}
+	}
+
+	for _, id := range []int64{high.ID, mid.ID, low.ID} {
+		ins, err := s.ClaimNext("10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ins.ID != id {
+			t.Errorf("expected to claim %d, got %d", id, ins.ID)
+		}
+	}
+	if _, err := s.ClaimNext("10.0.0.1"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	ins, err := s.ClaimNext("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.ID != pinned.ID {
+		t.Errorf("expected to claim %d, got %d", pinned.ID, ins.ID)
+	}
+}
+
 func TestInstanceRestarted(t *testing.T) {
 	ip := "10.0.0.1"
 	ins := instanceSetupClaimed("fat-pat", ip)
@@ -379,6 +710,156 @@ func TestInstanceRestarted(t *testing.T) {
 	}
 }
 
+func TestInstanceRequestRestart(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("fat-pat", ip)
+
+	if err := ins.RequestRestart("env changed"); !IsErrInvalidState(err) {
+		t.Errorf("expected invalid state error for claimed instance, got %v", err)
+	}
+
+	ins, err := ins.Started(ip, "fat-pat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ins.RequestRestart("env changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := ins.GetRestartRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req == nil || req.Reason != "env changed" {
+		t.Fatalf("expected restart request with reason, got %#v", req)
+	}
+
+	if _, err := ins.Restarted(InsRestarts{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	req, err = ins.GetRestartRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req != nil {
+		t.Errorf("expected restart request to be cleared, got %#v", req)
+	}
+}
+
+func TestInstanceCrashLoop(t *testing.T) {
+	s := instanceSetup()
+	ip := "10.0.0.1"
+
+	app, err := s.NewApp("loop-cat", "git://loop.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.CrashLoop = &CrashLoopPolicy{Restarts: 0, WindowSec: 60}
+	if _, err := proc.StoreAttrs(); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	proc.Attrs.CrashLoop = &CrashLoopPolicy{Restarts: 2, WindowSec: 60}
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := storeFromSnapshotable(proc).RegisterInstance(app.Name, "128af9", proc.Name, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(ip, "loop-cat.com", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	if ins, err = ins.Restarted(InsRestarts{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	looping, err := ins.IsCrashLooping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if looping {
+		t.Error("expected instance not to crash loop after one restart")
+	}
+
+	if ins, err = ins.Restarted(InsRestarts{0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	looping, err = ins.IsCrashLooping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !looping {
+		t.Error("expected instance to crash loop after two restarts")
+	}
+
+	times, err := ins.GetRestartTimes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 {
+		t.Errorf("expected 2 restart times, got %d", len(times))
+	}
+}
+
+func TestInstanceRestartBudget(t *testing.T) {
+	s := instanceSetup()
+	ip := "10.0.0.1"
+
+	app, err := s.NewApp("budget-cat", "git://budget.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.MaxRestarts = 2
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := storeFromSnapshotable(proc).RegisterInstance(app.Name, "128af9", proc.Name, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(ip, "budget-cat.com", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	if ins, err = ins.Restarted(InsRestarts{1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusRunning {
+		t.Fatalf("expected instance to be running within budget, got %s", ins.Status)
+	}
+	if ins, err = ins.Restarted(InsRestarts{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusFailed {
+		t.Fatalf("expected instance to be failed, got %s", ins.Status)
+	}
+	testInstanceStatus(s, t, ins.ID, InsStatusFailed)
+
+	failed, err := s.GetSerialisedInstance(app.Name, proc.Name, ins.ID, InsStatusFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Termination.Reason == "" {
+		t.Error("expected termination reason to be set")
+	}
+}
+
 func TestInstanceRestartAndGet(t *testing.T) {
 
 	s := instanceSetup()
@@ -742,6 +1223,37 @@ func TestInstanceSerialisation(t *testing.T) {
 	}
 }
 
+func TestInstanceObjectVersions(t *testing.T) {
+	for i, tt := range []struct {
+		val   string
+		valid bool
+		env   string
+	}{
+		{"cat 128af9 web", true, ""},
+		{"cat 128af9 web prod", true, "prod"},
+		{"cat 128af9", false, ""},
+		{`{"app":"cat","rev":"128af9","proc":"web","env":"prod","priority":3}`, true, "prod"},
+		{`{"version":1,"app":"cat","rev":"128af9","proc":"web","env":"prod"}`, true, "prod"},
+		{`{"version":2,"app":"cat","rev":"128af9","proc":"web","env":"prod"}`, false, ""},
+		{`{"app":`, false, ""},
+	} {
+		obj, err := parseObject(1, tt.val)
+		if !tt.valid {
+			if !IsErrInvalidFile(err) {
+				t.Errorf("%d. expected invalid file error, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. %s", i, err)
+			continue
+		}
+		if obj.App != "cat" || obj.Rev != "128af9" || obj.Proc != "web" || obj.Env != tt.env {
+			t.Errorf("%d. unexpected object %#v", i, obj)
+		}
+	}
+}
+
 func testInstanceStatus(s *Store, t *testing.T, id int64, status InsStatus) {
 	ins, err := s.GetInstance(id)
 	if err != nil {
@@ -766,3 +1278,71 @@ func expectInstance(i *Instance, instancec chan *Instance, errc chan error) erro
 		return errors.New("expected instance, got timeout")
 	}
 }
+
+func TestInstanceSetRuntimeInfo(t *testing.T) {
+	ins := instanceSetupClaimed("runtime-cat", "10.0.0.1")
+	s := storeFromSnapshotable(ins)
+
+	info := RuntimeInfo{
+		ContainerID: "4f7c1bd2e1a0",
+		CgroupPath:  "/sys/fs/cgroup/visor/runtime-cat",
+		ImageDigest: "sha256:9b2a",
+		RunnerPID:   4242,
+	}
+	ins, err := ins.SetRuntimeInfo(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := s.GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Runtime == nil || *fetched.Runtime != info {
+		t.Errorf("expected runtime info %#v, got %#v", info, fetched.Runtime)
+	}
+
+	if err := fetched.Unregister("instance-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	done, err := s.GetSerialisedInstance(ins.AppName, ins.ProcessName, ins.ID, InsStatusDone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Runtime == nil || *done.Runtime != info {
+		t.Errorf("expected runtime info to be serialised, got %#v", done.Runtime)
+	}
+}
+
+func TestInstanceReportUsage(t *testing.T) {
+	ins := instanceSetupClaimed("usage-cat", "10.0.0.1")
+
+	samples, err := ins.GetUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 0 {
+		t.Errorf("expected no samples, got %d", len(samples))
+	}
+
+	for n := 0; n < maxUsageSamples+5; n++ {
+		ins, err = ins.ReportUsage(ResourceUsage{RSS: uint64(n), CPUSeconds: float64(n) / 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err = ins.GetUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != maxUsageSamples {
+		t.Fatalf("expected %d samples, got %d", maxUsageSamples, len(samples))
+	}
+	if samples[0].RSS != 5 || samples[len(samples)-1].RSS != maxUsageSamples+4 {
+		t.Errorf("expected the oldest samples to be dropped, got %d..%d", samples[0].RSS, samples[len(samples)-1].RSS)
+	}
+	if samples[0].Time.IsZero() {
+		t.Error("expected sample time to be set")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// DefaultIterConcurrency is the number of objects iterators fetch in
+// parallel unless limited by Store.SetMaxConcurrentReads.
+const DefaultIterConcurrency = 16
+
+// iter lazily fetches the objects for a list of names, at most concurrency
+// at a time, preserving the order of names.
+type iter struct {
+	names        []string
+	fetch        func(string) (cp.Snapshotable, error)
+	concurrency  int
+	skipNotFound bool
+
+	batch []cp.Snapshotable
+	cur   cp.Snapshotable
+	err   error
+}
+
+func newIter(names []string, concurrency int, fetch func(string) (cp.Snapshotable, error)) *iter {
+	if concurrency < 1 {
+		concurrency = DefaultIterConcurrency
+	}
+	return &iter{names: names, fetch: fetch, concurrency: concurrency}
+}
+
+func (it *iter) next() bool {
+	for len(it.batch) == 0 {
+		if it.err != nil || len(it.names) == 0 {
+			it.cur = nil
+			return false
+		}
+		it.fill()
+	}
+	it.cur, it.batch = it.batch[0], it.batch[1:]
+	return true
+}
+
+// fill fetches the next batch of objects in parallel.
+func (it *iter) fill() {
+	n := it.concurrency
+	if n > len(it.names) {
+		n = len(it.names)
+	}
+	names := it.names[:n]
+	it.names = it.names[n:]
+
+	type result struct {
+		s   cp.Snapshotable
+		err error
+	}
+	results := make([]chan result, n)
+	for i, name := range names {
+		results[i] = make(chan result, 1)
+		go func(name string, ch chan result) {
+			s, err := it.fetch(name)
+			ch <- result{s, err}
+		}(name, results[i])
+	}
+
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil {
+			if it.skipNotFound && IsErrNotFound(r.err) {
+				continue
+			}
+			if it.err == nil {
+				it.err = r.err
+			}
+			continue
+		}
+		if it.err == nil {
+			it.batch = append(it.batch, r.s)
+		}
+	}
+	if it.err != nil {
+		it.batch = nil
+	}
+}
+
+// Len returns the number of objects which have not been fetched yet.
+func (it *iter) Len() int {
+	return len(it.names) + len(it.batch)
+}
+
+// InstanceIter iterates over instances fetching them lazily.
+//
+//	it, err := store.InstancesIter()
+//	if err != nil {
+//	    return err
+//	}
+//	for it.Next() {
+//	    fmt.Println(it.Instance())
+//	}
+//	if err := it.Err(); err != nil {
+//	    return err
+//	}
+type InstanceIter struct {
+	*iter
+}
+
+// Next fetches the next Instance and reports whether there was one.
+func (it *InstanceIter) Next() bool {
+	return it.next()
+}
+
+// Instance returns the Instance fetched by the last call to Next.
+func (it *InstanceIter) Instance() *Instance {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.(*Instance)
+}
+
+// Err returns the first error encountered during iteration.
+func (it *InstanceIter) Err() error {
+	return it.err
+}
+
+// InstancesIter returns an iterator over all existing instances. Instances
+// which are removed while iterating are skipped.
+func (s *Store) InstancesIter() (*InstanceIter, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := sp.Getdir(instancesPath)
+	if err != nil {
+		if !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		ids = []string{}
+	}
+	it := newIter(ids, s.reads.get(), func(idstr string) (cp.Snapshotable, error) {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, err
+		}
+		return getInstance(id, s.join(sp))
+	})
+	it.skipNotFound = true
+
+	return &InstanceIter{it}, nil
+}
+
+// AppIter iterates over apps fetching them lazily.
+type AppIter struct {
+	*iter
+}
+
+// Next fetches the next App and reports whether there was one.
+func (it *AppIter) Next() bool {
+	return it.next()
+}
+
+// App returns the App fetched by the last call to Next.
+func (it *AppIter) App() *App {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.(*App)
+}
+
+// Err returns the first error encountered during iteration.
+func (it *AppIter) Err() error {
+	return it.err
+}
+
+// AppsIter returns an iterator over all registered apps.
+func (s *Store) AppsIter() (*AppIter, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	names, err := sp.Getdir(appsPath)
+	if err != nil {
+		if !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		names = []string{}
+	}
+	it := newIter(names, s.reads.get(), func(name string) (cp.Snapshotable, error) {
+		return getApp(name, s.join(sp))
+	})
+
+	return &AppIter{it}, nil
+}
+
+// RevisionIter iterates over the revisions of all apps fetching them lazily,
+// one app at a time.
+type RevisionIter struct {
+	apps *AppIter
+	revs *iter
+	err  error
+}
+
+// Next fetches the next Revision and reports whether there was one.
+func (it *RevisionIter) Next() bool {
+	for it.err == nil {
+		if it.revs != nil {
+			if it.revs.next() {
+				return true
+			}
+			if it.err = it.revs.err; it.err != nil {
+				break
+			}
+		}
+		if !it.apps.Next() {
+			it.err = it.apps.Err()
+			break
+		}
+
+		app := it.apps.App()
+		sp := app.GetSnapshot()
+		refs, err := sp.Getdir(app.dir.Prefix(revsPath))
+		if err != nil {
+			if cp.IsErrNoEnt(err) {
+				it.revs = nil
+				continue
+			}
+			it.err = err
+			break
+		}
+		it.revs = newIter(refs, it.apps.concurrency, func(ref string) (cp.Snapshotable, error) {
+			return getRevision(app, ref, sp)
+		})
+	}
+	return false
+}
+
+// Revision returns the Revision fetched by the last call to Next.
+func (it *RevisionIter) Revision() *Revision {
+	if it.revs == nil || it.revs.cur == nil {
+		return nil
+	}
+	return it.revs.cur.(*Revision)
+}
+
+// Err returns the first error encountered during iteration.
+func (it *RevisionIter) Err() error {
+	return it.err
+}
+
+// RevisionsIter returns an iterator over the revisions of all apps.
+func (s *Store) RevisionsIter() (*RevisionIter, error) {
+	apps, err := s.AppsIter()
+	if err != nil {
+		return nil, err
+	}
+	return &RevisionIter{apps: apps}, nil
+}
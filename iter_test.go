@@ -0,0 +1,113 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func iterSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/iter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestInstancesIter(t *testing.T) {
+	s := iterSetup(t)
+
+	n := DefaultIterConcurrency*2 + 3
+	ids := map[int64]bool{}
+	for i := 0; i < n; i++ {
+		ins, err := s.RegisterInstance("iter", "128af9", "web", "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[ins.ID] = true
+	}
+
+	it, err := s.InstancesIter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for it.Next() {
+		if !ids[it.Instance().ID] {
+			t.Errorf("unexpected instance %d", it.Instance().ID)
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("expected %d instances, got %d", n, count)
+	}
+	if it.Instance() != nil {
+		t.Error("expected no instance after iteration ended")
+	}
+}
+
+func TestAppsAndRevisionsIter(t *testing.T) {
+	s := iterSetup(t)
+
+	for i := 0; i < 3; i++ {
+		app, err := s.NewApp(fmt.Sprintf("iter-%d", i), "git://iter.git", "stack").Register()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < i; j++ {
+			ref := fmt.Sprintf("ref%d", j)
+			if _, err := s.NewRevision(app, ref, ref+".img").Register(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	apps, err := s.AppsIter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for apps.Next() {
+		count++
+	}
+	if err := apps.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 apps, got %d", count)
+	}
+
+	revs, err := s.RevisionsIter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count = 0
+	for revs.Next() {
+		if revs.Revision().App == nil {
+			t.Error("expected revision to reference its app")
+		}
+		count++
+	}
+	if err := revs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 revisions, got %d", count)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const labelsPath = "labels"
+
+var reLabelKey = regexp.MustCompile(`^[[:alnum:]][-_.[:alnum:]]*$`)
+
+// SelectorOp is the operator of a LabelRequirement.
+type SelectorOp string
+
+// Selector operators.
+const (
+	SelectEquals       SelectorOp = "="
+	SelectNotEquals    SelectorOp = "!="
+	SelectIn           SelectorOp = "in"
+	SelectNotIn        SelectorOp = "notin"
+	SelectExists       SelectorOp = "exists"
+	SelectDoesNotExist SelectorOp = "!"
+)
+
+// LabelRequirement is a single condition on the value of a label.
+type LabelRequirement struct {
+	Key    string
+	Op     SelectorOp
+	Values []string
+}
+
+// Matches reports whether the given labels satisfy the requirement.
+func (r LabelRequirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+
+	switch r.Op {
+	case SelectEquals:
+		return ok && len(r.Values) > 0 && v == r.Values[0]
+	case SelectNotEquals:
+		return !ok || len(r.Values) == 0 || v != r.Values[0]
+	case SelectIn:
+		return ok && contains(r.Values, v)
+	case SelectNotIn:
+		return !ok || !contains(r.Values, v)
+	case SelectExists:
+		return ok
+	case SelectDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (r LabelRequirement) String() string {
+	switch r.Op {
+	case SelectEquals, SelectNotEquals:
+		return r.Key + string(r.Op) + strings.Join(r.Values, "")
+	case SelectIn, SelectNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Op, strings.Join(r.Values, ","))
+	case SelectDoesNotExist:
+		return "!" + r.Key
+	}
+	return r.Key
+}
+
+// LabelSelector matches labels if all of its requirements are met. An empty
+// selector matches everything.
+type LabelSelector []LabelRequirement
+
+// Matches reports whether the given labels satisfy all requirements.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s LabelSelector) String() string {
+	reqs := make([]string, len(s))
+	for i, r := range s {
+		reqs[i] = r.String()
+	}
+	return strings.Join(reqs, ",")
+}
+
+// ParseLabelSelector parses the textual representation of a LabelSelector:
+// a comma separated list of requirements of the forms "key=value",
+// "key!=value", "key in (a,b)", "key notin (a,b)", "key" and "!key".
+func ParseLabelSelector(sel string) (LabelSelector, error) {
+	selector := LabelSelector{}
+
+	for _, part := range splitSelector(sel) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var r LabelRequirement
+
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = LabelRequirement{Key: kv[0], Op: SelectNotEquals, Values: []string{kv[1]}}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			r = LabelRequirement{Key: kv[0], Op: SelectEquals, Values: []string{kv[1]}}
+		case strings.HasSuffix(part, ")"):
+			fields := strings.Fields(part)
+			if len(fields) < 3 {
+				return nil, errorf(ErrInvalidArgument, "invalid selector requirement %q", part)
+			}
+			op := SelectorOp(fields[1])
+			if op != SelectIn && op != SelectNotIn {
+				return nil, errorf(ErrInvalidArgument, "invalid selector operator %q", fields[1])
+			}
+			set := strings.TrimSpace(strings.Join(fields[2:], ""))
+			if !strings.HasPrefix(set, "(") {
+				return nil, errorf(ErrInvalidArgument, "invalid selector requirement %q", part)
+			}
+			values := []string{}
+			for _, v := range strings.Split(set[1:len(set)-1], ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+			r = LabelRequirement{Key: fields[0], Op: op, Values: values}
+		case strings.HasPrefix(part, "!"):
+			r = LabelRequirement{Key: part[1:], Op: SelectDoesNotExist}
+		default:
+			r = LabelRequirement{Key: part, Op: SelectExists}
+		}
+
+		r.Key = strings.TrimSpace(r.Key)
+		for i := range r.Values {
+			r.Values[i] = strings.TrimSpace(r.Values[i])
+		}
+		if !reLabelKey.MatchString(r.Key) {
+			return nil, errorf(ErrInvalidKey, "invalid label key %q", r.Key)
+		}
+		selector = append(selector, r)
+	}
+
+	return selector, nil
+}
+
+// splitSelector splits on commas which are not enclosed in parentheses.
+func splitSelector(sel string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i, c := range sel {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, sel[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, sel[start:])
+}
+
+// SelectApps returns all apps whose labels match the selector.
+func (s *Store) SelectApps(selector LabelSelector) ([]*App, error) {
+	apps, err := s.GetApps()
+	if err != nil {
+		return nil, err
+	}
+	result := []*App{}
+	for _, app := range apps {
+		if selector.Matches(app.Labels) {
+			result = append(result, app)
+		}
+	}
+	return result, nil
+}
+
+// SelectInstances returns all instances matching the selector. The labels of
+// an instance are the union of its app, proc and own labels, where proc
+// labels override app labels and instance labels override both.
+func (s *Store) SelectInstances(selector LabelSelector) ([]*Instance, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	apps, err := s.join(sp).GetApps()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Instance{}
+	for _, app := range apps {
+		procs, err := getProcs(app, sp)
+		if err != nil {
+			return nil, err
+		}
+		for _, proc := range procs {
+			ids, err := getProcInstanceIds(proc, sp)
+			if err != nil {
+				if cp.IsErrNoEnt(err) {
+					continue
+				}
+				return nil, err
+			}
+			inherited := mergeLabels(app.Labels, proc.Labels)
+			for _, id := range ids {
+				ins, err := getInstance(id, sp)
+				if err != nil {
+					return nil, err
+				}
+				if selector.Matches(mergeLabels(inherited, ins.Labels)) {
+					result = append(result, ins)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// SetLabels replaces the labels of the app.
+func (a *App) SetLabels(labels map[string]string) (*App, error) {
+	if err := guardOf(a).authorize("", AuditLabels, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	f, err := setLabels(a.dir, labels)
+	if err != nil {
+		return nil, err
+	}
+	a.Labels = labels
+	a.dir = a.dir.Join(f)
+
+	audit(a, "", AuditLabels, "app:"+a.Name)
+	return a, nil
+}
+
+// SetLabels replaces the labels of the proc.
+func (p *Proc) SetLabels(labels map[string]string) (*Proc, error) {
+	if err := guardOf(p).authorize("", AuditLabels, p.auditName()); err != nil {
+		return nil, err
+	}
+	f, err := setLabels(p.dir, labels)
+	if err != nil {
+		return nil, err
+	}
+	p.Labels = labels
+	p.dir = p.dir.Join(f)
+
+	audit(p, "", AuditLabels, p.auditName())
+	return p, nil
+}
+
+// SetLabels replaces the labels of the instance.
+func (i *Instance) SetLabels(labels map[string]string) (*Instance, error) {
+	if err := i.guard.authorize("", AuditLabels, i.auditName()); err != nil {
+		return nil, err
+	}
+	f, err := setLabels(i.dir, labels)
+	if err != nil {
+		return nil, err
+	}
+	i.Labels = labels
+	i.dir = i.dir.Join(f)
+
+	audit(i, "", AuditLabels, i.auditName())
+	return i, nil
+}
+
+func setLabels(d *cp.Dir, labels map[string]string) (*cp.File, error) {
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	sp, err := d.Snapshot.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return cp.NewFile(d.Prefix(labelsPath), labels, new(cp.JsonCodec), sp).Save()
+}
+
+func getLabels(d *cp.Dir) (map[string]string, error) {
+	labels := map[string]string{}
+	_, err := d.GetFile(labelsPath, &cp.JsonCodec{DecodedVal: &labels})
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	return labels, nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for k := range labels {
+		if !reLabelKey.MatchString(k) {
+			return errorf(ErrInvalidKey, "invalid label key %q", k)
+		}
+	}
+	return nil
+}
+
+func mergeLabels(layers ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, l := range layers {
+		for k, v := range l {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
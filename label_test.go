@@ -0,0 +1,137 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func labelSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/label-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.reset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	for i, tt := range []struct {
+		in   string
+		want LabelSelector
+	}{
+		{"", LabelSelector{}},
+		{"team=search", LabelSelector{{"team", SelectEquals, []string{"search"}}}},
+		{"team!=search", LabelSelector{{"team", SelectNotEquals, []string{"search"}}}},
+		{"zone in (eu, us),canary", LabelSelector{
+			{"zone", SelectIn, []string{"eu", "us"}},
+			{"canary", SelectExists, nil},
+		}},
+		{"zone notin (ap),!canary", LabelSelector{
+			{"zone", SelectNotIn, []string{"ap"}},
+			{"canary", SelectDoesNotExist, nil},
+		}},
+	} {
+		have, err := ParseLabelSelector(tt.in)
+		if err != nil {
+			t.Errorf("%d. unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.want, have) {
+			t.Errorf("%d. want %v, have %v", i, tt.want, have)
+		}
+	}
+
+	if _, err := ParseLabelSelector("zone within (eu)"); !IsErrInvalidArgument(err) {
+		t.Errorf("want ErrInvalidArgument, have %v", err)
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "search", "zone": "eu"}
+
+	for i, tt := range []struct {
+		sel  string
+		want bool
+	}{
+		{"", true},
+		{"team=search", true},
+		{"team=search,zone=eu", true},
+		{"team=search,zone=us", false},
+		{"zone in (us,eu)", true},
+		{"zone notin (us,eu)", false},
+		{"team!=web", true},
+		{"canary", false},
+		{"!canary", true},
+	} {
+		sel, err := ParseLabelSelector(tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := sel.Matches(labels); have != tt.want {
+			t.Errorf("%d. %q: want %t, have %t", i, tt.sel, tt.want, have)
+		}
+	}
+}
+
+func TestSelectInstances(t *testing.T) {
+	s := labelSetup(t)
+
+	app, err := s.NewApp("search", "git://search.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err = app.SetLabels(map[string]string{"team": "search"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewProc(app, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	eu, err := s.RegisterInstance(app.Name, "rev", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eu.SetLabels(map[string]string{"zone": "eu"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "rev", "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	sel, err := ParseLabelSelector("team=search,zone=eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := s.SelectInstances(sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != eu.ID {
+		t.Errorf("expected only instance %d, got %v", eu.ID, instances)
+	}
+
+	apps, err := s.SelectApps(LabelSelector{{"team", SelectEquals, []string{"search"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].Name != app.Name {
+		t.Errorf("expected only app %s, got %v", app.Name, apps)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"path"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Config key of the cluster-wide default limits. The defaults of an app are
+// stored under the key followed by a dot and the app name.
+const configDefaultLimits = "default-limits"
+
+// SetDefaultLimits stores the limits procs of all apps get for the limits
+// neither they nor their app set.
+func (s *Store) SetDefaultLimits(l ResourceLimits) (*Store, error) {
+	return s.setDefaultLimits(configDefaultLimits, l)
+}
+
+// GetDefaultLimits returns the cluster-wide default limits, none if they
+// were never set.
+func (s *Store) GetDefaultLimits() (ResourceLimits, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	return getDefaultLimits(configDefaultLimits, sp)
+}
+
+// SetDefaultLimits stores the limits procs of the app get for the limits they
+// don't set. Limits the app doesn't set either fall back to the cluster-wide
+// defaults.
+func (a *App) SetDefaultLimits(l ResourceLimits) (*App, error) {
+	s, err := storeFromSnapshotable(a).setDefaultLimits(appDefaultLimitsKey(a.Name), l)
+	if err != nil {
+		return nil, err
+	}
+	a.dir = a.dir.Join(s)
+	return a, nil
+}
+
+// GetDefaultLimits returns the default limits of the app, without the
+// cluster-wide ones.
+func (a *App) GetDefaultLimits() (ResourceLimits, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	return getDefaultLimits(appDefaultLimitsKey(a.Name), sp)
+}
+
+// EffectiveLimits returns the limits instances of the proc run with: every
+// limit the proc doesn't set is taken from the defaults of its app, then
+// from the cluster-wide defaults.
+func (p *Proc) EffectiveLimits() (ResourceLimits, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	attrs, err := getProcAttrs(p.App.Name, p.Name, sp)
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	app, err := getDefaultLimits(appDefaultLimitsKey(p.App.Name), sp)
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	cluster, err := getDefaultLimits(configDefaultLimits, sp)
+	if err != nil {
+		return ResourceLimits{}, err
+	}
+	return attrs.Limits.withDefaults(app).withDefaults(cluster), nil
+}
+
+// withDefaults returns the limits with the unset ones taken from d.
+func (l ResourceLimits) withDefaults(d ResourceLimits) ResourceLimits {
+	for _, limit := range []struct {
+		v **int
+		d *int
+	}{
+		{&l.MemoryLimitMb, d.MemoryLimitMb},
+		{&l.CPUShares, d.CPUShares},
+		{&l.CPUQuotaPercent, d.CPUQuotaPercent},
+		{&l.DiskQuotaMb, d.DiskQuotaMb},
+		{&l.MaxFileDescriptors, d.MaxFileDescriptors},
+	} {
+		if *limit.v == nil {
+			*limit.v = limit.d
+		}
+	}
+	return l
+}
+
+func (s *Store) setDefaultLimits(key string, l ResourceLimits) (*Store, error) {
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return s.SetConfig(key, string(b))
+}
+
+func getDefaultLimits(key string, sp cp.Snapshot) (ResourceLimits, error) {
+	l := ResourceLimits{}
+	_, err := sp.GetFile(path.Join(configPath, key), &cp.JsonCodec{DecodedVal: &l})
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return l, err
+	}
+	return l, nil
+}
+
+func appDefaultLimitsKey(app string) string {
+	return configDefaultLimits + "." + app
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestResourceLimitsWithDefaults(t *testing.T) {
+	l := ResourceLimits{MemoryLimitMb: intPtr(256)}
+	d := ResourceLimits{MemoryLimitMb: intPtr(512), CPUShares: intPtr(1024)}
+
+	have := l.withDefaults(d)
+	want := ResourceLimits{MemoryLimitMb: intPtr(256), CPUShares: intPtr(1024)}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("want %#v, have %#v", want, have)
+	}
+	if l.CPUShares != nil {
+		t.Error("want limits to be left unchanged")
+	}
+}
+
+func TestProcEffectiveLimits(t *testing.T) {
+	s, app := procSetup("limits-app")
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+
+	proc := s.NewProc(app, "web")
+	proc.Attrs.Limits.MemoryLimitMb = intPtr(256)
+	proc, err = proc.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.SetDefaultLimits(ResourceLimits{MemoryLimitMb: intPtr(0)}); !IsErrInvalidArgument(err) {
+		t.Errorf("want invalid limits to be rejected, have %v", err)
+	}
+	if _, err := s.SetDefaultLimits(ResourceLimits{
+		MemoryLimitMb:      intPtr(1024),
+		CPUShares:          intPtr(512),
+		MaxFileDescriptors: intPtr(4096),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.SetDefaultLimits(ResourceLimits{CPUShares: intPtr(2048)}); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := proc.EffectiveLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ResourceLimits{
+		MemoryLimitMb:      intPtr(256),
+		CPUShares:          intPtr(2048),
+		MaxFileDescriptors: intPtr(4096),
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("want %#v, have %#v", want, have)
+	}
+}
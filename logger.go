@@ -0,0 +1,126 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// LoggerInfo describes a bazooka-log endpoint for log routing.
+type LoggerInfo struct {
+	Addr       string    `json:"-"`
+	Registered time.Time `json:"-"`
+	Version    string    `json:"version"`
+	Protocol   string    `json:"protocol,omitempty"`
+	// Group the logger belongs to, see LogRouting.LoggerGroup.
+	Group string `json:"group,omitempty"`
+	// Apps the logger accepts logs of, all if empty.
+	Apps []string `json:"apps,omitempty"`
+	// Maximum number of streams, unlimited if 0.
+	Capacity int `json:"capacity,omitempty"`
+	// Number of streams at the time of the last health report.
+	Load int `json:"load,omitempty"`
+	// Time of the last health report.
+	Healthy time.Time `json:"healthy"`
+}
+
+// Accepts reports whether the logger takes logs of the given app.
+func (l *LoggerInfo) Accepts(app string) bool {
+	if len(l.Apps) == 0 {
+		return true
+	}
+	for _, a := range l.Apps {
+		if a == app {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHealthy reports whether the logger reported its health within maxAge.
+func (l *LoggerInfo) IsHealthy(maxAge time.Duration) bool {
+	return time.Since(l.Healthy) <= maxAge
+}
+
+// IsOverloaded reports whether the load of the logger reached its capacity.
+func (l *LoggerInfo) IsOverloaded() bool {
+	return l.Capacity > 0 && l.Load >= l.Capacity
+}
+
+// RegisterLoggerInfo stores the logger at addr with the given metadata. It
+// counts as a health report with no load.
+func (s *Store) RegisterLoggerInfo(addr string, info LoggerInfo) (*Store, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	info.Healthy = time.Now().UTC()
+	return s.registerService(ServiceLogger, addr, info)
+}
+
+// GetLoggerInfo returns the metadata of the logger at addr.
+func (s *Store) GetLoggerInfo(addr string) (*LoggerInfo, error) {
+	svc, err := s.GetService(ServiceLogger, addr)
+	if err != nil {
+		return nil, err
+	}
+	return loggerInfo(svc)
+}
+
+// GetLoggerInfos returns the metadata of all loggers.
+func (s *Store) GetLoggerInfos() ([]*LoggerInfo, error) {
+	services, err := s.GetServices(ServiceLogger)
+	if err != nil {
+		return nil, err
+	}
+	infos := []*LoggerInfo{}
+	for _, svc := range services {
+		info, err := loggerInfo(svc)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// ReportLoggerHealth records that the logger at addr is alive and serving
+// load streams.
+func (s *Store) ReportLoggerHealth(addr string, load int) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.guard.authorize("", ActionHealth, serviceObject(ServiceLogger, addr)); err != nil {
+		return err
+	}
+	svc, err := s.GetService(ServiceLogger, addr)
+	if err != nil {
+		return err
+	}
+	info, err := loggerInfo(svc)
+	if err != nil {
+		return err
+	}
+	info.Load = load
+	info.Healthy = time.Now().UTC()
+
+	if svc.Meta, err = json.Marshal(info); err != nil {
+		return err
+	}
+	_, err = svc.file.Set(svc)
+	return err
+}
+
+func loggerInfo(svc *Service) (*LoggerInfo, error) {
+	info := &LoggerInfo{}
+	if err := svc.DecodeMeta(info); err != nil {
+		return nil, errorf(ErrInvalidFile, "invalid metadata of logger %s: %w", svc.Addr, err)
+	}
+	info.Addr = svc.Addr
+	info.Registered = svc.Registered
+	return info, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoggerInfo(t *testing.T) {
+	s := serviceSetup(t)
+	addr := "10.0.0.1:9000"
+
+	_, err := s.RegisterLoggerInfo(addr, LoggerInfo{
+		Version:  "v1",
+		Protocol: "syslog",
+		Apps:     []string{"cat"},
+		Capacity: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterLoggerInfo("10.0.0.2", LoggerInfo{}); err == nil {
+		t.Error("expected addr without port to be rejected")
+	}
+
+	info, err := s.GetLoggerInfo(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Addr != addr || info.Protocol != "syslog" || info.Capacity != 2 {
+		t.Errorf("retrieved logger info differs: %#v", info)
+	}
+	if !info.Accepts("cat") || info.Accepts("dog") {
+		t.Errorf("expected logger to only accept cat: %v", info.Apps)
+	}
+	if !info.IsHealthy(time.Minute) || info.IsOverloaded() {
+		t.Errorf("expected logger to be healthy and not overloaded: %#v", info)
+	}
+
+	if err := s.ReportLoggerHealth(addr, 2); err != nil {
+		t.Fatal(err)
+	}
+	info, err = s.GetLoggerInfo(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsOverloaded() {
+		t.Errorf("expected logger to be overloaded with load %d", info.Load)
+	}
+	if info.Protocol != "syslog" {
+		t.Errorf("expected metadata to be kept, got %#v", info)
+	}
+
+	infos, err := s.GetLoggerInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("expected one logger, got %d", len(infos))
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	maintenancePath  = "maintenance"
+	deployFreezePath = "/deploy-freeze"
+)
+
+// Audited operations of switching maintenance mode and the deploy freeze.
+const (
+	AuditMaintenance  = "maintenance"
+	AuditDeployFreeze = "deploy-freeze"
+)
+
+// Maintenance describes why an app or proc is in maintenance mode. No
+// instances can be registered for it while it is.
+type Maintenance struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// DeployFreeze stops revisions and instances from being registered in the
+// whole cluster, e.g. during coordinated maintenance.
+type DeployFreeze struct {
+	file   *cp.File
+	Actor  string    `json:"actor"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// SetDeployFreeze switches the cluster-wide deploy freeze on or off. While
+// it's on, registering revisions and instances fails with ErrDeployFrozen.
+func (s *Store) SetDeployFreeze(on bool, actor, reason string) (*Store, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if err := s.guard.authorize(actor, AuditDeployFreeze, "cluster"); err != nil {
+		return nil, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	if on {
+		if actor == "" || reason == "" {
+			return nil, errorf(ErrInvalidArgument, "deploy freeze actor and reason must not be empty")
+		}
+		freeze := &DeployFreeze{Actor: actor, Reason: reason, Since: time.Now().UTC()}
+		f, err := cp.NewFile(deployFreezePath, freeze, new(cp.JsonCodec), sp).Save()
+		if err != nil {
+			return nil, err
+		}
+		sp = f.Snapshot
+	} else {
+		err := sp.Del(deployFreezePath)
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		if sp, err = sp.FastForward(); err != nil {
+			return nil, err
+		}
+	}
+	audit(sp, actor, AuditDeployFreeze, "cluster")
+	return s.join(sp), nil
+}
+
+// GetDeployFreeze returns the cluster-wide deploy freeze, nil if there is
+// none.
+func (s *Store) GetDeployFreeze() (*DeployFreeze, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	return getDeployFreeze(sp)
+}
+
+// IsDeployFrozen reports whether the cluster-wide deploy freeze is on.
+func (s *Store) IsDeployFrozen() (bool, error) {
+	freeze, err := s.GetDeployFreeze()
+	if err != nil {
+		return false, err
+	}
+	return freeze != nil, nil
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (f *DeployFreeze) GetSnapshot() cp.Snapshot {
+	return f.file.Snapshot
+}
+
+func getDeployFreeze(s cp.Snapshotable) (*DeployFreeze, error) {
+	freeze := &DeployFreeze{}
+	f, err := s.GetSnapshot().GetFile(deployFreezePath, &cp.JsonCodec{DecodedVal: freeze})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	freeze.file = f
+	return freeze, nil
+}
+
+// checkDeployFreeze fails with ErrDeployFrozen if the deploy freeze is on.
+func checkDeployFreeze(sp cp.Snapshot) error {
+	freeze, err := getDeployFreeze(sp)
+	if err != nil {
+		return err
+	}
+	if freeze != nil {
+		return errorf(ErrDeployFrozen, "deploys are frozen by %s: %s", freeze.Actor, freeze.Reason)
+	}
+	return nil
+}
+
+// SetMaintenance switches maintenance mode of the app on or off. While it's
+// on, registering instances of any of its procs fails with ErrMaintenance.
+func (a *App) SetMaintenance(on bool, reason string) (*App, error) {
+	if err := guardOf(a).authorize("", AuditMaintenance, "app:"+a.Name); err != nil {
+		return nil, err
+	}
+	d, err := setMaintenance(a.dir, on, reason)
+	if err != nil {
+		return nil, err
+	}
+	a.dir = d
+	audit(a, "", AuditMaintenance, "app:"+a.Name)
+	return a, nil
+}
+
+// GetMaintenance returns the maintenance mode of the app, nil if it's off.
+func (a *App) GetMaintenance() (*Maintenance, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getMaintenance(a.dir.Prefix(maintenancePath), sp)
+}
+
+// SetMaintenance switches maintenance mode of the proc on or off. While it's
+// on, registering instances of the proc fails with ErrMaintenance.
+func (p *Proc) SetMaintenance(on bool, reason string) (*Proc, error) {
+	if err := guardOf(p).authorize("", AuditMaintenance, p.auditName()); err != nil {
+		return nil, err
+	}
+	d, err := setMaintenance(p.dir, on, reason)
+	if err != nil {
+		return nil, err
+	}
+	p.dir = d
+	audit(p, "", AuditMaintenance, p.auditName())
+	return p, nil
+}
+
+// GetMaintenance returns the maintenance mode of the proc, nil if it's off.
+// The maintenance mode of its app isn't taken into account.
+func (p *Proc) GetMaintenance() (*Maintenance, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getMaintenance(p.dir.Prefix(maintenancePath), sp)
+}
+
+func setMaintenance(d *cp.Dir, on bool, reason string) (*cp.Dir, error) {
+	sp, err := d.Snapshot.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	d = d.Join(sp)
+	if !on {
+		err := sp.Del(d.Prefix(maintenancePath))
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		sp, err = sp.FastForward()
+		if err != nil {
+			return nil, err
+		}
+		return d.Join(sp), nil
+	}
+	if reason == "" {
+		return nil, errorf(ErrInvalidArgument, "maintenance reason must not be empty")
+	}
+	m := &Maintenance{Reason: reason, Since: time.Now().UTC()}
+	f, err := cp.NewFile(d.Prefix(maintenancePath), m, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	return d.Join(f), nil
+}
+
+func getMaintenance(p string, sp cp.Snapshot) (*Maintenance, error) {
+	m := &Maintenance{}
+	_, err := sp.GetFile(p, &cp.JsonCodec{DecodedVal: m})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkMaintenance fails with ErrMaintenance if the app or the proc is in
+// maintenance mode.
+func checkMaintenance(app, proc string, sp cp.Snapshot) error {
+	appDir := path.Join(appsPath, app)
+	for _, p := range []string{
+		path.Join(appDir, maintenancePath),
+		path.Join(appDir, procsPath, proc, maintenancePath),
+	} {
+		m, err := getMaintenance(p, sp)
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			return errorf(ErrMaintenance, "%s:%s is in maintenance: %s", app, proc, m.Reason)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+)
+
+func maintenanceSetup(appid string) (*Store, *App, *Proc) {
+	s, err := DialURI(DefaultURI, "/maintenance-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp(appid, "git://maintenance.git", "master").Register()
+	if err != nil {
+		panic(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, app, proc
+}
+
+func TestProcMaintenance(t *testing.T) {
+	s, app, proc := maintenanceSetup("maint-cat")
+
+	if _, err := proc.SetMaintenance(true, ""); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	proc, err := proc.SetMaintenance(true, "db migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := proc.GetMaintenance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Reason != "db migration" {
+		t.Errorf("expected maintenance for db migration, got %#v", m)
+	}
+	if _, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "default"); !IsErrMaintenance(err) {
+		t.Errorf("expected maintenance error, got %v", err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "128af9", "worker", "default"); err != nil {
+		t.Errorf("expected other procs to be unaffected, got %v", err)
+	}
+
+	if proc, err = proc.SetMaintenance(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = proc.GetMaintenance(); err != nil || m != nil {
+		t.Errorf("expected maintenance to be off, got %#v, %v", m, err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "default"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppMaintenance(t *testing.T) {
+	s, app, proc := maintenanceSetup("maint-dog")
+
+	app, err := app.SetMaintenance(true, "incident")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "default"); !IsErrMaintenance(err) {
+		t.Errorf("expected maintenance error, got %v", err)
+	}
+	if _, err = app.SetMaintenance(false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "default"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeployFreeze(t *testing.T) {
+	s, app, proc := maintenanceSetup("freeze-cat")
+
+	if _, err := s.SetDeployFreeze(true, "ops", ""); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	s, err := s.SetDeployFreeze(true, "ops", "datacenter move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frozen, err := s.IsDeployFrozen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !frozen {
+		t.Error("expected deploys to be frozen")
+	}
+	freeze, err := s.GetDeployFreeze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freeze.Actor != "ops" || freeze.Reason != "datacenter move" {
+		t.Errorf("expected freeze by ops, got %#v", freeze)
+	}
+
+	if _, err := s.NewRevision(app, "128af9", "http://archive").Register(); !IsErrDeployFrozen(err) {
+		t.Errorf("expected deploy frozen error, got %v", err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "default"); !IsErrDeployFrozen(err) {
+		t.Errorf("expected deploy frozen error, got %v", err)
+	}
+
+	if s, err = s.SetDeployFreeze(false, "ops", ""); err != nil {
+		t.Fatal(err)
+	}
+	if frozen, err = s.IsDeployFrozen(); err != nil || frozen {
+		t.Errorf("expected deploys not to be frozen, got %t, %v", frozen, err)
+	}
+	if _, err := s.NewRevision(app, "128af9", "http://archive").Register(); err != nil {
+		t.Fatal(err)
+	}
+}
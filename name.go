@@ -0,0 +1,46 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import "regexp"
+
+// Length limits of names.
+const (
+	// App names are used as DNS labels.
+	MaxAppNameLen = 63
+	MaxRevRefLen  = 128
+)
+
+// ValidateNames can be set to false to register apps and revisions whose
+// names predate validation. Registered entries are never rejected when read.
+var ValidateNames = true
+
+var (
+	// Lower case letters, digits and inner dashes.
+	reAppName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	// Letters, digits, dashes and dots, starting with a letter or digit.
+	reRevRef = regexp.MustCompile(`^[[:alnum:]][-.[:alnum:]]*$`)
+)
+
+// ValidateAppName returns ErrBadName unless name consists of lower case
+// letters, digits and dashes, doesn't start or end with a dash and is at most
+// MaxAppNameLen long.
+func ValidateAppName(name string) error {
+	if len(name) > MaxAppNameLen || !reAppName.MatchString(name) {
+		return errorf(ErrBadName, "invalid app name %q: only lower case letters, digits and inner dashes allowed, at most %d chars", name, MaxAppNameLen)
+	}
+	return nil
+}
+
+// ValidateRevRef returns ErrBadName unless ref consists of letters, digits,
+// dashes and dots, starts with a letter or digit and is at most
+// MaxRevRefLen long.
+func ValidateRevRef(ref string) error {
+	if len(ref) > MaxRevRefLen || !reRevRef.MatchString(ref) {
+		return errorf(ErrBadName, "invalid revision ref %q: only letters, digits, dashes and dots allowed, at most %d chars", ref, MaxRevRefLen)
+	}
+	return nil
+}
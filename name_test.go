@@ -0,0 +1,52 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAppName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"cat":                                true,
+		"cat-a-log":                          true,
+		"9lives":                             true,
+		strings.Repeat("c", MaxAppNameLen):   true,
+		strings.Repeat("c", MaxAppNameLen+1): false,
+		"":                                   false,
+		"-cat":                               false,
+		"cat-":                               false,
+		"Cat":                                false,
+		"cat.log":                            false,
+		"cat_log":                            false,
+		"cat/log":                            false,
+	} {
+		if err := ValidateAppName(name); (err == nil) != valid {
+			t.Errorf("%q: expected valid %t, got %v", name, valid, err)
+		} else if err != nil && !IsErrBadName(err) {
+			t.Errorf("%q: expected bad name error, got %v", name, err)
+		}
+	}
+}
+
+func TestValidateRevRef(t *testing.T) {
+	for ref, valid := range map[string]bool{
+		"128af9":                            true,
+		"v1.2.3":                            true,
+		"release-2013-10":                   true,
+		strings.Repeat("a", MaxRevRefLen):   true,
+		strings.Repeat("a", MaxRevRefLen+1): false,
+		"":                                  false,
+		".hidden":                           false,
+		"feature/cats":                      false,
+		"v1 2":                              false,
+	} {
+		if err := ValidateRevRef(ref); (err == nil) != valid {
+			t.Errorf("%q: expected valid %t, got %v", ref, valid, err)
+		}
+	}
+}
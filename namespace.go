@@ -0,0 +1,126 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"regexp"
+	"sort"
+)
+
+const namespacesPath = "namespaces"
+
+var reNamespace = regexp.MustCompile(`^[[:alnum:]][-_[:alnum:]]*$`)
+
+// Namespace returns a Store rooted at the sub-tree of the given namespace,
+// which separates logical environments like staging and production on one
+// coordinator. All paths, events and schema checks of the returned Store are
+// scoped to the namespace; call Init on it before first use. The namespace
+// Store has its own connection, which is shared by all calls for the same
+// name and closed with it or with s. It acts as the actor of s and is
+// read-only if s is pinned with At.
+func (s *Store) Namespace(name string) (*Store, error) {
+	if !reNamespace.MatchString(name) {
+		return nil, errorf(ErrInvalidKey, "invalid namespace %q", name)
+	}
+	if s.closer.uri == "" {
+		return nil, errorf(ErrInvalidState, "store was not dialed")
+	}
+	if s.IsClosed() {
+		return nil, errorf(ErrInvalidState, "store is closed")
+	}
+
+	ns, err := s.namespaceConn(name)
+	if err != nil {
+		return nil, err
+	}
+	// Only the connection is shared, the guard and pinning are the ones of s.
+	sp := ns.GetSnapshot()
+	if s.pinned {
+		sp.Rev = s.GetSnapshot().Rev
+	} else if sp, err = sp.FastForward(); err != nil {
+		return nil, err
+	}
+	return &Store{snapshot: sp, closer: ns.closer, reads: ns.reads, guard: s.guard, pinned: s.pinned}, nil
+}
+
+// namespaceConn returns the Store holding the connection of the namespace,
+// dialing it on first use.
+func (s *Store) namespaceConn(name string) (*Store, error) {
+	s.closer.mu.Lock()
+	defer s.closer.mu.Unlock()
+	if ns, ok := s.closer.namespaces[name]; ok && !ns.IsClosed() {
+		return ns, nil
+	}
+	ns, err := DialURI(s.closer.uri, path.Join(s.closer.root, namespacesPath, name))
+	if err != nil {
+		return nil, err
+	}
+	ns.SetMaxConcurrentReads(s.MaxConcurrentReads())
+	ns.closer.ids = s.closer.ids
+	if s.closer.namespaces == nil {
+		s.closer.namespaces = map[string]*Store{}
+	}
+	s.closer.namespaces[name] = ns
+
+	go func() {
+		select {
+		case <-s.closed():
+			ns.Close()
+		case <-ns.closed():
+		}
+	}()
+	return ns, nil
+}
+
+// GetNamespaces returns the names of all initialised namespaces.
+func (s *Store) GetNamespaces() ([]string, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	names, err := getdirOrEmpty(sp, namespacesPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Namespaces returns a Store for every initialised namespace, keyed by name.
+func (s *Store) Namespaces() (map[string]*Store, error) {
+	names, err := s.GetNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	stores := map[string]*Store{}
+	for _, name := range names {
+		ns, err := s.Namespace(name)
+		if err != nil {
+			return nil, err
+		}
+		stores[name] = ns
+	}
+	return stores, nil
+}
+
+// GetAppsByNamespace returns the apps of all namespaces, keyed by namespace
+// name.
+func (s *Store) GetAppsByNamespace() (map[string][]*App, error) {
+	stores, err := s.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+	apps := map[string][]*App{}
+	for name, ns := range stores {
+		a, err := ns.GetApps()
+		if err != nil {
+			return nil, err
+		}
+		apps[name] = a
+	}
+	return apps, nil
+}
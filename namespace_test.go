@@ -0,0 +1,99 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func namespaceSetup() *Store {
+	s, err := DialURI(DefaultURI, "/namespace-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestNamespace(t *testing.T) {
+	s := namespaceSetup()
+
+	if _, err := s.Namespace("staging/eu"); !IsErrInvalidKey(err) {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+	staging, err := s.Namespace("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging, err = staging.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = staging.NewApp("ns-cat", "git://ns.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	apps, err := s.GetApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 0 {
+		t.Errorf("expected no apps outside the namespace, got %d", len(apps))
+	}
+	names, err := s.GetNamespaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "staging" {
+		t.Errorf("expected [staging], got %v", names)
+	}
+	byNs, err := s.GetAppsByNamespace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byNs["staging"]) != 1 || byNs["staging"][0].Name != "ns-cat" {
+		t.Errorf("expected ns-cat in staging, got %v", byNs)
+	}
+
+	// The cached connection doesn't carry the actor or pinning of the
+	// first caller.
+	bob, err := s.As("bob").Namespace("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := s.As("alice").Namespace("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actorOf(bob) != "bob" || actorOf(alice) != "alice" {
+		t.Errorf("expected namespace stores of bob and alice, got %q and %q", actorOf(bob), actorOf(alice))
+	}
+	pinned, err := s.At(s.GetSnapshot().Rev).Namespace("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pinned.Init(); !IsErrReadOnly(err) {
+		t.Errorf("expected pinned namespace to be read-only, got %v", err)
+	}
+
+	s.Close()
+	select {
+	case <-staging.closed():
+	case <-time.After(time.Second):
+		t.Error("expected namespace to be closed with its parent")
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const offerPath = "offer"
+
+// OfferTTL is the time a host has to claim an offered instance before it
+// can be offered to another host.
+var OfferTTL = 10 * time.Second
+
+// Offer is the exclusive right of a host to claim a pending instance until
+// it expires. Pending instances are offered to the hosts with registered
+// runners round-robin, so hosts don't race each other for every instance.
+type Offer struct {
+	file       *cp.File
+	guard      *guard
+	InstanceID int64     `json:"instance"`
+	Host       string    `json:"host"`
+	Expires    time.Time `json:"expires"`
+}
+
+// NextOffer hands out an Offer for the pending instance with the highest
+// priority which is due for the given host. An instance is due for the host
+// it's assigned to by the round-robin or its host constraint, and for any
+// host once it has been pending for longer than OfferTTL. Asking again
+// returns the unexpired Offer the host already holds. It fails with
+// ErrNotFound if there is nothing to offer.
+func (s *Store) NextOffer(host string) (*Offer, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	for {
+		sp, err := s.GetSnapshot().FastForward()
+		if err != nil {
+			return nil, err
+		}
+		hosts, err := getdirOrEmpty(sp, runnersPath)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(hosts)
+
+		pending, err := s.join(sp).GetPendingInstances()
+		if err != nil {
+			return nil, err
+		}
+
+		var due *Instance
+		for _, ins := range pending {
+			offer, err := getOffer(ins, sp)
+			if err != nil && !IsErrNotFound(err) {
+				return nil, err
+			}
+			if offer != nil && !offer.IsExpired() {
+				if offer.Host == host {
+					return offer, nil
+				}
+				continue
+			}
+			if due == nil && isOfferDue(ins, host, hosts) {
+				due = ins
+			}
+		}
+		if due == nil {
+			return nil, errorf(ErrNotFound, "no pending instance to offer to %s", host)
+		}
+
+		offer := &Offer{
+			guard:      s.guard,
+			InstanceID: due.ID,
+			Host:       host,
+			Expires:    time.Now().Add(OfferTTL).UTC(),
+		}
+		offer.file, err = cp.NewFile(due.dir.Prefix(offerPath), nil, new(cp.JsonCodec), sp).Set(offer)
+		if err == nil {
+			return offer, nil
+		}
+		if !cp.IsErrRevMismatch(err) {
+			return nil, err
+		}
+		// Lost the race for the instance, look for the next one.
+	}
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (o *Offer) GetSnapshot() cp.Snapshot {
+	return o.file.Snapshot
+}
+
+// IsExpired reports whether the host ran out of time to claim the instance.
+func (o *Offer) IsExpired() bool {
+	return time.Now().After(o.Expires)
+}
+
+// Claim claims the offered instance for the host holding the Offer.
+func (o *Offer) Claim() (*Instance, error) {
+	if o.IsExpired() {
+		return nil, errorf(ErrUnauthorized, "%s expired", o)
+	}
+	sp, err := o.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	ins, err := getInstance(o.InstanceID, sp)
+	if err != nil {
+		return nil, err
+	}
+	return ins.Claim(o.Host)
+}
+
+// Decline gives up the Offer, so the instance can be offered to another host
+// right away. It fails with ErrUnauthorized if the instance has been offered
+// to another host in the meantime.
+func (o *Offer) Decline() error {
+	if err := o.guard.authorize(o.Host, ActionDecline, fmt.Sprintf("instance:%d", o.InstanceID)); err != nil {
+		return err
+	}
+	sp, err := o.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	current := &Offer{}
+	f, err := sp.GetFile(o.file.Path, &cp.JsonCodec{DecodedVal: current})
+	if cp.IsErrNoEnt(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Host != o.Host {
+		return errorf(ErrUnauthorized, "%s was declined by %s, but is offered to %s", o, o.Host, current.Host)
+	}
+	err = f.Del()
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	return nil
+}
+
+func (o *Offer) String() string {
+	return fmt.Sprintf("Offer{%d, %s}", o.InstanceID, o.Host)
+}
+
+// isOfferDue reports whether the instance may be offered to host. Hosts are
+// assigned instances by a hash of their ID, as ids like the ones of
+// SnowflakeIDs aren't spread evenly.
+func isOfferDue(ins *Instance, host string, hosts []string) bool {
+	if ins.HostConstraint != "" {
+		return ins.HostConstraint == host
+	}
+	if time.Since(ins.Registered) > OfferTTL {
+		return true
+	}
+	if len(hosts) == 0 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(ins.ID, 10)))
+	return hosts[h.Sum64()%uint64(len(hosts))] == host
+}
+
+func getOffer(ins *Instance, sp cp.Snapshot) (*Offer, error) {
+	offer := &Offer{}
+	f, err := sp.GetFile(ins.dir.Prefix(offerPath), &cp.JsonCodec{DecodedVal: offer})
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "no offer for %s", ins)
+		}
+		return nil, err
+	}
+	offer.file = f
+	offer.guard = ins.guard
+	return offer, nil
+}
+
+// checkOffer fails with ErrUnauthorized if the instance is offered to
+// another host.
+func (i *Instance) checkOffer(host string) error {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	offer, err := getOffer(i, sp)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if offer.Host != host && !offer.IsExpired() {
+		return errorf(ErrUnauthorized, "%s is offered to %s", i, offer.Host)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func offerSetup() *Store {
+	s, err := DialURI(DefaultURI, "/offer-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestNextOffer(t *testing.T) {
+	s := offerSetup()
+	hosts := []string{"10.0.0.1", "10.0.0.2"}
+	for _, host := range hosts {
+		if _, err := s.NewRunner(host+":9000", 0).Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ins, err := s.RegisterInstance("offer-cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := hosts[ins.ID%2]
+	other := hosts[(ins.ID+1)%2]
+
+	if _, err := s.NextOffer(other); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	offer, err := s.NextOffer(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.InstanceID != ins.ID || offer.Host != owner {
+		t.Errorf("expected offer of %d to %s, got %s", ins.ID, owner, offer)
+	}
+	again, err := s.NextOffer(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.InstanceID != offer.InstanceID {
+		t.Errorf("expected the same offer, got %s", again)
+	}
+
+	if _, err := ins.Claim(other); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	claimed, err := offer.Claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed.Status != InsStatusClaimed {
+		t.Errorf("expected instance to be claimed, got %s", claimed.Status)
+	}
+	if _, err := s.NextOffer(owner); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestOfferDecline(t *testing.T) {
+	s := offerSetup()
+	ins, err := s.RegisterInstanceWithOpts(InstanceOpts{
+		App:            "offer-cat",
+		Rev:            "128af9",
+		Proc:           "web",
+		Env:            "default",
+		HostConstraint: "10.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NextOffer("10.0.0.2"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	offer, err := s.NextOffer("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.InstanceID != ins.ID {
+		t.Errorf("expected offer of %d, got %s", ins.ID, offer)
+	}
+	other := *offer
+	other.Host = "10.0.0.2"
+	if err := other.Decline(); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error declining for another host, got %v", err)
+	}
+	if err := offer.Decline(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.Claim("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsOfferDueSpread(t *testing.T) {
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	offered := map[string]int{}
+	for i := int64(1); i <= 400; i++ {
+		// Snowflake ids of the first id of a millisecond.
+		ins := &Instance{ID: i << snowflakeSeqBits, Registered: time.Now()}
+		for _, host := range hosts {
+			if isOfferDue(ins, host, hosts) {
+				offered[host]++
+			}
+		}
+	}
+	for _, host := range hosts {
+		if offered[host] < 50 {
+			t.Errorf("expected instances to be spread over all hosts, got %v", offered)
+			break
+		}
+	}
+}
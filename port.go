@@ -0,0 +1,287 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const (
+	portsPath       = "ports"
+	portsRangePath  = "range"
+	portsNextPath   = "next"
+	portsFreePath   = "free"
+	defaultPortPool = "default"
+	maxPort         = 65535
+	// The default pool ends below the ports handed out per host.
+	defaultPortMax = hostPortMin - 1
+)
+
+// PortPool hands out the ports for procs of one type from a fixed range.
+// Ports released by unregistered procs are kept in a free-list and handed
+// out again before the range is advanced. Procs of types without a pool get
+// their ports from the default pool, which counts up from the cluster wide
+// next port to defaultPortMax, skipping the ranges of configured pools.
+type PortPool struct {
+	dir  *cp.Dir
+	Name string
+	Min  int
+	Max  int
+}
+
+// SetPortPool configures the range of ports procs of the given type are
+// assigned. Ranges of different pools must not overlap, neither with each
+// other nor with the host ports of ClaimHostPort. A range must also not
+// overlap ports the default pool already handed out, unless it lies within
+// the previous range of the pool.
+func (s *Store) SetPortPool(procType string, min, max int) (*PortPool, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if !reProcName.MatchString(procType) || procType == defaultPortPool {
+		return nil, errorf(ErrInvalidArgument, "invalid port pool name %q", procType)
+	}
+	if min < 1 || max > maxPort || min > max {
+		return nil, errorf(ErrInvalidPort, "invalid port range %d-%d", min, max)
+	}
+	if err := s.guard.authorize("", ActionPort, "port-pool:"+procType); err != nil {
+		return nil, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := s.join(sp).GetPortPools()
+	if err != nil {
+		return nil, err
+	}
+	var prev *PortPool
+	for _, p := range pools {
+		if p.Name == procType {
+			prev = p
+			continue
+		}
+		if min <= p.Max && max >= p.Min {
+			return nil, errorf(ErrConflict, "port range %d-%d overlaps pool %s", min, max, p)
+		}
+	}
+	if max >= hostPortMin {
+		return nil, errorf(ErrConflict, "port range %d-%d overlaps host ports from %d on", min, max, hostPortMin)
+	}
+	if prev == nil || min < prev.Min || max > prev.Max {
+		next, _, err := sp.Get(nextPortPath)
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		if n, _ := strconv.Atoi(next); min < n && max >= startPort {
+			return nil, errorf(ErrConflict, "port range %d-%d overlaps ports handed out by the default pool up to %d", min, max, n-1)
+		}
+	}
+
+	pool := &PortPool{
+		Name: procType,
+		Min:  min,
+		Max:  max,
+		dir:  cp.NewDir(path.Join(portsPath, procType), sp),
+	}
+	f, err := pool.dir.Join(sp).Set(portsRangePath, fmt.Sprintf("%d %d", min, max))
+	if err != nil {
+		return nil, err
+	}
+	pool.dir = f
+	return pool, nil
+}
+
+// GetPortPools returns all configured port pools.
+func (s *Store) GetPortPools() ([]*PortPool, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	names, err := getdirOrEmpty(sp, portsPath)
+	if err != nil {
+		return nil, err
+	}
+	pools := []*PortPool{}
+	for _, name := range names {
+		if name == defaultPortPool {
+			continue
+		}
+		pool, err := getPortPool(name, sp)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (p *PortPool) GetSnapshot() cp.Snapshot {
+	return p.dir.Snapshot
+}
+
+// Contains reports whether the port lies in the range of the pool.
+func (p *PortPool) Contains(port int) bool {
+	return port >= p.Min && port <= p.Max
+}
+
+// FreePorts returns the released ports waiting to be handed out again.
+func (p *PortPool) FreePorts() ([]int, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getFreePorts(p, sp)
+}
+
+func (p *PortPool) String() string {
+	return fmt.Sprintf("PortPool<%s>{%d-%d}", p.Name, p.Min, p.Max)
+}
+
+// claim hands out a port, preferring released ones.
+func (p *PortPool) claim() (int, error) {
+	for {
+		sp, err := p.GetSnapshot().FastForward()
+		if err != nil {
+			return -1, err
+		}
+
+		free, err := getFreePorts(p, sp)
+		if err != nil {
+			return -1, err
+		}
+		if len(free) > 0 {
+			port := free[0]
+			err := sp.Del(p.dir.Prefix(portsFreePath, strconv.Itoa(port)))
+			if err == nil && p.Contains(port) {
+				return port, nil
+			}
+			if err != nil && !cp.IsErrNoEnt(err) && !cp.IsErrRevMismatch(err) {
+				return -1, err
+			}
+			// Lost the race for it or it was dropped from the range.
+			continue
+		}
+
+		if p.Name == defaultPortPool {
+			return claimNextPort(sp)
+		}
+
+		f, err := sp.GetFile(p.dir.Prefix(portsNextPath), new(cp.IntCodec))
+		if cp.IsErrNoEnt(err) {
+			f, err = cp.NewFile(p.dir.Prefix(portsNextPath), p.Min, new(cp.IntCodec), sp), nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		port := f.Value.(int)
+		if port < p.Min {
+			port = p.Min
+		}
+		if port > p.Max {
+			return -1, errorf(ErrNoPorts, "%s is exhausted", p)
+		}
+		if _, err := f.Set(port + 1); err == nil {
+			return port, nil
+		} else if !cp.IsErrRevMismatch(err) {
+			return -1, err
+		}
+		time.Sleep(time.Second / 10)
+	}
+}
+
+// release puts the port on the free-list.
+func (p *PortPool) release(port int) error {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	_, err = sp.Set(p.dir.Prefix(portsFreePath, strconv.Itoa(port)), timestamp())
+	return err
+}
+
+// claimPort claims a port from the pool of the given proc type.
+func claimPort(sp cp.Snapshot, procType string) (int, error) {
+	pool, err := getPortPool(procType, sp)
+	if IsErrNotFound(err) {
+		pool, err = getDefaultPortPool(sp), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return pool.claim()
+}
+
+// releasePort returns the port to the pool whose range contains it.
+func releasePort(sp cp.Snapshot, port int) error {
+	if port <= 0 {
+		return nil
+	}
+	pools, err := storeFromSnapshotable(sp).GetPortPools()
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		if pool.Contains(port) {
+			return pool.release(port)
+		}
+	}
+	return getDefaultPortPool(sp).release(port)
+}
+
+func getPortPool(name string, sp cp.Snapshot) (*PortPool, error) {
+	pool := &PortPool{
+		Name: name,
+		dir:  cp.NewDir(path.Join(portsPath, name), sp),
+	}
+	f, err := pool.dir.GetFile(portsRangePath, new(cp.ListIntCodec))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "port pool %s not found", name)
+		}
+		return nil, err
+	}
+	fields := f.Value.([]int)
+	if len(fields) != 2 {
+		return nil, errorf(ErrInvalidFile, "port range of %s has %d instead of 2 fields", name, len(fields))
+	}
+	pool.Min, pool.Max = fields[0], fields[1]
+	return pool, nil
+}
+
+func getDefaultPortPool(sp cp.Snapshot) *PortPool {
+	return &PortPool{
+		Name: defaultPortPool,
+		Min:  startPort,
+		Max:  defaultPortMax,
+		dir:  cp.NewDir(path.Join(portsPath, defaultPortPool), sp),
+	}
+}
+
+func getFreePorts(p *PortPool, sp cp.Snapshot) ([]int, error) {
+	names, err := getdirOrEmpty(sp, p.dir.Prefix(portsFreePath))
+	if err != nil {
+		return nil, err
+	}
+	ports := []int{}
+	for _, name := range names {
+		port, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, errorf(ErrInvalidPort, "invalid free port %s in %s", name, p)
+		}
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func portSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/port-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPortPool(t *testing.T) {
+	s := portSetup(t)
+
+	if _, err := s.SetPortPool("web", 9000, 9003); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetPortPool("worker", 9002, 9010); !IsErrConflict(err) {
+		t.Errorf("expected overlapping range to conflict, got %v", err)
+	}
+	if _, err := s.SetPortPool("worker", 9010, 9005); !IsErrInvalidPort(err) {
+		t.Errorf("expected invalid port error, got %v", err)
+	}
+
+	procs := []*Proc{}
+	for i := 0; i < 2; i++ {
+		app, err := s.NewApp(fmt.Sprintf("port-%d", i), "git://port.git", "stack").Register()
+		if err != nil {
+			t.Fatal(err)
+		}
+		proc, err := s.NewProc(app, "web").Register()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proc.Port < 9000 || proc.Port > 9003 || proc.ControlPort < 9000 || proc.ControlPort > 9003 {
+			t.Errorf("expected ports of %s in pool range, got %d and %d", proc, proc.Port, proc.ControlPort)
+		}
+		procs = append(procs, proc)
+	}
+
+	app, err := s.NewApp("port-2", "git://port.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewProc(app, "web").Register(); !IsErrNoPorts(err) {
+		t.Errorf("expected pool to be exhausted, got %v", err)
+	}
+
+	// Other proc types keep using the default pool.
+	other, err := s.NewProc(app, "cron").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Port < startPort || (other.Port >= 9000 && other.Port <= 9003) {
+		t.Errorf("expected port from default pool, got %d", other.Port)
+	}
+
+	if err := procs[0].Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc.Port != procs[0].Port && proc.Port != procs[0].ControlPort {
+		t.Errorf("expected released port to be reused, got %d", proc.Port)
+	}
+}
+
+func TestSkipPortPools(t *testing.T) {
+	pools := []*PortPool{{Name: "web", Min: 8002, Max: 8003}, {Name: "worker", Min: 8000, Max: 8001}}
+	for port, want := range map[int]int{7999: 7999, 8000: 8004, 8002: 8004, 8004: 8004} {
+		if have := skipPortPools(port, pools); have != want {
+			t.Errorf("expected %d to skip to %d, got %d", port, want, have)
+		}
+	}
+}
+
+func TestPortPoolDefaultRange(t *testing.T) {
+	s := portSetup(t)
+
+	if _, err := s.SetPortPool("web", startPort, startPort+1); err != nil {
+		t.Fatal(err)
+	}
+	app, err := s.NewApp("port-default", "git://port.git", "stack").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "cron").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc.Port != startPort+2 || proc.ControlPort != startPort+3 {
+		t.Errorf("expected default ports to skip the web pool, got %d and %d", proc.Port, proc.ControlPort)
+	}
+
+	if _, err := s.SetPortPool("worker", startPort+2, startPort+10); !IsErrConflict(err) {
+		t.Errorf("expected range with handed out ports to conflict, got %v", err)
+	}
+	if _, err := s.SetPortPool("worker", 9000, hostPortMin); !IsErrConflict(err) {
+		t.Errorf("expected range with host ports to conflict, got %v", err)
+	}
+	if _, err := s.SetPortPool("web", startPort, startPort); err != nil {
+		t.Errorf("expected pool to shrink within its range, got %v", err)
+	}
+}
@@ -6,43 +6,293 @@
 package visor
 
 import (
+	"context"
 	"fmt"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
 )
 
-var reProcName = regexp.MustCompile("^[[:alnum:]]+$")
+var (
+	reProcName = regexp.MustCompile("^[[:alnum:]]+$")
+	reZoneName = regexp.MustCompile("^[[:alnum:]][-.[:alnum:]]*$")
+	reEnvName  = regexp.MustCompile("^[[:alnum:]][-.[:alnum:]]*$")
+)
 
 // Proc represents a process type with a certain scale.
 type Proc struct {
 	dir         *cp.Dir
+	closer      *closer
+	reads       *readLimit
+	guard       *guard
 	Name        string
 	App         *App
 	Port        int
 	ControlPort int
 	Attrs       ProcAttrs
+	Labels      map[string]string
 	Registered  time.Time
+	// Actor which registered the proc, empty if unknown.
+	RegisteredBy string
 }
 
 // ProcAttrs are mutable extra information for a proc.
 type ProcAttrs struct {
-	Limits         ResourceLimits  `json:"limits"`
-	LogPersistence bool            `json:"log_persistence"`
-	TrafficControl *TrafficControl `json:"trafficControl"`
+	Limits         ResourceLimits   `json:"limits"`
+	LogPersistence bool             `json:"log_persistence"`
+	TrafficControl *TrafficControl  `json:"trafficControl"`
+	CrashLoop      *CrashLoopPolicy `json:"crash-loop,omitempty"`
+	// Restarts after which an instance is failed, unlimited if 0.
+	MaxRestarts int               `json:"max-restarts,omitempty"`
+	Reschedule  *ReschedulePolicy `json:"reschedule,omitempty"`
+	LogRouting  *LogRouting       `json:"log-routing,omitempty"`
+	// Instances stopped at once by Drain, DefaultDrainParallelism if 0.
+	DrainParallelism int `json:"drain-parallelism,omitempty"`
+	// How a Reconciler replaces instances of one revision with another.
+	Strategy *DeployStrategy `json:"strategy,omitempty"`
+	// What SweepClaims does with stale claims, StaleClaimUnclaim if empty.
+	StaleClaims StaleClaimPolicy `json:"stale-claims,omitempty"`
+	// How runners tell whether instances are healthy.
+	Healthcheck *Healthcheck `json:"healthcheck,omitempty"`
+	// Whether instances only take traffic once they are ready, see
+	// Instance.Ready.
+	ReadinessGate bool `json:"readiness-gate,omitempty"`
+	// Actor which stored the attrs, set by StoreAttrs.
+	UpdatedBy string `json:"updated-by,omitempty"`
+}
+
+// Validate checks all attrs which have constraints.
+func (a ProcAttrs) Validate() error {
+	if err := a.Limits.Validate(); err != nil {
+		return err
+	}
+	if a.MaxRestarts < 0 {
+		return errorf(ErrInvalidArgument, "max restarts must not be negative")
+	}
+	if a.DrainParallelism < 0 {
+		return errorf(ErrInvalidArgument, "drain parallelism must not be negative")
+	}
+	if a.CrashLoop != nil {
+		if err := a.CrashLoop.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.TrafficControl != nil {
+		if err := a.TrafficControl.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.Reschedule != nil {
+		if err := a.Reschedule.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.LogRouting != nil {
+		if err := a.LogRouting.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.Strategy != nil {
+		if err := a.Strategy.Validate(); err != nil {
+			return err
+		}
+	}
+	if a.Healthcheck != nil {
+		if err := a.Healthcheck.Validate(); err != nil {
+			return err
+		}
+	}
+	switch a.StaleClaims {
+	case "", StaleClaimUnclaim, StaleClaimFail:
+	default:
+		return errorf(ErrInvalidArgument, "unknown stale claim policy %q", a.StaleClaims)
+	}
+	return nil
+}
+
+// Types of DeployStrategy.
+const (
+	DeployRolling   = "rolling"
+	DeployRecreate  = "recreate"
+	DeployBlueGreen = "blue-green"
+)
+
+// DeployStrategy defines how instances of a proc are replaced when the
+// scales of its revisions in an env change:
+//
+//   - rolling keeps at most MaxSurge instances more than desired and at most
+//     MaxUnavailable running instances less than desired.
+//   - recreate stops all instances which aren't desired before any new
+//     instance is registered.
+//   - blue-green registers all new instances at once and only stops the old
+//     ones once every revision runs at its scale.
+type DeployStrategy struct {
+	Type           string `json:"type"`
+	MaxUnavailable int    `json:"max-unavailable,omitempty"`
+	MaxSurge       int    `json:"max-surge,omitempty"`
+}
+
+// Validate checks that the strategy can make progress.
+func (d *DeployStrategy) Validate() error {
+	switch d.Type {
+	case DeployRolling:
+		if d.MaxUnavailable < 0 || d.MaxSurge < 0 {
+			return errorf(ErrInvalidArgument, "max unavailable and max surge must not be negative")
+		}
+		if d.MaxUnavailable == 0 && d.MaxSurge == 0 {
+			return errorf(ErrInvalidArgument, "max unavailable and max surge must not both be 0")
+		}
+	case DeployRecreate, DeployBlueGreen:
+		if d.MaxUnavailable != 0 || d.MaxSurge != 0 {
+			return errorf(ErrInvalidArgument, "max unavailable and max surge only apply to %s", DeployRolling)
+		}
+	default:
+		return errorf(ErrInvalidArgument, "invalid deploy strategy %q", d.Type)
+	}
+	return nil
+}
+
+// Log levels of LogRouting.
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// LogRouting configures how the logs of instances of a proc are handled by
+// the loggers. Zero values leave the decision to the loggers.
+type LogRouting struct {
+	// Days logs are kept, forever if 0.
+	RetentionDays int `json:"retention-days,omitempty"`
+	// Minimum level of lines which are shipped.
+	Level string `json:"level,omitempty"`
+	// Group of loggers the logs are sent to, see LoggerInfo.Group.
+	LoggerGroup string `json:"logger-group,omitempty"`
+	// Fraction of lines which are shipped, all if 0.
+	SampleRate float64 `json:"sample-rate,omitempty"`
+}
+
+// Validate checks that the routing can be applied by the loggers.
+func (l *LogRouting) Validate() error {
+	if l.RetentionDays < 0 {
+		return errorf(ErrInvalidArgument, "log retention must not be negative")
+	}
+	switch l.Level {
+	case "", LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		return errorf(ErrInvalidArgument, "invalid log level %q", l.Level)
+	}
+	if l.LoggerGroup != "" && !rServiceKind.MatchString(l.LoggerGroup) {
+		return errorf(ErrInvalidArgument, "invalid logger group %q", l.LoggerGroup)
+	}
+	if l.SampleRate < 0 || l.SampleRate > 1 {
+		return errorf(ErrInvalidArgument, "log sample rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// CrashLoopPolicy defines when instances of a proc are crash looping: if
+// they restarted at least Restarts times within the last WindowSec seconds.
+type CrashLoopPolicy struct {
+	Restarts  int `json:"restarts"`
+	WindowSec int `json:"window-sec"`
+}
+
+// Validate checks that the policy can be met.
+func (c *CrashLoopPolicy) Validate() error {
+	if c.Restarts < 1 || c.WindowSec < 1 {
+		return errorf(ErrInvalidArgument, "crash loop restarts and window must be positive")
+	}
+	return nil
+}
+
+// ReschedulePolicy defines the backoff before failed instances of a proc are
+// replaced. The first replacement is delayed by InitialSec seconds, every
+// further one in the same lineage by Multiplier times the previous delay, up
+// to MaxSec seconds.
+type ReschedulePolicy struct {
+	InitialSec int     `json:"initial-sec"`
+	Multiplier float64 `json:"multiplier"`
+	MaxSec     int     `json:"max-sec"`
+}
+
+// Validate checks that the delays of the policy never shrink.
+func (r *ReschedulePolicy) Validate() error {
+	if r.InitialSec < 0 || r.Multiplier < 1 || r.MaxSec < r.InitialSec {
+		return errorf(ErrInvalidArgument, "reschedule backoff must not shrink")
+	}
+	return nil
+}
+
+// Delay returns the backoff after the given number of consecutive failures.
+func (r *ReschedulePolicy) Delay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	delay := float64(r.InitialSec)
+	for i := 1; i < failures && delay < float64(r.MaxSec); i++ {
+		delay *= r.Multiplier
+	}
+	if delay > float64(r.MaxSec) {
+		delay = float64(r.MaxSec)
+	}
+	return time.Duration(delay * float64(time.Second))
 }
 
+// Bounds of the cgroup cpu shares.
+const (
+	minCPUShares = 2
+	maxCPUShares = 262144
+)
+
 // ResourceLimits are per proc constraints like memory/cpu.
 type ResourceLimits struct {
 	// Maximum memory allowance in MB for an instance of this Proc.
 	MemoryLimitMb *int `json:"memory-limit-mb,omitemproc"`
+	// Relative cpu weight of an instance, see cgroup cpu.shares.
+	CPUShares *int `json:"cpu-shares,omitempty"`
+	// Maximum cpu time of an instance in percent of one core.
+	CPUQuotaPercent *int `json:"cpu-quota-percent,omitempty"`
+	// Maximum disk usage in MB of an instance.
+	DiskQuotaMb *int `json:"disk-quota-mb,omitempty"`
+	// Maximum number of open file descriptors of an instance.
+	MaxFileDescriptors *int `json:"max-file-descriptors,omitempty"`
+}
+
+// Validate checks that all set limits are in range.
+func (l ResourceLimits) Validate() error {
+	for _, limit := range []struct {
+		name string
+		v    *int
+	}{
+		{"memory limit", l.MemoryLimitMb},
+		{"cpu quota", l.CPUQuotaPercent},
+		{"disk quota", l.DiskQuotaMb},
+		{"max file descriptors", l.MaxFileDescriptors},
+	} {
+		if limit.v != nil && *limit.v <= 0 {
+			return errorf(ErrInvalidArgument, "%s must be positive, got %d", limit.name, *limit.v)
+		}
+	}
+	if l.CPUShares != nil && (*l.CPUShares < minCPUShares || *l.CPUShares > maxCPUShares) {
+		return errorf(ErrInvalidArgument, "cpu shares must be within %d-%d, got %d", minCPUShares, maxCPUShares, *l.CPUShares)
+	}
+	return nil
 }
 
 // TrafficControl enables and sets traffic shares a proc should receive.
 type TrafficControl struct {
 	Share int `json:"share"`
+	// Steps the share is moved through by AdvanceRamp, optional.
+	Ramp *RampPlan `json:"ramp,omitempty"`
+	// Shares of the traffic per zone, see Instance.Zone. They add up to 100
+	// if set.
+	ZoneShares map[string]int `json:"zone-shares,omitempty"`
 }
 
 // Validate checks if the configured traffic share is in the allowed
@@ -51,6 +301,24 @@ func (t *TrafficControl) Validate() error {
 	if t.Share < 0 || t.Share > 100 {
 		return errorf(ErrInvalidShare, "must be between 0 and 100")
 	}
+	if len(t.ZoneShares) > 0 {
+		total := 0
+		for zone, share := range t.ZoneShares {
+			if !reZoneName.MatchString(zone) {
+				return errorf(ErrInvalidArgument, "invalid zone name %q", zone)
+			}
+			if share < 0 || share > 100 {
+				return errorf(ErrInvalidShare, "share of zone %s must be between 0 and 100", zone)
+			}
+			total += share
+		}
+		if total != 100 {
+			return errorf(ErrInvalidShare, "zone shares must add up to 100, got %d", total)
+		}
+	}
+	if t.Ramp != nil {
+		return t.Ramp.Validate()
+	}
 
 	return nil
 }
@@ -60,14 +328,24 @@ const (
 	procsPortPath        = "port"
 	procsControlPortPath = "port-control"
 	procsAttrsPath       = "attrs"
+	procsEnvAttrsPath    = "env-attrs"
+	// Number of running instances, see NumInstances.
+	procsInstanceCountPath = "instance-count"
+	// Totals of terminated instances, see Counters.
+	procsCountersPath = "counters"
+	// Env receiving the traffic, see App.SetActiveEnv.
+	procsActiveEnvPath = "active-env"
 )
 
 // NewProc creates a Proc given App and name.
 func (s *Store) NewProc(app *App, name string) *Proc {
 	return &Proc{
-		Name: name,
-		App:  app,
-		dir:  cp.NewDir(app.dir.Prefix(procsPath, string(name)), s.GetSnapshot()),
+		Name:   name,
+		App:    app,
+		dir:    cp.NewDir(app.dir.Prefix(procsPath, string(name)), s.GetSnapshot()),
+		closer: s.closer,
+		reads:  s.reads,
+		guard:  s.guard,
 	}
 }
 
@@ -77,7 +355,11 @@ func (p *Proc) GetSnapshot() cp.Snapshot {
 }
 
 // Register registers a proc with the registry.
-func (p *Proc) Register() (*Proc, error) {
+func (p *Proc) Register() (_ *Proc, err error) {
+	defer p.annotate(&err, "register")
+	if err := guardOf(p).authorize("", AuditRegister, p.auditName()); err != nil {
+		return nil, err
+	}
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -88,17 +370,27 @@ func (p *Proc) Register() (*Proc, error) {
 		return nil, err
 	}
 	if exists {
-		return nil, ErrConflict
+		return nil, errorf(ErrConflict, `proc "%s" of %s already exists`, p.Name, p.App.Name)
 	}
 
 	if !reProcName.MatchString(p.Name) {
 		return nil, ErrBadProcName
 	}
 
-	p.Port, err = claimNextPort(sp)
+	p.Port, err = claimPort(sp, p.Name)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't claim port: %s", err)
+		return nil, errorf(unwrapErr(err), "couldn't claim port: %w", err)
 	}
+	// A failed registration gives its ports back and removes what it wrote.
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, port := range []int{p.Port, p.ControlPort} {
+			releasePort(sp, port)
+		}
+		p.dir.Join(sp).Del("/")
+	}()
 
 	port := cp.NewFile(p.dir.Prefix(procsPortPath), p.Port, new(cp.IntCodec), sp)
 	port, err = port.Save()
@@ -107,9 +399,9 @@ func (p *Proc) Register() (*Proc, error) {
 	}
 
 	// Claim control port.
-	p.ControlPort, err = claimNextPort(sp)
+	p.ControlPort, err = claimPort(sp, p.Name)
 	if err != nil {
-		return nil, fmt.Errorf("claim control port: %s", err)
+		return nil, errorf(unwrapErr(err), "claim control port: %w", err)
 	}
 
 	controlPort := cp.NewFile(p.dir.Prefix(procsControlPortPath), p.ControlPort, new(cp.IntCodec), sp)
@@ -123,23 +415,44 @@ func (p *Proc) Register() (*Proc, error) {
 		return nil, err
 	}
 
-	d, err := p.dir.Join(sp).Set(registeredPath, formatTime(reg))
+	count := cp.NewFile(p.dir.Prefix(procsInstanceCountPath), 0, new(cp.IntCodec), sp)
+	if _, err = count.Save(); err != nil {
+		return nil, err
+	}
+
+	p.RegisteredBy = actorOf(p)
+	d, err := p.dir.Join(sp).Set(registeredPath, formatRegistered(reg, p.RegisteredBy))
 	if err != nil {
 		return nil, err
 	}
 	p.Registered = reg
 	p.dir = d
 
+	audit(p, "", AuditRegister, p.auditName())
+
 	return p, nil
 }
 
-// Unregister unregisters a proc from the registry.
-func (p *Proc) Unregister() error {
+// Unregister unregisters a proc from the registry and releases its ports.
+func (p *Proc) Unregister() (err error) {
+	defer p.annotate(&err, "unregister")
+	if err := guardOf(p).authorize("", AuditUnregister, p.auditName()); err != nil {
+		return err
+	}
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return err
 	}
-	return p.dir.Join(sp).Del("/")
+	if err := p.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	for _, port := range []int{p.Port, p.ControlPort} {
+		if err := releasePort(sp, port); err != nil {
+			return err
+		}
+	}
+	audit(sp, actorOf(p), AuditUnregister, p.auditName())
+	return nil
 }
 
 // DoneInstancesPath returns the doozerd path where done instances are stored.
@@ -159,20 +472,36 @@ func (p *Proc) lostInstancesPath() string {
 	return p.dir.Prefix(lostPath)
 }
 
-// NumInstances returns the number of instances running for a proc.
+// NumInstances returns the number of instances running for a proc. It reads
+// the counter maintained on registration and termination of instances and
+// only counts the lookup entries of every revision for procs registered
+// before the counter existed.
 func (p *Proc) NumInstances() (int, error) {
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return -1, err
 	}
-	revs, err := sp.Getdir(p.dir.Prefix("instances"))
+	f, err := sp.GetFile(p.dir.Prefix(procsInstanceCountPath), new(cp.IntCodec))
+	if err == nil {
+		return f.Value.(int), nil
+	}
+	if !cp.IsErrNoEnt(err) {
+		return -1, err
+	}
+	return countInstances(p.dir.Name, sp)
+}
+
+// countInstances counts the lookup entries of running instances below the
+// proc dir.
+func countInstances(dir string, sp cp.Snapshot) (int, error) {
+	revs, err := getdirOrEmpty(sp, path.Join(dir, instancesPath))
 	if err != nil {
 		return -1, err
 	}
 	total := 0
 
 	for _, rev := range revs {
-		size, _, err := sp.Stat(p.dir.Prefix("instances", rev), &sp.Rev)
+		size, _, err := sp.Stat(path.Join(dir, instancesPath, rev), &sp.Rev)
 		if err != nil {
 			return -1, err
 		}
@@ -209,6 +538,129 @@ func (p *Proc) GetFailedInstances() ([]*Instance, error) {
 	return getSerialisedInstances(ids, InsStatusFailed, p, sp)
 }
 
+// GetFailedInstancesSince returns the instances which failed at or after t,
+// including those unregistered since. Only the day buckets of the failure
+// index from t on are read. Failures from before the index was kept and done
+// entries which were removed aren't returned.
+func (p *Proc) GetFailedInstancesSince(t time.Time) ([]*Instance, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	days, err := getdirOrEmpty(sp, p.dir.Prefix(failedDaysPath))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(days)
+
+	since := formatDay(t)
+	instances := []*Instance{}
+	for _, day := range days {
+		if day < since {
+			continue
+		}
+		ids, err := getdirOrEmpty(sp, p.dir.Prefix(failedDaysPath, day))
+		if err != nil {
+			return nil, err
+		}
+		for _, idstr := range ids {
+			id, err := parseInstanceID(idstr)
+			if err != nil {
+				return nil, err
+			}
+			ins, err := getFailedRecord(p.App.Name, p.Name, id, day, sp)
+			if err != nil {
+				return nil, err
+			}
+			if ins == nil || ins.Termination.Time.Before(t) {
+				continue
+			}
+			instances = append(instances, ins)
+		}
+	}
+	return instances, nil
+}
+
+// getFailedRecord returns the serialised instance which failed on day from
+// the failed or, once unregistered, the done lookup or the archive. Nil if it
+// is in none of them.
+func getFailedRecord(app, proc string, id int64, day string, sp cp.Snapshot) (*Instance, error) {
+	for _, status := range []InsStatus{InsStatusFailed, InsStatusDone} {
+		ins := &Instance{ID: id, AppName: app, ProcessName: proc}
+		exists, _, err := sp.Exists(ins.procStatusPath(status))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return getSerialisedInstance(app, proc, id, status, sp)
+		}
+	}
+	return getArchivedRecord(app, proc, id, day, sp)
+}
+
+// NextRescheduleTime returns when the failed or lost instance should be
+// replaced according to the reschedule policy of the proc for its env. The backoff grows
+// with every failed predecessor in the lineage of the instance. Without a
+// policy it's the time the instance terminated.
+func (p *Proc) NextRescheduleTime(ins *Instance) (time.Time, error) {
+	terminated := ins.Termination.Time
+	if terminated.IsZero() {
+		terminated = time.Now()
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return time.Time{}, err
+	}
+	attrs, err := getProcAttrsForEnv(p.App.Name, p.Name, ins.Env, sp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if attrs.Reschedule == nil {
+		return terminated, nil
+	}
+	failures, err := countFailedLineage(ins, sp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return terminated.Add(attrs.Reschedule.Delay(failures)), nil
+}
+
+// countFailedLineage counts the instance and the terminated instances it
+// replaced, directly or transitively.
+func countFailedLineage(ins *Instance, sp cp.Snapshot) (int, error) {
+	failures := 1
+	seen := map[int64]bool{ins.ID: true}
+	for id := ins.Replaces; id != 0 && !seen[id]; {
+		seen[id] = true
+		prev, err := getTerminatedInstance(ins.AppName, ins.ProcessName, id, sp)
+		if err != nil {
+			return 0, err
+		}
+		if prev == nil {
+			break
+		}
+		failures++
+		id = prev.Replaces
+	}
+	return failures, nil
+}
+
+// getTerminatedInstance returns the serialised failed or lost instance with
+// the given id, nil if there is none.
+func getTerminatedInstance(app, proc string, id int64, sp cp.Snapshot) (*Instance, error) {
+	ins := &Instance{ID: id, AppName: app, ProcessName: proc}
+	for _, status := range []InsStatus{InsStatusFailed, InsStatusLost} {
+		exists, _, err := sp.Exists(ins.procStatusPath(status))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return getSerialisedInstance(app, proc, id, status, sp)
+		}
+	}
+	return nil, nil
+}
+
 // GetLostInstances returns all Instances in lost state.
 func (p *Proc) GetLostInstances() ([]*Instance, error) {
 	sp, err := p.GetSnapshot().FastForward()
@@ -237,7 +689,81 @@ func (p *Proc) GetInstances() ([]*Instance, error) {
 		s := strconv.FormatInt(id, 10)
 		idStrs = append(idStrs, s)
 	}
-	return getProcInstances(idStrs, sp)
+	return getProcInstances(p.reads, idStrs, sp)
+}
+
+// GetInstancesPage returns at most limit Instances of the proc ordered by id,
+// skipping the first offset, together with the total number of instances.
+func (p *Proc) GetInstancesPage(offset, limit int) ([]*Instance, int, error) {
+	if err := validatePage(offset, limit); err != nil {
+		return nil, 0, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := getProcInstanceIds(p, sp)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*Instance{}, 0, nil
+		}
+		return nil, 0, err
+	}
+	idStrs := []string{}
+	for _, id := range ids {
+		idStrs = append(idStrs, strconv.FormatInt(id, 10))
+	}
+	page, total, err := pageIds(idStrs, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	ins, err := getProcInstances(p.reads, page, sp)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Sort(instancesByID(ins))
+	return ins, total, nil
+}
+
+// GetDoneInstancesPage is the paginated form of GetDoneInstances.
+func (p *Proc) GetDoneInstancesPage(offset, limit int) ([]*Instance, int, error) {
+	return p.getSerialisedInstancesPage(p.DoneInstancesPath(), InsStatusDone, offset, limit)
+}
+
+// GetFailedInstancesPage is the paginated form of GetFailedInstances.
+func (p *Proc) GetFailedInstancesPage(offset, limit int) ([]*Instance, int, error) {
+	return p.getSerialisedInstancesPage(p.failedInstancesPath(), InsStatusFailed, offset, limit)
+}
+
+// GetLostInstancesPage is the paginated form of GetLostInstances.
+func (p *Proc) GetLostInstancesPage(offset, limit int) ([]*Instance, int, error) {
+	return p.getSerialisedInstancesPage(p.lostInstancesPath(), InsStatusLost, offset, limit)
+}
+
+func (p *Proc) getSerialisedInstancesPage(dir string, state InsStatus, offset, limit int) ([]*Instance, int, error) {
+	if err := validatePage(offset, limit); err != nil {
+		return nil, 0, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := sp.Getdir(dir)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return []*Instance{}, 0, nil
+		}
+		return nil, 0, err
+	}
+	page, total, err := pageIds(ids, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	ins, err := getSerialisedInstances(page, state, p, sp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ins, total, nil
 }
 
 // GetRunningRevs returns all revs with at least one running instance.
@@ -254,27 +780,202 @@ func (p Proc) GetRunningRevs() ([]string, error) {
 }
 
 // StoreAttrs saves the set Attrs for the Proc.
-func (p *Proc) StoreAttrs() (*Proc, error) {
-	if p.Attrs.TrafficControl != nil {
-		if err := p.Attrs.TrafficControl.Validate(); err != nil {
-			return nil, err
-		}
+func (p *Proc) StoreAttrs() (_ *Proc, err error) {
+	defer p.annotate(&err, "store-attrs")
+	if err := guardOf(p).authorize("", AuditAttrs, p.auditName()); err != nil {
+		return nil, err
+	}
+	if err := p.Attrs.Validate(); err != nil {
+		return nil, err
 	}
 
 	sp, err := p.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
 	}
-	attrs := cp.NewFile(p.dir.Prefix(procsAttrsPath), p.Attrs, new(cp.JsonCodec), sp)
+	prev, err := getProcAttrs(p.App.Name, p.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	p.Attrs.versionHealthcheck(prev)
+	p.Attrs.UpdatedBy = actorOf(p)
+	codec, err := encodeCodec(sp, CodecProcAttrs)
+	if err != nil {
+		return nil, err
+	}
+	attrs := cp.NewFile(p.dir.Prefix(procsAttrsPath), p.Attrs, codec, sp)
 	attrs, err = attrs.Save()
 	if err != nil {
 		return nil, err
 	}
 	p.dir = p.dir.Join(attrs)
 
+	audit(p, "", AuditAttrs, p.auditName())
+
+	return p, nil
+}
+
+// StoreAttrsForEnv saves attrs which apply to instances of the given env
+// instead of the proc-wide Attrs.
+func (p *Proc) StoreAttrsForEnv(env string, attrs ProcAttrs) (_ *Proc, err error) {
+	defer p.annotate(&err, "store-attrs")
+	if err := guardOf(p).authorize("", AuditAttrs, p.auditName()+"@"+env); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	if err := attrs.Validate(); err != nil {
+		return nil, err
+	}
+
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	prev, err := getProcAttrsForEnv(p.App.Name, p.Name, env, sp)
+	if err != nil {
+		return nil, err
+	}
+	attrs.versionHealthcheck(prev)
+	attrs.UpdatedBy = actorOf(p)
+	codec, err := encodeCodec(sp, CodecProcAttrs)
+	if err != nil {
+		return nil, err
+	}
+	f, err := cp.NewFile(p.dir.Prefix(procsEnvAttrsPath, env), attrs, codec, sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	p.dir = p.dir.Join(f)
+
+	audit(p, "", AuditAttrs, p.auditName()+"@"+env)
+	return p, nil
+}
+
+// DelAttrsForEnv removes the attrs of the given env, so its instances fall
+// back to the proc-wide Attrs.
+func (p *Proc) DelAttrsForEnv(env string) (_ *Proc, err error) {
+	defer p.annotate(&err, "del-attrs")
+	if err := guardOf(p).authorize("", AuditAttrs, p.auditName()+"@"+env); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	err = sp.Del(p.dir.Prefix(procsEnvAttrsPath, env))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "no attrs for env %s of %s", env, p)
+		}
+		return nil, err
+	}
+	if sp, err = sp.FastForward(); err != nil {
+		return nil, err
+	}
+	p.dir = p.dir.Join(sp)
+
+	audit(p, "", AuditAttrs, p.auditName()+"@"+env)
 	return p, nil
 }
 
+// validateEnv returns ErrInvalidArgument for env names which would escape
+// the directory they are joined into.
+func validateEnv(env string) error {
+	if !reEnvName.MatchString(env) {
+		return errorf(ErrInvalidArgument, "invalid env name %q", env)
+	}
+	return nil
+}
+
+// GetAttrsForEnv returns the attrs which apply to instances of the given env:
+// the attrs stored for it or the proc-wide Attrs if there are none.
+func (p *Proc) GetAttrsForEnv(env string) (ProcAttrs, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return ProcAttrs{}, err
+	}
+	return getProcAttrsForEnv(p.App.Name, p.Name, env, sp)
+}
+
+// SetEnvironmentVar stores the value for the given key which only applies to
+// instances of this proc, overriding app-wide and env-qualified values.
+func (p *Proc) SetEnvironmentVar(k, v string) (_ *Proc, err error) {
+	defer p.annotate(&err, "set-env")
+	if err := guardOf(p).authorize("", AuditEnv, p.auditName()); err != nil {
+		return nil, err
+	}
+	d, err := p.dir.Set(path.Join(envPath, envKey(k)), v)
+	if err != nil {
+		return nil, err
+	}
+	p.dir = d
+
+	audit(p, "", AuditEnv, p.auditName())
+	return p, nil
+}
+
+// GetEnvironmentVar returns the proc specific value stored for the given key.
+func (p *Proc) GetEnvironmentVar(k string) (string, error) {
+	v, _, err := p.dir.Get(path.Join(envPath, envKey(k)))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, `"%s" not found in %s's environment`, k, p)
+		}
+		return "", err
+	}
+	return v, nil
+}
+
+// DelEnvironmentVar removes the proc specific value for the given key.
+func (p *Proc) DelEnvironmentVar(k string) (_ *Proc, err error) {
+	defer p.annotate(&err, "del-env")
+	if err := guardOf(p).authorize("", AuditEnv, p.auditName()); err != nil {
+		return nil, err
+	}
+	err = p.dir.Del(path.Join(envPath, envKey(k)))
+	if err != nil {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	p.dir = p.dir.Join(sp)
+
+	audit(p, "", AuditEnv, p.auditName())
+	return p, nil
+}
+
+// EnvironmentVars returns all proc specific variables.
+func (p *Proc) EnvironmentVars() (map[string]string, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getEnvVars(p.dir.Prefix(envPath), sp)
+}
+
+func (p *Proc) auditName() string {
+	return fmt.Sprintf("proc:%s:%s", p.App.Name, p.Name)
+}
+
+func (p *Proc) annotate(err *error, op string) {
+	annotate(err, op, p.auditName(), p.dir.Name, p.dir.Snapshot.Rev)
+}
+
+// Watch starts a Watcher for the events of the proc: changes of its attrs,
+// scale, drains and maintenance as well as the lifecycle of its instances as
+// recorded by its lookups. Optionally the events can be filtered by type.
+func (p *Proc) Watch(ctx context.Context, filter ...EventType) (*Watcher, error) {
+	scope := EventScope{App: p.App.Name, Proc: p.Name}
+	return storeFromSnapshotable(p).Watch(ctx, WatchOptions{Scope: scope, Filter: filter})
+}
+
 func (p *Proc) String() string {
 	return fmt.Sprintf("Proc<%s:%s>", p.App.Name, p.Name)
 }
@@ -285,14 +986,19 @@ func (a *App) GetProc(name string) (*Proc, error) {
 	if err != nil {
 		return nil, err
 	}
-	return getProc(a, name, sp)
+	p, err := getProc(a, name, sp)
+	annotate(&err, "get", fmt.Sprintf("proc:%s:%s", a.Name, name), a.dir.Prefix(procsPath, name), sp.Rev)
+	return p, err
 }
 
 func getProc(app *App, name string, s cp.Snapshotable) (*Proc, error) {
 	p := &Proc{
-		dir:  cp.NewDir(app.dir.Prefix(procsPath, name), s.GetSnapshot()),
-		Name: name,
-		App:  app,
+		dir:    cp.NewDir(app.dir.Prefix(procsPath, name), s.GetSnapshot()),
+		Name:   name,
+		App:    app,
+		closer: app.closer,
+		reads:  app.reads,
+		guard:  app.guard,
 	}
 
 	port, err := p.dir.GetFile(procsPortPath, new(cp.IntCodec))
@@ -322,11 +1028,16 @@ func getProc(app *App, name string, s cp.Snapshotable) (*Proc, error) {
 		p.ControlPort = controlPort.Value.(int)
 	}
 
-	_, err = p.dir.GetFile(procsAttrsPath, &cp.JsonCodec{DecodedVal: &p.Attrs})
+	_, err = p.dir.GetFile(procsAttrsPath, decodeCodec(&p.Attrs))
 	if err != nil && !cp.IsErrNoEnt(err) {
 		return nil, err
 	}
 
+	p.Labels, err = getLabels(p.dir)
+	if err != nil {
+		return nil, err
+	}
+
 	f, err := p.dir.GetFile(registeredPath, new(cp.StringCodec))
 	if err != nil {
 		if cp.IsErrNoEnt(err) {
@@ -334,7 +1045,7 @@ func getProc(app *App, name string, s cp.Snapshotable) (*Proc, error) {
 		}
 		return nil, err
 	}
-	p.Registered, err = parseTime(f.Value.(string))
+	p.Registered, p.RegisteredBy, err = parseRegistered(f.Value.(string))
 	if err != nil {
 		return nil, err
 	}
@@ -342,8 +1053,8 @@ func getProc(app *App, name string, s cp.Snapshotable) (*Proc, error) {
 	return p, nil
 }
 
-func getProcInstances(ids []string, s cp.Snapshotable) ([]*Instance, error) {
-	ch, errch := cp.GetSnapshotables(ids, func(idstr string) (cp.Snapshotable, error) {
+func getProcInstances(l *readLimit, ids []string, s cp.Snapshotable) ([]*Instance, error) {
+	ch, errch := getSnapshotables(l, ids, func(idstr string) (cp.Snapshotable, error) {
 		id, err := parseInstanceID(idstr)
 		if err != nil {
 			return nil, err
@@ -403,6 +1114,44 @@ func getSerialisedInstances(
 	return is, nil
 }
 
+func validatePage(offset, limit int) error {
+	if offset < 0 || limit < 1 {
+		return errorf(ErrInvalidArgument, "invalid page offset %d limit %d", offset, limit)
+	}
+	return nil
+}
+
+// pageIds sorts the instance ids numerically and returns the requested page
+// together with the total number of ids.
+func pageIds(idStrs []string, offset, limit int) ([]string, int, error) {
+	ids := Int64Slice{}
+	for _, idstr := range idStrs {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	total := len(ids)
+	if offset > total {
+		offset = total
+	}
+	// Clamped before adding, offset + limit can overflow.
+	if limit > total-offset {
+		limit = total - offset
+	}
+	end := offset + limit
+	page := []string{}
+	for _, id := range ids[offset:end] {
+		page = append(page, strconv.FormatInt(id, 10))
+	}
+	return page, total, nil
+}
+
+// claimNextPort hands out the next port of the default pool, skipping the
+// ranges of configured pools.
 func claimNextPort(s cp.Snapshot) (int, error) {
 	for {
 		var err error
@@ -410,18 +1159,38 @@ func claimNextPort(s cp.Snapshot) (int, error) {
 		if err != nil {
 			return -1, err
 		}
+		pools, err := storeFromSnapshotable(s).GetPortPools()
+		if err != nil {
+			return -1, err
+		}
 
 		f, err := s.GetFile(nextPortPath, new(cp.IntCodec))
-		if err == nil {
-			port := f.Value.(int)
+		if err != nil {
+			return -1, err
+		}
+		port := skipPortPools(f.Value.(int), pools)
+		if port > defaultPortMax {
+			return -1, errorf(ErrNoPorts, "default port pool is exhausted")
+		}
+		if _, err = f.Set(port + 1); err == nil {
+			return port, nil
+		} else if !cp.IsErrRevMismatch(err) {
+			return -1, err
+		}
+		time.Sleep(time.Second / 10)
+	}
+}
 
-			f, err = f.Set(port + 1)
-			if err == nil {
-				return port, nil
+// skipPortPools returns the first port from port on which lies in none of the
+// pools.
+func skipPortPools(port int, pools []*PortPool) int {
+	for skipped := true; skipped; {
+		skipped = false
+		for _, p := range pools {
+			if p.Contains(port) {
+				port, skipped = p.Max+1, true
 			}
-			time.Sleep(time.Second / 10)
-		} else {
-			return -1, err
 		}
 	}
+	return port
 }
@@ -7,8 +7,10 @@ package visor
 
 import (
 	"errors"
+	"math"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func procSetup(appid string) (s *Store, app *App) {
@@ -135,6 +137,127 @@ func TestProcGetInstances(t *testing.T) {
 	}
 }
 
+func TestProcNumInstances(t *testing.T) {
+	var (
+		appid  = "num-instances-app"
+		s, app = procSetup(appid)
+	)
+
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is := []*Instance{}
+	for _, rev := range []string{"128af9", "128af9", "9fa821"} {
+		ins, err := s.RegisterInstance(appid, rev, "web", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		is = append(is, ins)
+	}
+	assertNum := func(want int) {
+		have, err := proc.NumInstances()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != want {
+			t.Errorf("expected %d instances, got %d", want, have)
+		}
+	}
+	assertNum(3)
+
+	if err := is[0].Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := is[1].Failed("10.0.2.12", errors.New("no reason"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNum(1)
+
+	// Failed instances aren't running, unregistering them doesn't count.
+	if err := failed.Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	assertNum(1)
+
+	ins, err := is[2].Claim("10.0.2.12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started("10.0.2.12", "web.org", 9898, 9899); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Exited("10.0.2.12"); err != nil {
+		t.Fatal(err)
+	}
+	assertNum(0)
+	if err := ins.Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	assertNum(0)
+
+	// Procs registered before the counter are counted from their lookups.
+	if _, err := s.RegisterInstance(appid, "9fa821", "web", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Del(proc.dir.Prefix(procsInstanceCountPath)); err != nil {
+		t.Fatal(err)
+	}
+	assertNum(1)
+}
+
+func TestProcCounters(t *testing.T) {
+	var (
+		appid  = "counters-app"
+		s, app = procSetup(appid)
+	)
+
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is := []*Instance{}
+	for i := 0; i < 4; i++ {
+		ins, err := s.RegisterInstance(appid, "128af9", "web", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		is = append(is, ins)
+	}
+	if err := is[0].Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	failed, err := is[1].Failed("10.0.2.12", errors.New("no reason"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := failed.Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is[2].Lost("proc-test", errors.New("no reason")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := proc.Counters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Done != 2 || c.Failed != 1 || c.Lost != 1 {
+		t.Errorf("expected 2 done, 1 failed and 1 lost, got %#v", c)
+	}
+	if c.LastFailure.Before(before.Add(-time.Second)) {
+		t.Errorf("expected last failure after %s, got %s", before, c.LastFailure)
+	}
+}
+
 func TestProcGetDoneInstances(t *testing.T) {
 	var (
 		appid  = "get-done-instances-app"
@@ -234,6 +357,51 @@ func TestProcGetFailedInstances(t *testing.T) {
 	}
 }
 
+func TestProcGetFailedInstancesSince(t *testing.T) {
+	var (
+		appid  = "get-failed-since-app"
+		s, app = procSetup(appid)
+	)
+
+	proc, err := s.NewProc(app, "worker").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	failed := []*Instance{}
+	for i := 0; i < 2; i++ {
+		ins, err := s.RegisterInstance(appid, "643asd3", "worker", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, err = ins.Failed("10.0.2.12", errors.New("no reason"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed = append(failed, ins)
+	}
+	if err := failed[0].Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+
+	is, err := proc.GetFailedInstancesSince(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(is) != len(failed) {
+		t.Errorf("expected %d failed instances, got %d", len(failed), len(is))
+	}
+
+	is, err = proc.GetFailedInstancesSince(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(is) != 0 {
+		t.Errorf("expected no failed instances in the future, got %d", len(is))
+	}
+}
+
 func TestProcGetLostInstances(t *testing.T) {
 	appid := "get-lost-instances-app"
 	s, app := procSetup(appid)
@@ -325,6 +493,33 @@ func TestProcAttr(t *testing.T) {
 		t.Fatalf("MemoryLimitMb does not contain the value that was set")
 	}
 
+	// CPU and disk limits
+	cpuShares, cpuQuota, diskQuota, maxFds := 512, 150, 2048, 4096
+	proc.Attrs.Limits.CPUShares = &cpuShares
+	proc.Attrs.Limits.CPUQuotaPercent = &cpuQuota
+	proc.Attrs.Limits.DiskQuotaMb = &diskQuota
+	proc.Attrs.Limits.MaxFileDescriptors = &maxFds
+	if _, err := proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	proc, err = app.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := proc.Attrs.Limits
+	if limits.CPUShares == nil || *limits.CPUShares != cpuShares {
+		t.Errorf("expected cpu shares %d, got %v", cpuShares, limits.CPUShares)
+	}
+	if limits.CPUQuotaPercent == nil || *limits.CPUQuotaPercent != cpuQuota {
+		t.Errorf("expected cpu quota %d, got %v", cpuQuota, limits.CPUQuotaPercent)
+	}
+	if limits.DiskQuotaMb == nil || *limits.DiskQuotaMb != diskQuota {
+		t.Errorf("expected disk quota %d, got %v", diskQuota, limits.DiskQuotaMb)
+	}
+	if limits.MaxFileDescriptors == nil || *limits.MaxFileDescriptors != maxFds {
+		t.Errorf("expected max file descriptors %d, got %v", maxFds, limits.MaxFileDescriptors)
+	}
+
 	// LogPersistence
 	if proc.Attrs.LogPersistence != false {
 		t.Fatal("LogPersistence should be off by default")
@@ -380,4 +575,304 @@ func TestTrafficControlValidate(t *testing.T) {
 	if err := c.Validate(); !IsErrInvalidShare(err) {
 		t.Error("expected TrafficControl to not validate")
 	}
+
+	c = &TrafficControl{Share: 100, ZoneShares: map[string]int{"eu-1": 70, "us-1": 30}}
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected zone shares to validate: %s", err)
+	}
+
+	c = &TrafficControl{Share: 100, ZoneShares: map[string]int{"eu-1": 70, "us-1": 20}}
+
+	if err := c.Validate(); !IsErrInvalidShare(err) {
+		t.Error("expected zone shares not adding up to 100 to not validate")
+	}
+
+	c = &TrafficControl{Share: 100, ZoneShares: map[string]int{"eu/1": 100}}
+
+	if err := c.Validate(); !IsErrInvalidArgument(err) {
+		t.Error("expected invalid zone name to not validate")
+	}
+}
+
+func TestPageIds(t *testing.T) {
+	ids := []string{"3", "1", "2"}
+	for _, test := range []struct {
+		offset, limit int
+		want          []string
+	}{
+		{0, 2, []string{"1", "2"}},
+		{1, math.MaxInt, []string{"2", "3"}},
+		{5, math.MaxInt, []string{}},
+	} {
+		page, total, err := pageIds(ids, test.offset, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || !reflect.DeepEqual(page, test.want) {
+			t.Errorf("offset %d limit %d: expected %v of 3, got %v of %d", test.offset, test.limit, test.want, page, total)
+		}
+	}
+}
+
+func TestProcGetInstancesPage(t *testing.T) {
+	var (
+		appid  = "get-instances-page-app"
+		s, app = procSetup(appid)
+		host   = "10.0.2.13"
+	)
+
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{}
+	for i := 0; i < 5; i++ {
+		ins, err := s.RegisterInstance(appid, "128af90", "web", "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ins.ID)
+	}
+
+	page, total, err := proc.GetInstancesPage(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 {
+		t.Errorf("expected total of 5, got %d", total)
+	}
+	if len(page) != 2 || page[0].ID != ids[1] || page[1].ID != ids[2] {
+		t.Errorf("expected instances %v, got %v", ids[1:3], page)
+	}
+
+	page, _, err = proc.GetInstancesPage(4, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != ids[4] {
+		t.Errorf("expected last instance %d, got %v", ids[4], page)
+	}
+
+	page, _, err = proc.GetInstancesPage(1, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 4 {
+		t.Errorf("expected 4 instances, got %v", page)
+	}
+
+	if _, _, err = proc.GetInstancesPage(-1, 10); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+
+	page, total, err = proc.GetDoneInstancesPage(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 || len(page) != 0 {
+		t.Errorf("expected no done instances, got %v", page)
+	}
+
+	ins, err := s.GetInstance(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(host); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(host, appid+".org", 9898, 9899); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Exited(host); err != nil {
+		t.Fatal(err)
+	}
+	if err = ins.Unregister("proc-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+
+	page, total, err = proc.GetDoneInstancesPage(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(page) != 1 || page[0].ID != ids[0] {
+		t.Errorf("expected done instance %d, got %v", ids[0], page)
+	}
+}
+
+func TestResourceLimitsValidate(t *testing.T) {
+	one, zero, huge := 1, 0, maxCPUShares+1
+	for i, tt := range []struct {
+		limits ResourceLimits
+		valid  bool
+	}{
+		{ResourceLimits{}, true},
+		{ResourceLimits{DiskQuotaMb: &one, MaxFileDescriptors: &one}, true},
+		{ResourceLimits{MemoryLimitMb: &zero}, false},
+		{ResourceLimits{CPUQuotaPercent: &zero}, false},
+		{ResourceLimits{CPUShares: &one}, false},
+		{ResourceLimits{CPUShares: &huge}, false},
+	} {
+		if err := tt.limits.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%d. expected valid %t, got %v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestLogRoutingValidate(t *testing.T) {
+	for i, tt := range []struct {
+		routing LogRouting
+		valid   bool
+	}{
+		{LogRouting{}, true},
+		{LogRouting{RetentionDays: 30, Level: LogLevelInfo, LoggerGroup: "bulk", SampleRate: 0.5}, true},
+		{LogRouting{RetentionDays: -1}, false},
+		{LogRouting{Level: "verbose"}, false},
+		{LogRouting{LoggerGroup: "bulk/eu"}, false},
+		{LogRouting{SampleRate: 1.5}, false},
+	} {
+		if err := tt.routing.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%d. expected valid %t, got %v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestDeployStrategyValidate(t *testing.T) {
+	for i, tt := range []struct {
+		strategy DeployStrategy
+		valid    bool
+	}{
+		{DeployStrategy{Type: DeployRolling, MaxUnavailable: 1, MaxSurge: 1}, true},
+		{DeployStrategy{Type: DeployRolling, MaxSurge: 1}, true},
+		{DeployStrategy{Type: DeployRolling}, false},
+		{DeployStrategy{Type: DeployRolling, MaxUnavailable: -1, MaxSurge: 1}, false},
+		{DeployStrategy{Type: DeployRecreate}, true},
+		{DeployStrategy{Type: DeployBlueGreen, MaxSurge: 1}, false},
+		{DeployStrategy{Type: "canary"}, false},
+	} {
+		if err := tt.strategy.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%d. expected valid %t, got %v", i, tt.valid, err)
+		}
+	}
+}
+
+func TestReschedulePolicyDelay(t *testing.T) {
+	policy := &ReschedulePolicy{InitialSec: 10, Multiplier: 2, MaxSec: 60}
+	for failures, expected := range []time.Duration{0, 10, 20, 40, 60, 60} {
+		if got := policy.Delay(failures); got != expected*time.Second {
+			t.Errorf("%d. expected %s, got %s", failures, expected*time.Second, got)
+		}
+	}
+	for _, invalid := range []*ReschedulePolicy{
+		{InitialSec: -1, Multiplier: 2, MaxSec: 60},
+		{InitialSec: 10, Multiplier: 0.5, MaxSec: 60},
+		{InitialSec: 10, Multiplier: 2, MaxSec: 5},
+	} {
+		if err := invalid.Validate(); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for %#v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestProcNextRescheduleTime(t *testing.T) {
+	ip := "10.0.0.1"
+	s, app := procSetup("backoff-cat")
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.Reschedule = &ReschedulePolicy{InitialSec: 10, Multiplier: 3, MaxSec: 600}
+	if proc, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := func(replaces int64) *Instance {
+		ins, err := s.RegisterInstanceWithOpts(InstanceOpts{
+			App:      app.Name,
+			Rev:      "128af9",
+			Proc:     proc.Name,
+			Env:      "default",
+			Replaces: replaces,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Claim(ip); err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Failed(ip, errors.New("exploded")); err != nil {
+			t.Fatal(err)
+		}
+		return ins
+	}
+
+	first := fail(0)
+	second := fail(first.ID)
+	for _, tt := range []struct {
+		ins   *Instance
+		delay time.Duration
+	}{
+		{first, 10 * time.Second},
+		{second, 30 * time.Second},
+	} {
+		next, err := proc.NextRescheduleTime(tt.ins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := tt.ins.Termination.Time.Add(tt.delay); !next.Equal(expected) {
+			t.Errorf("expected %d to be rescheduled at %s, got %s", tt.ins.ID, expected, next)
+		}
+	}
+}
+
+func TestProcAttrsForEnv(t *testing.T) {
+	s, app := procSetup("env-attrs-cat")
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.MaxRestarts = 5
+	if proc, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := proc.StoreAttrsForEnv("staging", ProcAttrs{MaxRestarts: -1}); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	for _, env := range []string{"", "../attrs", ".."} {
+		if _, err := proc.StoreAttrsForEnv(env, ProcAttrs{}); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for env %q, got %v", env, err)
+		}
+		if _, err := proc.DelAttrsForEnv(env); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for env %q, got %v", env, err)
+		}
+	}
+	if proc, err = proc.StoreAttrsForEnv("staging", ProcAttrs{MaxRestarts: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for env, expected := range map[string]int{"staging": 1, "production": 5} {
+		attrs, err := proc.GetAttrsForEnv(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.MaxRestarts != expected {
+			t.Errorf("expected max restarts %d for %s, got %d", expected, env, attrs.MaxRestarts)
+		}
+	}
+
+	if proc, err = proc.DelAttrsForEnv("staging"); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := proc.GetAttrsForEnv("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.MaxRestarts != 5 {
+		t.Errorf("expected fallback to proc-wide attrs, got %d", attrs.MaxRestarts)
+	}
+	if _, err := proc.DelAttrsForEnv("staging"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
 }
@@ -0,0 +1,189 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// ProxyState is the admin state of a proxy.
+type ProxyState string
+
+// ProxyStates.
+const (
+	ProxyActive   = ProxyState("active")
+	ProxyDraining = ProxyState("draining")
+)
+
+// DefaultProxyWeight is the weight of proxies registered without one.
+const DefaultProxyWeight = 1
+
+// Proxy is a bazooka-proxy host with the configuration traffic is
+// distributed by.
+type Proxy struct {
+	svc        *Service
+	Host       string     `json:"-"`
+	Registered time.Time  `json:"-"`
+	Weight     int        `json:"weight"`
+	Zone       string     `json:"zone,omitempty"`
+	State      ProxyState `json:"state"`
+}
+
+// ProxyEvent is sent by WatchProxies for every registered, changed or
+// unregistered proxy. Proxy is nil for ServiceUnregister events.
+type ProxyEvent struct {
+	Type  ServiceEventType
+	Host  string
+	Proxy *Proxy
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (p *Proxy) GetSnapshot() cp.Snapshot {
+	return p.svc.GetSnapshot()
+}
+
+// IsActive reports whether the proxy should receive traffic.
+func (p *Proxy) IsActive() bool {
+	return p.State == ProxyActive
+}
+
+// RegisterProxyInfo stores the proxy with the given configuration. The
+// state of a proxy registered again is kept unless p sets one, so a
+// restarting proxy which is being drained doesn't get traffic again.
+func (s *Store) RegisterProxyInfo(p Proxy) (*Proxy, error) {
+	if p.Weight < 0 {
+		return nil, errorf(ErrInvalidArgument, "invalid proxy weight %d", p.Weight)
+	}
+	if p.Weight == 0 {
+		p.Weight = DefaultProxyWeight
+	}
+	if p.State == "" {
+		p.State = ProxyActive
+		current, err := s.GetProxy(p.Host)
+		if err != nil && !IsErrNotFound(err) {
+			return nil, err
+		}
+		if current != nil {
+			p.State = current.State
+		}
+	}
+	if !isProxyState(p.State) {
+		return nil, errorf(ErrInvalidArgument, "invalid proxy state %q", p.State)
+	}
+
+	svc, err := s.RegisterService(ServiceProxy, p.Host, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	return proxyOf(svc)
+}
+
+// GetProxy returns the proxy on the given host.
+func (s *Store) GetProxy(host string) (*Proxy, error) {
+	svc, err := s.GetService(ServiceProxy, host)
+	if err != nil {
+		return nil, err
+	}
+	return proxyOf(svc)
+}
+
+// GetProxyInfos returns all proxies including draining ones.
+func (s *Store) GetProxyInfos() ([]*Proxy, error) {
+	services, err := s.GetServices(ServiceProxy)
+	if err != nil {
+		return nil, err
+	}
+	proxies := []*Proxy{}
+	for _, svc := range services {
+		p, err := proxyOf(svc)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, nil
+}
+
+// SetProxyState changes the admin state of the proxy on the given host.
+func (s *Store) SetProxyState(host string, state ProxyState) (*Proxy, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if !isProxyState(state) {
+		return nil, errorf(ErrInvalidArgument, "invalid proxy state %q", state)
+	}
+	if err := s.guard.authorize("", AuditAttrs, "proxy:"+host); err != nil {
+		return nil, err
+	}
+	svc, err := s.GetService(ServiceProxy, host)
+	if err != nil {
+		return nil, err
+	}
+	p, err := proxyOf(svc)
+	if err != nil {
+		return nil, err
+	}
+	p.State = state
+
+	if svc.Meta, err = json.Marshal(p); err != nil {
+		return nil, err
+	}
+	if svc.file, err = svc.file.Set(svc); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WatchProxies sends an event for every proxy registered, changed or
+// unregistered. The channel is closed when WatchProxies returns. If the
+// Store is closed it returns nil.
+func (s *Store) WatchProxies(ch chan *ProxyEvent) error {
+	defer close(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan *ServiceEvent)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.watchServices(ctx, ServiceProxy, events)
+	}()
+
+	for e := range events {
+		pe := &ProxyEvent{Type: e.Type, Host: e.Addr}
+		if e.Service != nil {
+			p, err := proxyOf(e.Service)
+			if err != nil {
+				return err
+			}
+			pe.Proxy = p
+		}
+		select {
+		case ch <- pe:
+		case <-s.closed():
+		}
+	}
+	return <-errc
+}
+
+func proxyOf(svc *Service) (*Proxy, error) {
+	// Proxies registered before they had a configuration.
+	p := &Proxy{Weight: DefaultProxyWeight, State: ProxyActive}
+	if err := svc.DecodeMeta(p); err != nil {
+		return nil, errorf(ErrInvalidFile, "invalid configuration of proxy %s: %w", svc.Addr, err)
+	}
+	p.svc = svc
+	p.Host = svc.Addr
+	p.Registered = svc.Registered
+	return p, nil
+}
+
+func isProxyState(state ProxyState) bool {
+	return state == ProxyActive || state == ProxyDraining
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProxyRegister(t *testing.T) {
+	s := serviceSetup(t)
+
+	if _, err := s.RegisterProxy("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.RegisterProxyInfo(Proxy{Host: "10.0.0.2", Weight: 3, Zone: "eu-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Weight != 3 || p.Zone != "eu-1" || !p.IsActive() {
+		t.Errorf("registered proxy differs: %#v", p)
+	}
+
+	p, err = s.GetProxy("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Weight != DefaultProxyWeight || p.State != ProxyActive {
+		t.Errorf("expected defaults for proxy, got %#v", p)
+	}
+
+	if _, err := s.SetProxyState("10.0.0.2", ProxyState("gone")); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	if _, err := s.SetProxyState("10.0.0.2", ProxyDraining); err != nil {
+		t.Fatal(err)
+	}
+	// Registering again keeps the state.
+	if _, err := s.RegisterProxyInfo(Proxy{Host: "10.0.0.2", Weight: 3}); err != nil {
+		t.Fatal(err)
+	}
+	p, err = s.GetProxy("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.State != ProxyDraining {
+		t.Errorf("expected proxy to be draining, got %s", p.State)
+	}
+
+	proxies, err := s.GetProxyInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 2 {
+		t.Errorf("expected 2 proxies, got %d", len(proxies))
+	}
+}
+
+func TestWatchProxies(t *testing.T) {
+	s := serviceSetup(t)
+	if _, err := s.RegisterProxy("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan *ProxyEvent)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- s.WatchProxies(ch)
+	}()
+
+	if _, err := s.SetProxyState("10.0.0.1", ProxyDraining); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-ch:
+		if e.Host != "10.0.0.1" || e.Proxy == nil || e.Proxy.State != ProxyDraining {
+			t.Errorf("expected draining proxy, got %#v", e)
+		}
+	case err := <-errch:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected proxy event, got timeout")
+	}
+}
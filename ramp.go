@@ -0,0 +1,156 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// Progress of the ramp plan of a proc.
+const procsRampPath = "ramp"
+
+// RampPlan moves the traffic share of a proc through its steps, e.g. from 5%
+// to 25% to 100%. Each step is held for its HoldSec before AdvanceRamp moves
+// on to the next.
+type RampPlan struct {
+	Steps []RampStep `json:"steps"`
+}
+
+// RampStep is a traffic share of a RampPlan.
+type RampStep struct {
+	Share   int `json:"share"`
+	HoldSec int `json:"hold-sec"`
+}
+
+// Validate checks that the plan has steps and all shares are valid.
+func (r *RampPlan) Validate() error {
+	if len(r.Steps) == 0 {
+		return errorf(ErrInvalidArgument, "ramp plan must have steps")
+	}
+	for _, step := range r.Steps {
+		if step.Share < 0 || step.Share > 100 {
+			return errorf(ErrInvalidShare, "ramp share must be between 0 and 100")
+		}
+		if step.HoldSec < 0 {
+			return errorf(ErrInvalidArgument, "ramp hold must not be negative")
+		}
+	}
+	return nil
+}
+
+// RampState is how far a proc got through its ramp plan.
+type RampState struct {
+	Step  int       `json:"step"`
+	Share int       `json:"share"`
+	Since time.Time `json:"since"`
+}
+
+// AdvanceRamp moves the proc to the next step of its ramp plan once the
+// current one was held long enough, starting with the first step. The
+// traffic share of the proc is set to the one of the step and an
+// EvProcRampStep event is emitted. It returns the resulting state, which is
+// unchanged if the step isn't due yet or the plan is complete. Callers
+// running concurrently advance the ramp only once.
+func (p *Proc) AdvanceRamp() (*RampState, error) {
+	if err := guardOf(p).authorize("", AuditAttrs, p.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := getProcAttrs(p.App.Name, p.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.TrafficControl == nil || attrs.TrafficControl.Ramp == nil {
+		return nil, errorf(ErrInvalidState, "proc %s has no ramp plan", p.Name)
+	}
+	plan := attrs.TrafficControl.Ramp
+
+	state, f, err := getRampState(p.dir.Join(sp))
+	if err != nil {
+		return nil, err
+	}
+	next := 0
+	if state != nil {
+		if state.Step >= len(plan.Steps)-1 {
+			return state, nil
+		}
+		hold := time.Duration(plan.Steps[state.Step].HoldSec) * time.Second
+		if time.Since(state.Since) < hold {
+			return state, nil
+		}
+		next = state.Step + 1
+	}
+
+	state = &RampState{Step: next, Share: plan.Steps[next].Share, Since: time.Now()}
+	if f == nil {
+		f, err = cp.NewFile(p.dir.Prefix(procsRampPath), state, new(cp.JsonCodec), sp).Save()
+	} else {
+		f, err = f.Set(state)
+	}
+	if err != nil {
+		if cp.IsErrRevMismatch(err) {
+			err = errorf(ErrConflict, "ramp of proc %s advanced concurrently", p.Name)
+		}
+		return nil, err
+	}
+	p.dir = p.dir.Join(f)
+
+	attrs.TrafficControl.Share = state.Share
+	p.Attrs = attrs
+	if _, err := p.StoreAttrs(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ResetRamp forgets the progress of the ramp plan, so the next AdvanceRamp
+// starts with its first step again.
+func (p *Proc) ResetRamp() (*Proc, error) {
+	if err := guardOf(p).authorize("", AuditAttrs, p.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	err = sp.Del(p.dir.Prefix(procsRampPath))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	if sp, err = sp.FastForward(); err != nil {
+		return nil, err
+	}
+	p.dir = p.dir.Join(sp)
+	return p, nil
+}
+
+// GetRampState returns the progress of the ramp plan, nil if it wasn't
+// started.
+func (p *Proc) GetRampState() (*RampState, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	state, _, err := getRampState(p.dir.Join(sp))
+	return state, err
+}
+
+func getRampState(d *cp.Dir) (*RampState, *cp.File, error) {
+	state := &RampState{}
+	f, err := d.GetFile(procsRampPath, &cp.JsonCodec{DecodedVal: state})
+	if cp.IsErrNoEnt(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, f, nil
+}
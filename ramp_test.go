@@ -0,0 +1,85 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRampPlanValidate(t *testing.T) {
+	for _, test := range []struct {
+		plan  RampPlan
+		valid bool
+	}{
+		{RampPlan{}, false},
+		{RampPlan{Steps: []RampStep{{Share: 5, HoldSec: 60}, {Share: 100}}}, true},
+		{RampPlan{Steps: []RampStep{{Share: 101}}}, false},
+		{RampPlan{Steps: []RampStep{{Share: 5, HoldSec: -1}}}, false},
+	} {
+		if err := test.plan.Validate(); (err == nil) != test.valid {
+			t.Errorf("want valid %t for %#v, have %v", test.valid, test.plan, err)
+		}
+	}
+}
+
+func TestProcAdvanceRamp(t *testing.T) {
+	s, app := procSetup("ramp-app")
+	proc := s.NewProc(app, "web")
+	proc.Attrs.TrafficControl = &TrafficControl{Ramp: &RampPlan{Steps: []RampStep{
+		{Share: 5},
+		{Share: 25, HoldSec: 3600},
+		{Share: 100},
+	}}}
+	proc, err := proc.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := proc.Watch(ctx, EvProcRampStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []int{5, 25, 25} {
+		state, err := proc.AdvanceRamp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Share != want {
+			t.Errorf("want share %d, have %d", want, state.Share)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-w.Events():
+		case <-time.After(time.Second):
+			t.Fatalf("want event for step %d, have timeout", i)
+		}
+	}
+
+	attrs, err := proc.GetAttrsForEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.TrafficControl.Share != 25 {
+		t.Errorf("want traffic share 25, have %d", attrs.TrafficControl.Share)
+	}
+
+	if _, err := proc.ResetRamp(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := proc.GetRampState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != nil {
+		t.Errorf("want ramp to be reset, have %#v", state)
+	}
+}
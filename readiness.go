@@ -0,0 +1,294 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const readyPath = "ready"
+
+// Ready tells the coordinator that the running instance can take traffic,
+// which emits EvInsReady. The readiness is also recorded in the lookup entry
+// of the instance, see Proc.GetInstanceLookups. Procs with a ReadinessGate
+// only get traffic routed to ready instances, and their running instances
+// only count as up for deploys once ready. Readiness is cleared when the
+// instance is restarted, unclaimed or started again.
+func (i *Instance) Ready() (_ *Instance, err error) {
+	defer i.annotate(&err, "ready")
+	//
+	//   apps/
+	//       <app>/
+	//           procs/
+	//               <proc>/
+	//                   instances/
+	//                       <rev>/
+	// -                         6868 = 2012-07-19T16:22:00Z
+	// +                         6868 = {"registered":"2012-07-19T16:22:00Z","ready":"2012-07-19T16:41:00Z"}
+	//   instances/
+	//       6868/
+	//           start = {"ip":"10.0.0.1","port":24690,...}
+	// +         ready = 2012-07-19T16:41:00Z
+	//
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	i, err = i.refresh(sp)
+	if err != nil {
+		return nil, err
+	}
+	if i.Status != InsStatusRunning {
+		return nil, errorf(ErrInvalidState, "%s is not running", i)
+	}
+	if err := i.guard.authorize("", ActionReady, i.auditName()); err != nil {
+		return nil, err
+	}
+	if i.IsReady() {
+		return i, nil
+	}
+	ready := time.Now()
+	lookup, err := getLookup(i.procInstancesPath(), sp)
+	if err != nil {
+		return nil, err
+	}
+	lookup.Ready = ready
+	// The lookup is written first, so it is ready once EvInsReady is seen.
+	sp, err = storeFromSnapshotable(i).Txn().
+		Set(i.procInstancesPath(), lookup.String()).
+		Set(i.dir.Prefix(readyPath), formatTime(ready)).
+		commit()
+	if err != nil {
+		return nil, err
+	}
+	i.dir = i.dir.Join(sp)
+	i.ReadyAt = ready
+	return i, nil
+}
+
+// clearReadiness removes the readiness and health reported by the previous
+// process of the instance, which the next one has to report again.
+func (i *Instance) clearReadiness() error {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	txn := storeFromSnapshotable(i).Txn()
+	stale := false
+	for _, p := range []string{readyPath, healthPath} {
+		exists, _, err := sp.Exists(i.dir.Prefix(p))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if p == readyPath {
+			// Unready before the ready file goes, like in Ready.
+			lookup, err := getLookup(i.procInstancesPath(), sp)
+			if err != nil {
+				return err
+			}
+			lookup.Ready = time.Time{}
+			txn.Set(i.procInstancesPath(), lookup.String())
+		}
+		txn.Del(i.dir.Prefix(p))
+		stale = true
+	}
+	i.ReadyAt, i.Health = time.Time{}, nil
+	if !stale {
+		return nil
+	}
+	if sp, err = txn.commit(); err != nil {
+		return err
+	}
+	i.dir = i.dir.Join(sp)
+	return nil
+}
+
+// IsReady reports whether the instance reported to be ready since it was
+// last started.
+func (i *Instance) IsReady() bool {
+	return !i.ReadyAt.IsZero()
+}
+
+// WaitReady blocks until the instance is ready and returns it, starting from
+// the snapshot of the instance. It fails with ErrInvalidState once the
+// instance terminated before getting ready. A zero timeout waits forever,
+// otherwise an error of kind ErrTimeout is returned once it passed. Closing
+// the Store ends the wait with ErrInvalidState.
+func (i *Instance) WaitReady(timeout time.Duration) (*Instance, error) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	closed := storeFromSnapshotable(i).closed()
+	go func() {
+		select {
+		case <-closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ins, err := i.waitReady(ctx)
+	if err != nil || ins != nil {
+		return ins, err
+	}
+	select {
+	case <-closed:
+		return nil, errorf(ErrInvalidState, "store closed while waiting for %s to get ready", i)
+	default:
+		return nil, errorf(ErrTimeout, "%s not ready after %s", i, timeout)
+	}
+}
+
+// waitReady returns nil, nil once ctx is done.
+func (i *Instance) waitReady(ctx context.Context) (*Instance, error) {
+	sp := i.GetSnapshot()
+	for {
+		ins, err := i.refresh(sp)
+		if err != nil {
+			return nil, err
+		}
+		if ins.IsReady() {
+			return ins, nil
+		}
+		switch ins.Status {
+		case InsStatusPending, InsStatusClaimed, InsStatusStarting, InsStatusRunning:
+		default:
+			return nil, errorf(ErrInvalidState, "%s is %s and won't get ready", ins, ins.Status)
+		}
+		ev, err := waitContext(ctx, sp, path.Join(instancePath(i.ID), "*"))
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sp = sp.Join(ev)
+	}
+}
+
+// InstanceLookup is the lookup entry of an instance of a proc, from which
+// proxies can tell which instances are ready without reading each of them.
+type InstanceLookup struct {
+	ID         int64
+	Rev        string
+	Registered time.Time
+	// When the instance got ready, zero if it isn't.
+	Ready time.Time
+}
+
+// IsReady reports whether the instance was ready when the entry was read.
+func (l *InstanceLookup) IsReady() bool {
+	return !l.Ready.IsZero()
+}
+
+// GetInstanceLookups returns the lookup entries of the instances of the proc
+// which are neither done, failed nor lost.
+func (p *Proc) GetInstanceLookups() ([]*InstanceLookup, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	revs, err := sp.Getdir(p.dir.Prefix(instancesPath))
+	if cp.IsErrNoEnt(err) {
+		return []*InstanceLookup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lookups := []*InstanceLookup{}
+	for _, rev := range revs {
+		ids, err := getInstanceIds(p.App.Name, rev, p.Name, sp)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			l, err := getLookup(path.Join(procInstancesPath(p.App.Name, rev, p.Name), strconv.FormatInt(id, 10)), sp)
+			if err != nil {
+				return nil, err
+			}
+			lookups = append(lookups, &InstanceLookup{ID: id, Rev: rev, Registered: l.Registered, Ready: l.Ready})
+		}
+	}
+	return lookups, nil
+}
+
+// insLookup is the value of the lookup entry of a live instance: the time it
+// was registered, followed by the actor which registered it when re-created
+// by RepairInstanceLookups, or JSON once the instance is ready.
+type insLookup struct {
+	Registered time.Time `json:"registered"`
+	Ready      time.Time `json:"ready"`
+}
+
+func (l insLookup) String() string {
+	if l.Ready.IsZero() {
+		return formatTime(l.Registered)
+	}
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+func parseLookup(val string) (insLookup, error) {
+	l := insLookup{}
+	if strings.HasPrefix(val, "{") {
+		if err := json.Unmarshal([]byte(val), &l); err != nil {
+			return l, errorf(ErrInvalidFile, "invalid lookup entry: %s", err)
+		}
+		return l, nil
+	}
+	t, _, err := parseRegistered(val)
+	if err != nil {
+		return l, errorf(ErrInvalidFile, "invalid lookup entry %q", val)
+	}
+	l.Registered = t
+	return l, nil
+}
+
+func getLookup(p string, sp cp.Snapshot) (insLookup, error) {
+	val, _, err := sp.Get(p)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "lookup entry %s not found", p)
+		}
+		return insLookup{}, err
+	}
+	return parseLookup(val)
+}
+
+// takesTraffic reports whether the instance is running and, if gated, ready.
+func (i *Instance) takesTraffic(gated bool) bool {
+	return i.Status == InsStatusRunning && (!gated || i.IsReady())
+}
+
+// getReadyAt returns the zero time if the instance isn't ready.
+func getReadyAt(d *cp.Dir) (time.Time, error) {
+	v, _, err := d.Get(readyPath)
+	if cp.IsErrNoEnt(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTime(v)
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLookup(t *testing.T) {
+	registered := time.Date(2012, 7, 19, 16, 22, 0, 0, time.UTC)
+	ready := registered.Add(time.Minute)
+	for _, l := range []insLookup{{Registered: registered}, {Registered: registered, Ready: ready}} {
+		have, err := parseLookup(l.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !have.Registered.Equal(l.Registered) || !have.Ready.Equal(l.Ready) {
+			t.Errorf("expected %#v, got %#v", l, have)
+		}
+	}
+	l, err := parseLookup(formatRegistered(registered, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Registered.Equal(registered) || !l.Ready.IsZero() {
+		t.Errorf("unexpected lookup %#v", l)
+	}
+}
+
+func TestInstanceUnclaimClearsReadiness(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("unready-cat", ip)
+	ins, err := ins.Started(ip, "box1.unready-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.SetHealth(HealthUnhealthy, "GET /health: 503"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ins, err = ins.Unclaim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started("10.0.0.2", "box2.unready-cat.com", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.IsReady() || ins.Health != nil {
+		t.Errorf("expected readiness and health to be cleared, got %v and %#v", ins.ReadyAt, ins.Health)
+	}
+	lookup, err := getLookup(ins.procInstancesPath(), ins.GetSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lookup.Ready.IsZero() {
+		t.Errorf("expected lookup to be unready, got %#v", lookup)
+	}
+}
+
+func TestInstanceReady(t *testing.T) {
+	ip := "10.0.0.1"
+	ins := instanceSetupClaimed("ready-cat", ip)
+
+	if _, err := ins.Ready(); !IsErrInvalidState(err) {
+		t.Errorf("expected readiness of claimed instance to be rejected, got %v", err)
+	}
+	if _, err := ins.WaitReady(100 * time.Millisecond); !IsErrTimeout(err) {
+		t.Errorf("expected timeout waiting for readiness, got %v", err)
+	}
+
+	ins, err := ins.Started(ip, "box1.ready-cat.com", 9999, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.IsReady() {
+		t.Error("expected started instance not to be ready")
+	}
+
+	waitc := make(chan *Instance, 1)
+	go func() {
+		ready, err := ins.WaitReady(time.Second)
+		if err != nil {
+			t.Error(err)
+		}
+		waitc <- ready
+	}()
+	if _, err = ins.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	if ready := <-waitc; ready == nil || !ready.IsReady() {
+		t.Errorf("expected WaitReady to return the ready instance, got %v", ready)
+	}
+
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ins.IsReady() {
+		t.Error("expected instance to be ready")
+	}
+	s := storeFromSnapshotable(ins)
+	lookups, err := s.NewProc(s.NewApp("ready-cat", "git://ready.git", "master"), "web").GetInstanceLookups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookups) != 1 || lookups[0].ID != ins.ID || !lookups[0].IsReady() {
+		t.Errorf("expected ready lookup of instance %d, got %v", ins.ID, lookups)
+	}
+	if ins, err = ins.Restarted(InsRestarts{Fail: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ins, err = storeFromSnapshotable(ins).GetInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.IsReady() {
+		t.Error("expected restarted instance not to be ready")
+	}
+}
+
+func TestResolveServiceReadinessGate(t *testing.T) {
+	ip := "10.0.0.1"
+	s := discoverySetup()
+
+	app, err := s.GetApp("dns-cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := app.GetProc("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.Attrs.ReadinessGate = true
+	if _, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := s.RegisterInstance("dns-cat", "128af9", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Claim(ip); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started(ip, "box1.dns.net", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := storeFromSnapshotable(ins).ResolveService(ins.ServiceName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected no records before the instance is ready, got %v", records)
+	}
+
+	if ins, err = ins.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	records, err = storeFromSnapshotable(ins).ResolveService(ins.ServiceName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Instance != ins.ID {
+		t.Errorf("expected record of instance %d, got %v", ins.ID, records)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const reconcilerClient = "reconciler"
+
+// DefaultResyncInterval is the interval of full reconciliations used if the
+// Reconciler doesn't set one.
+var DefaultResyncInterval = time.Minute
+
+// ReconcileActionType distinguishes ReconcileActions.
+type ReconcileActionType string
+
+// ReconcileActionTypes.
+const (
+	ReconcileRegister   = ReconcileActionType("register")
+	ReconcileUnregister = ReconcileActionType("unregister")
+	ReconcileStop       = ReconcileActionType("stop")
+)
+
+// ReconcileAction is a change which brings the instances of a proc closer to
+// its desired Scale.
+type ReconcileAction struct {
+	Type ReconcileActionType
+	App  string
+	Proc string
+	Rev  string
+	Env  string
+	// Instance to unregister or stop, or the registered one once applied.
+	Instance *Instance
+	// Error applying the action.
+	Err error
+}
+
+// Reconciler keeps the number of instances of every proc, revision and env
+// at the Scale stored for it. Pending instances which are not needed are
+// unregistered, running ones stopped. Revisions and envs without a Scale
+// are left alone. Replacing the instances of one revision with another
+// follows the DeployStrategy of the proc for the env.
+type Reconciler struct {
+	store *Store
+	// Interval of full reconciliations between events, jittered by up to
+	// half of it. DefaultResyncInterval if 0.
+	ResyncInterval time.Duration
+	// Called with every applied action, optional.
+	OnAction func(*ReconcileAction)
+}
+
+// NewReconciler returns a Reconciler for the Store. Call Run to start it.
+func (s *Store) NewReconciler() *Reconciler {
+	return &Reconciler{store: s}
+}
+
+// Diff returns the actions needed to reach the desired scales without
+// applying them.
+func (r *Reconciler) Diff() ([]*ReconcileAction, error) {
+	store, err := r.store.FastForward()
+	if err != nil {
+		return nil, err
+	}
+	apps, err := store.GetApps()
+	if err != nil {
+		return nil, err
+	}
+	actions := []*ReconcileAction{}
+	for _, app := range apps {
+		procs, err := app.GetProcs()
+		if err != nil {
+			return nil, err
+		}
+		for _, proc := range procs {
+			a, err := diffProc(proc)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, a...)
+		}
+	}
+	return actions, nil
+}
+
+// Reconcile applies the actions returned by Diff. Failing actions don't stop
+// the others, their error is set on the returned action.
+func (r *Reconciler) Reconcile() ([]*ReconcileAction, error) {
+	actions, err := r.Diff()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		r.apply(a)
+		if r.OnAction != nil {
+			r.OnAction(a)
+		}
+	}
+	return actions, nil
+}
+
+// Run reconciles whenever a scale changes or an instance gets ready or goes
+// away, and every ResyncInterval in between. It blocks until the Store is
+// closed or watching or reconciling fails.
+func (r *Reconciler) Run() error {
+	w, err := r.store.Watch(context.Background(), WatchOptions{
+		Filter: []EventType{EvProcScale, EvInsReady, EvInsStop, EvInsUnreg, EvInsFail, EvInsExit, EvInsLost},
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		if _, err := r.Reconcile(); err != nil {
+			if r.store.IsClosed() {
+				return nil
+			}
+			return err
+		}
+
+		timer := time.NewTimer(r.resyncDelay())
+		select {
+		case _, ok := <-w.Events():
+			timer.Stop()
+			if !ok {
+				return w.Stop()
+			}
+			// Coalesce a burst of events into one reconciliation.
+		drain:
+			for {
+				select {
+				case _, ok := <-w.Events():
+					if !ok {
+						return w.Stop()
+					}
+				default:
+					break drain
+				}
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *Reconciler) resyncDelay() time.Duration {
+	interval := r.ResyncInterval
+	if interval <= 0 {
+		interval = DefaultResyncInterval
+	}
+	return interval + time.Duration(rand.Int63n(int64(interval)/2+1))
+}
+
+func (r *Reconciler) apply(a *ReconcileAction) {
+	switch a.Type {
+	case ReconcileRegister:
+		a.Instance, a.Err = r.store.RegisterInstance(a.App, a.Rev, a.Proc, a.Env)
+	case ReconcileUnregister:
+		a.Err = a.Instance.Unregister(reconcilerClient, errors.New("scaled down"))
+	case ReconcileStop:
+		a.Err = a.Instance.Stop()
+	}
+}
+
+// diffProc compares the live instances of the proc with its scales.
+func diffProc(p *Proc) ([]*ReconcileAction, error) {
+	scales, err := p.GetScales()
+	if err != nil || len(scales) == 0 {
+		return nil, err
+	}
+	instances, err := p.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+	live := map[[2]string][]*Instance{}
+	for _, ins := range instances {
+		switch ins.Status {
+		case InsStatusPending, InsStatusClaimed, InsStatusStarting, InsStatusRunning:
+			k := [2]string{ins.RevisionName, ins.Env}
+			live[k] = append(live[k], ins)
+		}
+	}
+	byEnv := map[string][]*Scale{}
+	envs := []string{}
+	for _, scale := range scales {
+		if _, ok := byEnv[scale.Env]; !ok {
+			envs = append(envs, scale.Env)
+		}
+		byEnv[scale.Env] = append(byEnv[scale.Env], scale)
+	}
+	sort.Strings(envs)
+
+	actions := []*ReconcileAction{}
+	for _, env := range envs {
+		attrs, err := p.GetAttrsForEnv(env)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, diffEnv(p, byEnv[env], live, attrs)...)
+	}
+	return actions, nil
+}
+
+// diffEnv computes the actions for the scales of one env, limited by the
+// deploy strategy. Running instances of procs with a readiness gate only
+// count as up once they are ready.
+func diffEnv(p *Proc, scales []*Scale, live map[[2]string][]*Instance, attrs ProcAttrs) []*ReconcileAction {
+	strategy := attrs.Strategy
+	var (
+		registers, unregisters, stops []*ReconcileAction
+		desired, liveN, running       int
+		// Whether a revision runs fewer instances than desired.
+		shortfall bool
+	)
+	for _, scale := range scales {
+		have := live[[2]string{scale.Rev, scale.Env}]
+		newAction := func(t ReconcileActionType, ins *Instance) *ReconcileAction {
+			return &ReconcileAction{
+				Type:     t,
+				App:      p.App.Name,
+				Proc:     p.Name,
+				Rev:      scale.Rev,
+				Env:      scale.Env,
+				Instance: ins,
+			}
+		}
+		up := 0
+		for _, ins := range have {
+			if ins.takesTraffic(attrs.ReadinessGate) {
+				up++
+			}
+		}
+		desired += scale.Instances
+		liveN += len(have)
+		running += up
+		if up < scale.Instances {
+			shortfall = true
+		}
+
+		for n := len(have); n < scale.Instances; n++ {
+			registers = append(registers, newAction(ReconcileRegister, nil))
+		}
+		excess := len(have) - scale.Instances
+		if excess <= 0 {
+			continue
+		}
+		// Give up the newest instances first, pending before running ones.
+		// Claimed and starting instances are left to settle.
+		sort.Sort(sort.Reverse(instancesByID(have)))
+		for _, ins := range have {
+			if excess > 0 && ins.Status == InsStatusPending {
+				unregisters = append(unregisters, newAction(ReconcileUnregister, ins))
+				excess--
+			}
+		}
+		for _, ins := range have {
+			if excess > 0 && ins.Status == InsStatusRunning {
+				stops = append(stops, newAction(ReconcileStop, ins))
+				excess--
+			}
+		}
+	}
+
+	if strategy != nil {
+		switch strategy.Type {
+		case DeployRolling:
+			registers = limitActions(registers, desired+strategy.MaxSurge-liveN)
+			stops = limitActions(stops, running-desired+strategy.MaxUnavailable)
+		case DeployRecreate:
+			if len(unregisters)+len(stops) > 0 {
+				registers = nil
+			}
+		case DeployBlueGreen:
+			if shortfall {
+				stops = nil
+			}
+		}
+	}
+
+	actions := append(registers, unregisters...)
+	return append(actions, stops...)
+}
+
+func limitActions(actions []*ReconcileAction, max int) []*ReconcileAction {
+	if max < 0 {
+		max = 0
+	}
+	if len(actions) > max {
+		return actions[:max]
+	}
+	return actions
+}
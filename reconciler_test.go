@@ -0,0 +1,282 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+)
+
+func reconcilerSetup(appid string) (*Store, *Proc) {
+	s, err := DialURI(DefaultURI, "/reconciler-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	app, err := s.NewApp(appid, "git://reconciler.git", "master").Register()
+	if err != nil {
+		panic(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		panic(err)
+	}
+	return s, proc
+}
+
+func countActions(actions []*ReconcileAction, t ReconcileActionType) int {
+	n := 0
+	for _, a := range actions {
+		if a.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func TestProcScale(t *testing.T) {
+	_, proc := reconcilerSetup("scale-cat")
+
+	for _, c := range [][2]string{{"", "prod"}, {"..", "attrs"}, {"128af9", ".."}, {"128af9", ""}} {
+		if _, err := proc.SetScale(c[0], c[1], 3); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for %v, got %v", c, err)
+		}
+		if _, err := proc.DelScale(c[0], c[1]); !IsErrInvalidArgument(err) {
+			t.Errorf("expected invalid argument error for %v, got %v", c, err)
+		}
+	}
+	if _, err := proc.SetScale("128af9", "prod", -1); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	proc, err := proc.SetScale("128af9", "prod", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc, err = proc.SetScale("128af9", "staging", 1); err != nil {
+		t.Fatal(err)
+	}
+	scales, err := proc.GetScales()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scales) != 2 {
+		t.Fatalf("expected 2 scales, got %d", len(scales))
+	}
+	for _, scale := range scales {
+		if scale.Env == "prod" && scale.Instances != 3 {
+			t.Errorf("expected 3 prod instances, got %d", scale.Instances)
+		}
+	}
+	if proc, err = proc.DelScale("128af9", "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proc.DelScale("128af9", "staging"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestReconcilerReconcile(t *testing.T) {
+	ip := "10.0.0.1"
+	s, proc := reconcilerSetup("reconcile-cat")
+	r := s.NewReconciler()
+
+	proc, err := proc.SetScale("128af9", "prod", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := r.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countActions(actions, ReconcileRegister); n != 2 || len(actions) != 2 {
+		t.Fatalf("expected 2 registrations, got %d actions", len(actions))
+	}
+	for _, a := range actions {
+		if a.Err != nil {
+			t.Fatal(a.Err)
+		}
+	}
+
+	// Start one, so scaling down has to choose between pending and running.
+	running, err := actions[0].Instance.Claim(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running, err = running.Started(ip, "reconcile-cat.com", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	if actions, err = r.Diff(); err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 0 {
+		t.Errorf("expected no actions at the desired scale, got %d", len(actions))
+	}
+
+	if _, err = proc.SetScale("128af9", "prod", 0); err != nil {
+		t.Fatal(err)
+	}
+	if actions, err = r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if countActions(actions, ReconcileUnregister) != 1 || countActions(actions, ReconcileStop) != 1 {
+		t.Errorf("expected one unregistration and one stop, got %d actions", len(actions))
+	}
+	ins, err := s.GetInstance(running.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Status != InsStatusStopping {
+		t.Errorf("expected running instance to be stopped, got %s", ins.Status)
+	}
+}
+
+func TestReconcilerRun(t *testing.T) {
+	s, proc := reconcilerSetup("run-cat")
+	r := s.NewReconciler()
+	r.ResyncInterval = time.Hour
+	registered := make(chan *ReconcileAction, 2)
+	r.OnAction = func(a *ReconcileAction) {
+		registered <- a
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.Run()
+	}()
+	defer s.Close()
+
+	if _, err := proc.SetScale("128af9", "prod", 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case a := <-registered:
+		if a.Type != ReconcileRegister || a.Err != nil {
+			t.Errorf("expected successful registration, got %#v", a)
+		}
+	case err := <-errc:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("expected reconciliation after scale change")
+	}
+}
+
+func TestReconcilerStrategy(t *testing.T) {
+	ip := "10.0.0.1"
+	s, proc := reconcilerSetup("strategy-cat")
+
+	for i := 0; i < 2; i++ {
+		ins, err := s.RegisterInstance("strategy-cat", "128af9", "web", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Claim(ip); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ins.Started(ip, "strategy-cat.com", 9999+i, 10000+i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	proc, err := proc.SetScale("128af9", "prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc, err = proc.SetScale("9f8e7d", "prod", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.NewReconciler()
+	for i, tt := range []struct {
+		strategy         *DeployStrategy
+		registers, stops int
+	}{
+		{nil, 2, 2},
+		{&DeployStrategy{Type: DeployRolling, MaxUnavailable: 1}, 0, 1},
+		{&DeployStrategy{Type: DeployRolling, MaxSurge: 1}, 1, 0},
+		{&DeployStrategy{Type: DeployRecreate}, 0, 2},
+		{&DeployStrategy{Type: DeployBlueGreen}, 2, 0},
+	} {
+		proc.Attrs.Strategy = tt.strategy
+		if proc, err = proc.StoreAttrs(); err != nil {
+			t.Fatal(err)
+		}
+		actions, err := r.Diff()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := countActions(actions, ReconcileRegister); n != tt.registers {
+			t.Errorf("%d. expected %d registrations, got %d", i, tt.registers, n)
+		}
+		if n := countActions(actions, ReconcileStop); n != tt.stops {
+			t.Errorf("%d. expected %d stops, got %d", i, tt.stops, n)
+		}
+	}
+}
+
+func TestReconcilerReadinessGate(t *testing.T) {
+	ip := "10.0.0.1"
+	s, proc := reconcilerSetup("ready-cat")
+
+	var instances []*Instance
+	for i, rev := range []string{"128af9", "9f8e7d"} {
+		ins, err := s.RegisterInstance("ready-cat", rev, "web", "prod")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Claim(ip); err != nil {
+			t.Fatal(err)
+		}
+		if ins, err = ins.Started(ip, "ready-cat.com", 9999+i, 10000+i); err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, ins)
+	}
+	proc, err := proc.SetScale("128af9", "prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc, err = proc.SetScale("9f8e7d", "prod", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	r := s.NewReconciler()
+	expectStops := func(want int) {
+		actions, err := r.Diff()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := countActions(actions, ReconcileStop); n != want {
+			t.Errorf("expected %d stops, got %d", want, n)
+		}
+	}
+
+	proc.Attrs.Strategy = &DeployStrategy{Type: DeployBlueGreen}
+	if proc, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	expectStops(1)
+
+	proc.Attrs.ReadinessGate = true
+	if proc, err = proc.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	expectStops(0)
+
+	if _, err = instances[1].Ready(); err != nil {
+		t.Fatal(err)
+	}
+	expectStops(1)
+}
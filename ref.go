@@ -0,0 +1,97 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Ref references the instances of a proc in the canonical form
+// "app:proc@rev#env" returned by Instance.RefString. Rev and Env are optional
+// and match any revision or env if empty.
+type Ref struct {
+	App  string
+	Proc string
+	Rev  string
+	Env  string
+}
+
+// ParseRef parses a ref of the form "app:proc", "app:proc@rev" or
+// "app:proc@rev#env".
+func ParseRef(s string) (Ref, error) {
+	r := Ref{}
+	rest := s
+	if n := strings.LastIndex(rest, "#"); n >= 0 {
+		rest, r.Env = rest[:n], rest[n+1:]
+		if r.Env == "" {
+			return Ref{}, errorf(ErrInvalidArgument, "invalid ref %q: empty env", s)
+		}
+	}
+	if n := strings.LastIndex(rest, "@"); n >= 0 {
+		rest, r.Rev = rest[:n], rest[n+1:]
+		if r.Rev == "" {
+			return Ref{}, errorf(ErrInvalidArgument, "invalid ref %q: empty revision", s)
+		}
+	}
+	n := strings.Index(rest, ":")
+	if n < 0 {
+		return Ref{}, errorf(ErrInvalidArgument, "invalid ref %q: missing proc", s)
+	}
+	r.App, r.Proc = rest[:n], rest[n+1:]
+	if r.App == "" || r.Proc == "" || strings.ContainsAny(r.Proc, ":@#") {
+		return Ref{}, errorf(ErrInvalidArgument, "invalid ref %q", s)
+	}
+	return r, nil
+}
+
+// String returns the ref in the form ParseRef parses.
+func (r Ref) String() string {
+	s := fmt.Sprintf("%s:%s", r.App, r.Proc)
+	if r.Rev != "" {
+		s += "@" + r.Rev
+	}
+	if r.Env != "" {
+		s += "#" + r.Env
+	}
+	return s
+}
+
+// Matches reports whether the instance is referenced by r.
+func (r Ref) Matches(i *Instance) bool {
+	return r.App == i.AppName &&
+		r.Proc == i.ProcessName &&
+		(r.Rev == "" || r.Rev == i.RevisionName) &&
+		(r.Env == "" || r.Env == i.Env)
+}
+
+// Ref returns the ref of the instance.
+func (i *Instance) Ref() Ref {
+	return Ref{App: i.AppName, Proc: i.ProcessName, Rev: i.RevisionName, Env: i.Env}
+}
+
+// GetInstancesByRef returns the instances referenced by ref.
+func (s *Store) GetInstancesByRef(ref Ref) ([]*Instance, error) {
+	app, err := s.GetApp(ref.App)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := app.GetProc(ref.Proc)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := proc.GetInstances()
+	if err != nil && !IsErrNotFound(err) {
+		return nil, err
+	}
+	result := []*Instance{}
+	for _, i := range instances {
+		if ref.Matches(i) {
+			result = append(result, i)
+		}
+	}
+	return result, nil
+}
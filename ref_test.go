@@ -0,0 +1,86 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import "testing"
+
+func TestParseRef(t *testing.T) {
+	for _, test := range []struct {
+		s   string
+		ref Ref
+		err bool
+	}{
+		{s: "cat:web@128af9#default", ref: Ref{"cat", "web", "128af9", "default"}},
+		{s: "cat:web@128af9", ref: Ref{"cat", "web", "128af9", ""}},
+		{s: "cat:web", ref: Ref{"cat", "web", "", ""}},
+		{s: "cat:web#prod", ref: Ref{"cat", "web", "", "prod"}},
+		{s: "cat", err: true},
+		{s: ":web", err: true},
+		{s: "cat:@128af9", err: true},
+		{s: "cat:web@", err: true},
+		{s: "cat:web@128af9#", err: true},
+	} {
+		ref, err := ParseRef(test.s)
+		if (err != nil) != test.err {
+			t.Errorf("%q: expected error %t, got %v", test.s, test.err, err)
+			continue
+		}
+		if test.err {
+			if !IsErrInvalidArgument(err) {
+				t.Errorf("%q: expected ErrInvalidArgument, got %v", test.s, err)
+			}
+			continue
+		}
+		if ref != test.ref {
+			t.Errorf("%q: expected %#v, got %#v", test.s, test.ref, ref)
+		}
+		if ref.String() != test.s {
+			t.Errorf("expected %q, got %q", test.s, ref.String())
+		}
+	}
+}
+
+func TestStoreGetInstancesByRef(t *testing.T) {
+	s := instanceSetup()
+	app := s.NewApp("ref-cat", "git://ref-cat.git", "whiskers")
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+	if _, err := s.NewProc(app, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := s.RegisterInstance("ref-cat", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterInstance("ref-cat", "4dd2b3", "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := ParseRef(ins.RefString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances, err := storeFromSnapshotable(ins).GetInstancesByRef(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != ins.ID {
+		t.Errorf("expected only instance %d, got %v", ins.ID, instances)
+	}
+
+	ref.Rev = ""
+	instances, err = storeFromSnapshotable(ins).GetInstancesByRef(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 {
+		t.Errorf("expected 2 instances, got %d", len(instances))
+	}
+}
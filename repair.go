@@ -0,0 +1,250 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// LookupRepair lists the lookup paths changed by RepairInstanceLookups.
+type LookupRepair struct {
+	Created []string
+	Removed []string
+}
+
+func (r *LookupRepair) String() string {
+	return fmt.Sprintf("LookupRepair{created: %d, removed: %d}", len(r.Created), len(r.Removed))
+}
+
+// RepairInstanceLookups makes the per proc lookup entries consistent with
+// /instances. Missing entries under
+// apps/<app>/procs/<proc>/{instances/<rev>,failed,lost} are re-created from
+// the object file of each instance, entries without a matching instance are
+// removed and the instance counts of affected procs are corrected. Done
+// entries are history and left alone. Registrations running concurrently can
+// be reported as inconsistent, so this is meant to be run by operators on a
+// quiet cluster.
+func (s *Store) RepairInstanceLookups() (*LookupRepair, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if err := s.guard.authorize("", AuditRepairLookups, "cluster"); err != nil {
+		return nil, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	repair := &LookupRepair{Created: []string{}, Removed: []string{}}
+
+	ids, err := sp.Getdir(instancesPath)
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
+	expected := map[string]bool{}
+	for _, idstr := range ids {
+		id, err := parseInstanceID(idstr)
+		if err != nil {
+			return nil, err
+		}
+		ins, value, err := lookupOf(id, sp)
+		if err != nil {
+			return nil, err
+		}
+		if ins == nil {
+			continue
+		}
+		p := ins.procStatusPath(ins.Status)
+		expected[p] = true
+
+		exists, _, err := sp.Exists(p)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+		if sp, err = sp.Set(p, value); err != nil {
+			return nil, err
+		}
+		repair.Created = append(repair.Created, p)
+	}
+
+	lookups, err := getLookupPaths(sp)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range lookups {
+		if expected[p] {
+			continue
+		}
+		if err := sp.Del(p); err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		repair.Removed = append(repair.Removed, p)
+	}
+
+	if err := recountInstances(s, append(repair.Created, repair.Removed...)); err != nil {
+		return nil, err
+	}
+
+	audit(s, "", AuditRepairLookups, "cluster")
+	return repair, nil
+}
+
+// recountInstances resets the instance counters of the procs whose running
+// lookup entries were changed by a repair.
+func recountInstances(s cp.Snapshotable, lookups []string) error {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{}
+	for _, p := range lookups {
+		if revs := path.Dir(path.Dir(p)); path.Base(revs) == instancesPath {
+			dirs[path.Dir(revs)] = true
+		}
+	}
+	for dir := range dirs {
+		f, err := sp.GetFile(path.Join(dir, procsInstanceCountPath), new(cp.IntCodec))
+		if cp.IsErrNoEnt(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n, err := countInstances(dir, sp)
+		if err != nil {
+			return err
+		}
+		if f, err = f.Set(n); err != nil {
+			return err
+		}
+		sp = f.Snapshot
+	}
+	return nil
+}
+
+// lookupOf returns the instance described by the object file of the given id
+// and the value its lookup entry should have. If the object file is missing
+// the instance is nil.
+func lookupOf(id int64, sp cp.Snapshot) (*Instance, string, error) {
+	ins := &Instance{ID: id, Status: InsStatusPending}
+
+	obj, err := getObject(id, sp)
+	if err != nil {
+		if IsErrNotFound(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	ins.AppName, ins.RevisionName, ins.ProcessName = obj.App, obj.Rev, obj.Proc
+
+	status, _, err := sp.Get(path.Join(instancePath(id), statusPath))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, "", err
+	}
+	if status := InsStatus(status); status == InsStatusFailed || status == InsStatusLost {
+		// Terminated lookups carry the serialised instance.
+		full, err := getInstance(id, sp)
+		if err != nil {
+			return nil, "", err
+		}
+		codec, err := encodeCodec(sp, CodecInstances)
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := codec.Encode(full)
+		if err != nil {
+			return nil, "", err
+		}
+		return full, string(b), nil
+	}
+
+	registered, _, err := sp.Get(path.Join(instancePath(id), registeredPath))
+	if cp.IsErrNoEnt(err) {
+		registered, err = formatTime(time.Now()), nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	ready, _, err := sp.Get(path.Join(instancePath(id), readyPath))
+	if cp.IsErrNoEnt(err) {
+		return ins, registered, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	l, err := parseLookup(registered)
+	if err != nil {
+		return nil, "", err
+	}
+	if l.Ready, err = parseTime(ready); err != nil {
+		return nil, "", err
+	}
+	return ins, l.String(), nil
+}
+
+// getLookupPaths returns the paths of all running, failed and lost lookup
+// entries.
+func getLookupPaths(sp cp.Snapshot) ([]string, error) {
+	paths := []string{}
+
+	apps, err := sp.Getdir(appsPath)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return paths, nil
+		}
+		return nil, err
+	}
+	for _, app := range apps {
+		procs, err := getdirOrEmpty(sp, path.Join(appsPath, app, procsPath))
+		if err != nil {
+			return nil, err
+		}
+		for _, proc := range procs {
+			procDir := path.Join(appsPath, app, procsPath, proc)
+
+			revs, err := getdirOrEmpty(sp, path.Join(procDir, instancesPath))
+			if err != nil {
+				return nil, err
+			}
+			for _, rev := range revs {
+				ids, err := getdirOrEmpty(sp, path.Join(procDir, instancesPath, rev))
+				if err != nil {
+					return nil, err
+				}
+				for _, id := range ids {
+					paths = append(paths, path.Join(procDir, instancesPath, rev, id))
+				}
+			}
+
+			for _, dir := range []string{failedPath, lostPath} {
+				ids, err := getdirOrEmpty(sp, path.Join(procDir, dir))
+				if err != nil {
+					return nil, err
+				}
+				for _, id := range ids {
+					paths = append(paths, path.Join(procDir, dir, id))
+				}
+			}
+		}
+	}
+	return paths, nil
+}
+
+func getdirOrEmpty(sp cp.Snapshot, p string) ([]string, error) {
+	names, err := sp.Getdir(p)
+	if cp.IsErrNoEnt(err) {
+		return []string{}, nil
+	}
+	return names, err
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+)
+
+func repairSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/repair-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRepairInstanceLookups(t *testing.T) {
+	s := repairSetup(t)
+
+	ins, err := s.RegisterInstance("repair", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := ins.procStatusPath(InsStatusRunning)
+	dangling := procInstancesPath("repair", "128af9", "web") + "/999999"
+
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.Del(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if sp, err = sp.Set(dangling, formatTime(ins.Registered)); err != nil {
+		t.Fatal(err)
+	}
+
+	repair, err := s.RepairInstanceLookups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repair.Created) != 1 || repair.Created[0] != lookup {
+		t.Errorf("expected %s to be created, got %v", lookup, repair.Created)
+	}
+	if len(repair.Removed) != 1 || repair.Removed[0] != dangling {
+		t.Errorf("expected %s to be removed, got %v", dangling, repair.Removed)
+	}
+
+	if sp, err = sp.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _, _ := sp.Exists(lookup); !exists {
+		t.Errorf("expected %s to exist", lookup)
+	}
+	if exists, _, _ := sp.Exists(dangling); exists {
+		t.Errorf("expected %s to be removed", dangling)
+	}
+
+	repair, err = s.RepairInstanceLookups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repair.Created) != 0 || len(repair.Removed) != 0 {
+		t.Errorf("expected consistent tree, got %s", repair)
+	}
+}
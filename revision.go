@@ -6,14 +6,35 @@
 package visor
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"regexp"
+	"strings"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
 )
 
-var RefFormat = regexp.MustCompile(`^[[:alnum:]\-\.]+$`)
+var (
+	RefFormat = regexp.MustCompile(`^[[:alnum:]\-\.]+$`)
+	reSHA256  = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// validateRev returns ErrInvalidArgument for revision names which don't
+// match RefFormat or would escape the directory they are joined into.
+func validateRev(rev string) error {
+	if !RefFormat.MatchString(rev) || rev == "." || rev == ".." {
+		return errorf(ErrInvalidArgument, "invalid revision name %q", rev)
+	}
+	return nil
+}
 
 // A Revision represents an application revision,
 // identifiable by its `ref`.
@@ -22,12 +43,59 @@ type Revision struct {
 	App        *App
 	Ref        string
 	ArchiveURL string
+	Attrs      RevisionAttrs
+	Checksum   *Checksum
 	Registered time.Time
+	// Actor which registered the revision, empty if unknown.
+	RegisteredBy string
+}
+
+// Checksum is a digest of the archive of a revision.
+type Checksum struct {
+	Algo   string
+	Digest string
+}
+
+func (c *Checksum) String() string {
+	return c.Algo + ":" + c.Digest
+}
+
+// Supported checksum algorithms.
+var checksumAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// RevisionAttrs describe the build artifact the archive url points to.
+type RevisionAttrs struct {
+	// Hex encoded SHA256 digest of the archive.
+	Digest      string    `json:"digest,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	BuildNumber string    `json:"build-number,omitempty"`
+	BuilderHost string    `json:"builder-host,omitempty"`
+	BuildTime   time.Time `json:"build-time,omitempty"`
+	// Actor which stored the attrs, set by StoreAttrs.
+	UpdatedBy string `json:"updated-by,omitempty"`
+}
+
+// Validate checks the format of the digest.
+func (a RevisionAttrs) Validate() error {
+	if a.Digest != "" && !reSHA256.MatchString(a.Digest) {
+		return errorf(ErrInvalidArgument, "invalid sha256 digest %q", a.Digest)
+	}
+	if a.Size < 0 {
+		return errorf(ErrInvalidArgument, "invalid size %d", a.Size)
+	}
+	return nil
 }
 
 const (
 	archiveURLPath = "archive-url"
 	revsPath       = "revs"
+	revsAttrsPath  = "attrs"
+	checksumPath   = "checksum"
 )
 
 // NewRevision returns a new instance of Revision.
@@ -45,6 +113,14 @@ func (r *Revision) GetSnapshot() cp.Snapshot {
 
 // Register registers a new Revision with the registry.
 func (r *Revision) Register() (*Revision, error) {
+	if err := guardOf(r).authorize("", AuditRegister, r.auditName()); err != nil {
+		return nil, err
+	}
+	if ValidateNames {
+		if err := ValidateRevRef(r.Ref); err != nil {
+			return nil, err
+		}
+	}
 	sp, err := r.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -55,15 +131,30 @@ func (r *Revision) Register() (*Revision, error) {
 		return nil, err
 	}
 	if exists {
-		return nil, ErrConflict
+		return nil, errorf(ErrConflict, `revision "%s" of %s already exists`, r.Ref, r.App.Name)
+	}
+
+	if err := r.Attrs.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkDeployFreeze(sp); err != nil {
+		return nil, err
 	}
 
 	d, err := r.dir.Join(sp).Set(archiveURLPath, r.ArchiveURL)
 	if err != nil {
 		return nil, err
 	}
+	if r.Attrs != (RevisionAttrs{}) {
+		attrs, err := cp.NewFile(d.Prefix(revsAttrsPath), r.Attrs, new(cp.JsonCodec), d.Snapshot).Save()
+		if err != nil {
+			return nil, err
+		}
+		d = d.Join(attrs)
+	}
 	reg := time.Now()
-	d, err = r.dir.Set(registeredPath, formatTime(reg))
+	r.RegisteredBy = actorOf(r)
+	d, err = r.dir.Set(registeredPath, formatRegistered(reg, r.RegisteredBy))
 	if err != nil {
 		return nil, err
 	}
@@ -71,16 +162,195 @@ func (r *Revision) Register() (*Revision, error) {
 
 	r.dir = d
 
+	audit(r, "", AuditRegister, r.auditName())
+
 	return r, nil
 }
 
-// Unregister unregisters a revision from the registry.
+// Unregister unregisters a revision from the registry. It fails with
+// ErrRevisionInUse as long as instances of the revision exist.
 func (r *Revision) Unregister() error {
+	if err := guardOf(r).authorize("", AuditUnregister, r.auditName()); err != nil {
+		return err
+	}
+	sp, err := r.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	procs, err := r.procsInUse(sp)
+	if err != nil {
+		return err
+	}
+	if len(procs) > 0 {
+		return errorf(ErrRevisionInUse, "%s has instances of procs %s", r, strings.Join(procs, ", "))
+	}
+	return r.unregister(sp)
+}
+
+// UnregisterForce unregisters a revision regardless of existing instances.
+func (r *Revision) UnregisterForce() error {
+	if err := guardOf(r).authorize("", AuditUnregister, r.auditName()); err != nil {
+		return err
+	}
+	sp, err := r.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	return r.unregister(sp)
+}
+
+func (r *Revision) unregister(sp cp.Snapshot) error {
+	if err := r.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	audit(sp, actorOf(r), AuditUnregister, r.auditName())
+	return nil
+}
+
+// procsInUse returns the names of the procs with instances of the revision,
+// including failed and lost ones.
+func (r *Revision) procsInUse(sp cp.Snapshot) ([]string, error) {
+	procs, err := getdirOrEmpty(sp, r.App.dir.Prefix(procsPath))
+	if err != nil {
+		return nil, err
+	}
+	inUse := []string{}
+	for _, proc := range procs {
+		ids, err := getdirOrEmpty(sp, r.App.dir.Prefix(procsPath, proc, instancesPath, r.Ref))
+		if err != nil {
+			return nil, err
+		}
+		used := len(ids) > 0
+		if !used {
+			if used, err = r.hasTerminated(proc, sp); err != nil {
+				return nil, err
+			}
+		}
+		if used {
+			inUse = append(inUse, proc)
+		}
+	}
+	return inUse, nil
+}
+
+// hasTerminated reports whether the proc has failed or lost instances of the
+// revision. Their lookups aren't kept by revision, so the serialised
+// instances are read.
+func (r *Revision) hasTerminated(proc string, sp cp.Snapshot) (bool, error) {
+	for status, p := range map[InsStatus]string{InsStatusFailed: failedPath, InsStatusLost: lostPath} {
+		ids, err := getdirOrEmpty(sp, r.App.dir.Prefix(procsPath, proc, p))
+		if err != nil {
+			return false, err
+		}
+		for _, idstr := range ids {
+			id, err := parseInstanceID(idstr)
+			if err != nil {
+				return false, err
+			}
+			ins, err := getSerialisedInstance(r.App.Name, proc, id, status, sp)
+			if err != nil {
+				return false, err
+			}
+			if ins.RevisionName == r.Ref {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// StoreAttrs saves the set Attrs for the Revision.
+func (r *Revision) StoreAttrs() (*Revision, error) {
+	if err := guardOf(r).authorize("", AuditAttrs, r.auditName()); err != nil {
+		return nil, err
+	}
+	if err := r.Attrs.Validate(); err != nil {
+		return nil, err
+	}
+	sp, err := r.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	r.Attrs.UpdatedBy = actorOf(r)
+	attrs := cp.NewFile(r.dir.Prefix(revsAttrsPath), r.Attrs, new(cp.JsonCodec), sp)
+	attrs, err = attrs.Save()
+	if err != nil {
+		return nil, err
+	}
+	r.dir = r.dir.Join(attrs)
+
+	audit(r, "", AuditAttrs, r.auditName())
+
+	return r, nil
+}
+
+// SetChecksum stores the digest of the archive computed with the given
+// algorithm, one of md5, sha1, sha256 or sha512. The digest is hex encoded.
+func (r *Revision) SetChecksum(algo, digest string) (*Revision, error) {
+	h, ok := checksumAlgos[algo]
+	if !ok {
+		return nil, errorf(ErrInvalidArgument, "unsupported checksum algorithm %q", algo)
+	}
+	digest = strings.ToLower(digest)
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != h().Size() {
+		return nil, errorf(ErrInvalidArgument, "invalid %s digest %q", algo, digest)
+	}
+	if err := guardOf(r).authorize("", AuditAttrs, r.auditName()); err != nil {
+		return nil, err
+	}
+
+	c := &Checksum{Algo: algo, Digest: digest}
 	sp, err := r.GetSnapshot().FastForward()
 	if err != nil {
+		return nil, err
+	}
+	d, err := r.dir.Join(sp).Set(checksumPath, c.String())
+	if err != nil {
+		return nil, err
+	}
+	r.Checksum = c
+	r.dir = d
+
+	audit(r, "", AuditAttrs, r.auditName())
+	return r, nil
+}
+
+// VerifyArchive reads the archive from rd and compares its digest with the
+// one set by SetChecksum, or if none was set with the SHA256 digest of the
+// attrs. It returns an error of kind ErrChecksumMismatch if they differ and
+// ErrNotFound if the revision has no digest.
+func (r *Revision) VerifyArchive(rd io.Reader) error {
+	c := r.Checksum
+	if c == nil && r.Attrs.Digest != "" {
+		c = &Checksum{Algo: "sha256", Digest: r.Attrs.Digest}
+	}
+	if c == nil {
+		return errorf(ErrNotFound, "no checksum registered for %s", r)
+	}
+	newHash, ok := checksumAlgos[c.Algo]
+	if !ok {
+		return errorf(ErrInvalidArgument, "unsupported checksum algorithm %q", c.Algo)
+	}
+
+	h := newHash()
+	if _, err := io.Copy(h, rd); err != nil {
 		return err
 	}
-	return r.dir.Join(sp).Del("/")
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != c.Digest {
+		return errorf(ErrChecksumMismatch, "%s archive has %s digest %s, expected %s", r, c.Algo, digest, c.Digest)
+	}
+	return nil
+}
+
+func (r *Revision) auditName() string {
+	return fmt.Sprintf("rev:%s:%s", r.App.Name, r.Ref)
+}
+
+// Watch starts a Watcher for the events of the revision: its registration,
+// tags moving to or away from it and the activity of its instances.
+// Optionally the events can be filtered by type.
+func (r *Revision) Watch(ctx context.Context, filter ...EventType) (*Watcher, error) {
+	return storeFromSnapshotable(r).Watch(ctx, WatchOptions{Filter: filter, app: r.App.Name, rev: r.Ref})
 }
 
 func (r *Revision) String() string {
@@ -106,7 +376,7 @@ func (s *Store) GetRevisions() (revisions []*Revision, err error) {
 	revisions = []*Revision{}
 
 	for i := range apps {
-		revs, e := apps[i].GetRevisions()
+		revs, e := getRevisions(apps[i], apps[i].GetSnapshot())
 		if e != nil {
 			return nil, e
 		}
@@ -139,6 +409,22 @@ func getRevision(app *App, ref string, s cp.Snapshotable) (*Revision, error) {
 	}
 	r.ArchiveURL = f.Value.(string)
 
+	_, err = r.dir.GetFile(revsAttrsPath, &cp.JsonCodec{DecodedVal: &r.Attrs})
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
+	checksum, _, err := r.dir.Get(checksumPath)
+	if err == nil {
+		parts := strings.SplitN(checksum, ":", 2)
+		if len(parts) != 2 {
+			return nil, errorf(ErrInvalidFile, "invalid checksum %q for %s", checksum, r)
+		}
+		r.Checksum = &Checksum{Algo: parts[0], Digest: parts[1]}
+	} else if !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+
 	f, err = r.dir.GetFile(registeredPath, new(cp.StringCodec))
 	if err != nil {
 		if cp.IsErrNoEnt(err) {
@@ -146,7 +432,7 @@ func getRevision(app *App, ref string, s cp.Snapshotable) (*Revision, error) {
 		}
 		return nil, err
 	}
-	r.Registered, err = parseTime(f.Value.(string))
+	r.Registered, r.RegisteredBy, err = parseRegistered(f.Value.(string))
 	if err != nil {
 		return nil, err
 	}
@@ -6,7 +6,10 @@
 package visor
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 )
 
 func revSetup() (s *Store, app *App) {
@@ -102,3 +105,144 @@ func TestRevisionGet(t *testing.T) {
 		t.Errorf("want error '%s', have '%s'", want, have)
 	}
 }
+
+func TestRevisionAttrs(t *testing.T) {
+	s, app := revSetup()
+
+	rev := s.NewRevision(app, "attrs", "attrs.img")
+	rev.Attrs = RevisionAttrs{
+		Digest:      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		Size:        1024,
+		BuildNumber: "42",
+		BuilderHost: "builder1.example.net",
+		BuildTime:   time.Date(2013, 4, 2, 12, 0, 0, 0, time.UTC),
+	}
+	rev, err := rev.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := app.GetRevision("attrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Attrs.Digest != rev.Attrs.Digest || check.Attrs.Size != 1024 || check.Attrs.BuildNumber != "42" {
+		t.Errorf("expected attrs %v, got %v", rev.Attrs, check.Attrs)
+	}
+	if !check.Attrs.BuildTime.Equal(rev.Attrs.BuildTime) {
+		t.Errorf("expected build time %s, got %s", rev.Attrs.BuildTime, check.Attrs.BuildTime)
+	}
+
+	check.Attrs.BuilderHost = "builder2.example.net"
+	if _, err = check.StoreAttrs(); err != nil {
+		t.Fatal(err)
+	}
+	check, err = app.GetRevision("attrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Attrs.BuilderHost != "builder2.example.net" {
+		t.Errorf("expected updated builder host, got %s", check.Attrs.BuilderHost)
+	}
+
+	check.Attrs.Digest = "not-a-digest"
+	if _, err = check.StoreAttrs(); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+}
+
+func TestRevisionVerifyArchive(t *testing.T) {
+	s, app := revSetup()
+
+	rev, err := s.NewRevision(app, "checksum", "checksum.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rev.VerifyArchive(strings.NewReader("")); !IsErrNotFound(err) {
+		t.Errorf("expected not found error without checksum, got %v", err)
+	}
+	if _, err = rev.SetChecksum("crc32", "deadbeef"); !IsErrInvalidArgument(err) {
+		t.Errorf("expected unsupported algorithm error, got %v", err)
+	}
+
+	// sha256 of "archive"
+	digest := "0eb3e36bfb24dcd9bb1d1bece1531216b59539a8fde17ee80224af0653c92aa3"
+	if _, err = rev.SetChecksum("sha256", digest); err != nil {
+		t.Fatal(err)
+	}
+
+	rev, err = app.GetRevision("checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Checksum == nil || rev.Checksum.Algo != "sha256" {
+		t.Fatalf("expected sha256 checksum, got %v", rev.Checksum)
+	}
+	if err = rev.VerifyArchive(strings.NewReader("archive")); err != nil {
+		t.Errorf("expected archive to verify, got %v", err)
+	}
+	if err = rev.VerifyArchive(strings.NewReader("tampered")); !IsErrChecksumMismatch(err) {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestRevisionUnregisterInUse(t *testing.T) {
+	s, app := revSetup()
+
+	rev, err := s.NewRevision(app, "inuse", "inuse.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.NewProc(app, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.RegisterInstance(app.Name, rev.Ref, "web", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rev.Unregister(); !IsErrRevisionInUse(err) {
+		t.Errorf("expected revision in use error, got %v", err)
+	}
+	if err = rev.UnregisterForce(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.GetRevision(rev.Ref); !IsErrNotFound(err) {
+		t.Errorf("expected revision to be unregistered, got %v", err)
+	}
+}
+
+func TestRevisionUnregisterFailedInUse(t *testing.T) {
+	s, app := revSetup()
+
+	rev, err := s.NewRevision(app, "failing", "failing.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.NewRevision(app, "other", "other.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.NewProc(app, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := s.RegisterInstance(app.Name, rev.Ref, "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = failed.Failed("10.0.0.1", errors.New("crashed")); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := s.RegisterInstance(app.Name, other.Ref, "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lost.Lost("watchdog", errors.New("gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*Revision{rev, other} {
+		if err = r.Unregister(); !IsErrRevisionInUse(err) {
+			t.Errorf("expected %s to be in use, got %v", r.Ref, err)
+		}
+	}
+}
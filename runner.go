@@ -10,23 +10,54 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	cp "github.com/soundcloud/cotterpin"
 )
 
-const runnersPath = "runners"
+const (
+	runnersPath    = "runners"
+	heartbeatsPath = "runner-heartbeats"
+	// Addr of the runner handling an instance, stored in the instance dir.
+	runnerIdxPath = "runner"
+)
+
+// RunnerEventType distinguishes RunnerEvents.
+type RunnerEventType string
+
+// RunnerEventTypes.
+const (
+	RunnerStart = RunnerEventType("runner-start")
+	RunnerStop  = RunnerEventType("runner-stop")
+)
+
+// RunnerEvent is sent by WatchRunnersByHost for every registered or
+// unregistered Runner. Runner is nil for RunnerStop events.
+type RunnerEvent struct {
+	Type   RunnerEventType
+	Addr   string
+	Runner *Runner
+}
 
 // Runner is representation of a bazooka-runner process.
 type Runner struct {
 	dir        *cp.Dir
+	guard      *guard
+	closer     *closer
+	reads      *readLimit
 	Addr       string
 	InstanceID int64
+	// Time of the last heartbeat, zero if the runner never sent one.
+	LastHeartbeat time.Time
 }
 
 // NewRunner creates a Runner for the given Instance.
 func (s *Store) NewRunner(addr string, instanceID int64) *Runner {
 	return &Runner{
 		dir:        cp.NewDir(runnerPath(addr), s.GetSnapshot()),
+		guard:      s.guard,
+		closer:     s.closer,
+		reads:      s.reads,
 		Addr:       addr,
 		InstanceID: instanceID,
 	}
@@ -39,6 +70,9 @@ func (r *Runner) GetSnapshot() cp.Snapshot {
 
 // Register saves the runner in the coordinator.
 func (r *Runner) Register() (*Runner, error) {
+	if err := r.guard.authorize("", AuditRegister, "runner:"+r.Addr); err != nil {
+		return nil, err
+	}
 	sp, err := r.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -49,7 +83,7 @@ func (r *Runner) Register() (*Runner, error) {
 		return nil, err
 	}
 	if exists {
-		return nil, ErrConflict
+		return nil, errorf(ErrConflict, `runner "%s" already exists`, r.Addr)
 	}
 
 	f := cp.NewFile(r.dir.Name, []string{strconv.FormatInt(r.InstanceID, 10)}, new(cp.ListCodec), sp)
@@ -59,16 +93,134 @@ func (r *Runner) Register() (*Runner, error) {
 	}
 	r.dir = r.dir.Join(f)
 
+	if _, err := r.Heartbeat(); err != nil {
+		return nil, err
+	}
+	if err := r.index(); err != nil {
+		return nil, err
+	}
+
+	audit(r, "", AuditRegister, "runner:"+r.Addr)
+
 	return r, nil
 }
 
 // Unregister removes the Runner from the store.
 func (r *Runner) Unregister() error {
+	if err := r.guard.authorize("", AuditUnregister, "runner:"+r.Addr); err != nil {
+		return err
+	}
 	sp, err := r.GetSnapshot().FastForward()
 	if err != nil {
 		return err
 	}
-	return r.dir.Join(sp).Del("/")
+	if err := r.dir.Join(sp).Del("/"); err != nil {
+		return err
+	}
+	if err := sp.Del(heartbeatPath(r.Addr)); err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	if err := r.unindex(sp); err != nil {
+		return err
+	}
+	audit(sp, actorOf(r), AuditUnregister, "runner:"+r.Addr)
+	return nil
+}
+
+// Heartbeat records that the Runner is alive. Runners are expected to call
+// it periodically, see GetStaleRunners.
+func (r *Runner) Heartbeat() (*Runner, error) {
+	if err := r.guard.authorize("", ActionHeartbeat, "runner:"+r.Addr); err != nil {
+		return nil, err
+	}
+	sp, err := r.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sp, err = sp.Set(heartbeatPath(r.Addr), formatTime(now))
+	if err != nil {
+		return nil, err
+	}
+	r.dir = r.dir.Join(sp)
+	r.LastHeartbeat = now
+	return r, nil
+}
+
+// IsStale reports whether the last heartbeat of the Runner is older than
+// maxAge. Runners which never sent a heartbeat are not considered stale, as
+// they predate heartbeats.
+func (r *Runner) IsStale(maxAge time.Duration) bool {
+	return !r.LastHeartbeat.IsZero() && time.Since(r.LastHeartbeat) > maxAge
+}
+
+// GetStaleRunners returns all Runners whose last heartbeat is older than
+// maxAge.
+func (s *Store) GetStaleRunners(maxAge time.Duration) ([]*Runner, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := getdirOrEmpty(sp, runnersPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stale := []*Runner{}
+	for _, host := range hosts {
+		runners, err := s.join(sp).RunnersByHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range runners {
+			if r.IsStale(maxAge) {
+				stale = append(stale, r)
+			}
+		}
+	}
+	return stale, nil
+}
+
+// UnregisterStaleRunners unregisters all Runners whose last heartbeat is
+// older than maxAge and returns them. Runners which sent a heartbeat in the
+// meantime are kept. Watchers of WatchRunnerStop receive their addrs like for
+// any other unregistered Runner.
+func (s *Store) UnregisterStaleRunners(maxAge time.Duration) ([]*Runner, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	stale, err := s.GetStaleRunners(maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []*Runner{}
+	for _, r := range stale {
+		sp, err := s.GetSnapshot().FastForward()
+		if err != nil {
+			return removed, err
+		}
+		r, err := getRunner(r.Addr, s.join(sp))
+		if IsErrNotFound(err) {
+			// Unregistered concurrently.
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if !r.IsStale(maxAge) {
+			continue
+		}
+		if err := r.Unregister(); err != nil {
+			if IsErrNotFound(err) || cp.IsErrNoEnt(err) {
+				// Unregistered concurrently.
+				continue
+			}
+			return removed, err
+		}
+		removed = append(removed, r)
+	}
+	return removed, nil
 }
 
 // Runners returns all runners known.
@@ -90,7 +242,7 @@ func (s *Store) Runners() (runners []*Runner, err error) {
 
 // RunnersByHost returns all Runners for a given host.
 func (s *Store) RunnersByHost(host string) ([]*Runner, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
@@ -98,8 +250,8 @@ func (s *Store) RunnersByHost(host string) ([]*Runner, error) {
 	if err != nil {
 		return nil, err
 	}
-	ch, errch := cp.GetSnapshotables(ids, func(id string) (cp.Snapshotable, error) {
-		return getRunner(runnerAddr(host, id), sp)
+	ch, errch := getSnapshotables(s.reads, ids, func(id string) (cp.Snapshotable, error) {
+		return getRunner(runnerAddr(host, id), s.join(sp))
 	})
 	runners := []*Runner{}
 	for i := 0; i < len(ids); i++ {
@@ -117,20 +269,42 @@ func (s *Store) RunnersByHost(host string) ([]*Runner, error) {
 
 // GetRunner returns the Runner for the given addr.
 func (s *Store) GetRunner(addr string) (*Runner, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	return getRunner(addr, s.join(sp))
+}
+
+// GetRunnerForInstance returns the Runner handling the Instance with the
+// given id.
+func (s *Store) GetRunnerForInstance(id int64) (*Runner, error) {
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
-	return getRunner(addr, sp)
+	return getRunnerForInstance(id, s.join(sp))
 }
 
-// WatchRunnerStart sends all runners transitioned to start.
+// GetRunner returns the Runner handling the Instance.
+func (i *Instance) GetRunner() (*Runner, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getRunnerForInstance(i.ID, storeFromSnapshotable(i).join(sp))
+}
+
+// WatchRunnerStart sends all runners transitioned to start. The channel is
+// closed when the watcher stops.
 func (s *Store) WatchRunnerStart(ch chan *Runner, errch chan error) {
+	defer close(ch)
+
 	var sp cp.Snapshotable = s
 	for {
 		ev, err := waitRunners(sp)
 		if err != nil {
-			errch <- err
+			s.sendErr(errch, err)
 			return
 		}
 		sp = ev
@@ -142,20 +316,27 @@ func (s *Store) WatchRunnerStart(ch chan *Runner, errch chan error) {
 
 		runner, err := getRunner(addr, ev)
 		if err != nil {
-			errch <- err
+			s.sendErr(errch, err)
+			return
+		}
+		select {
+		case ch <- runner:
+		case <-s.closed():
 			return
 		}
-		ch <- runner
 	}
 }
 
-// WatchRunnerStop sends all Runners transitioned to stop.
+// WatchRunnerStop sends all Runners transitioned to stop. The channel is
+// closed when the watcher stops.
 func (s *Store) WatchRunnerStop(ch chan string, errch chan error) {
+	defer close(ch)
+
 	var sp cp.Snapshotable = s
 	for {
 		ev, err := waitRunners(sp)
 		if err != nil {
-			errch <- err
+			s.sendErr(errch, err)
 			return
 		}
 		sp = ev
@@ -163,10 +344,61 @@ func (s *Store) WatchRunnerStop(ch chan string, errch chan error) {
 		if !ev.IsDel() {
 			continue
 		}
-		ch <- addrFromPath(ev.Path)
+		select {
+		case ch <- addrFromPath(ev.Path):
+		case <-s.closed():
+			return
+		}
+	}
+}
+
+// WatchRunnersByHost sends an event for every Runner started or stopped on
+// the given host. Runners of other hosts aren't watched at all. The channel
+// is closed when WatchRunnersByHost returns. If the Store is closed it
+// returns nil.
+func (s *Store) WatchRunnersByHost(host string, ch chan *RunnerEvent) error {
+	defer close(ch)
+
+	sp := s.GetSnapshot()
+	for {
+		ev, err := sp.Wait(path.Join(runnersPath, host, "*"))
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			return err
+		}
+		sp = sp.Join(ev)
+
+		e := &RunnerEvent{Addr: addrFromPath(ev.Path)}
+		switch {
+		case ev.IsSet():
+			e.Type = RunnerStart
+			if e.Runner, err = getRunner(e.Addr, ev); err != nil {
+				return err
+			}
+		case ev.IsDel():
+			e.Type = RunnerStop
+		default:
+			continue
+		}
+		select {
+		case ch <- e:
+		case <-s.closed():
+			return nil
+		}
 	}
 }
 
+// sendErr reports err on errch unless the Store has been closed, in which
+// case the error is the expected result of the shutdown.
+func (s *Store) sendErr(errch chan error, err error) {
+	if s.IsClosed() {
+		return
+	}
+	errch <- err
+}
+
 func addrFromPath(path string) string {
 	parts := strings.Split(path, "/")
 	addr := runnerAddr(parts[2], parts[3])
@@ -190,7 +422,68 @@ func getRunner(addr string, s cp.Snapshotable) (*Runner, error) {
 		return nil, err
 	}
 
-	return storeFromSnapshotable(sp).NewRunner(addr, insID), nil
+	r := storeFromSnapshotable(s).NewRunner(addr, insID)
+
+	beat, _, err := sp.Get(heartbeatPath(addr))
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	if err == nil {
+		if r.LastHeartbeat, err = parseTime(beat); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func getRunnerForInstance(id int64, s cp.Snapshotable) (*Runner, error) {
+	addr, _, err := s.GetSnapshot().Get(path.Join(instancePath(id), runnerIdxPath))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "no runner found for instance %d", id)
+		}
+		return nil, err
+	}
+	return getRunner(addr, s)
+}
+
+// index stores the addr of the Runner with its instance. Runners of unknown
+// instances aren't indexed.
+func (r *Runner) index() error {
+	sp, err := r.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	exists, _, err := sp.Exists(path.Join(instancePath(r.InstanceID), objectPath))
+	if err != nil || !exists {
+		return err
+	}
+	sp, err = sp.Set(path.Join(instancePath(r.InstanceID), runnerIdxPath), r.Addr)
+	if err != nil {
+		return err
+	}
+	r.dir = r.dir.Join(sp)
+	return nil
+}
+
+// unindex removes the index entry of the Runner unless another runner took
+// over the instance since.
+func (r *Runner) unindex(sp cp.Snapshot) error {
+	p := path.Join(instancePath(r.InstanceID), runnerIdxPath)
+	addr, _, err := sp.Get(p)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return nil
+		}
+		return err
+	}
+	if addr != r.Addr {
+		return nil
+	}
+	if err := sp.Del(p); err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	return nil
 }
 
 func waitRunners(s cp.Snapshotable) (cp.Event, error) {
@@ -206,3 +499,8 @@ func runnerPath(addr string) string {
 	parts := strings.Split(addr, ":")
 	return path.Join(runnersPath, parts[0], parts[1])
 }
+
+func heartbeatPath(addr string) string {
+	parts := strings.Split(addr, ":")
+	return path.Join(heartbeatsPath, parts[0], parts[1])
+}
@@ -177,3 +177,129 @@ func TestWatchRunnerStop(t *testing.T) {
 		t.Errorf("expected runner, got timeout")
 	}
 }
+
+func TestWatchRunnersByHost(t *testing.T) {
+	s := runnerSetup()
+	ch := make(chan *RunnerEvent)
+	errch := make(chan error, 1)
+
+	go func() {
+		errch <- s.WatchRunnersByHost("10.0.0.1", ch)
+	}()
+
+	if _, err := s.NewRunner("10.0.0.2:9000", 1).Register(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.NewRunner("10.0.0.1:9000", 2).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []RunnerEventType{RunnerStart, RunnerStop} {
+		select {
+		case e := <-ch:
+			if e.Type != expected || e.Addr != r.Addr {
+				t.Errorf("expected %s of %s, got %s of %s", expected, r.Addr, e.Type, e.Addr)
+			}
+			if e.Type == RunnerStart && (e.Runner == nil || e.Runner.InstanceID != r.InstanceID) {
+				t.Errorf("expected runner in start event, got %#v", e.Runner)
+			}
+		case err := <-errch:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s event, got timeout", expected)
+		}
+	}
+}
+
+func TestRunnerHeartbeat(t *testing.T) {
+	s := runnerSetup()
+
+	fresh, err := s.NewRunner("127.0.0.1:7000", 1).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := s.NewRunner("127.0.0.2:7000", 2).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.LastHeartbeat.IsZero() {
+		t.Error("expected heartbeat to be set on register")
+	}
+
+	sp, err := stale.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sp.Set(heartbeatPath(stale.Addr), formatTime(time.Now().Add(-time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.Heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+
+	runners, err := s.GetStaleRunners(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runners) != 1 || runners[0].Addr != stale.Addr {
+		t.Fatalf("expected %s to be stale, got %v", stale.Addr, runners)
+	}
+
+	removed, err := s.UnregisterStaleRunners(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 {
+		t.Errorf("expected one runner to be unregistered, got %d", len(removed))
+	}
+	if _, err := s.GetRunner(stale.Addr); !IsErrNotFound(err) {
+		t.Errorf("expected stale runner to be unregistered, got %v", err)
+	}
+	if _, err := s.GetRunner(fresh.Addr); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetRunnerForInstance(t *testing.T) {
+	s := runnerSetup()
+
+	ins, err := s.RegisterInstance("runner", "128af9", "web", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetRunnerForInstance(ins.ID); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	r, err := s.NewRunner("127.0.0.1:8000", ins.ID).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := s.GetRunnerForInstance(ins.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.Addr != r.Addr {
+		t.Errorf("expected runner %s, got %s", r.Addr, r1.Addr)
+	}
+	r1, err = ins.GetRunner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.Addr != r.Addr {
+		t.Errorf("expected runner %s, got %s", r.Addr, r1.Addr)
+	}
+
+	if err := r.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.GetRunner(); !IsErrNotFound(err) {
+		t.Errorf("expected index to be removed, got %v", err)
+	}
+}
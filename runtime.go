@@ -0,0 +1,55 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const runtimePath = "runtime"
+
+// RuntimeInfo describes where an instance runs on its host, so tools can go
+// from an instance to its container.
+type RuntimeInfo struct {
+	ContainerID string `json:"container-id,omitempty"`
+	CgroupPath  string `json:"cgroup-path,omitempty"`
+	ImageDigest string `json:"image-digest,omitempty"`
+	// PID of the runner supervising the instance.
+	RunnerPID int `json:"runner-pid,omitempty"`
+}
+
+// SetRuntimeInfo stores the runtime info of the instance, replacing any
+// previous one.
+func (i *Instance) SetRuntimeInfo(info RuntimeInfo) (_ *Instance, err error) {
+	defer i.annotate(&err, "set-runtime-info")
+	if err := i.guard.authorize("", ActionRuntime, i.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	f, err := cp.NewFile(i.dir.Prefix(runtimePath), info, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	i.Runtime = &info
+	i.dir = i.dir.Join(f)
+	return i, nil
+}
+
+// getRuntimeInfo returns nil if the instance has no runtime info.
+func getRuntimeInfo(d *cp.Dir) (*RuntimeInfo, error) {
+	info := &RuntimeInfo{}
+	_, err := d.GetFile(runtimePath, &cp.JsonCodec{DecodedVal: info})
+	if cp.IsErrNoEnt(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const scalePath = "scale"
+
+// Scale is the desired number of instances of a proc for one revision and
+// env.
+type Scale struct {
+	Rev       string
+	Env       string
+	Instances int
+}
+
+// SetScale stores the desired number of instances of the proc for the given
+// revision and env. A scale of 0 asks for all instances to be stopped.
+func (p *Proc) SetScale(rev, env string, n int) (*Proc, error) {
+	if err := guardOf(p).authorize("", ActionScale, p.auditName()); err != nil {
+		return nil, err
+	}
+	if err := validateRev(rev); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, errorf(ErrInvalidArgument, "scale must not be negative")
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	f, err := cp.NewFile(p.dir.Prefix(scalePath, rev, env), n, new(cp.IntCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	p.dir = p.dir.Join(f)
+
+	audit(p, "", ActionScale, p.auditName())
+	return p, nil
+}
+
+// DelScale removes the desired scale of the given revision and env, so its
+// instances are no longer managed by a Reconciler.
+func (p *Proc) DelScale(rev, env string) (*Proc, error) {
+	if err := guardOf(p).authorize("", ActionScale, p.auditName()); err != nil {
+		return nil, err
+	}
+	if err := validateRev(rev); err != nil {
+		return nil, err
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	err = sp.Del(p.dir.Prefix(scalePath, rev, env))
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "no scale for %s@%s#%s", p.Name, rev, env)
+		}
+		return nil, err
+	}
+	if sp, err = sp.FastForward(); err != nil {
+		return nil, err
+	}
+	p.dir = p.dir.Join(sp)
+
+	audit(p, "", ActionScale, p.auditName())
+	return p, nil
+}
+
+// GetScales returns the desired scales of the proc for all revisions and
+// envs.
+func (p *Proc) GetScales() ([]*Scale, error) {
+	sp, err := p.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getScales(p, sp)
+}
+
+func getScales(p *Proc, sp cp.Snapshot) ([]*Scale, error) {
+	revs, err := getdirOrEmpty(sp, p.dir.Prefix(scalePath))
+	if err != nil {
+		return nil, err
+	}
+	scales := []*Scale{}
+	for _, rev := range revs {
+		envs, err := getdirOrEmpty(sp, p.dir.Prefix(scalePath, rev))
+		if err != nil {
+			return nil, err
+		}
+		for _, env := range envs {
+			f, err := sp.GetFile(path.Join(p.dir.Prefix(scalePath, rev), env), new(cp.IntCodec))
+			if err != nil {
+				if cp.IsErrNoEnt(err) {
+					continue
+				}
+				return nil, err
+			}
+			scales = append(scales, &Scale{Rev: rev, Env: env, Instances: f.Value.(int)})
+		}
+	}
+	return scales, nil
+}
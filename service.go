@@ -0,0 +1,315 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const servicesPath = "/services"
+
+// Kinds of the bazooka services, stored in their own directories.
+const (
+	ServiceLogger = "logger"
+	ServiceProxy  = "proxy"
+	ServicePm     = "pm"
+)
+
+var (
+	rServiceKind = regexp.MustCompile("^[-[:alnum:]]+$")
+
+	serviceDirs = map[string]string{
+		ServiceLogger: loggerDir,
+		ServiceProxy:  proxyDir,
+		ServicePm:     pmDir,
+	}
+)
+
+// Service is an endpoint of a cluster service like a logger or a proxy.
+// Services registered with a TTL expire unless they are registered again
+// before it runs out.
+type Service struct {
+	file       *cp.File
+	closer     *closer
+	reads      *readLimit
+	Kind       string          `json:"-"`
+	Addr       string          `json:"addr"`
+	Meta       json.RawMessage `json:"meta,omitempty"`
+	Registered time.Time       `json:"registered"`
+	// Zero if the service doesn't expire.
+	Expires time.Time `json:"expires"`
+}
+
+// ServiceEventType distinguishes ServiceEvents.
+type ServiceEventType string
+
+// ServiceEventTypes.
+const (
+	ServiceRegister   = ServiceEventType("service-register")
+	ServiceUnregister = ServiceEventType("service-unregister")
+)
+
+// ServiceEvent is sent by WatchServices for every registered or unregistered
+// Service. Service is nil for ServiceUnregister events.
+type ServiceEvent struct {
+	Type    ServiceEventType
+	Kind    string
+	Addr    string
+	Service *Service
+}
+
+// RegisterService stores the Service of the given kind at addr. meta is
+// stored JSON encoded and can be retrieved with DecodeMeta. If ttl is
+// positive the Service is left out of listings once it's older than ttl.
+func (s *Store) RegisterService(kind, addr string, meta interface{}, ttl time.Duration) (*Service, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
+	if !rServiceKind.MatchString(kind) {
+		return nil, errorf(ErrInvalidArgument, "invalid service kind %q", kind)
+	}
+	if addr == "" || strings.Contains(addr, "/") {
+		return nil, errorf(ErrInvalidArgument, "invalid service addr %q", addr)
+	}
+	if err := s.guard.authorize("", AuditRegister, serviceObject(kind, addr)); err != nil {
+		return nil, err
+	}
+	svc := &Service{
+		closer:     s.closer,
+		reads:      s.reads,
+		Kind:       kind,
+		Addr:       addr,
+		Registered: time.Now().UTC(),
+	}
+	if meta != nil {
+		b, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		svc.Meta = b
+	}
+	if ttl > 0 {
+		svc.Expires = svc.Registered.Add(ttl)
+	}
+
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	svc.file, err = cp.NewFile(servicePath(kind, addr), svc, new(cp.JsonCodec), sp).Save()
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// UnregisterService removes the Service of the given kind at addr.
+func (s *Store) UnregisterService(kind, addr string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.guard.authorize("", AuditUnregister, serviceObject(kind, addr)); err != nil {
+		return err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	err = sp.Del(servicePath(kind, addr))
+	if cp.IsErrNoEnt(err) {
+		return errorf(ErrNotFound, "%s %s not found", kind, addr)
+	}
+	return err
+}
+
+// GetService returns the Service of the given kind at addr, even if it has
+// expired.
+func (s *Store) GetService(kind, addr string) (*Service, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	return getService(kind, serviceKey(addr), sp)
+}
+
+// GetServices returns all unexpired Services of the given kind.
+func (s *Store) GetServices(kind string) ([]*Service, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := getdirOrEmpty(sp, serviceDir(kind))
+	if err != nil {
+		return nil, err
+	}
+	services := []*Service{}
+	for _, key := range keys {
+		svc, err := getService(kind, key, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if svc.IsExpired() {
+			continue
+		}
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// WatchServices sends an event for every Service of the given kind which is
+// registered or unregistered. The channel is closed when WatchServices
+// returns. If the Store is closed it returns nil.
+func (s *Store) WatchServices(kind string, ch chan *ServiceEvent) error {
+	return s.watchServices(context.Background(), kind, ch)
+}
+
+// watchServices is WatchServices, which also returns nil once ctx is done.
+func (s *Store) watchServices(ctx context.Context, kind string, ch chan *ServiceEvent) error {
+	defer close(ch)
+
+	sp := s.GetSnapshot()
+	for {
+		ev, err := waitContext(ctx, sp, path.Join(serviceDir(kind), "*"))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			return err
+		}
+		sp = sp.Join(ev)
+
+		key := path.Base(ev.Path)
+		e := &ServiceEvent{Kind: kind, Addr: serviceAddr(kind, key)}
+		switch {
+		case ev.IsSet():
+			e.Type = ServiceRegister
+			if e.Service, err = getService(kind, key, ev); err != nil {
+				return err
+			}
+			e.Addr = e.Service.Addr
+		case ev.IsDel():
+			e.Type = ServiceUnregister
+			// The addr is only known from the removed entry.
+			prev := ev.GetSnapshot()
+			prev.Rev--
+			if svc, err := getService(kind, key, prev); err == nil {
+				e.Addr = svc.Addr
+			}
+		default:
+			continue
+		}
+		select {
+		case ch <- e:
+		case <-s.closed():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (svc *Service) GetSnapshot() cp.Snapshot {
+	return svc.file.Snapshot
+}
+
+// IsExpired reports whether the TTL of the Service ran out.
+func (svc *Service) IsExpired() bool {
+	return !svc.Expires.IsZero() && time.Now().After(svc.Expires)
+}
+
+// DecodeMeta decodes the metadata of the Service into v.
+func (svc *Service) DecodeMeta(v interface{}) error {
+	if len(svc.Meta) == 0 {
+		return nil
+	}
+	return json.Unmarshal(svc.Meta, v)
+}
+
+func getService(kind, key string, s cp.Snapshotable) (*Service, error) {
+	sp := s.GetSnapshot()
+	p := path.Join(serviceDir(kind), key)
+
+	value, _, err := sp.Get(p)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			err = errorf(ErrNotFound, "%s %s not found", kind, serviceAddr(kind, key))
+		}
+		return nil, err
+	}
+	svc := &Service{}
+	if err := json.Unmarshal([]byte(value), svc); err != nil {
+		// Entries written before services were JSON encoded hold
+		// "<timestamp> [<version>]".
+		svc, err = parseLegacyService(serviceAddr(kind, key), value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	svc.Kind = kind
+	svc.closer, svc.reads = closerOf(s), readLimitOf(s)
+	svc.file = cp.NewFile(p, svc, new(cp.JsonCodec), sp)
+	return svc, nil
+}
+
+func parseLegacyService(addr, value string) (*Service, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, errorf(ErrInvalidFile, "service %s is empty", addr)
+	}
+	registered, err := parseTime(fields[0])
+	if err != nil {
+		return nil, errorf(ErrInvalidFile, "service %s has invalid timestamp %q", addr, fields[0])
+	}
+	svc := &Service{Addr: addr, Registered: registered}
+	if len(fields) > 1 {
+		svc.Meta, _ = json.Marshal(map[string]string{"version": fields[1]})
+	}
+	return svc, nil
+}
+
+func serviceDir(kind string) string {
+	if dir, ok := serviceDirs[kind]; ok {
+		return dir
+	}
+	return path.Join(servicesPath, kind)
+}
+
+func servicePath(kind, addr string) string {
+	return path.Join(serviceDir(kind), serviceKey(addr))
+}
+
+// serviceObject names the Service for the Authorizer.
+func serviceObject(kind, addr string) string {
+	return "service:" + kind + ":" + addr
+}
+
+// serviceKey turns host:port into a file name.
+func serviceKey(addr string) string {
+	return strings.Replace(addr, ":", "-", 1)
+}
+
+// serviceAddr guesses the addr from a file name. Proxies and pms are stored
+// by host only, which may contain dashes.
+func serviceAddr(kind, key string) string {
+	if kind == ServiceProxy || kind == ServicePm {
+		return key
+	}
+	return strings.Replace(key, "-", ":", 1)
+}
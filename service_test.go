@@ -0,0 +1,144 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+func serviceSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/service-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServiceRegister(t *testing.T) {
+	s := serviceSetup(t)
+
+	meta := map[string]string{"zone": "eu-1"}
+	if _, err := s.RegisterService("cache", "10.0.0.1:11211", meta, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterService("cache", "10.0.0.2:11211", nil, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterService("cache/x", "10.0.0.3:11211", nil, 0); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error, got %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	services, err := s.GetServices("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].Addr != "10.0.0.1:11211" {
+		t.Fatalf("expected only the unexpired service, got %v", services)
+	}
+	m := map[string]string{}
+	if err := services[0].DecodeMeta(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m["zone"] != "eu-1" {
+		t.Errorf("expected meta %v, got %v", meta, m)
+	}
+
+	expired, err := s.GetService("cache", "10.0.0.2:11211")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expired.IsExpired() {
+		t.Error("expected service to be expired")
+	}
+
+	if err := s.UnregisterService("cache", "10.0.0.1:11211"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnregisterService("cache", "10.0.0.1:11211"); !IsErrNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestServiceLegacy(t *testing.T) {
+	s := serviceSetup(t)
+
+	sp, err := s.GetSnapshot().Set(path.Join(loggerDir, "10.0.0.1-9000"), timestamp()+" v1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = s.join(sp)
+
+	if _, err := s.RegisterLogger("10.0.0.2:9000", "v1.3.0"); err != nil {
+		t.Fatal(err)
+	}
+	services, err := s.GetServices(ServiceLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("expected 2 loggers, got %d", len(services))
+	}
+	for _, svc := range services {
+		m := map[string]string{}
+		if err := svc.DecodeMeta(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m["version"] == "" {
+			t.Errorf("expected version of %s to be set", svc.Addr)
+		}
+	}
+
+	loggers, err := s.GetLoggers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loggers) != 2 || loggers[0] != "10.0.0.1:9000" {
+		t.Errorf("unexpected loggers %v", loggers)
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	s := serviceSetup(t)
+	ch := make(chan *ServiceEvent)
+	errch := make(chan error, 1)
+
+	go func() {
+		errch <- s.WatchServices(ServiceProxy, ch)
+	}()
+
+	if _, err := s.RegisterPm("10.0.0.1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterProxy("proxy-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnregisterProxy("proxy-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []ServiceEventType{ServiceRegister, ServiceUnregister} {
+		select {
+		case e := <-ch:
+			if e.Type != expected || e.Addr != "proxy-1" {
+				t.Errorf("expected %s of proxy-1, got %s of %s", expected, e.Type, e.Addr)
+			}
+		case err := <-errch:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s event, got timeout", expected)
+		}
+	}
+}
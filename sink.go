@@ -0,0 +1,212 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Batching and retry behaviour of PipeEvents.
+var (
+	SinkBatchSize     = 100
+	SinkFlushInterval = time.Second
+	SinkRetryDelay    = time.Second
+	SinkMaxRetryDelay = time.Minute
+)
+
+// EventSink receives batches of events from PipeEvents, e.g. to archive
+// them in a message queue.
+type EventSink interface {
+	// Send delivers the events in order. A failing batch is sent again,
+	// unless the error is an ErrSinkRejected.
+	Send(events []*Event) error
+	Close() error
+}
+
+// DroppingSink is an EventSink which is told about the batches PipeEvents
+// gives up on, e.g. to count or log them.
+type DroppingSink interface {
+	EventSink
+	// Dropped is called with the batch and the error of its last Send.
+	Dropped(events []*Event, err error)
+}
+
+// PipeEvents sends all events matching the filter to the sink in batches of
+// up to SinkBatchSize, at least every SinkFlushInterval. Failed batches are
+// retried with exponential backoff up to SinkMaxRetryDelay, so a sink can
+// reconnect without losing events. Batches rejected with ErrSinkRejected
+// are dropped right away. Once the Store is closed, the pending batch is
+// flushed, the sink closed and PipeEvents returns nil, or the error of the
+// last batch if it couldn't be delivered.
+func (s *Store) PipeEvents(sink EventSink, filter ...EventType) error {
+	defer sink.Close()
+
+	events := make(chan *Event)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.WatchEvent(events, filter...)
+	}()
+
+	batch := []*Event{}
+	ticker := time.NewTicker(SinkFlushInterval)
+	defer ticker.Stop()
+	for {
+		flush := false
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				if err := s.sendBatch(sink, batch); err != nil {
+					return err
+				}
+				return <-errc
+			}
+			batch = append(batch, ev)
+			flush = len(batch) >= SinkBatchSize
+		case <-ticker.C:
+			flush = len(batch) > 0
+		}
+		if flush {
+			// Dropped batches are reported to the sink, piping goes on
+			// until the Store is closed.
+			if err := s.sendBatch(sink, batch); err != nil && s.IsClosed() {
+				return err
+			}
+			batch = []*Event{}
+		}
+	}
+}
+
+// sendBatch sends the batch until it succeeds, is rejected or the Store is
+// closed, in which case a last attempt is made. The error of a batch which
+// wasn't delivered is returned once it was reported as dropped.
+func (s *Store) sendBatch(sink EventSink, batch []*Event) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	delay := SinkRetryDelay
+	for {
+		err := sink.Send(batch)
+		if err == nil {
+			return nil
+		}
+		if IsErrSinkRejected(err) {
+			return dropBatch(sink, batch, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-s.closed():
+			if err := sink.Send(batch); err != nil {
+				return dropBatch(sink, batch, err)
+			}
+			return nil
+		}
+		if delay *= 2; delay > SinkMaxRetryDelay {
+			delay = SinkMaxRetryDelay
+		}
+	}
+}
+
+func dropBatch(sink EventSink, batch []*Event, err error) error {
+	if d, ok := sink.(DroppingSink); ok {
+		d.Dropped(batch, err)
+	}
+	return err
+}
+
+// NSQSink publishes events as JSON messages to a topic of an nsqd through
+// its HTTP interface.
+type NSQSink struct {
+	// Address of the nsqd HTTP interface, e.g. http://127.0.0.1:4151.
+	Addr   string
+	Topic  string
+	Client *http.Client
+}
+
+// Send publishes the events with a single multi-publish request.
+func (n *NSQSink) Send(events []*Event) error {
+	body := &bytes.Buffer{}
+	for _, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return errorf(ErrSinkRejected, "encoding event: %w", err)
+		}
+		body.Write(b)
+		body.WriteByte('\n')
+	}
+	u := fmt.Sprintf("%s/mpub?topic=%s", n.Addr, url.QueryEscape(n.Topic))
+	return postSink(n.Client, u, "application/octet-stream", body.Bytes())
+}
+
+// Close satisfies the EventSink interface.
+func (n *NSQSink) Close() error {
+	return nil
+}
+
+// KafkaSink produces events as JSON records to a Kafka topic through a
+// Kafka REST proxy. Records are keyed by app, so the events of an app stay
+// in order within a partition.
+type KafkaSink struct {
+	// Address of the REST proxy, e.g. http://127.0.0.1:8082.
+	Addr   string
+	Topic  string
+	Client *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Send produces the events with a single request.
+func (k *KafkaSink) Send(events []*Event) error {
+	records := make([]kafkaRecord, len(events))
+	for i, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return errorf(ErrSinkRejected, "encoding event: %w", err)
+		}
+		records[i].Value = b
+		if ev.Path.App != nil {
+			records[i].Key = *ev.Path.App
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return errorf(ErrSinkRejected, "encoding records: %w", err)
+	}
+	u := fmt.Sprintf("%s/topics/%s", k.Addr, url.PathEscape(k.Topic))
+	return postSink(k.Client, u, "application/vnd.kafka.json.v2+json", body)
+}
+
+// Close satisfies the EventSink interface.
+func (k *KafkaSink) Close() error {
+	return nil
+}
+
+// postSink posts the body. Responses other than server errors and too many
+// requests reject it, as sending it again won't help.
+func postSink(client *http.Client, u, contentType string, body []byte) error {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	resp, err := client.Post(u, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("%s responded with %s", u, resp.Status)
+	}
+	return errorf(ErrSinkRejected, "%s responded with %s", u, resp.Status)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sinkSetup() *Store {
+	s, err := DialURI(DefaultURI, "/sink-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// testSink fails the first Send and records the batches of the others.
+type testSink struct {
+	mu      sync.Mutex
+	failed  bool
+	batches [][]*Event
+	closed  bool
+}
+
+func (s *testSink) Send(events []*Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.failed {
+		s.failed = true
+		return errors.New("connection refused")
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestPipeEvents(t *testing.T) {
+	defer func(d time.Duration) { SinkRetryDelay = d }(SinkRetryDelay)
+	defer func(d time.Duration) { SinkFlushInterval = d }(SinkFlushInterval)
+	SinkRetryDelay = time.Millisecond
+	SinkFlushInterval = 10 * time.Millisecond
+
+	s := sinkSetup()
+	sink := &testSink{}
+	errc := make(chan error, 1)
+	go func() { errc <- s.PipeEvents(sink, EvAppReg) }()
+
+	for _, name := range []string{"sink-cat", "sink-dog"} {
+		if _, err := s.NewApp(name, "git://sink.git", "master").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for _, batch := range sink.batches {
+		n += len(batch)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 events despite the failed send, got %d", n)
+	}
+	if !sink.closed {
+		t.Error("expected sink to be closed")
+	}
+}
+
+func sinkEvents() []*Event {
+	app := "sink-cat"
+	return []*Event{
+		{Type: EvAppReg, Rev: 1, Path: EventData{App: &app}},
+		{Type: EvAppUnreg, Rev: 2, Path: EventData{App: &app}},
+	}
+}
+
+func TestNSQSink(t *testing.T) {
+	var body []byte
+	var topic string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic = r.URL.Query().Get("topic")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	sink := &NSQSink{Addr: srv.URL, Topic: "visor-events"}
+	if err := sink.Send(sinkEvents()); err != nil {
+		t.Fatal(err)
+	}
+	if topic != "visor-events" {
+		t.Errorf("expected topic visor-events, got %q", topic)
+	}
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(lines))
+	}
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(lines[1], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["type"] != string(EvAppUnreg) {
+		t.Errorf("expected %s, got %v", EvAppUnreg, msg["type"])
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	var req struct {
+		Records []struct {
+			Key   string
+			Value map[string]interface{}
+		}
+	}
+	var reqPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&req)
+	}))
+	defer srv.Close()
+
+	sink := &KafkaSink{Addr: srv.URL, Topic: "visor-events"}
+	if err := sink.Send(sinkEvents()); err != nil {
+		t.Fatal(err)
+	}
+	if reqPath != "/topics/visor-events" {
+		t.Errorf("expected topic path, got %q", reqPath)
+	}
+	if len(req.Records) != 2 || req.Records[0].Key != "sink-cat" {
+		t.Fatalf("expected 2 records keyed by app, got %+v", req.Records)
+	}
+	if req.Records[0].Value["type"] != string(EvAppReg) {
+		t.Errorf("expected %s, got %v", EvAppReg, req.Records[0].Value["type"])
+	}
+
+	srv.Close()
+	if err := sink.Send(sinkEvents()); err == nil {
+		t.Error("expected error sending to a closed proxy")
+	}
+}
+
+// droppingSink fails every Send with err and records the dropped batches.
+type droppingSink struct {
+	err     error
+	sends   int
+	dropped [][]*Event
+}
+
+func (s *droppingSink) Send(events []*Event) error {
+	s.sends++
+	return s.err
+}
+
+func (s *droppingSink) Close() error {
+	return nil
+}
+
+func (s *droppingSink) Dropped(events []*Event, err error) {
+	s.dropped = append(s.dropped, events)
+}
+
+func TestSendBatchRejected(t *testing.T) {
+	s := &Store{closer: newCloser()}
+	sink := &droppingSink{err: NewError(ErrSinkRejected, "bad request")}
+
+	if err := s.sendBatch(sink, sinkEvents()); !IsErrSinkRejected(err) {
+		t.Fatalf("expected ErrSinkRejected, got %v", err)
+	}
+	if sink.sends != 1 {
+		t.Errorf("expected a rejected batch to be sent once, got %d sends", sink.sends)
+	}
+	if len(sink.dropped) != 1 {
+		t.Errorf("expected the batch to be dropped, got %d drops", len(sink.dropped))
+	}
+}
+
+func TestSendBatchClosed(t *testing.T) {
+	s := &Store{closer: newCloser()}
+	close(s.closer.done)
+	sink := &droppingSink{err: errors.New("connection refused")}
+
+	if err := s.sendBatch(sink, sinkEvents()); err != sink.err {
+		t.Fatalf("expected the error of the last send, got %v", err)
+	}
+	if sink.sends != 2 {
+		t.Errorf("expected a last send once closed, got %d sends", sink.sends)
+	}
+	if len(sink.dropped) != 1 {
+		t.Errorf("expected the batch to be dropped, got %d drops", len(sink.dropped))
+	}
+}
+
+func TestNSQSinkRejected(t *testing.T) {
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := &NSQSink{Addr: srv.URL, Topic: "visor-events"}
+	if err := sink.Send(sinkEvents()); !IsErrSinkRejected(err) {
+		t.Errorf("expected ErrSinkRejected, got %v", err)
+	}
+	for _, status = range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		if err := sink.Send(sinkEvents()); err == nil || IsErrSinkRejected(err) {
+			t.Errorf("expected a retryable error on %d, got %v", status, err)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package visor
 
 import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
 )
 
 const (
-	tagsPath = "tags"
+	tagsPath       = "tags"
+	tagHistoryPath = "tag-history"
 )
 
 // Tag represents a human readable alias for a revision. It's analogous to a
@@ -19,6 +24,10 @@ type Tag struct {
 	Name       string    `json:"name"`
 	Ref        string    `json:"ref"`
 	Registered time.Time `json:"registered"`
+	// Actor recorded in the history of the tag, optional.
+	RegisteredBy string `json:"registered-by,omitempty"`
+	// Owner of the protection, empty if the tag isn't protected.
+	ProtectedBy string `json:"protected-by,omitempty"`
 }
 
 // NewTag returns a named Tag referencing a given ref.
@@ -43,6 +52,9 @@ func (t *Tag) GetSnapshot() cp.Snapshot {
 // Register stores the Tag in store. It does permit overwriting an existing tag
 // with the same name to enable atomic updates.
 func (t *Tag) Register() error {
+	if err := guardOf(t).authorize("", AuditRegister, t.auditName()); err != nil {
+		return err
+	}
 	var err error
 
 	revs, err := t.App.GetRevisions()
@@ -63,16 +75,50 @@ func (t *Tag) Register() error {
 		return errorf(ErrNotFound, `revision "%s" not found for app "%s"`, t.Ref, t.App.Name)
 	}
 
+	sp, err := t.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	current, err := getTag(t.App, t.Name, sp)
+	if err != nil && !IsErrNotFound(err) {
+		return err
+	}
+	if current != nil {
+		if current.IsProtected() {
+			return errorf(ErrTagProtected, "tag %q is protected by %s", t.Name, current.ProtectedBy)
+		}
+		t.file = current.file
+	}
+
+	t.ProtectedBy = ""
 	t.Registered = time.Now()
+	if t.RegisteredBy == "" {
+		t.RegisteredBy = actorOf(t)
+	}
 	t.file, err = t.file.Set(t)
 	if err != nil {
 		return err
 	}
+	if current != nil && current.Ref != t.Ref {
+		if err := current.unindexRef(); err != nil {
+			return err
+		}
+	}
+	if err := t.indexRef(); err != nil {
+		return err
+	}
+	if err := t.recordChange(t.Ref, t.RegisteredBy, t.Registered); err != nil {
+		return err
+	}
+	audit(t, "", AuditRegister, t.auditName())
 	return nil
 }
 
 // Unregister removes the stored Tag from store.
 func (t *Tag) Unregister() error {
+	if err := guardOf(t).authorize("", AuditUnregister, t.auditName()); err != nil {
+		return err
+	}
 	sp, err := t.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -84,7 +130,183 @@ func (t *Tag) Unregister() error {
 	if !exists {
 		return errorf(ErrNotFound, `tag "%s" not found`, t.Name)
 	}
-	return t.file.Del()
+	current, err := getTag(t.App, t.Name, sp)
+	if err != nil {
+		return err
+	}
+	if current.IsProtected() {
+		return errorf(ErrTagProtected, "tag %q is protected by %s", t.Name, current.ProtectedBy)
+	}
+	if err := current.file.Del(); err != nil {
+		return err
+	}
+	if err := current.unindexRef(); err != nil {
+		return err
+	}
+	if err := t.recordChange("", actorOf(t), time.Now()); err != nil {
+		return err
+	}
+	audit(sp, actorOf(t), AuditUnregister, t.auditName())
+	return nil
+}
+
+// Protect prevents the tag from being re-registered or unregistered until
+// the same owner calls Unprotect. If the Store has an actor set with As, it
+// is the only allowed owner.
+func (t *Tag) Protect(owner string) (*Tag, error) {
+	if owner == "" {
+		return nil, errorf(ErrInvalidArgument, "owner must not be empty")
+	}
+	return t.setProtection("", owner)
+}
+
+// Unprotect lifts the protection set by Protect. It fails with
+// ErrUnauthorized if owner didn't protect the tag.
+func (t *Tag) Unprotect(owner string) (*Tag, error) {
+	return t.setProtection(owner, "")
+}
+
+// IsProtected reports whether the tag is protected.
+func (t *Tag) IsProtected() bool {
+	return t.ProtectedBy != ""
+}
+
+func (t *Tag) setProtection(from, to string) (*Tag, error) {
+	owner := from + to
+	if actor := actorOf(t); actor != "" && owner != actor {
+		return nil, errorf(ErrUnauthorized, "owner %s of tag %q is not the actor %s", owner, t.Name, actor)
+	}
+	if err := guardOf(t).authorize("", ActionProtect, t.auditName()); err != nil {
+		return nil, err
+	}
+	sp, err := t.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	current, err := getTag(t.App, t.Name, sp)
+	if err != nil {
+		return nil, err
+	}
+	if current.ProtectedBy != from {
+		if from == "" {
+			return nil, errorf(ErrTagProtected, "tag %q is protected by %s", t.Name, current.ProtectedBy)
+		}
+		return nil, errorf(ErrUnauthorized, "tag %q is not protected by %s", t.Name, from)
+	}
+
+	current.ProtectedBy = to
+	current.file, err = current.file.Set(current)
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// TagChange records a tag being pointed to a ref. An empty Ref records the
+// tag being unregistered.
+type TagChange struct {
+	Ref   string    `json:"ref"`
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// History returns all changes of the tag ordered from oldest to newest.
+func (t *Tag) History() ([]*TagChange, error) {
+	sp, err := t.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	return getTagHistory(t.App, t.Name, sp)
+}
+
+// GetTagAt returns the Tag with the given name as it was at time at.
+func (a *App) GetTagAt(name string, at time.Time) (*Tag, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	history, err := getTagHistory(a, name, sp)
+	if err != nil {
+		return nil, err
+	}
+
+	var change *TagChange
+	for _, c := range history {
+		if c.Time.After(at) {
+			break
+		}
+		change = c
+	}
+	if change == nil || change.Ref == "" {
+		return nil, errorf(ErrNotFound, `tag "%s" not found at %s`, name, at.Format(time.RFC3339))
+	}
+
+	tag := a.NewTag(name, change.Ref)
+	tag.file = tag.file.Join(sp)
+	tag.Registered = change.Time
+	tag.RegisteredBy = change.Actor
+	return tag, nil
+}
+
+func (t *Tag) recordChange(ref, actor string, at time.Time) error {
+	sp, err := t.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	c := &TagChange{Ref: ref, Actor: actor, Time: at.UTC()}
+	p := t.App.dir.Prefix(tagHistoryPath, t.Name, fmt.Sprintf("%019d", c.Time.UnixNano()))
+	_, err = cp.NewFile(p, c, new(cp.JsonCodec), sp).Save()
+	return err
+}
+
+func getTagHistory(a *App, name string, sp cp.Snapshot) ([]*TagChange, error) {
+	dir := a.dir.Prefix(tagHistoryPath, name)
+	names, err := getdirOrEmpty(sp, dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	history := []*TagChange{}
+	for _, n := range names {
+		c := &TagChange{}
+		if _, err := sp.GetFile(path.Join(dir, n), &cp.JsonCodec{DecodedVal: c}); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, nil
+}
+
+func (t *Tag) refIndexPath() string {
+	return t.App.dir.Prefix(revsPath, t.Ref, tagsPath, t.Name)
+}
+
+// indexRef adds the tag to the index of its revision.
+func (t *Tag) indexRef() error {
+	sp, err := t.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	_, err = sp.Set(t.refIndexPath(), timestamp())
+	return err
+}
+
+// unindexRef removes the tag from the index of its revision.
+func (t *Tag) unindexRef() error {
+	sp, err := t.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	err = sp.Del(t.refIndexPath())
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return err
+	}
+	return nil
+}
+
+func (t *Tag) auditName() string {
+	return "tag:" + t.App.Name + "/" + t.Name
 }
 
 // GetTag retrieves the Tag with the given name.
@@ -96,20 +318,94 @@ func (a *App) GetTag(name string) (*Tag, error) {
 	return getTag(a, name, sp)
 }
 
+// WatchTag sends the revision the named tag references to ch, first the
+// current one if the tag exists and then again every time the tag is
+// registered, whether it moved or not. The channel is closed when WatchTag
+// returns, which it does with nil once ctx is done.
+func (a *App) WatchTag(ctx context.Context, name string, ch chan *Revision) error {
+	defer close(ch)
+
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	tag, err := getTag(a, name, sp)
+	if err != nil && !IsErrNotFound(err) {
+		return err
+	}
+	if tag != nil {
+		rev, err := getRevision(a, tag.Ref, sp)
+		if err != nil {
+			return err
+		}
+		select {
+		case ch <- rev:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	// Registration always sets the entry in the index of the revision, even
+	// if the tag didn't move.
+	glob := a.dir.Prefix(revsPath, "*", tagsPath, name)
+	for {
+		ev, err := waitContext(ctx, sp, glob)
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		sp = sp.Join(ev)
+		if !ev.IsSet() {
+			continue
+		}
+		match := reRevTag.FindStringSubmatch(ev.Path)
+		if match == nil {
+			continue
+		}
+		rev, err := getRevision(a, match[2], ev)
+		if err != nil {
+			return err
+		}
+		select {
+		case ch <- rev:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 // GetTags retrieves all tags for the revision.
 func (r *Revision) GetTags() ([]*Tag, error) {
-	tags, err := r.App.GetTags()
+	return r.App.GetTagsByRef(r.Ref)
+}
+
+// GetTagsByRef returns all Tags referencing the given ref. The lookup is
+// backed by an index under the revision which is maintained by Register and
+// Unregister.
+func (a *App) GetTagsByRef(ref string) ([]*Tag, error) {
+	sp, err := a.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	names, err := getdirOrEmpty(sp, a.dir.Prefix(revsPath, ref, tagsPath))
 	if err != nil {
 		return nil, err
 	}
 
-	rtags := []*Tag{}
-	for _, tag := range tags {
-		if tag.Ref == r.Ref {
-			rtags = append(rtags, tag)
+	tags := []*Tag{}
+	for _, name := range names {
+		tag, err := getTag(a, name, sp)
+		if err != nil {
+			if IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		// Guard against the index lagging behind a re-registration.
+		if tag.Ref == ref {
+			tags = append(tags, tag)
 		}
 	}
-	return rtags, nil
+	return tags, nil
 }
 
 // GetTags returns a list of all Tags for the app.
@@ -125,7 +421,7 @@ func (a *App) GetTags() ([]*Tag, error) {
 	}
 
 	tags := []*Tag{}
-	ch, errch := cp.GetSnapshotables(names, func(name string) (cp.Snapshotable, error) {
+	ch, errch := getSnapshotables(a.reads, names, func(name string) (cp.Snapshotable, error) {
 		return getTag(a, name, sp)
 	})
 	for i := 0; i < len(names); i++ {
@@ -1,6 +1,10 @@
 package visor
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func TestTagRegister(t *testing.T) {
 	var (
@@ -130,6 +134,63 @@ func TestTagList(t *testing.T) {
 	}
 }
 
+func TestTagGetTagsByRef(t *testing.T) {
+	var (
+		app  = tagSetup(t)
+		ref1 = "idx1234"
+		ref2 = "idx9876"
+	)
+
+	for _, ref := range []string{ref1, ref2} {
+		if _, err := tagStore.NewRevision(app, ref, "http://unknown").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"stable", "canary"} {
+		if err := app.NewTag(name, ref1).Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tags, err := app.GetTagsByRef(ref1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("want 2 tags for %s, have %d", ref1, len(tags))
+	}
+
+	// Repoint a tag, the index of the old ref has to follow.
+	if err := app.NewTag("canary", ref2).Register(); err != nil {
+		t.Fatal(err)
+	}
+	tags, err = app.GetTagsByRef(ref1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "stable" {
+		t.Errorf("want only stable for %s, have %v", ref1, tags)
+	}
+	tags, err = app.GetTagsByRef(ref2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "canary" {
+		t.Errorf("want only canary for %s, have %v", ref2, tags)
+	}
+
+	if err := tags[0].Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	tags, err = app.GetTagsByRef(ref2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("want no tags for %s after unregister, have %v", ref2, tags)
+	}
+}
+
 func TestTagLookup(t *testing.T) {
 	var (
 		app  = tagSetup(t)
@@ -160,6 +221,194 @@ func TestTagLookup(t *testing.T) {
 	}
 }
 
+func TestTagProtect(t *testing.T) {
+	var (
+		app  = tagSetup(t)
+		name = "stable"
+		ref1 = "123abcd"
+		ref2 = "d1324cs"
+	)
+
+	for _, ref := range []string{ref1, ref2} {
+		if _, err := tagStore.NewRevision(app, ref, "http://unknown").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag := app.NewTag(name, ref1)
+	if err := tag.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	tag, err := tag.Protect("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tag.IsProtected() {
+		t.Error("want tag to be protected")
+	}
+
+	if err := app.NewTag(name, ref2).Register(); !IsErrTagProtected(err) {
+		t.Errorf("want re-registration to fail with ErrTagProtected, have %v", err)
+	}
+	if err := tag.Unregister(); !IsErrTagProtected(err) {
+		t.Errorf("want unregistration to fail with ErrTagProtected, have %v", err)
+	}
+	if _, err := tag.Unprotect("bob"); !IsErrUnauthorized(err) {
+		t.Errorf("want unprotect by other owner to fail with ErrUnauthorized, have %v", err)
+	}
+
+	bobApp, err := tagStore.As("bob").GetApp(app.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bobApp.NewTag(name, ref1).Unprotect("alice"); !IsErrUnauthorized(err) {
+		t.Errorf("want unprotect on behalf of another actor to fail with ErrUnauthorized, have %v", err)
+	}
+
+	if tag, err = tag.Unprotect("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag(name, ref2).Register(); err != nil {
+		t.Fatal(err)
+	}
+	tag, err = app.GetTag(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Ref != ref2 || tag.IsProtected() {
+		t.Errorf("want unprotected tag on %s, have %s protected by %q", ref2, tag.Ref, tag.ProtectedBy)
+	}
+	if err := tag.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTagHistory(t *testing.T) {
+	var (
+		app  = tagSetup(t)
+		name = "stable"
+		ref1 = "123abcd"
+		ref2 = "d1324cs"
+	)
+
+	for _, ref := range []string{ref1, ref2} {
+		if _, err := tagStore.NewRevision(app, ref, "http://unknown").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := time.Now()
+	tag := app.NewTag(name, ref1)
+	tag.RegisteredBy = "alice"
+	if err := tag.Register(); err != nil {
+		t.Fatal(err)
+	}
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	tag = app.NewTag(name, ref2)
+	tag.RegisteredBy = "bob"
+	if err := tag.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := tag.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("want 2 history entries, have %d", len(history))
+	}
+	if history[0].Ref != ref1 || history[0].Actor != "alice" || history[1].Ref != ref2 || history[1].Actor != "bob" {
+		t.Errorf("unexpected history %v, %v", history[0], history[1])
+	}
+
+	if _, err := app.GetTagAt(name, before.Add(-time.Second)); !IsErrNotFound(err) {
+		t.Errorf("want tag to not exist before registration, have %v", err)
+	}
+	old, err := app.GetTagAt(name, between)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Ref != ref1 {
+		t.Errorf("want tag to point to %s, have %s", ref1, old.Ref)
+	}
+
+	carol, err := tagStore.As("carol").GetApp(app.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag, err = carol.GetTag(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := tag.Unregister(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.GetTagAt(name, time.Now()); !IsErrNotFound(err) {
+		t.Errorf("want tag to not exist after unregistration, have %v", err)
+	}
+	history, err = tag.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[2].Actor != "carol" {
+		t.Errorf("want unregistration by carol recorded, have %v", history)
+	}
+}
+
+func TestTagWatch(t *testing.T) {
+	var (
+		app  = tagSetup(t)
+		name = "watch"
+		ref1 = "123abcd"
+		ref2 = "d1324cs"
+	)
+	for _, ref := range []string{ref1, ref2} {
+		if _, err := tagStore.NewRevision(app, ref, "http://unknown").Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.NewTag(name, ref1).Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan *Revision)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- app.WatchTag(ctx, name, ch)
+	}()
+
+	expect := func(ref string) {
+		select {
+		case rev := <-ch:
+			if rev.Ref != ref {
+				t.Errorf("want revision %s, have %s", ref, rev.Ref)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("want revision %s, have timeout", ref)
+		}
+	}
+	expect(ref1)
+
+	if err := app.NewTag(name, ref2).Register(); err != nil {
+		t.Fatal(err)
+	}
+	expect(ref2)
+	if err := app.NewTag(name, ref2).Register(); err != nil {
+		t.Fatal(err)
+	}
+	expect(ref2)
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("want channel to be closed")
+	}
+}
+
 var tagStore *Store
 
 func tagSetup(t *testing.T) *App {
@@ -0,0 +1,236 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const txnsPath = "/txns"
+
+// Txn stages writes to multiple paths and applies them together on Commit.
+// The coordinator has no multi-path transactions, so Commit first records
+// the staged writes together with the values they replace. If applying them
+// fails half-way the already applied writes are rolled back; if even that
+// fails the record is kept for RecoverTxns to clean up.
+type Txn struct {
+	store *Store
+	ops   []*txnOp
+	err   error
+}
+
+// txnRecord is stored while a transaction is applied.
+type txnRecord struct {
+	Time time.Time `json:"time"`
+	Ops  []*txnOp  `json:"ops"`
+}
+
+type txnOp struct {
+	Path    string `json:"path"`
+	Value   string `json:"value"`
+	Del     bool   `json:"del"`
+	Prev    string `json:"prev"`
+	Existed bool   `json:"existed"`
+}
+
+// Txn returns an empty transaction.
+func (s *Store) Txn() *Txn {
+	return &Txn{store: s}
+}
+
+// Set stages writing value to the given absolute path.
+func (t *Txn) Set(p, value string) *Txn {
+	t.ops = append(t.ops, &txnOp{Path: p, Value: value})
+	return t
+}
+
+// SetFile stages writing value encoded with codec to the given absolute path.
+// Encoding errors are returned by Commit.
+func (t *Txn) SetFile(p string, value interface{}, codec cp.Codec) *Txn {
+	b, err := codec.Encode(value)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return t
+	}
+	return t.Set(p, string(b))
+}
+
+// Del stages removing the given absolute path.
+func (t *Txn) Del(p string) *Txn {
+	t.ops = append(t.ops, &txnOp{Path: p, Del: true})
+	return t
+}
+
+// Commit applies the staged writes in order. It returns the snapshot after
+// the last write. On failure all applied writes are rolled back, if that is
+// not possible an error of kind ErrTxnIncomplete is returned. Every staged
+// path is authorized and audited as object "path:<path>".
+func (t *Txn) Commit() (cp.Snapshot, error) {
+	for _, op := range t.ops {
+		if err := t.store.guard.authorize("", AuditTxn, "path:"+op.Path); err != nil {
+			return cp.Snapshot{}, err
+		}
+	}
+	sp, err := t.commit()
+	if err != nil {
+		return sp, err
+	}
+	for _, op := range t.ops {
+		audit(sp, actorOf(t.store), AuditTxn, "path:"+op.Path)
+	}
+	return sp, nil
+}
+
+// commit is Commit for transactions of operations which were authorized
+// themselves.
+func (t *Txn) commit() (cp.Snapshot, error) {
+	if err := t.store.writable(); err != nil {
+		return cp.Snapshot{}, err
+	}
+	if t.err != nil {
+		return cp.Snapshot{}, t.err
+	}
+	sp, err := t.store.GetSnapshot().FastForward()
+	if err != nil {
+		return sp, err
+	}
+
+	for _, op := range t.ops {
+		op.Prev, _, err = sp.Get(op.Path)
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return sp, err
+		}
+		op.Existed = err == nil
+	}
+
+	id, err := t.store.nextID()
+	if err != nil {
+		return sp, err
+	}
+	r := &txnRecord{Time: time.Now().UTC(), Ops: t.ops}
+	record := cp.NewFile(txnPath(id), r, new(cp.JsonCodec), sp)
+	record, err = record.Save()
+	if err != nil {
+		return sp, err
+	}
+	sp = record.GetSnapshot()
+
+	for i, op := range t.ops {
+		sp, err = op.apply(sp)
+		if err != nil {
+			if rerr := rollbackTxn(sp, t.ops[:i]); rerr != nil {
+				return sp, errorf(ErrTxnIncomplete, "txn %d failed at %s: %s, rollback failed: %s", id, op.Path, err, rerr)
+			}
+			record.Del()
+			return sp, err
+		}
+	}
+
+	if err := record.Join(sp).Del(); err != nil {
+		return sp, err
+	}
+	return sp.FastForward()
+}
+
+// RecoverTxns rolls back the writes of all transactions started more than
+// olderThan ago which were left incomplete by failed rollbacks or crashed
+// clients and returns their number. olderThan should be well above the time
+// a Commit takes, so transactions in flight are not touched.
+func (s *Store) RecoverTxns(olderThan time.Duration) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return 0, err
+	}
+	ids, err := sp.Getdir(txnsPath)
+	if err != nil {
+		if cp.IsErrNoEnt(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	recovered := 0
+	for _, id := range ids {
+		r := &txnRecord{}
+		f, err := sp.GetFile(path.Join(txnsPath, id), &cp.JsonCodec{DecodedVal: r})
+		if err != nil {
+			if cp.IsErrNoEnt(err) {
+				// Committed concurrently.
+				continue
+			}
+			return recovered, err
+		}
+		if time.Since(r.Time) < olderThan {
+			continue
+		}
+		if err := rollbackTxn(sp, r.Ops); err != nil {
+			return recovered, err
+		}
+		if err := f.Del(); err != nil {
+			return recovered, err
+		}
+		recovered++
+	}
+	return recovered, nil
+}
+
+func (op *txnOp) apply(sp cp.Snapshot) (cp.Snapshot, error) {
+	if op.Del {
+		err := sp.Del(op.Path)
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return sp, err
+		}
+		return sp.FastForward()
+	}
+	return sp.Set(op.Path, op.Value)
+}
+
+// rollbackTxn restores the previous values of the given ops in reverse
+// order. Paths which were changed by someone else since are left alone.
+func rollbackTxn(sp cp.Snapshot, ops []*txnOp) error {
+	sp, err := sp.FastForward()
+	if err != nil {
+		return err
+	}
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+
+		cur, _, err := sp.Get(op.Path)
+		exists := err == nil
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return err
+		}
+		if op.Del && exists || !op.Del && (!exists || cur != op.Value) {
+			continue
+		}
+
+		if op.Existed {
+			sp, err = sp.Set(op.Path, op.Prev)
+		} else {
+			err = sp.Del(op.Path)
+			if cp.IsErrNoEnt(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func txnPath(id int64) string {
+	return path.Join(txnsPath, strconv.FormatInt(id, 10))
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"testing"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+func txnSetup(t *testing.T) *Store {
+	s, err := DialURI(DefaultURI, "/txn-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = s.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTxnCommit(t *testing.T) {
+	s := txnSetup(t)
+
+	sp, err := s.Txn().
+		Set("/txn/a", "1").
+		SetFile("/txn/b", 2, new(cp.IntCodec)).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, expected := range map[string]string{"/txn/a": "1", "/txn/b": "2"} {
+		v, _, err := sp.Get(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != expected {
+			t.Errorf("expected %s to be %q, got %q", p, expected, v)
+		}
+	}
+	if exists, _, _ := sp.Exists(txnsPath); exists {
+		if ids, _ := sp.Getdir(txnsPath); len(ids) != 0 {
+			t.Errorf("expected txn record to be removed, got %v", ids)
+		}
+	}
+
+	sp, err = s.Txn().Del("/txn/a").Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _, _ := sp.Exists("/txn/a"); exists {
+		t.Error("expected /txn/a to be removed")
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	s := txnSetup(t)
+
+	_, err := s.Txn().
+		Set("/txn/a", "1").
+		Set("/txn/bad path", "2").
+		Commit()
+	if err == nil {
+		t.Fatal("expected commit to fail")
+	}
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _, _ := sp.Exists("/txn/a"); exists {
+		t.Error("expected /txn/a to be rolled back")
+	}
+}
+
+func TestRecoverTxns(t *testing.T) {
+	s := txnSetup(t)
+
+	sp, err := s.GetSnapshot().Set("/txn/a", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a client crashing half-way through a commit.
+	r := &txnRecord{
+		Time: time.Now().Add(-time.Hour),
+		Ops: []*txnOp{
+			{Path: "/txn/a", Value: "new", Prev: "old", Existed: true},
+			{Path: "/txn/b", Value: "new"},
+		},
+	}
+	if _, err = cp.NewFile(txnPath(1), r, new(cp.JsonCodec), sp).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if sp, err = sp.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sp.Set("/txn/a", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.RecoverTxns(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 recovered txn, got %d", n)
+	}
+
+	if sp, err = sp.FastForward(); err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := sp.Get("/txn/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "old" {
+		t.Errorf("expected /txn/a to be restored to old, got %q", v)
+	}
+	if exists, _, _ := sp.Exists(txnPath(1)); exists {
+		t.Error("expected txn record to be removed")
+	}
+}
+
+// countingIDs hands out ids in order and counts them.
+type countingIDs struct {
+	n int64
+}
+
+func (c *countingIDs) NextID(s *Store) (int64, error) {
+	c.n++
+	return c.n, nil
+}
+
+func TestTxnCommitIDAllocator(t *testing.T) {
+	s := txnSetup(t)
+	ids := &countingIDs{}
+	s.SetIDAllocator(ids)
+
+	if _, err := s.Txn().Set("/txn/a", "1").Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ids.n != 1 {
+		t.Errorf("expected the txn id to be taken from the allocator, got %d ids", ids.n)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const usagePath = "usage"
+
+// Number of usage samples kept per instance, older ones are dropped.
+const maxUsageSamples = 60
+
+// Number of times ReportUsage tries to write a sample when concurrent
+// reports get in between.
+const maxUsageAttempts = 10
+
+// ResourceUsage is a sample of the resources used by an instance.
+type ResourceUsage struct {
+	// Resident memory in bytes.
+	RSS        uint64  `json:"rss"`
+	CPUSeconds float64 `json:"cpu-seconds"`
+	// Highest resident memory in bytes since the last restart.
+	PeakRSS uint64 `json:"peak-rss"`
+	// Set on reporting if zero.
+	Time time.Time `json:"time"`
+}
+
+// ReportUsage adds a usage sample of the instance. Only the last
+// maxUsageSamples samples are kept. Concurrent reports are retried up to
+// maxUsageAttempts times, after which the revision mismatch is returned.
+func (i *Instance) ReportUsage(u ResourceUsage) (_ *Instance, err error) {
+	//
+	//   instances/
+	//       6868/
+	//           ...
+	// -         usage = [{"rss":104857600,...}]
+	// +         usage = [{"rss":104857600,...},{"rss":125829120,...}]
+	//
+	defer i.annotate(&err, "report-usage")
+	if err := i.guard.authorize("", ActionUsage, i.auditName()); err != nil {
+		return nil, err
+	}
+	if u.Time.IsZero() {
+		u.Time = time.Now()
+	}
+	closed := storeFromSnapshotable(i).closed()
+	for attempt := 1; ; attempt++ {
+		sp, err := i.GetSnapshot().FastForward()
+		if err != nil {
+			return nil, err
+		}
+		samples := []ResourceUsage{}
+		f, err := sp.GetFile(i.dir.Prefix(usagePath), &cp.JsonCodec{DecodedVal: &samples})
+		if err != nil && !cp.IsErrNoEnt(err) {
+			return nil, err
+		}
+		samples = append(samples, u)
+		if len(samples) > maxUsageSamples {
+			samples = samples[len(samples)-maxUsageSamples:]
+		}
+		if f == nil {
+			f, err = cp.NewFile(i.dir.Prefix(usagePath), samples, new(cp.JsonCodec), sp).Save()
+		} else {
+			f, err = f.Set(samples)
+		}
+		if err == nil {
+			i.dir = i.dir.Join(f)
+			return i, nil
+		}
+		if !cp.IsErrRevMismatch(err) || attempt == maxUsageAttempts {
+			return nil, err
+		}
+		select {
+		case <-time.After(time.Second / 100):
+		case <-closed:
+			return nil, err
+		}
+	}
+}
+
+// GetUsage returns the usage samples of the instance, oldest first.
+func (i *Instance) GetUsage() ([]ResourceUsage, error) {
+	sp, err := i.GetSnapshot().FastForward()
+	if err != nil {
+		return nil, err
+	}
+	samples := []ResourceUsage{}
+	_, err = sp.GetFile(i.dir.Prefix(usagePath), &cp.JsonCodec{DecodedVal: &samples})
+	if err != nil && !cp.IsErrNoEnt(err) {
+		return nil, err
+	}
+	return samples, nil
+}
@@ -8,9 +8,9 @@ package visor
 import (
 	"fmt"
 	"net"
-	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	cp "github.com/soundcloud/cotterpin"
@@ -18,7 +18,7 @@ import (
 
 // SegenaVersion encodes the expected tree layout and MUST be increased
 // whenever breaking changes are introduced.
-const SchemaVersion = 7
+const SchemaVersion = 9
 
 // Defaults and paths
 const (
@@ -39,6 +39,161 @@ var Version string
 // Store is the representation of the coordinator tree.
 type Store struct {
 	snapshot cp.Snapshot
+	closer   *closer
+	reads    *readLimit
+	guard    *guard
+	pinned   bool
+}
+
+// closer is shared by all Stores derived from the same connection and
+// records whether it has been torn down. For dialed connections it also
+// keeps the address and root they were dialed with and the Stores of the
+// namespaces opened from them. It also holds the IDAllocator of the Stores.
+type closer struct {
+	once sync.Once
+	done chan struct{}
+	uri  string
+	root string
+
+	mu         sync.Mutex
+	namespaces map[string]*Store
+	ids        IDAllocator
+}
+
+func newCloser() *closer {
+	return &closer{done: make(chan struct{})}
+}
+
+// readLimit bounds the number of parallel reads listings fan out to. It is
+// shared by all Stores derived from the same connection and handed down to
+// the objects fetched through them.
+type readLimit struct {
+	mu  sync.RWMutex
+	max int
+}
+
+func (l *readLimit) get() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.max
+}
+
+func (l *readLimit) set(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = n
+}
+
+// closerOf returns the closer of the connection the object was obtained
+// through, nil if unknown.
+func closerOf(s cp.Snapshotable) *closer {
+	switch v := s.(type) {
+	case *Store:
+		return v.closer
+	case *App:
+		if v != nil {
+			return v.closer
+		}
+	case *Proc:
+		if v.closer != nil {
+			return v.closer
+		}
+		return closerOf(v.App)
+	case *Revision:
+		return closerOf(v.App)
+	case *Tag:
+		return closerOf(v.App)
+	case *Hook:
+		return closerOf(v.App)
+	case *Env:
+		return closerOf(v.App)
+	case *Instance:
+		return v.closer
+	case *Runner:
+		return v.closer
+	case *Service:
+		return v.closer
+	}
+	return nil
+}
+
+func readLimitOf(s cp.Snapshotable) *readLimit {
+	switch v := s.(type) {
+	case *Store:
+		return v.reads
+	case *App:
+		if v != nil {
+			return v.reads
+		}
+	case *Proc:
+		if v.reads != nil {
+			return v.reads
+		}
+		return readLimitOf(v.App)
+	case *Revision:
+		return readLimitOf(v.App)
+	case *Tag:
+		return readLimitOf(v.App)
+	case *Hook:
+		return readLimitOf(v.App)
+	case *Env:
+		return readLimitOf(v.App)
+	case *Instance:
+		return v.reads
+	case *Runner:
+		return v.reads
+	case *Service:
+		return v.reads
+	}
+	return nil
+}
+
+// getSnapshotables behaves like cp.GetSnapshotables but runs at most the
+// configured number of fetches in parallel.
+func getSnapshotables(l *readLimit, names []string, fn func(string) (cp.Snapshotable, error)) (chan cp.Snapshotable, chan error) {
+	n := l.get()
+	if n <= 0 || n >= len(names) {
+		return cp.GetSnapshotables(names, fn)
+	}
+
+	// Buffered so workers finish even if the caller bails out early.
+	ch := make(chan cp.Snapshotable, len(names))
+	errch := make(chan error, len(names))
+	work := make(chan string, len(names))
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			for name := range work {
+				s, err := fn(name)
+				if err != nil {
+					errch <- err
+					continue
+				}
+				ch <- s
+			}
+		}()
+	}
+	return ch, errch
+}
+
+// SetMaxConcurrentReads limits the number of parallel reads issued by
+// listings like GetApps, GetProcs or GetInstances. The limit applies to all
+// Stores derived from the same connection and the objects obtained through
+// them. Zero or a negative value removes the limit.
+func (s *Store) SetMaxConcurrentReads(n int) {
+	s.reads.set(n)
+}
+
+// MaxConcurrentReads returns the limit set by SetMaxConcurrentReads.
+func (s *Store) MaxConcurrentReads() int {
+	return s.reads.get()
 }
 
 // DialURI sets up a new Store.
@@ -47,7 +202,36 @@ func DialURI(uri, root string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{sp}, nil
+	c := newCloser()
+	c.uri, c.root = uri, root
+	return &Store{snapshot: sp, closer: c, reads: &readLimit{}, guard: newGuard()}, nil
+}
+
+// Close tears down the Store. Outstanding waits are cancelled, the
+// coordinator connection is closed and all watchers started from this Store
+// or any Store derived from it close their channels and return. It is safe to
+// call Close multiple times.
+func (s *Store) Close() error {
+	s.closer.once.Do(func() {
+		close(s.closer.done)
+		s.GetSnapshot().Close()
+	})
+	return nil
+}
+
+// IsClosed reports whether Close has been called on the Store.
+func (s *Store) IsClosed() bool {
+	select {
+	case <-s.closer.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// closed returns a channel which is closed once the Store is closed.
+func (s *Store) closed() <-chan struct{} {
+	return s.closer.done
 }
 
 // GetSnapshot satisfies the cp.Snapshotable interface.
@@ -55,17 +239,78 @@ func (s *Store) GetSnapshot() cp.Snapshot {
 	return s.snapshot
 }
 
+// At returns a read-only Store pinned to the given coordinator revision.
+// Queries on the returned Store like GetApps or GetInstances answer with the
+// state of the tree at rev, mutating operations on it fail with
+// ErrReadOnly. Objects obtained from it carry the historical snapshot, while
+// their own operations fast-forward as usual; Store operations reached
+// through them stay read-only. Use FastForward to get back to the latest
+// revision.
+func (s *Store) At(rev int64) *Store {
+	sp := s.GetSnapshot()
+	sp.Rev = rev
+	g := *s.guard
+	g.pinned = true
+	return &Store{snapshot: sp, closer: s.closer, reads: s.reads, guard: &g, pinned: true}
+}
+
+// As returns a Store whose mutating operations, and those of the objects
+// obtained through it, are performed on behalf of the given actor. The actor
+// is passed to the Authorizer, written to the audit log and recorded with
+// registrations and stored attrs.
+func (s *Store) As(actor string) *Store {
+	ns := s.join(s)
+	ns.guard = &guard{authz: s.guard.authz, actor: actor, pinned: s.pinned}
+	return ns
+}
+
+// Actor returns the actor set with As, empty if none.
+func (s *Store) Actor() string {
+	return s.guard.actor
+}
+
+// IsReadOnly reports whether the Store is pinned to a revision by At.
+func (s *Store) IsReadOnly() bool {
+	return s.pinned
+}
+
+// latest returns the snapshot queries should be answered from, which is the
+// latest revision unless the Store is pinned.
+func (s *Store) latest() (cp.Snapshot, error) {
+	if s.pinned {
+		return s.GetSnapshot(), nil
+	}
+	return s.GetSnapshot().FastForward()
+}
+
+// writable guards mutating operations against pinned Stores.
+func (s *Store) writable() error {
+	if s.pinned {
+		return errorf(ErrReadOnly, "store is pinned to rev %d", s.GetSnapshot().Rev)
+	}
+	return nil
+}
+
 // FastForward advances the store to the lastet revision.
 func (s *Store) FastForward() (*Store, error) {
 	sp, err := s.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
 	}
-	return &Store{sp}, nil
+	ns := s.join(sp)
+	if s.pinned {
+		g := *s.guard
+		g.pinned = false
+		ns.guard, ns.pinned = &g, false
+	}
+	return ns, nil
 }
 
 // Init sets up expected paths.
 func (s *Store) Init() (*Store, error) {
+	if err := s.writable(); err != nil {
+		return nil, err
+	}
 	sp, err := s.GetSnapshot().FastForward()
 	if err != nil {
 		return nil, err
@@ -83,6 +328,11 @@ func (s *Store) Init() (*Store, error) {
 		}
 	}
 
+	sp, err = indexInstanceStatuses(sp)
+	if err != nil {
+		return nil, err
+	}
+
 	v, err := cp.VerifySchema(SchemaVersion, sp)
 	if cp.IsErrNoEnt(err) {
 		sp, err = cp.SetSchemaVersion(SchemaVersion, sp)
@@ -103,41 +353,23 @@ func (s *Store) Init() (*Store, error) {
 
 // GetLoggers gets the list of bazooka-log services endpoints.
 func (s *Store) GetLoggers() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
-	if err != nil {
-		return nil, err
-	}
-	names, err := sp.Getdir(loggerDir)
-	if err != nil {
-		return nil, err
-	}
-	for i, name := range names {
-		names[i] = strings.Replace(name, "-", ":", 1)
-	}
-	return names, nil
+	return s.getServiceAddrs(ServiceLogger)
 }
 
-// GetProxies gets the list of bazooka-proxy service IPs
+// GetProxies gets the list of bazooka-proxy service IPs, including draining
+// ones.
 func (s *Store) GetProxies() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
-	if err != nil {
-		return nil, err
-	}
-	return sp.Getdir(proxyDir)
+	return s.getServiceAddrs(ServiceProxy)
 }
 
 // GetPms gets the list of bazooka-pm service IPs
 func (s *Store) GetPms() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
-	if err != nil {
-		return nil, err
-	}
-	return sp.Getdir(pmDir)
+	return s.getServiceAddrs(ServicePm)
 }
 
 // GetAppNames returns names of all registered apps.
 func (s *Store) GetAppNames() ([]string, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return nil, err
 	}
@@ -146,55 +378,43 @@ func (s *Store) GetAppNames() ([]string, error) {
 
 // RegisterLogger given an address and a version stores the Logger.
 func (s *Store) RegisterLogger(addr, version string) (*Store, error) {
-	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
-		return nil, err
-	}
-	sp, err := s.GetSnapshot().Set(path.Join(loggerDir, host+"-"+port), timestamp()+" "+version)
-	if err != nil {
-		return nil, err
-	}
-	s.snapshot = sp
-	return s, nil
+	return s.RegisterLoggerInfo(addr, LoggerInfo{Version: version})
 }
 
 // UnregisterLogger removes the logger for the given address from the store.
 func (s *Store) UnregisterLogger(addr string) error {
-	host, port, err := net.SplitHostPort(addr)
-	if err != nil {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
 		return err
 	}
-	return s.GetSnapshot().Del(path.Join(loggerDir, host+"-"+port))
+	return s.UnregisterService(ServiceLogger, addr)
 }
 
 // RegisterPm stores the pm for the given host.
 func (s *Store) RegisterPm(host, version string) (*Store, error) {
-	sp, err := s.GetSnapshot().Set(path.Join(pmDir, host), timestamp()+" "+version)
-	if err != nil {
-		return nil, err
-	}
-	s.snapshot = sp
-	return s, nil
+	return s.registerService(ServicePm, host, map[string]string{"version": version})
 }
 
 // UnregisterPm removes the pm for the given host.
 func (s *Store) UnregisterPm(host string) error {
-	return s.GetSnapshot().Del(path.Join(pmDir, host))
+	return s.UnregisterService(ServicePm, host)
 }
 
 // RegisterProxy stores the proxy for the given host.
 func (s *Store) RegisterProxy(host string) (*Store, error) {
-	sp, err := s.GetSnapshot().Set(path.Join(proxyDir, host), timestamp())
+	p, err := s.RegisterProxyInfo(Proxy{Host: host})
 	if err != nil {
 		return nil, err
 	}
-	s.snapshot = sp
+	s.snapshot = p.GetSnapshot()
 	return s, nil
 }
 
 // SetSchemaVersion is used to update the store schema which is used for
 // validation.
 func (s *Store) SetSchemaVersion(version int) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
 	sp, err := s.GetSnapshot().FastForward()
 	if err != nil {
 		return err
@@ -208,7 +428,7 @@ func (s *Store) SetSchemaVersion(version int) error {
 
 // VerifySchema will error if there is a schema missmatch.
 func (s *Store) VerifySchema() (int, error) {
-	sp, err := s.GetSnapshot().FastForward()
+	sp, err := s.latest()
 	if err != nil {
 		return -1, err
 	}
@@ -224,21 +444,81 @@ func (s *Store) VerifySchema() (int, error) {
 
 // UnregisterProxy removes the proxy for the given host from the store.
 func (s *Store) UnregisterProxy(host string) error {
-	return s.GetSnapshot().Del(path.Join(proxyDir, host))
+	return s.UnregisterService(ServiceProxy, host)
+}
+
+func (s *Store) registerService(kind, addr string, meta interface{}) (*Store, error) {
+	svc, err := s.RegisterService(kind, addr, meta, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshot = svc.GetSnapshot()
+	return s, nil
+}
+
+func (s *Store) getServiceAddrs(kind string) ([]string, error) {
+	services, err := s.GetServices(kind)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(services))
+	for i, svc := range services {
+		addrs[i] = svc.Addr
+	}
+	return addrs, nil
 }
 
 func (s *Store) reset() error {
 	return s.GetSnapshot().Reset()
 }
 
+// join returns a copy of the Store at the given snapshot which shares the
+// connection state and pinning of s.
+func (s *Store) join(sp cp.Snapshotable) *Store {
+	return &Store{snapshot: sp.GetSnapshot(), closer: s.closer, reads: s.reads, guard: s.guard, pinned: s.pinned}
+}
+
 func storeFromSnapshotable(sp cp.Snapshotable) *Store {
-	return &Store{sp.GetSnapshot()}
+	if s, ok := sp.(*Store); ok {
+		return s.join(s)
+	}
+	reads := readLimitOf(sp)
+	if reads == nil {
+		reads = &readLimit{}
+	}
+	g := guardOf(sp)
+	if g == nil {
+		g = newGuard()
+	}
+	c := closerOf(sp)
+	if c == nil {
+		c = newCloser()
+	}
+	return &Store{snapshot: sp.GetSnapshot(), closer: c, reads: reads, guard: g, pinned: g.pinned}
 }
 
 func formatTime(t time.Time) string {
 	return t.Format(time.RFC3339)
 }
 
+// formatRegistered encodes the time of a registration followed by the actor
+// which performed it, if known.
+func formatRegistered(t time.Time, actor string) string {
+	if actor == "" {
+		return formatTime(t)
+	}
+	return formatTime(t) + " " + actor
+}
+
+func parseRegistered(val string) (time.Time, string, error) {
+	parts := strings.SplitN(val, " ", 2)
+	t, err := parseTime(parts[0])
+	if err != nil || len(parts) == 1 {
+		return t, "", err
+	}
+	return t, parts[1], nil
+}
+
 func timestamp() string {
 	return time.Now().UTC().Format(time.RFC3339)
 }
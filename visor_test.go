@@ -0,0 +1,138 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+func TestGetSnapshotablesBounded(t *testing.T) {
+	var (
+		l        = &readLimit{}
+		mu       sync.Mutex
+		inflight = 0
+		peak     = 0
+		names    = []string{}
+	)
+	l.set(3)
+
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("app%d", i))
+	}
+
+	ch, errch := getSnapshotables(l, names, func(name string) (cp.Snapshotable, error) {
+		mu.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		return &App{Name: name}, nil
+	})
+
+	seen := map[string]bool{}
+	for i := 0; i < len(names); i++ {
+		select {
+		case r := <-ch:
+			seen[r.(*App).Name] = true
+		case err := <-errch:
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != len(names) {
+		t.Errorf("expected %d results, got %d", len(names), len(seen))
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 concurrent reads, got %d", peak)
+	}
+}
+
+func TestSetMaxConcurrentReads(t *testing.T) {
+	s, app := appSetup("max-reads-app")
+	s.SetMaxConcurrentReads(2)
+
+	app, err := app.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"web", "worker", "cron"} {
+		if _, err := s.NewProc(app, name).Register(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apps, err := s.GetApps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 {
+		t.Fatalf("expected one app, got %v", apps)
+	}
+	if n := apps[0].reads.get(); n != 2 {
+		t.Errorf("expected app to inherit read limit 2, got %d", n)
+	}
+	procs, err := apps[0].GetProcs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(procs) != 3 {
+		t.Errorf("expected 3 procs, got %v", procs)
+	}
+}
+
+func TestStoreFromSnapshotableCloser(t *testing.T) {
+	s := &Store{closer: newCloser(), reads: &readLimit{}, guard: newGuard()}
+	s.SetIDAllocator(CoordinatorIDs{})
+
+	app := s.NewApp("closer-cat", "git://closer.git", "master")
+	for _, sp := range []cp.Snapshotable{
+		app,
+		s.NewProc(app, "web"),
+		s.NewRevision(app, "128af9", "closer.img"),
+		&Tag{App: app},
+		&Hook{App: app},
+		&Env{App: app},
+		&Instance{closer: s.closer, reads: s.reads},
+		s.NewRunner("10.0.0.1:5000", 1),
+	} {
+		if closerOf(sp) != s.closer {
+			t.Errorf("expected %T to carry the closer of its Store", sp)
+		}
+		if readLimitOf(sp) != s.reads {
+			t.Errorf("expected %T to carry the read limit of its Store", sp)
+		}
+	}
+
+	if _, ok := storeFromSnapshotable(app).closer.ids.(CoordinatorIDs); !ok {
+		t.Error("expected Store derived from app to keep the IDAllocator")
+	}
+}
+
+func TestStoreFromSnapshotablePinned(t *testing.T) {
+	s := &Store{closer: newCloser(), reads: &readLimit{}, guard: newGuard()}
+	pinned := s.At(5)
+
+	app := pinned.NewApp("pinned-cat", "git://pinned.git", "master")
+	for _, d := range []*Store{pinned.join(pinned), pinned.As("alice"), storeFromSnapshotable(app)} {
+		if !d.IsReadOnly() {
+			t.Error("expected Store derived from a pinned one to be read-only")
+		}
+	}
+	if storeFromSnapshotable(s.NewApp("cat", "git://pinned.git", "master")).IsReadOnly() {
+		t.Error("expected Store derived from an unpinned one to be writable")
+	}
+}
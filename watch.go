@@ -0,0 +1,523 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+// InstanceEvent is a change in the lifecycle of an instance of a proc, as
+// seen by the lookup entries of the proc.
+type InstanceEvent struct {
+	// One of EvInsReg, EvInsExit, EvInsFail, EvInsLost and EvInsUnreg.
+	Type EventType
+	ID   int64
+	Rev  int64
+	// The registered instance for EvInsReg and EvInsExit, the serialised one
+	// otherwise.
+	Instance *Instance
+}
+
+var (
+	reRunningLookup  = regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/instances/(" + charPat + "+)/([0-9]+)$")
+	reTerminalLookup = regexp.MustCompile("^/apps/(" + charPat + "+)/procs/(" + charPat + "+)/(done|failed|lost)/([0-9]+)$")
+)
+
+// WatchInstances sends an event for every instance of the proc which is
+// registered, exits, fails, is lost or unregistered. Only the lookup entries
+// of the proc are watched, so other procs don't wake it up. The channel is
+// closed when WatchInstances returns, which it does with nil once ctx is
+// done.
+func (p *Proc) WatchInstances(ctx context.Context, ch chan *InstanceEvent) error {
+	defer close(ch)
+
+	sp := p.GetSnapshot()
+	for {
+		ev, err := waitContext(ctx, sp, p.dir.Prefix(globPlural))
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		sp = sp.Join(ev)
+
+		e, err := newInstanceEvent(ev)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			continue
+		}
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// newInstanceEvent translates a change of a lookup entry into an
+// InstanceEvent. It is nil for all other changes, for rewrites of a running
+// entry when the readiness changes and for the removal of a running entry
+// which was replaced by a terminal one.
+func newInstanceEvent(ev cp.Event) (*InstanceEvent, error) {
+	var (
+		match  []string
+		status InsStatus
+	)
+	if match = reRunningLookup.FindStringSubmatch(ev.Path); match != nil {
+		status = InsStatusRunning
+	} else if match = reTerminalLookup.FindStringSubmatch(ev.Path); match != nil {
+		status = terminalLookupStatus(match[3])
+	} else {
+		return nil, nil
+	}
+	id, err := parseInstanceID(match[4])
+	if err != nil {
+		return nil, err
+	}
+	e := &InstanceEvent{ID: id, Rev: ev.Rev}
+
+	switch {
+	case status == InsStatusRunning && ev.IsSet():
+		prev := ev.GetSnapshot()
+		prev.Rev = ev.Rev - 1
+		exists, _, err := prev.Exists(ev.Path)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			// The readiness of a registered instance changed.
+			return nil, nil
+		}
+		lookup, err := parseLookup(string(ev.Body))
+		if err != nil {
+			return nil, err
+		}
+		// The rest of the registration may not be written yet.
+		obj, err := getObject(id, ev.GetSnapshot())
+		if err != nil {
+			return nil, err
+		}
+		e.Type = EvInsReg
+		e.Instance = &Instance{
+			ID:             id,
+			AppName:        obj.App,
+			RevisionName:   obj.Rev,
+			ProcessName:    obj.Proc,
+			Env:            obj.Env,
+			HostConstraint: obj.HostConstraint,
+			Priority:       obj.Priority,
+			Registered:     lookup.Registered,
+			Status:         InsStatusPending,
+			dir:            cp.NewDir(instancePath(id), ev.GetSnapshot()),
+		}
+	case status == InsStatusRunning && ev.IsDel():
+		terminated, err := isTerminated(match[1], match[2], id, ev.GetSnapshot())
+		if err != nil || terminated {
+			return nil, err
+		}
+		ins, err := getInstance(id, ev.GetSnapshot())
+		if IsErrNotFound(err) {
+			// Removed by a repair of the lookups.
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.Type = EvInsExit
+		e.Instance = ins
+	case ev.IsSet():
+		ins := &Instance{ID: id, dir: cp.NewDir(instancePath(id), ev.GetSnapshot())}
+		if _, err := decodeCodec(ins).Decode(ev.Body); err != nil {
+			return nil, err
+		}
+		switch status {
+		case InsStatusDone:
+			e.Type = EvInsUnreg
+		case InsStatusFailed:
+			e.Type = EvInsFail
+		case InsStatusLost:
+			e.Type = EvInsLost
+		}
+		e.Instance = ins
+	default:
+		return nil, nil
+	}
+	return e, nil
+}
+
+func terminalLookupStatus(dir string) InsStatus {
+	switch dir {
+	case failedPath:
+		return InsStatusFailed
+	case lostPath:
+		return InsStatusLost
+	default:
+		return InsStatusDone
+	}
+}
+
+// isTerminated tells whether the instance has a terminal lookup entry.
+func isTerminated(app, proc string, id int64, sp cp.Snapshot) (bool, error) {
+	for _, dir := range []string{donePath, failedPath, lostPath} {
+		exists, _, err := sp.Exists(path.Join(appsPath, app, procsPath, proc, dir, strconv.FormatInt(id, 10)))
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// waitContext waits for the next change below glob like Snapshot.Wait, but
+// returns once ctx is done. The wait itself can't be cancelled and finishes
+// in the background with the next change or when the connection is closed.
+func waitContext(ctx context.Context, sp cp.Snapshot, glob string) (cp.Event, error) {
+	type result struct {
+		ev  cp.Event
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ev, err := sp.Wait(glob)
+		done <- result{ev, err}
+	}()
+	select {
+	case r := <-done:
+		return r.ev, r.err
+	case <-ctx.Done():
+		return cp.Event{}, nil
+	}
+}
+
+// WatchEventRaw is WatchEvent without enrichment. The events only carry
+// their type, path and revision, no Source or Actor, which saves reading
+// the changed objects from the coordinator.
+func (s *Store) WatchEventRaw(listener chan *Event, filter ...EventType) error {
+	defer close(listener)
+
+	return s.watchEvents(context.Background(), WatchOptions{Filter: filter, NoEnrich: true}, listener, nil)
+}
+
+// EventScope narrows watched events down to a part of the tree. The zero
+// value is the whole tree.
+type EventScope struct {
+	App string
+	// Requires App.
+	Proc     string
+	Instance int64
+}
+
+var reScopeName = regexp.MustCompile("^" + charPat + "+$")
+
+// glob returns the pattern of the paths in the scope.
+func (sc EventScope) glob() (string, error) {
+	switch {
+	case sc.Instance != 0:
+		if sc.App != "" || sc.Proc != "" {
+			return "", errorf(ErrInvalidArgument, "instance scope can't be combined with app or proc")
+		}
+		return path.Join("/", instancePath(sc.Instance), "*"), nil
+	case sc.Proc != "":
+		if !reScopeName.MatchString(sc.App) || !reScopeName.MatchString(sc.Proc) {
+			return "", errorf(ErrInvalidArgument, "invalid proc scope %s:%s", sc.App, sc.Proc)
+		}
+		return path.Join("/", appsPath, sc.App, procsPath, sc.Proc, globPlural), nil
+	case sc.App != "":
+		if !reScopeName.MatchString(sc.App) {
+			return "", errorf(ErrInvalidArgument, "invalid app scope %s", sc.App)
+		}
+		return path.Join("/", appsPath, sc.App, globPlural), nil
+	}
+	return globPlural, nil
+}
+
+// WatchScoped is WatchEvent for the events in the scope. Only changes in
+// the scope are waited for, so consumers aren't woken up by the rest of the
+// tree. Within an app or proc scope instance events are derived from the
+// lookups of its procs, which only record registration, exit, failure, loss
+// and unregistration.
+func (s *Store) WatchScoped(scope EventScope, listener chan *Event, filter ...EventType) error {
+	defer close(listener)
+
+	return s.watchEvents(context.Background(), WatchOptions{Scope: scope, Filter: filter}, listener, nil)
+}
+
+// watchEvents sends the events described by opts to the listener until ctx
+// is done or the Store is closed. If a Watcher is given its rev is kept up to
+// date and failed waits are recovered from with an EvResyncNeeded event.
+func (s *Store) watchEvents(ctx context.Context, opts WatchOptions, listener chan *Event, w *Watcher) error {
+	scope, filter := opts.Scope, opts.Filter
+	glob, err := scope.glob()
+	if err != nil {
+		return err
+	}
+	if opts.glob != "" {
+		glob = opts.glob
+	}
+	// Proc and instance scopes don't see the changes of apps.
+	var cache *sourceCache
+	if scope.Proc == "" && scope.Instance == 0 && !opts.NoEnrich {
+		cache = newSourceCache()
+	}
+	pipeline := s.newEnrichPipeline(ctx, listener, cache, w)
+	pipeline.noEnrich = opts.NoEnrich
+	if opts.app != "" {
+		pipeline.accept = func(e *Event) bool {
+			if e.Type == EvResyncNeeded {
+				return true
+			}
+			return e.appName() == opts.app && (opts.rev == "" || e.revisionName() == opts.rev)
+		}
+	}
+	defer pipeline.close()
+
+	sp := s.GetSnapshot()
+	if opts.Initial {
+		events, err := s.initialEvents(scope, sp)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if event.match(filter) && !pipeline.add(event) {
+				return nil
+			}
+		}
+	}
+	failed := false
+	for {
+		if w != nil {
+			atomic.StoreInt64(&w.rev, sp.Rev)
+		}
+		ev, err := waitContext(ctx, sp, glob)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			// Give up if waiting fails again right after a resync.
+			if w == nil || failed {
+				return err
+			}
+			failed = true
+			// Changes since sp may have been missed, continue from the
+			// latest revision and let the consumer relist.
+			if sp, err = sp.FastForward(); err != nil {
+				return err
+			}
+			if cache != nil {
+				cache.reset(sp.Rev)
+			}
+			if !pipeline.add(&Event{Type: EvResyncNeeded, Rev: sp.Rev}) {
+				return nil
+			}
+			continue
+		}
+		failed = false
+		sp = sp.Join(ev)
+		if cache != nil {
+			cache.observe(ev)
+		}
+
+		event, err := scope.newEvent(ev)
+		if err != nil {
+			return err
+		}
+		if event == nil || !event.match(filter) {
+			continue
+		}
+		// Instance events are only known to belong to the app once enriched.
+		if opts.app != "" && event.Path.Instance == nil {
+			if event.appName() != opts.app || (opts.rev != "" && event.revisionName() != opts.rev) {
+				continue
+			}
+		}
+		if !pipeline.add(event) {
+			return nil
+		}
+	}
+}
+
+// appName returns the name of the app the event belongs to, empty if it
+// isn't known.
+func (e *Event) appName() string {
+	if e.Path.App != nil {
+		return *e.Path.App
+	}
+	switch src := e.Source.(type) {
+	case *Instance:
+		return src.AppName
+	case *InstanceRestart:
+		return src.AppName
+	}
+	return ""
+}
+
+// revisionName returns the name of the revision the event belongs to, empty
+// if it isn't known.
+func (e *Event) revisionName() string {
+	if e.Path.Revision != nil {
+		return *e.Path.Revision
+	}
+	switch src := e.Source.(type) {
+	case *Instance:
+		return src.RevisionName
+	case *InstanceRestart:
+		return src.RevisionName
+	}
+	return ""
+}
+
+// newEvent translates a change in the scope into an Event, nil if it has
+// no meaning in the scope.
+func (sc EventScope) newEvent(ev cp.Event) (*Event, error) {
+	if sc.App == "" {
+		return newEvent(ev)
+	}
+	ie, err := newInstanceEvent(ev)
+	if err != nil {
+		return nil, err
+	}
+	if ie != nil {
+		return ie.event(ev), nil
+	}
+	if reRunningLookup.MatchString(ev.Path) || reTerminalLookup.MatchString(ev.Path) {
+		return nil, nil
+	}
+	return newEvent(ev)
+}
+
+// event returns the InstanceEvent as Event with the instance as source.
+func (e *InstanceEvent) event(raw cp.Event) *Event {
+	id := strconv.FormatInt(e.ID, 10)
+	ins := e.Instance
+	return &Event{
+		Type: e.Type,
+		Path: EventData{
+			App:      &ins.AppName,
+			Proc:     &ins.ProcessName,
+			Revision: &ins.RevisionName,
+			Instance: &id,
+		},
+		Rev:    e.Rev,
+		Source: ins,
+		Actor:  ins.RegisteredBy,
+		raw:    raw,
+	}
+}
+
+// initialEvents returns registration events for the apps, revisions, procs
+// and instances in the scope at sp, as if they had just been registered.
+func (s *Store) initialEvents(scope EventScope, sp cp.Snapshot) ([]*Event, error) {
+	events := []*Event{}
+	if scope.Instance != 0 {
+		ins, err := getInstance(scope.Instance, s.join(sp))
+		if IsErrNotFound(err) {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(events, instanceRegEvent(ins, sp.Rev)), nil
+	}
+
+	var names []string
+	if scope.App != "" {
+		names = []string{scope.App}
+	} else {
+		var err error
+		if names, err = getdirOrEmpty(sp, appsPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		app, err := getApp(name, s.join(sp))
+		if IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		procs := []*Proc{}
+		if scope.Proc != "" {
+			proc, err := getProc(app, scope.Proc, sp)
+			if IsErrNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			procs = append(procs, proc)
+		} else {
+			events = append(events, &Event{
+				Type:   EvAppReg,
+				Path:   EventData{App: &app.Name},
+				Rev:    sp.Rev,
+				Source: app,
+				Actor:  app.RegisteredBy,
+			})
+			revs, err := getRevisions(app, sp)
+			if err != nil && !cp.IsErrNoEnt(err) {
+				return nil, err
+			}
+			for _, rev := range revs {
+				events = append(events, &Event{
+					Type:   EvRevReg,
+					Path:   EventData{App: &app.Name, Revision: &rev.Ref},
+					Rev:    sp.Rev,
+					Source: rev,
+					Actor:  rev.RegisteredBy,
+				})
+			}
+			if procs, err = getProcs(app, sp); err != nil {
+				return nil, err
+			}
+		}
+		for _, proc := range procs {
+			events = append(events, &Event{
+				Type:   EvProcReg,
+				Path:   EventData{App: &app.Name, Proc: &proc.Name},
+				Rev:    sp.Rev,
+				Source: proc,
+				Actor:  proc.RegisteredBy,
+			})
+			ids, err := getProcInstanceIds(proc, sp)
+			if err != nil && !cp.IsErrNoEnt(err) {
+				return nil, err
+			}
+			for _, id := range ids {
+				ins, err := getInstance(id, s.join(sp))
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, instanceRegEvent(ins, sp.Rev))
+			}
+		}
+	}
+	return events, nil
+}
+
+func instanceRegEvent(ins *Instance, rev int64) *Event {
+	id := ins.idString()
+	return &Event{
+		Type: EvInsReg,
+		Path: EventData{
+			App:      &ins.AppName,
+			Proc:     &ins.ProcessName,
+			Revision: &ins.RevisionName,
+			Instance: &id,
+		},
+		Rev:    rev,
+		Source: ins,
+		Actor:  ins.RegisteredBy,
+	}
+}
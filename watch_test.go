@@ -0,0 +1,137 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func expectInstanceEvent(etype EventType, id int64, ch chan *InstanceEvent, t *testing.T) *InstanceEvent {
+	select {
+	case e := <-ch:
+		if e.Type != etype || e.ID != id {
+			t.Errorf("expected %s of instance %d, got %s of %d", etype, id, e.Type, e.ID)
+		}
+		if e.Instance == nil || e.Instance.ID != id {
+			t.Errorf("expected instance %d with the event, got %#v", id, e.Instance)
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("expected %s of instance %d, got timeout", etype, id)
+	}
+	return nil
+}
+
+func TestProcWatchInstances(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "watchcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.NewProc(app, "worker").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan *InstanceEvent)
+	errch := make(chan error, 1)
+	go func() { errch <- proc.WatchInstances(ctx, ch) }()
+
+	if _, err := s.RegisterInstance(app.Name, "128af9", other.Name, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := expectInstanceEvent(EvInsReg, ins.ID, ch, t)
+	if e.Instance.ProcessName != proc.Name || e.Instance.RevisionName != "128af9" {
+		t.Errorf("expected instance of %s, got %#v", proc.Name, e.Instance)
+	}
+
+	// Readiness rewrites the lookup entry, which is no registration.
+	if ins, err = ins.Claim("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Started("10.0.0.1", "box1.watchcat.com", 9999, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if ins, err = ins.Ready(); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err = ins.Failed("10.0.0.1", errors.New("no reason"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectInstanceEvent(EvInsFail, ins.ID, ch, t)
+
+	if err := ins.Unregister("watch-test", errors.New("done here")); err != nil {
+		t.Fatal(err)
+	}
+	expectInstanceEvent(EvInsUnreg, ins.ID, ch, t)
+
+	cancel()
+	select {
+	case err := <-errch:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WatchInstances to return once cancelled")
+	}
+	if _, ok := <-ch; ok {
+		t.Error("expected channel to be closed")
+	}
+}
+
+func TestWatchScoped(t *testing.T) {
+	s, l := eventSetup()
+	app, err := eventAppSetup(s, "scopedcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := eventAppSetup(s, "scopeddog").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(other)
+
+	if err := s.WatchScoped(EventScope{Proc: "web"}, make(chan *Event)); !IsErrInvalidArgument(err) {
+		t.Errorf("expected invalid argument error for proc without app, got %v", err)
+	}
+
+	go s.WatchScoped(EventScope{App: app.Name}, l)
+
+	if _, err := s.NewProc(other, "web").Register(); err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := expectEvent(EvProcReg, proc, l, t)
+	if *ev.Path.App != app.Name {
+		t.Errorf("expected event of %s, got %s", app.Name, *ev.Path.App)
+	}
+
+	ins, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = expectEvent(EvInsReg, ins, l, t)
+	if ev.Path.Instance == nil || *ev.Path.Instance != ins.idString() || *ev.Path.Proc != proc.Name {
+		t.Errorf("expected path of instance %d, got %s", ins.ID, ev.Path)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// WatchOptions configure a Watcher.
+type WatchOptions struct {
+	Scope EventScope
+	// Event types to deliver, all if empty.
+	Filter []EventType
+	// Events of the same type and object within the window are merged into
+	// the latest of them, which is delivered at the end of the window. Every
+	// event is delivered as it happens if zero.
+	Coalesce time.Duration
+	// Deliver registration events for the objects in the scope which exist
+	// when the Watcher starts, before any changes. Instances are only
+	// included if they are registered with a proc.
+	Initial bool
+	// Deliver events without Source and Actor, see WatchEventRaw.
+	NoEnrich bool
+
+	// Only deliver events of the app, including those of its instances
+	// outside of the app tree.
+	app string
+	// Only deliver events of the revision of app, see app.
+	rev string
+	// Watch glob instead of the one of Scope.
+	glob string
+}
+
+// Watcher delivers the events of a subscription until it is stopped. If
+// waiting for changes fails, e.g. because the connection was re-established
+// or the history was pruned, changes may have been missed. The Watcher then
+// continues from the latest revision and delivers an EvResyncNeeded event
+// first, after which consumers should relist whatever they cache.
+type Watcher struct {
+	rev        int64 // accessed atomically
+	unenriched int64 // accessed atomically
+	events     chan *Event
+	cancel     context.CancelFunc
+	done       chan struct{}
+	err        error
+}
+
+// Watch starts a Watcher for the events described by opts. It stops once
+// ctx is done, Stop is called or the Store is closed.
+func (s *Store) Watch(ctx context.Context, opts WatchOptions) (*Watcher, error) {
+	if _, err := opts.Scope.glob(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		events: make(chan *Event),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		defer close(w.events)
+		if opts.Coalesce <= 0 {
+			w.err = s.watchEvents(ctx, opts, w.events, w)
+			return
+		}
+		raw := make(chan *Event)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(raw)
+			errc <- s.watchEvents(ctx, opts, raw, w)
+		}()
+		coalesceEvents(ctx, raw, w.events, opts.Coalesce)
+		cancel()
+		w.err = <-errc
+	}()
+	return w, nil
+}
+
+// Events returns the channel the events are delivered on. It is closed once
+// the Watcher stopped.
+func (w *Watcher) Events() <-chan *Event {
+	return w.events
+}
+
+// Rev returns the revision the Watcher has seen all changes up to.
+func (w *Watcher) Rev() int64 {
+	return atomic.LoadInt64(&w.rev)
+}
+
+// Unenriched returns the number of events delivered without source because
+// it couldn't be read, see Event.EnrichErr.
+func (w *Watcher) Unenriched() int64 {
+	return atomic.LoadInt64(&w.unenriched)
+}
+
+// Stop stops the Watcher and returns the error it failed with, if any.
+func (w *Watcher) Stop() error {
+	w.cancel()
+	<-w.done
+	return w.err
+}
+
+// Err returns the error the Watcher failed with. It is nil while the Watcher
+// runs and if it was stopped.
+func (w *Watcher) Err() error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// coalesceEvents forwards the events of in to out, merging events of the
+// same type and object received within window of the first pending one.
+// Pending events are delivered in the order of their latest occurrence.
+func coalesceEvents(ctx context.Context, in <-chan *Event, out chan<- *Event, window time.Duration) {
+	var (
+		pending []*Event
+		flush   <-chan time.Time
+	)
+	send := func() bool {
+		for _, e := range pending {
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		pending, flush = nil, nil
+		return true
+	}
+	for {
+		select {
+		case e, ok := <-in:
+			if !ok {
+				send()
+				return
+			}
+			for n, p := range pending {
+				if p.Type == e.Type && p.Path.String() == e.Path.String() {
+					pending = append(pending[:n], pending[n+1:]...)
+					break
+				}
+			}
+			pending = append(pending, e)
+			if flush == nil {
+				flush = time.After(window)
+			}
+		case <-flush:
+			if !send() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
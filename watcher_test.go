@@ -0,0 +1,374 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalesceEvents(t *testing.T) {
+	var (
+		in   = make(chan *Event)
+		out  = make(chan *Event)
+		web  = "web"
+		work = "worker"
+		app  = "cat"
+	)
+	go coalesceEvents(context.Background(), in, out, 50*time.Millisecond)
+
+	for rev, proc := range []*string{&web, &work, &web, &web} {
+		in <- &Event{Type: EvProcAttrs, Path: EventData{App: &app, Proc: proc}, Rev: int64(rev)}
+	}
+	in <- &Event{Type: EvProcReg, Path: EventData{App: &app, Proc: &web}, Rev: 4}
+	close(in)
+
+	want := []struct {
+		etype EventType
+		rev   int64
+	}{{EvProcAttrs, 1}, {EvProcAttrs, 3}, {EvProcReg, 4}}
+	for _, w := range want {
+		select {
+		case e := <-out:
+			if e.Type != w.etype || e.Rev != w.rev {
+				t.Errorf("expected %s at %d, got %s at %d", w.etype, w.rev, e.Type, e.Rev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s at %d, got timeout", w.etype, w.rev)
+		}
+	}
+}
+
+func TestWatcherCoalesce(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "coalescecat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := storeFromSnapshotable(app).NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := storeFromSnapshotable(proc).Watch(context.Background(), WatchOptions{
+		Scope:    EventScope{App: app.Name},
+		Filter:   []EventType{EvProcAttrs},
+		Coalesce: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= 5; n++ {
+		proc.Attrs.DrainParallelism = n
+		if proc, err = proc.StoreAttrs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case e := <-w.Events():
+		if p := e.Source.(*Proc); p.Attrs.DrainParallelism != 5 {
+			t.Errorf("expected the latest attrs, got %#v", p.Attrs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected coalesced event, got timeout")
+	}
+	select {
+	case e := <-w.Events():
+		t.Errorf("expected a single event, got %s", e.Type)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := w.Stop(); err != nil {
+		t.Error(err)
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Error("expected events channel to be closed")
+	}
+}
+
+func TestWatcherRev(t *testing.T) {
+	s, _ := eventSetup()
+
+	w, err := s.Watch(context.Background(), WatchOptions{Filter: []EventType{EvAppReg}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	app, err := eventAppSetup(s, "revcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.Events():
+		if e.Type != EvAppReg {
+			t.Fatalf("expected %s, got %s", EvAppReg, e.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected event, got timeout")
+	}
+	// The watcher moves on to waiting after the delivered event.
+	time.Sleep(10 * time.Millisecond)
+	if rev := w.Rev(); rev < app.dir.Snapshot.Rev {
+		t.Errorf("expected watcher at rev %d or later, got %d", app.dir.Snapshot.Rev, rev)
+	}
+}
+
+func TestWatcherInitial(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "initialcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eventAppSetup(s, "initialdog").Register(); err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(proc)
+	ins, err := s.RegisterInstance(app.Name, "128af9", proc.Name, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(ins)
+
+	w, err := s.Watch(context.Background(), WatchOptions{
+		Scope:   EventScope{App: app.Name},
+		Initial: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	for _, etype := range []EventType{EvAppReg, EvProcReg, EvInsReg} {
+		select {
+		case e := <-w.Events():
+			if e.Type != etype {
+				t.Errorf("expected %s, got %s", etype, e.Type)
+			}
+			if e.Path.App == nil || *e.Path.App != app.Name {
+				t.Errorf("expected event of %s, got %s", app.Name, e.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s, got timeout", etype)
+		}
+	}
+
+	if _, err := s.NewProc(app, "worker").Register(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.Events():
+		if e.Type != EvProcReg || *e.Path.Proc != "worker" {
+			t.Errorf("expected registration of worker, got %s %s", e.Type, e.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected live event, got timeout")
+	}
+}
+
+func TestAppWatch(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "appwatchcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eventAppSetup(s, "appwatchdog").Register(); err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := app.Watch(ctx, EvInsReg, EvAppFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RegisterInstance("appwatchdog", "128af9", "web", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, "128af9", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.Events():
+		if i, ok := e.Source.(*Instance); !ok || i.ID != ins.ID {
+			t.Errorf("expected registration of instance %d, got %s %#v", ins.ID, e.Type, e.Source)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected instance event, got timeout")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-w.Events():
+		if ok {
+			t.Error("expected no more events")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected events channel to be closed once cancelled")
+	}
+	if err := w.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProcWatch(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "procwatchcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+	web, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewProc(app, "worker").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := web.Watch(ctx, EvInsReg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RegisterInstance(app.Name, "128af9", "worker", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, "128af9", "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.Events():
+		if i, ok := e.Source.(*Instance); !ok || i.ID != ins.ID {
+			t.Errorf("expected registration of instance %d, got %s %#v", ins.ID, e.Type, e.Source)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected instance event, got timeout")
+	}
+	if err := w.Stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRevisionWatch(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "revwatchcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = storeFromSnapshotable(app)
+	stable, err := s.NewRevision(app, "stable", "stable.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewRevision(app, "next", "next.img").Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := stable.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.NewTag("live", stable.Ref).Register(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.NewTag("live", "next").Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterInstance(app.Name, "next", "web", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := s.RegisterInstance(app.Name, stable.Ref, "web", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []EventType{EvRevTag, EvRevUntag, EvInsReg} {
+		select {
+		case e := <-w.Events():
+			if e.Type != want {
+				t.Fatalf("expected %s, got %s %s", want, e.Type, e.Path)
+			}
+			if e.revisionName() != stable.Ref {
+				t.Errorf("expected event of revision %s, got %s", stable.Ref, e.Path)
+			}
+			if want == EvRevTag {
+				if tag, ok := e.Source.(*Tag); !ok || tag.Name != "live" {
+					t.Errorf("expected tag live, got %#v", e.Source)
+				}
+			}
+			if want == EvInsReg {
+				if i, ok := e.Source.(*Instance); !ok || i.ID != ins.ID {
+					t.Errorf("expected registration of instance %d, got %#v", ins.ID, e.Source)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s event, got timeout", want)
+		}
+	}
+	if err := w.Stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatchersStopOnClose(t *testing.T) {
+	s, _ := eventSetup()
+	app, err := eventAppSetup(s, "closewatchcat").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := s.NewProc(app, "web").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := s.NewRevision(app, "stable", "stable.img").Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	watchers := map[string]func() (*Watcher, error){
+		"app":      func() (*Watcher, error) { return app.Watch(ctx) },
+		"proc":     func() (*Watcher, error) { return proc.Watch(ctx) },
+		"revision": func() (*Watcher, error) { return rev.Watch(ctx) },
+	}
+	started := map[string]*Watcher{}
+	for name, watch := range watchers {
+		w, err := watch()
+		if err != nil {
+			t.Fatal(err)
+		}
+		started[name] = w
+	}
+
+	s.Close()
+	for name, w := range started {
+		select {
+		case _, ok := <-w.Events():
+			if ok {
+				t.Errorf("expected no events from the %s watcher", name)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected %s watcher to stop once the store is closed", name)
+		}
+	}
+}
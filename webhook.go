@@ -0,0 +1,275 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	cp "github.com/soundcloud/cotterpin"
+)
+
+const deadLettersPath = "/webhooks/dead-letters"
+
+// Headers set on webhook requests.
+const (
+	WebhookEventHeader     = "X-Visor-Event"
+	WebhookSignatureHeader = "X-Visor-Signature"
+)
+
+// Defaults of the WebhookDispatcher.
+var (
+	DefaultWebhookAttempts   = 3
+	DefaultWebhookRetryDelay = time.Second
+	DefaultWebhookTimeout    = 10 * time.Second
+)
+
+// WebhookRule selects the events which are posted to an endpoint.
+type WebhookRule struct {
+	Name string
+	URL  string
+	// Key of the HMAC-SHA256 signature of the body sent in the
+	// X-Visor-Signature header, unsigned if empty.
+	Secret string
+	// Event types to post, all if empty.
+	Events []EventType
+	// Apps whose events are posted, all if empty.
+	Apps []string
+}
+
+// Matches reports whether the event is selected by the rule.
+func (r WebhookRule) Matches(ev *Event) bool {
+	if !ev.match(r.Events) {
+		return false
+	}
+	if len(r.Apps) == 0 {
+		return true
+	}
+	return ev.Path.App != nil && contains(r.Apps, *ev.Path.App)
+}
+
+// WebhookDispatcher posts JSON encoded events to the endpoints of the rules
+// they match. Failed requests are retried with exponential backoff, events
+// which can't be delivered are stored as DeadLetters.
+type WebhookDispatcher struct {
+	store *Store
+	Rules []WebhookRule
+	// Client used for requests, one with DefaultWebhookTimeout if nil.
+	Client *http.Client
+	// Attempts per delivery, DefaultWebhookAttempts if 0.
+	Attempts int
+	// Delay before the first retry, doubled for every further one.
+	// DefaultWebhookRetryDelay if 0.
+	RetryDelay time.Duration
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher for the given rules. Call
+// Run to start it.
+func (s *Store) NewWebhookDispatcher(rules ...WebhookRule) *WebhookDispatcher {
+	return &WebhookDispatcher{store: s, Rules: rules}
+}
+
+// Run dispatches all events, and EvResyncNeeded if changes were missed,
+// until the Store is closed or watching or storing a DeadLetter fails.
+// Events are delivered one after another in the order they happened.
+func (d *WebhookDispatcher) Run() error {
+	w, err := d.store.Watch(context.Background(), WatchOptions{})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for ev := range w.Events() {
+		if err := d.Dispatch(ev); err != nil {
+			return err
+		}
+	}
+	return w.Stop()
+}
+
+// Dispatch posts the event to the endpoints of all matching rules. Failing
+// deliveries are stored as DeadLetters, only an error storing them is
+// returned.
+func (d *WebhookDispatcher) Dispatch(ev *Event) error {
+	var body []byte
+	for _, rule := range d.Rules {
+		if !rule.Matches(ev) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(ev); err != nil {
+				return err
+			}
+		}
+		attempts, err := d.deliver(rule, string(ev.Type), body)
+		if err == nil {
+			continue
+		}
+		dl := &DeadLetter{
+			Rule:     rule.Name,
+			URL:      rule.URL,
+			Event:    body,
+			Error:    err.Error(),
+			Attempts: attempts,
+			Time:     time.Now().UTC(),
+		}
+		if err := storeDeadLetter(d.store, dl, ev.Rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver posts the body until it's accepted or the attempts are used up.
+func (d *WebhookDispatcher) deliver(rule WebhookRule, evType string, body []byte) (int, error) {
+	attempts := d.Attempts
+	if attempts <= 0 {
+		attempts = DefaultWebhookAttempts
+	}
+	delay := d.RetryDelay
+	if delay <= 0 {
+		delay = DefaultWebhookRetryDelay
+	}
+
+	var err error
+	for i := 1; i <= attempts; i++ {
+		var retry bool
+		if retry, err = d.post(rule, evType, body); err == nil || !retry {
+			return i, err
+		}
+		if i == attempts {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-d.store.closed():
+			return i, err
+		}
+		delay *= 2
+	}
+	return attempts, err
+}
+
+// post sends a single request and reports whether a failure is worth
+// retrying.
+func (d *WebhookDispatcher) post(rule WebhookRule, evType string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", rule.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, evType)
+	if rule.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(rule.Secret, body))
+	}
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s responded with %s", rule.URL, resp.Status)
+}
+
+// SignWebhook returns the value of the X-Visor-Signature header for the body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeadLetter is an event which couldn't be delivered to a webhook.
+type DeadLetter struct {
+	file     *cp.File
+	guard    *guard
+	Name     string          `json:"-"`
+	Rule     string          `json:"rule"`
+	URL      string          `json:"url"`
+	Event    json.RawMessage `json:"event"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	Time     time.Time       `json:"time"`
+}
+
+// GetSnapshot satisfies the cp.Snapshotable interface.
+func (dl *DeadLetter) GetSnapshot() cp.Snapshot {
+	return dl.file.Snapshot
+}
+
+// Del removes the DeadLetter, e.g. once it was redelivered.
+func (dl *DeadLetter) Del() error {
+	if err := dl.guard.authorize("", AuditUnregister, "dead-letter:"+dl.Name); err != nil {
+		return err
+	}
+	err := dl.file.Del()
+	if cp.IsErrNoEnt(err) {
+		err = errorf(ErrNotFound, "dead letter %s not found", dl.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	audit(dl, "", AuditUnregister, "dead-letter:"+dl.Name)
+	return nil
+}
+
+// GetDeadLetters returns the undelivered webhook events, oldest first.
+func (s *Store) GetDeadLetters() ([]*DeadLetter, error) {
+	sp, err := s.latest()
+	if err != nil {
+		return nil, err
+	}
+	names, err := getdirOrEmpty(sp, deadLettersPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	letters := []*DeadLetter{}
+	for _, name := range names {
+		dl := &DeadLetter{Name: name, guard: s.guard}
+		f, err := sp.GetFile(path.Join(deadLettersPath, name), &cp.JsonCodec{DecodedVal: dl})
+		if err != nil {
+			if cp.IsErrNoEnt(err) {
+				continue
+			}
+			return nil, err
+		}
+		dl.file = f
+		letters = append(letters, dl)
+	}
+	return letters, nil
+}
+
+func storeDeadLetter(s *Store, dl *DeadLetter, rev int64) error {
+	sp, err := s.GetSnapshot().FastForward()
+	if err != nil {
+		return err
+	}
+	// Names sort by time, the revision keeps them unique.
+	dl.Name = fmt.Sprintf("%019d-%d", dl.Time.UnixNano(), rev)
+	dl.guard = s.guard
+	dl.file, err = cp.NewFile(path.Join(deadLettersPath, dl.Name), dl, new(cp.JsonCodec), sp).Save()
+	return err
+}
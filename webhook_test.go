@@ -0,0 +1,134 @@
+// Copyright (c) 2013, SoundCloud Ltd.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Source code and contact info at http://github.com/soundcloud/visor
+
+package visor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func webhookSetup() *Store {
+	s, err := DialURI(DefaultURI, "/webhook-test")
+	if err != nil {
+		panic(err)
+	}
+	err = s.reset()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.FastForward()
+	if err != nil {
+		panic(err)
+	}
+	s, err = s.Init()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func webhookEventSetup(s *Store, t *testing.T) *Event {
+	l := make(chan *Event)
+	go s.WatchEvent(l, EvAppReg)
+	if _, err := s.NewApp("hook-cat", "git://webhook.git", "master").Register(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-l:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("expected app registration event")
+	}
+	return nil
+}
+
+func TestWebhookDispatch(t *testing.T) {
+	s := webhookSetup()
+	ev := webhookEventSetup(s, t)
+
+	received := make(chan *http.Request, 1)
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		received <- r
+	}))
+	defer srv.Close()
+
+	d := s.NewWebhookDispatcher(
+		WebhookRule{Name: "chatops", URL: srv.URL, Secret: "s3cret", Events: []EventType{EvAppReg}},
+		WebhookRule{Name: "other-app", URL: srv.URL, Apps: []string{"other-cat"}},
+	)
+	if err := d.Dispatch(ev); err != nil {
+		t.Fatal(err)
+	}
+	r := <-received
+	if r.Header.Get(WebhookEventHeader) != string(EvAppReg) {
+		t.Errorf("expected event header %s, got %q", EvAppReg, r.Header.Get(WebhookEventHeader))
+	}
+	if sig := r.Header.Get(WebhookSignatureHeader); sig != SignWebhook("s3cret", body) {
+		t.Errorf("expected valid signature, got %q", sig)
+	}
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["type"] != string(EvAppReg) {
+		t.Errorf("expected type %s in payload, got %v", EvAppReg, payload["type"])
+	}
+	select {
+	case <-received:
+		t.Error("expected event not to be posted for a rule of another app")
+	default:
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	s := webhookSetup()
+	ev := webhookEventSetup(s, t)
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	d := s.NewWebhookDispatcher(WebhookRule{Name: "ci", URL: srv.URL})
+	d.Attempts = 2
+	d.RetryDelay = time.Millisecond
+	if err := d.Dispatch(ev); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+
+	letters, err := s.GetDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Rule != "ci" || letters[0].Attempts != 2 {
+		t.Fatalf("expected one dead letter for ci, got %v", letters)
+	}
+	s.SetAuthorizer(AuthorizerFunc(func(actor, action, object string) error {
+		return errors.New("read-only")
+	}))
+	if err := letters[0].Del(); !IsErrUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+	s.SetAuthorizer(nil)
+	if err := letters[0].Del(); err != nil {
+		t.Fatal(err)
+	}
+	if letters, err = s.GetDeadLetters(); err != nil || len(letters) != 0 {
+		t.Errorf("expected no dead letters, got %v, %v", letters, err)
+	}
+}